@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/exchange"
+	"knowledge_master_backend/migrations"
+	"knowledge_master_backend/models"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runCommand 执行命令行子命令，没有子命令时返回false，由main继续启动服务
+//
+//	backup  -kb <kb_id> [-o file]                          导出知识库的JSON备份
+//	restore [-in file] [-owner <user_id|email>] [-keep-ids] 从备份恢复知识库
+//	migrate up|down [-steps n]|status|force <version>      数据库迁移
+func runCommand(args []string) bool {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "backup":
+		if err = migrations.Check(config.DB); err == nil {
+			err = backupCommand(args[1:])
+		}
+	case "restore":
+		if err = migrations.Check(config.DB); err == nil {
+			err = restoreCommand(args[1:])
+		}
+	case "migrate":
+		err = migrateCommand(args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	kbID := fs.String("kb", "", "knowledge base id")
+	output := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+
+	if *kbID == "" {
+		return fmt.Errorf("-kb is required")
+	}
+
+	backup, err := models.GetKBBackup(config.DB, *kbID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := exchange.WriteBackup(w, backup); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up %q: %d nodes, %d members\n",
+		backup.KnowledgeBase.Name, len(backup.Nodes), len(backup.Members))
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	input := fs.String("in", "", "backup file (default stdin)")
+	owner := fs.String("owner", "", "user id or email of the new owner (default: owner recorded in the backup)")
+	keepIDs := fs.Bool("keep-ids", false, "recreate the original knowledge base and node ids")
+	fs.Parse(args)
+
+	var data []byte
+	var err error
+	if *input == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*input)
+	}
+	if err != nil {
+		return err
+	}
+
+	backups, err := exchange.ParseBackup(data)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range backups {
+		ownerID, err := resolveOwner(*owner, b.KnowledgeBase)
+		if err != nil {
+			return err
+		}
+
+		kb, invited, skipped, err := models.RestoreKBBackup(config.DB, b, ownerID, *keepIDs)
+		if err != nil {
+			return fmt.Errorf("failed to restore %q: %w", b.KnowledgeBase.Name, err)
+		}
+		fmt.Printf("restored %q as %s: %d nodes\n", kb.Name, kb.KBID, len(b.Nodes))
+		for _, email := range invited {
+			fmt.Printf("  invited member %s\n", email)
+		}
+		for _, email := range skipped {
+			fmt.Printf("  skipped member %s: no email or invalid role\n", email)
+		}
+	}
+	return nil
+}
+
+// resolveOwner 根据-owner参数或备份中记录的所有者找到用户ID
+func resolveOwner(owner string, kb models.BackupKB) (string, error) {
+	candidates := []string{owner}
+	if owner == "" {
+		candidates = []string{kb.OwnerID, kb.OwnerEmail}
+	}
+
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if strings.Contains(c, "@") {
+			if user, err := models.GetUserByEmail(config.DB, c); err == nil {
+				return user.UserID, nil
+			}
+			continue
+		}
+		if _, err := models.GetUserEmail(config.DB, c); err == nil {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("owner not found, use -owner to choose an existing user")
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [-steps n]|status|force <version>")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrations.Up(config.DB)
+		for _, m := range done {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("already up to date")
+		}
+		return err
+
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		fs.Parse(args[1:])
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+
+		done, err := migrations.Down(config.DB, *steps)
+		for _, m := range done {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to roll back")
+		}
+		return err
+
+	case "status":
+		all, err := migrations.All()
+		if err != nil {
+			return err
+		}
+		applied, err := migrations.Applied(config.DB)
+		if err != nil {
+			return err
+		}
+		appliedAt := make(map[int64]string, len(applied))
+		for _, a := range applied {
+			appliedAt[a.Version] = a.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		pending := 0
+		for _, m := range all {
+			state, ok := appliedAt[m.Version]
+			if !ok {
+				state = "pending"
+				pending++
+			}
+			fmt.Printf("%04d  %-20s  %s\n", m.Version, m.Name, state)
+		}
+		fmt.Printf("%d applied, %d pending\n", len(all)-pending, pending)
+		return nil
+
+	case "force":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: migrate force <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := migrations.Force(config.DB, version); err != nil {
+			return err
+		}
+		fmt.Printf("schema version set to %d\n", version)
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
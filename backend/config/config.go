@@ -2,17 +2,339 @@ package config
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	_ "github.com/lib/pq"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"knowledge_master_backend/mail"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/storage"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var DB *sql.DB
 
-func InitDB() error {
-	connStr := "user=myuser dbname=mydatabase password=mypassword host=8.154.18.17 port=5432 sslmode=disable"
+// App 启动时加载的配置
+var App *Config
+
+// Config 服务的全部配置
+// 来源的优先级：命令行参数 > 环境变量（KM_前缀，如 KM_DATABASE_PASSWORD）> config.yaml > 默认值
+type Config struct {
+	Server   ServerConfig   `mapstructure:"server"`
+	Database DatabaseConfig `mapstructure:"database"`
+	JWT      JWTConfig      `mapstructure:"jwt"`
+	Storage  storage.Config `mapstructure:"storage"`
+	Mail     mail.Config    `mapstructure:"mail"`
+	OIDC     oidc.Config    `mapstructure:"oidc"`
+	CORS     CORSConfig     `mapstructure:"cors"`
+	Log      LogConfig      `mapstructure:"log"`
+	Trash    TrashConfig    `mapstructure:"trash"`
+	Admin    AdminConfig    `mapstructure:"admin"`
+}
+
+type ServerConfig struct {
+	Addr string `mapstructure:"addr"` // 监听地址，如 :8084
+}
+
+// DatabaseConfig 设置了DSN时忽略其他字段
+type DatabaseConfig struct {
+	DSN          string `mapstructure:"dsn"`
+	Host         string `mapstructure:"host"`
+	Port         int    `mapstructure:"port"`
+	User         string `mapstructure:"user"`
+	Password     string `mapstructure:"password"`
+	Name         string `mapstructure:"name"`
+	SSLMode      string `mapstructure:"sslmode"`
+	MaxOpenConns int    `mapstructure:"max_open_conns"`
+	MaxIdleConns int    `mapstructure:"max_idle_conns"`
+}
+
+type JWTConfig struct {
+	Secret     string        `mapstructure:"secret"`
+	TTL        time.Duration `mapstructure:"ttl"`         // 访问令牌的有效期
+	RefreshTTL time.Duration `mapstructure:"refresh_ttl"` // 刷新令牌的有效期，超过这么久未刷新需要重新登录
+}
+
+type CORSConfig struct {
+	AllowOrigins     []string      `mapstructure:"allow_origins"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+type LogConfig struct {
+	Mode   string `mapstructure:"mode"`   // Gin的运行模式：debug、release、test
+	File   string `mapstructure:"file"`   // 为空时只输出到标准输出
+	Access bool   `mapstructure:"access"` // 是否记录每个请求
+}
+
+type TrashConfig struct {
+	RetentionDays int `mapstructure:"retention_days"`
+}
+
+// AdminConfig 系统管理员，可以执行从备份恢复知识库等跨知识库的操作
+type AdminConfig struct {
+	UserIDs []string `mapstructure:"user_ids"`
+}
+
+// 最短的JWT密钥长度
+const minJWTSecretLength = 32
+
+// defaults 全部配置项的默认值，环境变量只对这里列出的配置项生效
+var defaults = map[string]interface{}{
+	"server.addr": ":8084",
+
+	"database.dsn":            "",
+	"database.host":           "localhost",
+	"database.port":           5432,
+	"database.user":           "",
+	"database.password":       "",
+	"database.name":           "",
+	"database.sslmode":        "disable",
+	"database.max_open_conns": 25,
+	"database.max_idle_conns": 5,
+
+	"jwt.secret":      "",
+	"jwt.ttl":         "15m",
+	"jwt.refresh_ttl": "720h",
+
+	"storage.driver":                "",
+	"storage.oss.endpoint":          "",
+	"storage.oss.access_key_id":     "",
+	"storage.oss.access_key_secret": "",
+	"storage.oss.bucket_name":       "",
+	"storage.oss.base_url":          "",
+	"storage.local.dir":             "./data/uploads",
+	"storage.local.base_url":        "/files",
+	"storage.local.secret":          "",
+	"storage.s3.endpoint":           "",
+	"storage.s3.region":             "us-east-1",
+	"storage.s3.bucket":             "",
+	"storage.s3.access_key_id":      "",
+	"storage.s3.secret_access_key":  "",
+	"storage.s3.path_style":         false,
+	"storage.s3.base_url":           "",
+
+	"mail.driver":        "log",
+	"mail.from":          "Knowledge Master <no-reply@localhost>",
+	"mail.link_base_url": "http://localhost:3000",
+	"mail.smtp.host":     "",
+	"mail.smtp.port":     587,
+	"mail.smtp.username": "",
+	"mail.smtp.password": "",
+	"mail.smtp.tls":      "starttls",
+
+	"oidc.issuer":        "",
+	"oidc.client_id":     "",
+	"oidc.client_secret": "",
+	"oidc.redirect_url":  "",
+	"oidc.scopes":        []string{"openid", "email", "profile"},
+
+	"cors.allow_origins":     []string{"*"},
+	"cors.allow_credentials": true,
+	"cors.max_age":           "12h",
+
+	"log.mode":   "debug",
+	"log.file":   "",
+	"log.access": true,
+
+	"trash.retention_days": 30,
+
+	"admin.user_ids": []string{},
+}
+
+// flags 可以通过命令行参数设置的配置项
+var flags = []struct {
+	key   string
+	usage string
+}{
+	{"server.addr", "listen address"},
+	{"database.dsn", "PostgreSQL connection string"},
+	{"storage.driver", "storage driver: oss, local or s3"},
+	{"log.mode", "gin mode: debug, release or test"},
+	{"log.file", "also write logs to this file"},
+}
+
+// Load 读取配置并校验，args为命令行参数（不含程序名）
+// 参数解析在第一个非参数处停止，返回剩余的参数（子命令及其参数）。
+func Load(args []string) (*Config, []string, error) {
+	fs := pflag.NewFlagSet("knowledge_master_backend", pflag.ContinueOnError)
+	fs.SetInterspersed(false)
+	configFile := fs.String("config", "", "config file (default ./config.yaml)")
+	for _, f := range flags {
+		fs.String(f.key, "", f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	v.SetEnvPrefix("KM")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	// 兼容旧的环境变量
+	v.BindEnv("trash.retention_days", "KM_TRASH_RETENTION_DAYS", "TRASH_RETENTION_DAYS")
+	v.BindEnv("admin.user_ids", "KM_ADMIN_USER_IDS", "ADMIN_USER_IDS")
+	for _, f := range flags {
+		v.BindPFlag(f.key, fs.Lookup(f.key))
+	}
+
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("yaml")
+		v.AddConfigPath(".")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		// 没有指定配置文件时，可以只用环境变量配置
+		var notFound viper.ConfigFileNotFoundError
+		if *configFile != "" || !errors.As(err, &notFound) {
+			return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	cfg := &Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	// 兼容旧配置文件中顶层的oss配置
+	if cfg.Storage.OSS == (storage.OSSConfig{}) && v.IsSet("oss") {
+		if err := v.UnmarshalKey("oss", &cfg.Storage.OSS); err != nil {
+			return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Validate 检查配置，一次报告所有的问题
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(key, env, msg string) {
+		problems = append(problems, fmt.Sprintf("%s %s (config.yaml or %s)", key, msg, env))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		add("server.addr", "KM_SERVER_ADDR", fmt.Sprintf("%q is not a valid listen address", c.Server.Addr))
+	}
+
+	if c.Database.DSN == "" {
+		if c.Database.Host == "" {
+			add("database.host", "KM_DATABASE_HOST", "is required")
+		}
+		if c.Database.User == "" {
+			add("database.user", "KM_DATABASE_USER", "is required")
+		}
+		if c.Database.Name == "" {
+			add("database.name", "KM_DATABASE_NAME", "is required")
+		}
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			add("database.port", "KM_DATABASE_PORT", "must be between 1 and 65535")
+		}
+	}
+
+	if c.JWT.Secret == "" {
+		add("jwt.secret", "KM_JWT_SECRET", "is required")
+	} else if len(c.JWT.Secret) < minJWTSecretLength {
+		add("jwt.secret", "KM_JWT_SECRET", fmt.Sprintf("must be at least %d characters", minJWTSecretLength))
+	}
+	if c.JWT.TTL <= 0 {
+		add("jwt.ttl", "KM_JWT_TTL", "must be a positive duration such as 15m")
+	}
+	if c.JWT.RefreshTTL <= c.JWT.TTL {
+		add("jwt.refresh_ttl", "KM_JWT_REFRESH_TTL", "must be longer than jwt.ttl")
+	}
+
+	switch c.Storage.Driver {
+	case "", "oss", "local", "s3":
+	default:
+		add("storage.driver", "KM_STORAGE_DRIVER", fmt.Sprintf("%q is not one of oss, local, s3", c.Storage.Driver))
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			add("mail.smtp.host", "KM_MAIL_SMTP_HOST", "is required when mail.driver is smtp")
+		}
+	default:
+		add("mail.driver", "KM_MAIL_DRIVER", fmt.Sprintf("%q is not one of smtp, log", c.Mail.Driver))
+	}
+	if !isAbsoluteURL(c.Mail.LinkBaseURL) {
+		add("mail.link_base_url", "KM_MAIL_LINK_BASE_URL", fmt.Sprintf("%q is not an absolute URL", c.Mail.LinkBaseURL))
+	}
+
+	// 设置了issuer才启用单点登录
+	if c.OIDC.Issuer != "" {
+		if !isAbsoluteURL(c.OIDC.Issuer) {
+			add("oidc.issuer", "KM_OIDC_ISSUER", fmt.Sprintf("%q is not an absolute URL", c.OIDC.Issuer))
+		}
+		if c.OIDC.ClientID == "" {
+			add("oidc.client_id", "KM_OIDC_CLIENT_ID", "is required when oidc.issuer is set")
+		}
+		if !isAbsoluteURL(c.OIDC.RedirectURL) {
+			add("oidc.redirect_url", "KM_OIDC_REDIRECT_URL", "must be an absolute URL when oidc.issuer is set")
+		}
+	}
+
+	if len(c.CORS.AllowOrigins) == 0 {
+		add("cors.allow_origins", "KM_CORS_ALLOW_ORIGINS", "must not be empty")
+	}
+
+	switch c.Log.Mode {
+	case "debug", "release", "test":
+	default:
+		add("log.mode", "KM_LOG_MODE", fmt.Sprintf("%q is not one of debug, release, test", c.Log.Mode))
+	}
+
+	if c.Trash.RetentionDays <= 0 {
+		add("trash.retention_days", "KM_TRASH_RETENTION_DAYS", "must be a positive number of days")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// ConnString 返回连接数据库使用的DSN
+func (c DatabaseConfig) ConnString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func InitDB(cfg DatabaseConfig) error {
 	var err error
-	DB, err = sql.Open("postgres", connStr)
+	DB, err = sql.Open("postgres", cfg.ConnString())
 	if err != nil {
 		return err
 	}
+	DB.SetMaxOpenConns(cfg.MaxOpenConns)
+	DB.SetMaxIdleConns(cfg.MaxIdleConns)
 	return DB.Ping()
 }
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"os"
+)
+
+// InitLogging 设置Gin的运行模式和日志输出，设置了log.file时同时写入文件
+func InitLogging(cfg LogConfig) error {
+	gin.SetMode(cfg.Mode)
+	if cfg.File == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	gin.DefaultWriter = io.MultiWriter(os.Stdout, f)
+	gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, f)
+	return nil
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"knowledge_master_backend/mail"
+	"log"
+)
+
+// Mailer 发送邮件使用的发件器，启动时由InitMail创建
+var Mailer mail.Sender
+
+// InitMail 创建发件器，只在启动时调用一次
+func InitMail(cfg mail.Config) error {
+	m, err := mail.New(cfg)
+	if err != nil {
+		return err
+	}
+	Mailer = m
+	if cfg.Driver == "log" {
+		log.Printf("邮件: 只打印到日志，不会真正发送")
+	} else {
+		log.Printf("邮件: %s", cfg.Driver)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"knowledge_master_backend/storage"
+	"log"
+)
+
+// Storage 上传文件使用的存储，启动时由InitStorage创建
+var Storage storage.Storage
+
+// InitStorage 创建存储，只在启动时调用一次
+// 没有设置storage.driver时，若oss配置完整则使用OSS，否则使用本地磁盘。
+func InitStorage(cfg storage.Config) error {
+	if cfg.Driver == "" {
+		cfg.Driver = "local"
+		if cfg.OSS.Endpoint != "" && cfg.OSS.AccessKeyID != "" && cfg.OSS.AccessKeySecret != "" && cfg.OSS.BucketName != "" {
+			cfg.Driver = "oss"
+		}
+	}
+
+	s, err := storage.New(cfg)
+	if err != nil {
+		return err
+	}
+	Storage = s
+	log.Printf("文件存储: %s", cfg.Driver)
+	return nil
+}
@@ -0,0 +1,10 @@
+package config
+
+import (
+	"time"
+)
+
+// Retention 回收站保留期
+func (c TrashConfig) Retention() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// 每个用户最多拥有的个人访问令牌数量
+	maxAccessTokens = 50
+	// 列表中显示的令牌开头部分的长度（包括前缀）
+	accessTokenDisplayLen = len(models.AccessTokenPrefix) + 4
+)
+
+// GetAccessTokens 当前用户的个人访问令牌，不包含令牌本身
+func (s *Server) GetAccessTokens(c *gin.Context) {
+	tokens, err := s.AccessTokens.ListForUser(c.GetString("userID"))
+	if err != nil {
+		log.Printf("获取访问令牌失败 - 用户: %s, 错误: %v", c.GetString("userID"), err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "获取访问令牌失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "Access tokens retrieved",
+		Data:    tokens,
+	})
+}
+
+// CreateAccessToken 创建个人访问令牌，令牌只在这次响应中返回
+// kb_ids为空时令牌可以访问用户的全部知识库，否则只能访问其中的知识库；
+// 令牌的权限不会超过用户自己在知识库中的角色。
+func (s *Server) CreateAccessToken(c *gin.Context) {
+	userID := c.GetString("userID")
+	var input struct {
+		Name      string                    `json:"name" binding:"required,max=100"`
+		Scopes    []models.AccessTokenScope `json:"scopes" binding:"required,min=1"`
+		KBIDs     []string                  `json:"kb_ids"`
+		ExpiresAt *time.Time                `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, apiResponse{Status: "failed", Message: "令牌名称不能为空", Data: nil})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !models.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, apiResponse{
+				Status:  "failed",
+				Message: "无效的权限范围",
+				Data:    gin.H{"scope": scope, "allowed": []models.AccessTokenScope{models.ScopeRead, models.ScopeWrite, models.ScopeAdmin}},
+			})
+			return
+		}
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, apiResponse{Status: "failed", Message: "过期时间必须晚于当前时间", Data: nil})
+		return
+	}
+
+	existing, err := s.AccessTokens.ListForUser(userID)
+	if err != nil {
+		log.Printf("获取访问令牌失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法创建访问令牌", Data: nil})
+		return
+	}
+	if len(existing) >= maxAccessTokens {
+		c.JSON(http.StatusConflict, apiResponse{
+			Status:  "failed",
+			Message: "访问令牌数量已达上限，请先撤销不再使用的令牌",
+			Data:    gin.H{"limit": maxAccessTokens},
+		})
+		return
+	}
+
+	// 只能限制到用户参与的知识库
+	kbIDs, err := s.accessibleKBs(userID, input.KBIDs)
+	if err != nil {
+		log.Printf("获取知识库失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法创建访问令牌", Data: nil})
+		return
+	}
+	if kbIDs == nil {
+		c.JSON(http.StatusBadRequest, apiResponse{Status: "failed", Message: "知识库不存在或无权访问", Data: nil})
+		return
+	}
+
+	random, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("生成访问令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法创建访问令牌", Data: nil})
+		return
+	}
+	raw := models.AccessTokenPrefix + random
+	token, err := s.AccessTokens.Create(&models.AccessToken{
+		UserID:    userID,
+		Name:      input.Name,
+		Prefix:    raw[:accessTokenDisplayLen],
+		Scopes:    input.Scopes,
+		KBIDs:     kbIDs,
+		ExpiresAt: input.ExpiresAt,
+	}, utils.HashToken(raw))
+	if err != nil {
+		log.Printf("创建访问令牌失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法创建访问令牌", Data: nil})
+		return
+	}
+	c.JSON(http.StatusCreated, apiResponse{
+		Status:  "success",
+		Message: "访问令牌已创建，请立即保存，之后将无法再次查看",
+		Data: gin.H{
+			"token":        raw,
+			"access_token": token,
+		},
+	})
+}
+
+// accessibleKBs 校验kbIDs都是用户参与的知识库并去重，有无法访问的知识库时返回nil
+func (s *Server) accessibleKBs(userID string, kbIDs []string) ([]string, error) {
+	if len(kbIDs) == 0 {
+		return []string{}, nil
+	}
+	kbs, err := s.KBs.ListForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	member := make(map[string]bool, len(kbs))
+	for _, kb := range kbs {
+		member[kb.KBID] = true
+	}
+	seen := make(map[string]bool, len(kbIDs))
+	result := []string{}
+	for _, id := range kbIDs {
+		if !member[id] {
+			return nil, nil
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// RevokeAccessToken 撤销当前用户的一个个人访问令牌，立即失效
+func (s *Server) RevokeAccessToken(c *gin.Context) {
+	err := s.AccessTokens.Revoke(c.GetString("userID"), c.Param("token_id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrAccessTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, apiResponse{
+			Status:  "failed",
+			Message: err.Error(),
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "Access token revoked",
+		Data:    nil,
+	})
+}
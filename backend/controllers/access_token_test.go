@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessTokens(t *testing.T) {
+	utils.InitJWT("0123456789abcdef0123456789abcdef", 15*time.Minute)
+	srv := NewServer(repository.NewMemory(), nil)
+	srv.Mailer = &recordingMailer{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+	r.POST("/login", srv.Login)
+	scopes := map[string]models.AccessTokenScope{
+		"GET /api/user/info":                    models.ScopeRead,
+		"GET /api/knowledge-bases/:kb_id/tree":  models.ScopeRead,
+		"POST /api/knowledge-bases/:kb_id/tree": models.ScopeWrite,
+	}
+	api := r.Group("/api", middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, scopes))
+	api.GET("/user/info", srv.GetUserInfo)
+	api.GET("/user/tokens", srv.GetAccessTokens)
+	api.POST("/user/tokens", srv.CreateAccessToken)
+	api.DELETE("/user/tokens/:token_id", srv.RevokeAccessToken)
+	api.POST("/knowledge-bases", srv.CreateKnowledgeBase)
+	api.GET("/knowledge-bases/:kb_id/tree", srv.GetKnowledgeTree)
+	api.POST("/knowledge-bases/:kb_id/tree", srv.AddKnowledgeNode)
+
+	credentials := gin.H{"email": "t@example.com", "password": "secret1", "username": "tom"}
+	if code := doJSON(t, r, "POST", "/register", "", credentials, nil); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	var login struct {
+		Data tokenData `json:"data"`
+	}
+	if code := doJSON(t, r, "POST", "/login", "", credentials, &login); code != http.StatusOK {
+		t.Fatalf("login: status %d", code)
+	}
+	session := authorized(r, login.Data.Token)
+
+	newKB := func(name string) string {
+		var kb struct {
+			Data struct {
+				KBID string `json:"kb_id"`
+			} `json:"data"`
+		}
+		if code := doJSON(t, session, "POST", "/api/knowledge-bases", "", gin.H{"name": name}, &kb); code != http.StatusCreated {
+			t.Fatalf("create knowledge base: status %d", code)
+		}
+		return kb.Data.KBID
+	}
+	notes, other := newKB("notes"), newKB("other")
+
+	create := func(body gin.H) (string, models.AccessToken, int) {
+		var resp struct {
+			Data struct {
+				Token       string             `json:"token"`
+				AccessToken models.AccessToken `json:"access_token"`
+			} `json:"data"`
+		}
+		code := doJSON(t, session, "POST", "/api/user/tokens", "", body, &resp)
+		return resp.Data.Token, resp.Data.AccessToken, code
+	}
+
+	// 参数校验
+	invalid := map[string]gin.H{
+		"no scopes":       {"name": "x", "scopes": []string{}},
+		"unknown scope":   {"name": "x", "scopes": []string{"delete"}},
+		"blank name":      {"name": "  ", "scopes": []string{"read"}},
+		"past expiry":     {"name": "x", "scopes": []string{"read"}, "expires_at": time.Now().Add(-time.Hour)},
+		"foreign kb":      {"name": "x", "scopes": []string{"read"}, "kb_ids": []string{"00000000-0000-4000-8000-000000000000"}},
+		"missing scopes":  {"name": "x"},
+		"name too long":   {"name": strings.Repeat("n", 101), "scopes": []string{"read"}},
+		"mixed kb access": {"name": "x", "scopes": []string{"read"}, "kb_ids": []string{notes, "not-a-kb"}},
+	}
+	for name, body := range invalid {
+		if _, _, code := create(body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+
+	// 只读令牌：可以读取，不能修改
+	readToken, readInfo, code := create(gin.H{"name": "reader", "scopes": []string{"read"}})
+	if code != http.StatusCreated || !strings.HasPrefix(readToken, models.AccessTokenPrefix) {
+		t.Fatalf("create read token: status %d, token %q", code, readToken)
+	}
+	if readInfo.Prefix == "" || !strings.HasPrefix(readToken, readInfo.Prefix) || len(readInfo.KBIDs) != 0 {
+		t.Fatalf("created token = %+v", readInfo)
+	}
+	reader := authorized(r, readToken)
+	var info struct {
+		Data UserInfoResponse `json:"data"`
+	}
+	if code := doJSON(t, reader, "GET", "/api/user/info", "", nil, &info); code != http.StatusOK || info.Data.UserID == "" {
+		t.Fatalf("user info with a read token: status %d", code)
+	}
+	if code := doJSON(t, reader, "GET", "/api/knowledge-bases/"+other+"/tree", "", nil, nil); code != http.StatusOK {
+		t.Fatalf("read tree with a read token: status %d", code)
+	}
+	node := gin.H{"type": "file", "name": "from script"}
+	if code := doJSON(t, reader, "POST", "/api/knowledge-bases/"+notes+"/tree", "", node, nil); code != http.StatusForbidden {
+		t.Fatalf("add node with a read token: status %d, want 403", code)
+	}
+	// 未登记的路由（令牌管理）只能用登录会话访问
+	if code := doJSON(t, reader, "GET", "/api/user/tokens", "", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("list tokens with an access token: status %d, want 403", code)
+	}
+
+	// 限制到一个知识库的写令牌
+	writeToken, writeInfo, code := create(gin.H{
+		"name":       "sync",
+		"scopes":     []string{"write"},
+		"kb_ids":     []string{notes, notes},
+		"expires_at": time.Now().Add(24 * time.Hour),
+	})
+	if code != http.StatusCreated || len(writeInfo.KBIDs) != 1 || writeInfo.ExpiresAt == nil {
+		t.Fatalf("create write token: status %d, token %+v", code, writeInfo)
+	}
+	writer := authorized(r, writeToken)
+	if code := doJSON(t, writer, "POST", "/api/knowledge-bases/"+notes+"/tree", "", node, nil); code != http.StatusCreated {
+		t.Fatalf("add node with a write token: status %d", code)
+	}
+	if code := doJSON(t, writer, "GET", "/api/knowledge-bases/"+notes+"/tree", "", nil, nil); code != http.StatusOK {
+		t.Fatalf("write token does not include read: status %d", code)
+	}
+	if code := doJSON(t, writer, "GET", "/api/knowledge-bases/"+other+"/tree", "", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("restricted token on another knowledge base: status %d, want 403", code)
+	}
+	if code := doJSON(t, writer, "GET", "/api/user/info", "", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("restricted token outside knowledge bases: status %d, want 403", code)
+	}
+
+	// 列表中不包含令牌本身
+	var list struct {
+		Data []models.AccessToken `json:"data"`
+	}
+	if code := doJSON(t, session, "GET", "/api/user/tokens", "", nil, &list); code != http.StatusOK || len(list.Data) != 2 {
+		t.Fatalf("list tokens: status %d, %d tokens", code, len(list.Data))
+	}
+	if list.Data[1].TokenID != readInfo.TokenID || list.Data[1].LastUsedAt == nil {
+		t.Fatalf("listed read token = %+v, want last use recorded", list.Data[1])
+	}
+
+	// 撤销后立即失效
+	if code := doJSON(t, session, "DELETE", "/api/user/tokens/"+readInfo.TokenID, "", nil, nil); code != http.StatusOK {
+		t.Fatalf("revoke: status %d", code)
+	}
+	if code := doJSON(t, reader, "GET", "/api/user/info", "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d, want 401", code)
+	}
+	if code := doJSON(t, session, "DELETE", "/api/user/tokens/"+readInfo.TokenID, "", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("revoke twice: status %d, want 404", code)
+	}
+
+	// 过期的令牌不能使用
+	expired := time.Now().Add(-time.Minute)
+	_, err := srv.AccessTokens.Create(&models.AccessToken{
+		UserID:    info.Data.UserID,
+		Name:      "old",
+		Prefix:    "kmpat_old",
+		Scopes:    []models.AccessTokenScope{models.ScopeAdmin},
+		ExpiresAt: &expired,
+	}, utils.HashToken("kmpat_expired"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := doJSON(t, authorized(r, "kmpat_expired"), "GET", "/api/user/info", "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("expired token: status %d, want 401", code)
+	}
+}
@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"knowledge_master_backend/mail"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// 验证邮箱链接的有效期
+	verifyEmailTTL = 48 * time.Hour
+	// 重置密码链接的有效期
+	resetPasswordTTL = time.Hour
+	// 同一用户两封同类邮件的最小间隔
+	tokenMailInterval = time.Minute
+)
+
+// sendTokenMail 生成一次性令牌，把包含链接的邮件发到email
+// path为前端处理该链接的页面，令牌放在token参数中。
+func (s *Server) sendTokenMail(userID, email, purpose, path string, ttl time.Duration, compose func(link string) mail.Message) error {
+	token, id, expiresAt, err := utils.NewSignedToken(purpose, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	err = s.Tokens.Create(utils.HashToken(id), &models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(s.LinkBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+	msg := compose(link)
+	msg.To = email
+	if err := s.Mailer.Send(msg); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+// sendVerificationMail 发送验证邮箱的邮件
+func (s *Server) sendVerificationMail(user *models.User) error {
+	return s.sendTokenMail(user.UserID, user.Email, models.TokenPurposeVerifyEmail, "/verify-email", verifyEmailTTL,
+		func(link string) mail.Message {
+			return mail.Message{
+				Subject: "验证你的邮箱",
+				Text: fmt.Sprintf("你好，%s：\n\n请打开下面的链接验证你在 Knowledge Master 注册的邮箱，链接在%d小时内有效：\n\n%s\n\n如果这不是你本人的操作，请忽略这封邮件。\n",
+					user.Username, int(verifyEmailTTL/time.Hour), link),
+			}
+		})
+}
+
+// sendPasswordResetMail 发送重置密码的邮件
+func (s *Server) sendPasswordResetMail(user *models.User) error {
+	return s.sendTokenMail(user.UserID, user.Email, models.TokenPurposeResetPassword, "/reset-password", resetPasswordTTL,
+		func(link string) mail.Message {
+			return mail.Message{
+				Subject: "重置密码",
+				Text: fmt.Sprintf("你好，%s：\n\n我们收到了重置你的 Knowledge Master 账号密码的请求。请打开下面的链接设置新密码，链接在%d分钟内有效，只能使用一次：\n\n%s\n\n如果这不是你本人的操作，请忽略这封邮件，你的密码不会改变。\n",
+					user.Username, int(resetPasswordTTL/time.Minute), link),
+			}
+		})
+}
+
+// recentlySent 是否在tokenMailInterval内给用户发过同类邮件
+func (s *Server) recentlySent(userID, purpose string) (bool, error) {
+	last, err := s.Tokens.LastIssuedAt(userID, purpose)
+	if err != nil {
+		return false, err
+	}
+	return time.Since(last) < tokenMailInterval, nil
+}
+
+// consumeMailToken 校验并使用邮件中的令牌，令牌无效时返回models.ErrTokenInvalid
+func (s *Server) consumeMailToken(purpose, token string) (*models.UserToken, error) {
+	id, err := utils.VerifySignedToken(purpose, token)
+	if err != nil {
+		return nil, models.ErrTokenInvalid
+	}
+	return s.Tokens.Consume(purpose, utils.HashToken(id))
+}
+
+// VerifyEmail 使用验证邮件中的令牌确认邮箱
+func (s *Server) VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	token, err := s.consumeMailToken(models.TokenPurposeVerifyEmail, input.Token)
+	if err == nil {
+		err = s.Users.MarkEmailVerified(token.UserID, token.Email)
+	}
+	if errors.Is(err, models.ErrTokenInvalid) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "链接无效或已过期，请重新发送验证邮件",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("验证邮箱失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "验证邮箱失败",
+			Data:    nil,
+		})
+		return
+	}
+	// 邮箱确认属于该用户之后才认领发往它的知识库邀请
+	claimed := s.claimInvites(token.UserID, token.Email)
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "邮箱验证成功",
+		Data:    gin.H{"claimed_invites": claimed},
+	})
+}
+
+// claimInvites 认领发往已验证邮箱的知识库邀请，返回认领的数量，失败只记录日志
+func (s *Server) claimInvites(userID, email string) int {
+	if s.ClaimInvites == nil {
+		return 0
+	}
+	claimed, err := s.ClaimInvites(userID, email)
+	if err != nil {
+		log.Printf("认领邀请失败 - 用户: %s, 错误: %v", userID, err)
+	}
+	return claimed
+}
+
+// ResendVerificationEmail 重新发送验证邮件，之前发送的链接失效
+func (s *Server) ResendVerificationEmail(c *gin.Context) {
+	user, err := s.Users.GetCredentials(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, apiResponse{
+			Status:  "failed",
+			Message: "User not found",
+			Data:    nil,
+		})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, apiResponse{
+			Status:  "failed",
+			Message: "邮箱已经验证",
+			Data:    nil,
+		})
+		return
+	}
+
+	recent, err := s.recentlySent(user.UserID, models.TokenPurposeVerifyEmail)
+	if err == nil && recent {
+		c.JSON(http.StatusTooManyRequests, apiResponse{
+			Status:  "failed",
+			Message: "发送过于频繁，请稍后再试",
+			Data:    nil,
+		})
+		return
+	}
+	if err == nil {
+		err = s.sendVerificationMail(user)
+	}
+	if err != nil {
+		log.Printf("发送验证邮件失败 - 用户: %s, 错误: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "发送验证邮件失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "验证邮件已发送",
+		Data:    nil,
+	})
+}
+
+// ForgotPassword 发送重置密码的邮件
+// 无论邮箱是否注册都返回成功，不暴露哪些邮箱注册过。
+func (s *Server) ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	user, err := s.Users.GetByEmail(input.Email)
+	if err == nil {
+		var recent bool
+		recent, err = s.recentlySent(user.UserID, models.TokenPurposeResetPassword)
+		if err == nil && !recent {
+			err = s.sendPasswordResetMail(user)
+		}
+	}
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		log.Printf("发送重置密码邮件失败 - 邮箱: %s, 错误: %v", input.Email, err)
+	}
+
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "如果该邮箱已注册，重置密码的邮件已经发出",
+		Data:    nil,
+	})
+}
+
+// ResetPassword 使用重置密码邮件中的令牌设置新密码，并退出全部会话
+func (s *Server) ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	// 先计算哈希，失败时令牌仍然可用
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法处理密码",
+			Data:    nil,
+		})
+		return
+	}
+
+	token, err := s.consumeMailToken(models.TokenPurposeResetPassword, input.Token)
+	if errors.Is(err, models.ErrTokenInvalid) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "链接无效或已过期，请重新申请重置密码",
+			Data:    nil,
+		})
+		return
+	}
+	if err == nil {
+		err = s.Users.SetPassword(token.UserID, string(hashedPassword))
+	}
+	if err != nil {
+		log.Printf("重置密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "重置密码失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	// 能收到重置邮件也就证明了邮箱属于该用户
+	if err := s.Users.MarkEmailVerified(token.UserID, token.Email); err != nil && !errors.Is(err, models.ErrTokenInvalid) {
+		log.Printf("验证邮箱失败 - 用户: %s, 错误: %v", token.UserID, err)
+	}
+	revoked, err := s.Sessions.RevokeAll(token.UserID)
+	if err != nil {
+		log.Printf("撤销会话失败 - 用户: %s, 错误: %v", token.UserID, err)
+	}
+	// 个人访问令牌可能是盗用账号的人创建的，同样全部撤销
+	revokedTokens, err := s.AccessTokens.RevokeAll(token.UserID)
+	if err != nil {
+		log.Printf("撤销访问令牌失败 - 用户: %s, 错误: %v", token.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "密码已重置，请重新登录",
+		Data:    gin.H{"revoked_sessions": revoked, "revoked_access_tokens": revokedTokens},
+	})
+}
+
+// ChangePassword 校验当前密码后修改密码，当前会话以外的会话全部退出
+func (s *Server) ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required,min=6"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	userID := c.GetString("userID")
+	user, err := s.Users.GetCredentials(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, apiResponse{
+			Status:  "failed",
+			Message: "User not found",
+			Data:    nil,
+		})
+		return
+	}
+	// 通过OIDC创建的账号没有密码，首次设置密码需要通过邮件重置，证明对邮箱的控制
+	if user.Password == "" {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "账号尚未设置密码，请使用忘记密码通过邮件设置",
+			Data:    gin.H{"password_set": false},
+		})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "当前密码不正确",
+			Data:    nil,
+		})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法处理密码",
+			Data:    nil,
+		})
+		return
+	}
+	if err := s.Users.SetPassword(userID, string(hashedPassword)); err != nil {
+		log.Printf("修改密码失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "修改密码失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	revoked, err := s.Sessions.RevokeOthers(userID, c.GetString("sessionID"))
+	if err != nil {
+		log.Printf("撤销会话失败 - 用户: %s, 错误: %v", userID, err)
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "密码已修改，其他设备需要重新登录",
+		Data:    gin.H{"revoked_sessions": revoked},
+	})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/mail"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// recordingMailer 记录发出的邮件而不发送
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var mailLinkPattern = regexp.MustCompile(`https://km\.example\.com(/[a-z-]+)\?token=(\S+)`)
+
+// lastLink 最后一封邮件发往的邮箱、链接的页面和令牌
+func (m *recordingMailer) lastLink(t *testing.T) (to, path, token string) {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("no mail was sent")
+	}
+	msg := m.sent[len(m.sent)-1]
+	match := mailLinkPattern.FindStringSubmatch(msg.Text)
+	if match == nil {
+		t.Fatalf("no link in mail %q", msg.Text)
+	}
+	token, err := url.QueryUnescape(match[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.To, match[1], token
+}
+
+func TestAccountFlows(t *testing.T) {
+	utils.InitJWT("0123456789abcdef0123456789abcdef", 15*time.Minute)
+	mailer := &recordingMailer{}
+	srv := NewServer(repository.NewMemory(), nil)
+	srv.Mailer = mailer
+	srv.LinkBaseURL = "https://km.example.com/"
+	var claimedFor []string
+	srv.ClaimInvites = func(userID, email string) (int, error) {
+		claimedFor = append(claimedFor, email)
+		return 1, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+	r.POST("/login", srv.Login)
+	r.POST("/verify-email", srv.VerifyEmail)
+	r.POST("/password/forgot", srv.ForgotPassword)
+	r.POST("/password/reset", srv.ResetPassword)
+	api := r.Group("/api", middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, nil))
+	api.GET("/user/info", srv.GetUserInfo)
+	api.PUT("/user/password", srv.ChangePassword)
+	api.POST("/user/verify-email/resend", srv.ResendVerificationEmail)
+
+	credentials := gin.H{"email": "a@example.com", "password": "secret1", "username": "ann"}
+	if code := doJSON(t, r, "POST", "/register", "", credentials, nil); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	login := func(password string) (tokenData, int) {
+		var resp struct {
+			Data tokenData `json:"data"`
+		}
+		code := doJSON(t, r, "POST", "/login", "", gin.H{"email": "a@example.com", "password": password}, &resp)
+		return resp.Data, code
+	}
+	emailVerified := func(token string) bool {
+		var info struct {
+			Data UserInfoResponse `json:"data"`
+		}
+		if code := doJSON(t, authorized(r, token), "GET", "/api/user/info", "", nil, &info); code != http.StatusOK {
+			t.Fatalf("user info: status %d", code)
+		}
+		return info.Data.EmailVerified
+	}
+
+	// 注册时发送验证邮件
+	to, path, verifyToken := mailer.lastLink(t)
+	if to != "a@example.com" || path != "/verify-email" {
+		t.Fatalf("verification mail to %s with link %s", to, path)
+	}
+	first, code := login("secret1")
+	if code != http.StatusOK {
+		t.Fatalf("login: status %d", code)
+	}
+	if emailVerified(first.Token) {
+		t.Fatal("email verified before the link was used")
+	}
+	if len(claimedFor) != 0 {
+		t.Fatalf("invites claimed before the email was verified: %v", claimedFor)
+	}
+	if code := doJSON(t, authorized(r, first.Token), "POST", "/api/user/verify-email/resend", "", nil, nil); code != http.StatusTooManyRequests {
+		t.Fatalf("resend right after register: status %d, want 429", code)
+	}
+
+	// 令牌只能用于签发时的用途，篡改后无效，使用一次后失效
+	if code := doJSON(t, r, "POST", "/password/reset", "", gin.H{"token": verifyToken, "password": "hijacked"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("reset password with a verification token: status %d, want 400", code)
+	}
+	if code := doJSON(t, r, "POST", "/verify-email", "", gin.H{"token": verifyToken + "x"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("verify with a tampered token: status %d, want 400", code)
+	}
+	if code := doJSON(t, r, "POST", "/verify-email", "", gin.H{"token": verifyToken}, nil); code != http.StatusOK {
+		t.Fatalf("verify email: status %d", code)
+	}
+	if !emailVerified(first.Token) {
+		t.Fatal("email not verified after using the link")
+	}
+	if len(claimedFor) != 1 || claimedFor[0] != "a@example.com" {
+		t.Fatalf("invites claimed for %v, want a@example.com once", claimedFor)
+	}
+	if code := doJSON(t, r, "POST", "/verify-email", "", gin.H{"token": verifyToken}, nil); code != http.StatusBadRequest {
+		t.Fatalf("verify twice: status %d, want 400", code)
+	}
+	if code := doJSON(t, authorized(r, first.Token), "POST", "/api/user/verify-email/resend", "", nil, nil); code != http.StatusConflict {
+		t.Fatalf("resend after verification: status %d, want 409", code)
+	}
+
+	// 修改密码需要当前密码，其他会话退出，当前会话保留
+	second, _ := login("secret1")
+	change := func(current, next string) int {
+		return doJSON(t, authorized(r, first.Token), "PUT", "/api/user/password", "",
+			gin.H{"current_password": current, "new_password": next}, nil)
+	}
+	if code := change("wrong-password", "secret2"); code != http.StatusBadRequest {
+		t.Fatalf("change password with a wrong current password: status %d, want 400", code)
+	}
+	if code := change("secret1", "secret2"); code != http.StatusOK {
+		t.Fatalf("change password: status %d", code)
+	}
+	if code := doJSON(t, authorized(r, second.Token), "GET", "/api/user/info", "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("other session after password change: status %d, want 401", code)
+	}
+	if code := doJSON(t, authorized(r, first.Token), "GET", "/api/user/info", "", nil, nil); code != http.StatusOK {
+		t.Fatalf("current session after password change: status %d", code)
+	}
+	if _, code := login("secret1"); code != http.StatusUnauthorized {
+		t.Fatalf("login with the old password: status %d, want 401", code)
+	}
+
+	// 忘记密码：未注册的邮箱同样返回成功，但不发送邮件；短时间内不重复发送
+	sent := len(mailer.sent)
+	if code := doJSON(t, r, "POST", "/password/forgot", "", gin.H{"email": "nobody@example.com"}, nil); code != http.StatusOK {
+		t.Fatalf("forgot password for an unknown email: status %d", code)
+	}
+	if len(mailer.sent) != sent {
+		t.Fatal("mail sent to an unregistered email")
+	}
+	if code := doJSON(t, r, "POST", "/password/forgot", "", gin.H{"email": "a@example.com"}, nil); code != http.StatusOK {
+		t.Fatalf("forgot password: status %d", code)
+	}
+	to, path, resetToken := mailer.lastLink(t)
+	if to != "a@example.com" || path != "/reset-password" {
+		t.Fatalf("reset mail to %s with link %s", to, path)
+	}
+	doJSON(t, r, "POST", "/password/forgot", "", gin.H{"email": "a@example.com"}, nil)
+	if len(mailer.sent) != sent+1 {
+		t.Fatalf("sent %d reset mails, want 1", len(mailer.sent)-sent)
+	}
+
+	// 重置密码后全部会话退出，个人访问令牌全部撤销，令牌不能再次使用
+	user, err := srv.Users.GetByEmail("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.AccessTokens.Create(&models.AccessToken{
+		UserID: user.UserID,
+		Name:   "script",
+		Prefix: "kmpat_script",
+		Scopes: []models.AccessTokenScope{models.ScopeAdmin},
+	}, utils.HashToken("kmpat_script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := doJSON(t, r, "POST", "/password/reset", "", gin.H{"token": resetToken, "password": "secret3"}, nil); code != http.StatusOK {
+		t.Fatalf("reset password: status %d", code)
+	}
+	if tokens, _ := srv.AccessTokens.ListForUser(user.UserID); len(tokens) != 0 {
+		t.Fatalf("access tokens after password reset: %+v", tokens)
+	}
+	if code := doJSON(t, authorized(r, first.Token), "GET", "/api/user/info", "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("session after password reset: status %d, want 401", code)
+	}
+	if code := doJSON(t, r, "POST", "/password/reset", "", gin.H{"token": resetToken, "password": "secret4"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("reuse reset token: status %d, want 400", code)
+	}
+	if _, code := login("secret3"); code != http.StatusOK {
+		t.Fatalf("login with the new password: status %d", code)
+	}
+}
@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// 附件的最大上传大小，各类文件另有更小的限制
+const maxAttachmentUploadSize = 100 << 20
+
+// 上传节点附件
+// multipart表单的file字段为上传的文件，返回附件信息和可以插入内容的Markdown。
+func UploadAttachment(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+	userID := c.GetString("userID")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentUploadSize+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Please upload a file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// 先确认节点存在，避免上传后才发现无法保存
+	if _, err := models.GetKnowledgeNode(config.DB, kbID, nodeID); err != nil {
+		c.JSON(nodeErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to open uploaded file",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to read uploaded file",
+			"error":   err.Error(),
+		})
+		return
+	}
+	contentType, ext, kind := utils.SniffFile(head[:n], fileHeader.Filename)
+	if err := utils.CheckFileSize(kind, fileHeader.Size); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to read uploaded file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	name, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to generate file name",
+			"error":   err.Error(),
+		})
+		return
+	}
+	key := fmt.Sprintf("kb/%s/%s/%s%s", kbID, nodeID, name, ext)
+
+	if err := config.Storage.Put(key, file, fileHeader.Size, contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to store file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	attachment, err := models.CreateAttachment(config.DB, &models.Attachment{
+		KBID:        kbID,
+		NodeID:      nodeID,
+		FileName:    attachmentFileName(fileHeader.Filename, ext),
+		ContentType: contentType,
+		Size:        fileHeader.Size,
+		URL:         config.Storage.URL(key),
+		StorageKey:  key,
+		UploadedBy:  userID,
+	})
+	if err != nil {
+		if err := config.Storage.Delete(key); err != nil {
+			log.Printf("删除附件文件失败 - Key: %s, 错误: %v", key, err)
+		}
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": "Failed to save attachment",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Attachment uploaded",
+		"data":    attachment,
+	})
+}
+
+// 获取节点的附件
+func GetNodeAttachments(c *gin.Context) {
+	listAttachments(c, c.Param("node_id"))
+}
+
+// 获取知识库的附件，可以用node_id参数筛选节点
+func GetKBAttachments(c *gin.Context) {
+	listAttachments(c, c.Query("node_id"))
+}
+
+func listAttachments(c *gin.Context, nodeID string) {
+	attachments, err := models.GetAttachments(config.DB, c.Param("kb_id"), nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get attachments",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Successfully queried attachments",
+		"data":    attachments,
+	})
+}
+
+// 删除附件，节点内容中对它的引用不会被修改
+func DeleteAttachment(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	attachmentID := c.Param("attachment_id")
+
+	attachment, err := models.DeleteAttachment(config.DB, kbID, attachmentID)
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 记录已删除，文件删除失败只记录日志
+	if err := config.Storage.Delete(attachment.StorageKey); err != nil {
+		log.Printf("删除附件文件失败 - Key: %s, 错误: %v", attachment.StorageKey, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Attachment deleted",
+	})
+}
+
+// attachmentFileName 整理上传时的文件名，用于显示和Markdown中的链接文字
+func attachmentFileName(name, ext string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == "/" {
+		name = "file" + ext
+	}
+	name = strings.ToValidUTF8(name, "")
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+	return name
+}
+
+// attachmentErrorStatus 将附件的错误映射为HTTP状态码
+func attachmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNodeNotFound), errors.Is(err, models.ErrAttachmentNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
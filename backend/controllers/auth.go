@@ -1,14 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"knowledge_master_backend/config"
 	"knowledge_master_backend/models"
-	"knowledge_master_backend/utils"
+	"log"
 	"net/http"
-	"time"
 )
 
 // 统一响应结构
@@ -18,18 +18,18 @@ type apiResponse struct {
 	Data    interface{} `json:"data"`    // 返回的具体数据
 }
 
-func SetupCORS(r *gin.Engine) {
+func SetupCORS(r *gin.Engine, cfg config.CORSConfig) {
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
+		AllowOrigins:     cfg.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
 	}))
 }
 
-func Register(c *gin.Context) {
+func (s *Server) Register(c *gin.Context) {
 	var input struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required,min=6"`
@@ -58,8 +58,8 @@ func Register(c *gin.Context) {
 	}
 
 	// 创建用户
-	user, err := models.CreateUser(config.DB, input.Email, string(hashedPassword), input.Username)
-	if err != nil {
+	user, err := s.Users.Create(input.Email, string(hashedPassword), input.Username)
+	if errors.Is(err, models.ErrEmailTaken) {
 		c.JSON(http.StatusConflict, apiResponse{
 			Status:  "failed",
 			Message: "邮箱已经被注册",
@@ -67,7 +67,22 @@ func Register(c *gin.Context) {
 		})
 		return
 	}
-	err = models.CreateUserProfile(config.DB, user.UserID, user.Username, user.Email, "是否尝试留下些什么...", "http://example.com", "https://avatar.iran.liara.run/public")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法创建用户",
+			Data:    nil,
+		})
+		return
+	}
+	err = s.Users.CreateProfile(&models.UserProfile{
+		UserID:      user.UserID,
+		Email:       user.Email,
+		Username:    user.Username,
+		Description: "是否尝试留下些什么...",
+		Website:     "http://example.com",
+		AvatarURI:   "https://avatar.iran.liara.run/public",
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, apiResponse{
 			Status:  "failed",
@@ -76,6 +91,10 @@ func Register(c *gin.Context) {
 		})
 		return
 	}
+	// 发送验证邮件，失败不影响注册，用户可以之后重新发送
+	if err := s.sendVerificationMail(user); err != nil {
+		log.Printf("发送验证邮件失败 - 用户: %s, 错误: %v", user.UserID, err)
+	}
 	user.Password = "******"
 	c.JSON(http.StatusCreated, apiResponse{
 		Status:  "success",
@@ -86,7 +105,7 @@ func Register(c *gin.Context) {
 	})
 }
 
-func Login(c *gin.Context) {
+func (s *Server) Login(c *gin.Context) {
 	var input struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
@@ -103,7 +122,7 @@ func Login(c *gin.Context) {
 	}
 
 	// 查询用户
-	user, err := models.GetUserByEmail(config.DB, input.Email)
+	user, err := s.Users.GetByEmail(input.Email)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, apiResponse{
 			Status:  "failed",
@@ -123,9 +142,31 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 生成Token
-	token, err := utils.GenerateToken(user.UserID)
+	s.finishLogin(c, user)
+}
+
+// finishLogin 身份验证通过（密码或单点登录）后创建会话并返回令牌
+// 启用了两步验证时先返回登录验证令牌，验证通过后才创建会话。
+func (s *Server) finishLogin(c *gin.Context, user *models.User) {
+	totp, err := s.TwoFactor.Get(user.UserID)
+	if err != nil && !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		log.Printf("获取两步验证状态失败 - 用户: %s, 错误: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+	if totp != nil && totp.Enabled {
+		s.startLoginChallenge(c, user.UserID)
+		return
+	}
+
+	// 创建会话并生成Token
+	tokens, err := s.startSession(c, user.UserID)
 	if err != nil {
+		log.Printf("创建会话失败 - 用户: %s, 错误: %v", user.UserID, err)
 		c.JSON(http.StatusInternalServerError, apiResponse{
 			Status:  "failed",
 			Message: "无法生成访问凭证，请检查后端服务器运行情况",
@@ -135,12 +176,10 @@ func Login(c *gin.Context) {
 	}
 
 	user.Password = "******"
+	tokens["user"] = user
 	c.JSON(http.StatusOK, apiResponse{
 		Status:  "success",
 		Message: "登录成功",
-		Data: gin.H{
-			"token": token,
-			"user":  user,
-		},
+		Data:    tokens,
 	})
 }
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/exchange"
+	"knowledge_master_backend/models"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 备份文件的最大上传大小
+const maxBackupUploadSize = 200 << 20
+
+// 下载知识库的完整JSON备份
+func BackupKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	backup, err := models.GetKBBackup(config.DB, kbID)
+	if err != nil {
+		c.JSON(kbErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": "Failed to back up knowledge base",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	setAttachmentHeaders(c, "application/json; charset=utf-8",
+		exchange.SanitizeFileName(backup.KnowledgeBase.Name)+".backup.json")
+	c.Status(http.StatusOK)
+	if err := exchange.WriteBackup(c.Writer, backup); err != nil {
+		log.Printf("写出备份失败 - KB: %s, 错误: %v", kbID, err)
+	}
+}
+
+// 从备份文件恢复知识库
+// 备份可以作为multipart的file字段上传，也可以直接作为请求体。
+// 只有系统管理员可以调用（见routes中的AdminMiddleware），备份中的所有者信息来自上传的文件，不能作为授权依据。
+// keep_ids=true 时保留原有的知识库和节点ID；默认重新生成ID。两种方式都恢复为当前用户拥有的知识库，
+// 其他成员转为邀请。
+func RestoreKnowledgeBaseBackup(c *gin.Context) {
+	userID := c.GetString("userID")
+	keepIDs := c.Query("keep_ids") == "true"
+
+	data, err := readBackupUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Failed to read backup",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	backups, err := exchange.ParseBackup(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid backup",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	restored := make([]gin.H, 0, len(backups))
+	for _, b := range backups {
+		kb, invited, skipped, err := models.RestoreKBBackup(config.DB, b, userID, keepIDs)
+		if err != nil {
+			c.JSON(backupErrorStatus(err), gin.H{
+				"status":  "failed",
+				"message": "Failed to restore backup",
+				"error":   err.Error(),
+				"data":    restored,
+			})
+			return
+		}
+		if invited == nil {
+			invited = []string{}
+		}
+		if skipped == nil {
+			skipped = []string{}
+		}
+		restored = append(restored, gin.H{
+			"knowledge_base":  kb,
+			"nodes":           len(b.Nodes),
+			"invited_members": invited,
+			"skipped_members": skipped,
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Backup restored",
+		"data":    restored,
+	})
+}
+
+// readBackupUpload 读取上传的备份内容
+func readBackupUpload(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBackupUploadSize)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(c.Request.Body)
+}
+
+// backupErrorStatus 将恢复备份的错误映射为HTTP状态码
+func backupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInvalidBackup):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrBackupConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
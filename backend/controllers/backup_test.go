@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRestoreBackupRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User-ID"))
+	})
+	api.POST("/knowledge-bases/restore", middleware.AdminMiddleware([]string{"admin"}), RestoreKnowledgeBaseBackup)
+
+	restore := func(path, userID, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 备份中声称的所有者不能代替管理员身份
+	backup := `{"schema_version": 1, "knowledge_base": {"owner_id": "user", "owner_email": "user@example.com"}}`
+	for _, path := range []string{"/api/knowledge-bases/restore", "/api/knowledge-bases/restore?keep_ids=true"} {
+		if code := restore(path, "user", backup); code != http.StatusForbidden {
+			t.Errorf("non-admin %s: status %d, want 403", path, code)
+		}
+		if code := restore(path, "", backup); code != http.StatusForbidden {
+			t.Errorf("anonymous %s: status %d, want 403", path, code)
+		}
+	}
+
+	// 管理员通过后才解析备份，无效的备份在访问数据库之前被拒绝
+	if code := restore("/api/knowledge-bases/restore", "admin", `{"nodes": []}`); code != http.StatusBadRequest {
+		t.Errorf("admin with invalid backup: status %d, want 400", code)
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/exchange"
+	"knowledge_master_backend/models"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// 导出知识库
+// format=markdown 时以zip流的形式返回Markdown文件夹；opml/freemind/xmind 导出为对应的思维导图文件；
+// html 导出为单个自包含的HTML文档（print=true 时打开后自动打印）。
+func ExportKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	format := c.DefaultQuery("format", "markdown")
+
+	kb, err := models.GetKnowledgeBaseById(config.DB, kbID)
+	if err != nil {
+		c.JSON(kbErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	switch format {
+	case "markdown":
+		exportMarkdown(c, kb)
+	case "opml":
+		exportMindMap(c, kb, "text/x-opml; charset=utf-8", ".opml", exchange.WriteOPML)
+	case "freemind":
+		exportMindMap(c, kb, "application/x-freemind; charset=utf-8", ".mm", exchange.WriteFreeMind)
+	case "xmind":
+		exportMindMap(c, kb, "application/vnd.xmind.workbook", ".xmind", exchange.WriteXMind)
+	case "html":
+		exportHTML(c, kb)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Unsupported export format: " + format,
+		})
+	}
+}
+
+func exportMarkdown(c *gin.Context, kb *models.KnowledgeBase) {
+	setAttachmentHeaders(c, "application/zip", exchange.SanitizeFileName(kb.Name)+".zip")
+	c.Status(http.StatusOK)
+
+	// 响应已经开始写出，出错时只能中断压缩包并记录日志
+	exporter := exchange.NewMarkdownExporter(c.Writer)
+	err := models.WalkKnowledgeTree(config.DB, kb.KBID, func(node *models.KnowledgeNode, _ int) error {
+		return exporter.WriteNode(node)
+	})
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err != nil {
+		log.Printf("导出知识库失败 - KB: %s, 错误: %v", kb.KBID, err)
+		c.Abort()
+	}
+}
+
+// exportMindMap 以思维导图格式导出整棵树
+func exportMindMap(c *gin.Context, kb *models.KnowledgeBase, contentType, ext string,
+	write func(io.Writer, *models.KnowledgeBase, []*models.KnowledgeNode) error) {
+	tree, err := models.GetKnowledgeTree(config.DB, kb.KBID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get knowledge tree",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	setAttachmentHeaders(c, contentType, exchange.SanitizeFileName(kb.Name)+ext)
+	c.Status(http.StatusOK)
+	if err := write(c.Writer, kb, tree); err != nil {
+		log.Printf("导出知识库失败 - KB: %s, 错误: %v", kb.KBID, err)
+		c.Abort()
+	}
+}
+
+// setAttachmentHeaders 设置下载文件的响应头，文件名按RFC 6266同时提供ASCII和UTF-8两种形式
+func setAttachmentHeaders(c *gin.Context, contentType, filename string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition",
+		`attachment; filename="export`+path.Ext(filename)+`"; filename*=UTF-8''`+url.PathEscape(filename))
+}
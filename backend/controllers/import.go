@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/exchange"
+	"knowledge_master_backend/models"
+	"log"
+	"net/http"
+)
+
+// 导入文件的最大上传大小
+const maxImportUploadSize = 100 << 20
+
+// 导入文件到知识库
+// multipart表单：file为上传的文件，parent_id为可选的目标目录（为空时导入到根目录），
+// format=markdown 时file为Markdown文件夹或Obsidian库的zip，opml/freemind/xmind 时为对应的思维导图文件。
+// 整个导入在一个事务中完成，Markdown中引用的图片保存为对应节点的附件。
+func ImportKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	parentID := c.PostForm("parent_id")
+	format := c.DefaultQuery("format", "markdown")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportUploadSize)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Please upload a file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to open uploaded file",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	var nodes []*models.KnowledgeNode
+	var attachments []models.ImportAttachment
+	var report *exchange.ImportReport
+	switch format {
+	case "markdown":
+		nodes, attachments, report, err = exchange.ParseMarkdownZip(file, fileHeader.Size, config.Storage, "kb/"+kbID+"/import/")
+	case "opml":
+		nodes, report, err = exchange.ParseOPML(file)
+	case "freemind":
+		nodes, report, err = exchange.ParseFreeMind(file)
+	case "xmind":
+		nodes, report, err = exchange.ParseXMind(file, fileHeader.Size)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Unsupported import format: " + format,
+		})
+		return
+	}
+	if err != nil {
+		deleteImportedFiles(attachments)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Failed to parse uploaded file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	for _, a := range attachments {
+		a.Attachment.UploadedBy = c.GetString("userID")
+	}
+	created, err := models.ImportKnowledgeNodes(config.DB, kbID, parentID, nodes, attachments)
+	if err != nil {
+		deleteImportedFiles(attachments)
+		c.JSON(importErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": "Failed to import nodes",
+			"error":   err.Error(),
+			"data":    report,
+		})
+		return
+	}
+	report.Created = created
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Import completed",
+		"data":    report,
+	})
+}
+
+// deleteImportedFiles 导入失败时删除已经上传的图片
+func deleteImportedFiles(attachments []models.ImportAttachment) {
+	for _, a := range attachments {
+		if err := config.Storage.Delete(a.Attachment.StorageKey); err != nil {
+			log.Printf("删除导入的图片失败 - Key: %s, 错误: %v", a.Attachment.StorageKey, err)
+		}
+	}
+}
+
+// importErrorStatus 将导入的错误映射为HTTP状态码
+func importErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNodeNotFound), errors.Is(err, models.ErrKnowledgeBaseNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrImportTargetNotFolder):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
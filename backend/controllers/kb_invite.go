@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"time"
+)
+
+// 邀请默认有效期
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// 获取知识库邀请列表
+func GetKBInvites(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	invites, err := models.GetKBInvites(config.DB, kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get invites",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Invites retrieved",
+		"data":    invites,
+	})
+}
+
+// 创建邀请
+// 不带email时生成可分享的链接令牌；带email时，已注册用户直接加入，未注册邮箱保存为待认领邀请。
+func CreateKBInvite(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	userID := c.GetString("userID")
+
+	var input struct {
+		Role           string `json:"role" binding:"required"`
+		Email          string `json:"email" binding:"omitempty,email"`
+		ExpiresInHours *int   `json:"expires_in_hours" binding:"omitempty,min=0"` // 0表示永不过期
+		MaxUses        *int   `json:"max_uses" binding:"omitempty,min=1"`         // 不传表示不限次数
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid input",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !models.IsValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "role must be one of OWNER/EDITOR/VIEWER",
+		})
+		return
+	}
+
+	var expiresAt *time.Time
+	ttl := defaultInviteTTL
+	if input.ExpiresInHours != nil {
+		ttl = time.Duration(*input.ExpiresInHours) * time.Hour
+	}
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	if input.Email != "" {
+		createEmailInvite(c, kbID, userID, input.Email, input.Role, expiresAt)
+		return
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to generate invite token",
+		})
+		return
+	}
+
+	invite, err := models.CreateKBLinkInvite(config.DB, kbID, userID, utils.HashToken(token), input.Role, input.MaxUses, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to create invite",
+			"error":   err.Error(),
+		})
+		return
+	}
+	invite.Token = token
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Invite created, the token is only shown once",
+		"data":    invite,
+	})
+}
+
+func createEmailInvite(c *gin.Context, kbID, userID, email, role string, expiresAt *time.Time) {
+	user, err := models.GetUserByEmail(config.DB, email)
+	if err == nil {
+		member, err := models.AddKBMember(config.DB, kbID, user.UserID, role)
+		if err != nil {
+			c.JSON(memberErrorStatus(err), gin.H{
+				"status":  "failed",
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"status":  "success",
+			"message": "User already registered, added as member",
+			"data":    member,
+		})
+		return
+	}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to look up user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	invite, err := models.CreateKBEmailInvite(config.DB, kbID, userID, email, role, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to create invite",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Invite pending until the email is registered",
+		"data":    invite,
+	})
+}
+
+// 撤销邀请
+func RevokeKBInvite(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	inviteID := c.Param("invite_id")
+
+	if err := models.RevokeKBInvite(config.DB, kbID, inviteID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrInviteNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Revoked invite " + inviteID,
+		"data":    nil,
+	})
+}
+
+// 接受邀请链接
+func AcceptKBInvite(c *gin.Context) {
+	token := c.Param("token")
+	userID := c.GetString("userID")
+
+	member, err := models.AcceptKBInvite(config.DB, utils.HashToken(token), userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, models.ErrInviteNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, models.ErrInviteInvalid):
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Joined knowledge base",
+		"data":    member,
+	})
+}
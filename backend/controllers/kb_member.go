@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"net/http"
+)
+
+// 获取知识库成员列表
+func (s *Server) GetKBMembers(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	members, err := s.Members.List(kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get members",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// 公开知识库的访客和普通成员也能查看成员列表，邮箱只对管理者可见
+	if !models.RoleAllows(c.GetString("kbRole"), models.PermissionManage) {
+		for i := range members {
+			members[i].Email = ""
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Members retrieved",
+		"data":    members,
+	})
+}
+
+// 添加知识库成员
+func (s *Server) AddKBMember(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+		Role  string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid input",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !models.IsValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "role must be one of OWNER/EDITOR/VIEWER",
+		})
+		return
+	}
+
+	user, err := s.Users.GetByEmail(input.Email)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"status":  "failed",
+			"message": "User not found",
+		})
+		return
+	}
+
+	member, err := s.Members.Add(kbID, user.UserID, input.Role)
+	if err != nil {
+		c.JSON(memberErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Member added",
+		"data":    member,
+	})
+}
+
+// 修改成员角色（仅所有者）
+func (s *Server) UpdateKBMemberRole(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	memberID := c.Param("user_id")
+
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid input",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !models.IsValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "role must be one of OWNER/EDITOR/VIEWER",
+		})
+		return
+	}
+
+	member, err := s.Members.UpdateRole(kbID, memberID, input.Role)
+	if err != nil {
+		c.JSON(memberErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Member role updated",
+		"data":    member,
+	})
+}
+
+// 移除成员：所有者可以移除任何人，其他成员只能退出自己
+func (s *Server) RemoveKBMember(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	memberID := c.Param("user_id")
+	userID := c.GetString("userID")
+
+	if memberID != userID && !models.RoleAllows(c.GetString("kbRole"), models.PermissionManage) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":  "failed",
+			"message": "permission denied",
+		})
+		return
+	}
+
+	if err := s.Members.Remove(kbID, memberID); err != nil {
+		c.JSON(memberErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Removed member " + memberID,
+		"data":    nil,
+	})
+}
+
+// 转移知识库所有权，只有主所有者可以操作
+func (s *Server) TransferKBOwnership(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	var input struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid input",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := s.Members.TransferOwnership(kbID, c.GetString("userID"), input.UserID); err != nil {
+		c.JSON(memberErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	kb, err := s.KBs.Get(kbID)
+	if err != nil {
+		c.JSON(kbErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": "Ownership transferred, but failed to reload knowledge base",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Ownership transferred",
+		"data":    kb,
+	})
+}
+
+// memberErrorStatus 将成员操作的错误映射为HTTP状态码
+func memberErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrMemberNotFound),
+		errors.Is(err, models.ErrKnowledgeBaseNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrMemberExists),
+		errors.Is(err, models.ErrLastOwner),
+		errors.Is(err, models.ErrPrimaryOwner):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrTransferToMember):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrNotPrimaryOwner):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
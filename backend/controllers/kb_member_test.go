@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"net/http"
+	"testing"
+)
+
+func TestTransferKBOwnershipRequiresPrimaryOwner(t *testing.T) {
+	repos := repository.NewMemory()
+	srv := NewServer(repos, nil)
+	r := newTestRouter(srv)
+
+	const kbPath = "/api/knowledge-bases/:kb_id"
+	specificKb := r.Group(kbPath, func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User-ID"))
+	}, middleware.KBPermissionMiddleware(srv.KBs, map[string]models.Permission{
+		"GET " + kbPath + "/members":             models.PermissionRead,
+		"POST " + kbPath + "/members":            models.PermissionManage,
+		"POST " + kbPath + "/transfer-ownership": models.PermissionManage,
+	}))
+	specificKb.GET("/members", srv.GetKBMembers)
+	specificKb.POST("/members", srv.AddKBMember)
+	specificKb.POST("/transfer-ownership", srv.TransferKBOwnership)
+
+	newUser := func(email string) *models.User {
+		user, err := repos.Users.Create(email, "hash", "tester")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	owner := newUser("owner@example.com")
+	coOwner := newUser("co-owner@example.com")
+	editor := newUser("editor@example.com")
+	kb, err := repos.KBs.Create("kb", "", owner.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "/api/knowledge-bases/" + kb.KBID
+
+	for _, m := range []struct{ email, role string }{
+		{coOwner.Email, models.RoleOwner},
+		{editor.Email, models.RoleEditor},
+	} {
+		if code := doJSON(t, r, "POST", base+"/members", owner.UserID, gin.H{"email": m.email, "role": m.role}, nil); code != http.StatusCreated {
+			t.Fatalf("add %s: status %d", m.email, code)
+		}
+	}
+
+	transfer := func(userID, toUserID string) int {
+		return doJSON(t, r, "POST", base+"/transfer-ownership", userID, gin.H{"user_id": toUserID}, nil)
+	}
+	// 其他OWNER通过了管理权限检查，但不能接管主所有权
+	if code := transfer(coOwner.UserID, coOwner.UserID); code != http.StatusForbidden {
+		t.Fatalf("co-owner takeover: status %d, want 403", code)
+	}
+	if code := transfer(coOwner.UserID, editor.UserID); code != http.StatusForbidden {
+		t.Fatalf("co-owner transfer to editor: status %d, want 403", code)
+	}
+	if code := transfer(editor.UserID, editor.UserID); code != http.StatusForbidden {
+		t.Fatalf("editor takeover: status %d, want 403", code)
+	}
+	if got, _ := repos.KBs.Get(kb.KBID); got.OwnerID != owner.UserID {
+		t.Fatalf("owner = %s after rejected transfers, want %s", got.OwnerID, owner.UserID)
+	}
+
+	var transferred struct {
+		Data models.KnowledgeBase `json:"data"`
+	}
+	if code := doJSON(t, r, "POST", base+"/transfer-ownership", owner.UserID, gin.H{"user_id": coOwner.UserID}, &transferred); code != http.StatusOK {
+		t.Fatalf("owner transfer: status %d", code)
+	}
+	if transferred.Data.OwnerID != coOwner.UserID {
+		t.Fatalf("transferred knowledge base = %+v", transferred.Data)
+	}
+
+	var members struct {
+		Data []models.KBMember `json:"data"`
+	}
+	if code := doJSON(t, r, "GET", base+"/members", editor.UserID, nil, &members); code != http.StatusOK {
+		t.Fatalf("members: status %d", code)
+	}
+	roles := map[string]string{}
+	for _, m := range members.Data {
+		roles[m.UserID] = m.Role
+		if m.Email != "" {
+			t.Errorf("member email %q visible to an editor", m.Email)
+		}
+	}
+	if roles[coOwner.UserID] != models.RoleOwner || roles[owner.UserID] != models.RoleEditor || roles[editor.UserID] != models.RoleEditor {
+		t.Fatalf("roles after transfer = %v", roles)
+	}
+}
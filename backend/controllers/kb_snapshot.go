@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"net/http"
+)
+
+// 获取知识库快照列表
+func GetKBSnapshots(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	snapshots, err := models.GetKBSnapshots(config.DB, kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get snapshots",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Snapshots retrieved",
+		"data":    snapshots,
+	})
+}
+
+// 为知识库当前的全部节点创建命名快照
+func CreateKBSnapshot(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	userID := c.GetString("userID")
+
+	var input struct {
+		Name        string `json:"name" binding:"required,max=255"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "Invalid input",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	snapshot, err := models.CreateKBSnapshot(config.DB, kbID, input.Name, input.Description, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to create snapshot",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Snapshot created",
+		"data":    snapshot,
+	})
+}
+
+// 比较快照与当前知识库树
+func DiffKBSnapshot(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	snapshotID := c.Param("snapshot_id")
+
+	diff, err := models.DiffKBSnapshot(config.DB, kbID, snapshotID)
+	if err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Diff computed",
+		"data":    diff,
+	})
+}
+
+// 将知识库恢复到快照时的状态，快照之后新增的节点移入回收站
+func RestoreKBSnapshot(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	snapshotID := c.Param("snapshot_id")
+	userID := c.GetString("userID")
+
+	if err := models.RestoreKBSnapshot(config.DB, kbID, snapshotID, userID); err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	tree, err := models.GetKnowledgeTree(config.DB, kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Snapshot restored but failed to load tree",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Snapshot restored",
+		"data":    tree,
+	})
+}
+
+// 删除快照
+func DeleteKBSnapshot(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	snapshotID := c.Param("snapshot_id")
+
+	if err := models.DeleteKBSnapshot(config.DB, kbID, snapshotID); err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Snapshot deleted",
+		"data":    nil,
+	})
+}
+
+// snapshotErrorStatus 将快照操作的错误映射为HTTP状态码
+func snapshotErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrKnowledgeBaseNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,14 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"knowledge_master_backend/config"
 	"knowledge_master_backend/models"
 	"net/http"
 )
 
 // 创建知识库
-func CreateKnowledgeBase(c *gin.Context) {
+func (s *Server) CreateKnowledgeBase(c *gin.Context) {
 	userID := c.GetString("userID") // 从中间件获取
 
 	var input struct {
@@ -25,7 +25,7 @@ func CreateKnowledgeBase(c *gin.Context) {
 		return
 	}
 
-	kb, err := models.CreateKnowledgeBase(config.DB, input.Name, input.Description, userID)
+	kb, err := s.KBs.Create(input.Name, input.Description, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "failed",
@@ -43,10 +43,10 @@ func CreateKnowledgeBase(c *gin.Context) {
 }
 
 // 获取用户的知识库列表
-func GetUserKnowledgeBases(c *gin.Context) {
+func (s *Server) GetUserKnowledgeBases(c *gin.Context) {
 	userID := c.GetString("userID") // 从中间件获取
 
-	kbs, err := models.GetUserKnowledgeBases(config.DB, userID)
+	kbs, err := s.KBs.ListForUser(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "failed",
@@ -63,11 +63,11 @@ func GetUserKnowledgeBases(c *gin.Context) {
 	})
 }
 
-func GetKnowledgeBaseByID(c *gin.Context) {
+func (s *Server) GetKnowledgeBaseByID(c *gin.Context) {
 	kbID := c.Param("kb_id")
-	kb, err := models.GetKnowledgeBaseById(config.DB, kbID)
+	kb, err := s.KBs.Get(kbID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(kbErrorStatus(err), gin.H{
 			"status":  "failed",
 			"message": "Failed to get knowledge bases",
 			"error":   err.Error(),
@@ -81,24 +81,8 @@ func GetKnowledgeBaseByID(c *gin.Context) {
 	})
 }
 
-func UpdateKnowledgeBase(c *gin.Context) {
+func (s *Server) UpdateKnowledgeBase(c *gin.Context) {
 	kbID := c.Param("kb_id")
-	userID := c.GetString("userID")
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if !hasPermission {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "failed",
-			"message": "permission denied",
-			"data":    nil,
-		})
-	}
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "failed",
-			"message": err.Error(),
-			"data":    nil,
-		})
-	}
 	var input struct {
 		Name        string `json:"name" binding:"required"`
 		Description string `json:"description"`
@@ -111,7 +95,7 @@ func UpdateKnowledgeBase(c *gin.Context) {
 		})
 		return
 	}
-	kb, err := models.UpdateKnowledgeBase(config.DB, kbID, input.Name, input.Description, userID)
+	kb, err := s.KBs.Update(kbID, input.Name, input.Description)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "failed",
@@ -127,35 +111,75 @@ func UpdateKnowledgeBase(c *gin.Context) {
 	})
 }
 
-func DeleteKnowledgeBase(c *gin.Context) {
+// 修改知识库可见性（仅所有者）
+func (s *Server) UpdateKnowledgeBaseVisibility(c *gin.Context) {
 	kbID := c.Param("kb_id")
-	userID := c.GetString("userID")
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if !hasPermission {
-		c.JSON(http.StatusUnauthorized, gin.H{
+	var input struct {
+		IsPublic          *bool  `json:"is_public"`
+		CollaborationMode string `json:"collaboration_mode"` // PRIVATE/TEAM/PUBLIC，优先于is_public
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "failed",
-			"message": "permission denied",
-			"data":    nil,
+			"message": "Invalid input",
+			"error":   err.Error(),
 		})
+		return
 	}
-	if err != nil {
+
+	mode := input.CollaborationMode
+	if mode == "" && input.IsPublic != nil {
+		mode = models.CollaborationPrivate
+		if *input.IsPublic {
+			mode = models.CollaborationPublic
+		}
+	}
+	if !models.IsValidCollaborationMode(mode) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "failed",
-			"message": err.Error(),
-			"data":    nil,
+			"message": "collaboration_mode must be one of PRIVATE/TEAM/PUBLIC",
 		})
+		return
 	}
-	err = models.DeleteKnowledgeBase(config.DB, kbID)
+
+	kb, err := s.KBs.SetVisibility(kbID, mode)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(kbErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": "Failed to update visibility",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Knowledge base visibility updated",
+		"data":    kb,
+	})
+}
+
+func (s *Server) DeleteKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	err := s.KBs.Delete(kbID)
+	if err != nil {
+		c.JSON(kbErrorStatus(err), gin.H{
 			"status":  "failed",
 			"message": err.Error(),
 			"data":    nil,
 		})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "Deleted Knowledge Base " + kbID,
+		"message": "Moved Knowledge Base " + kbID + " to trash",
 		"data":    nil,
 	})
 }
+
+// kbErrorStatus 将知识库操作的错误映射为HTTP状态码
+func kbErrorStatus(err error) int {
+	if errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
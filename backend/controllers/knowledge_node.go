@@ -1,42 +1,29 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"knowledge_master_backend/config"
 	"knowledge_master_backend/models"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
-func GetNodeData(c *gin.Context) {
+func (s *Server) GetNodeData(c *gin.Context) {
 	kbID := c.Param("kb_id")
 	nodeID := c.Param("node_id")
-	userID := c.GetString("userID")
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if !hasPermission {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "failed",
-			"message": "permission denied",
-			"data":    nil,
-		})
-	}
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "failed",
-			"message": err.Error(),
-			"data":    nil,
-		})
-	}
-	Node, err := models.GetKnowledgeNode(config.DB, kbID, nodeID)
+	Node, err := s.Nodes.Get(kbID, nodeID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(nodeErrorStatus(err), gin.H{
 			"status":  "failed",
 			"message": err.Error(),
 			"data":    nil,
 		})
+		return
 	}
+	c.Header("ETag", nodeETag(Node.Version))
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Successfully queried Knowledge Node",
@@ -45,28 +32,13 @@ func GetNodeData(c *gin.Context) {
 
 }
 
-func UpdateNodeData(c *gin.Context) {
+func (s *Server) UpdateNodeData(c *gin.Context) {
 	kbID := c.Param("kb_id")
 	nodeID := c.Param("node_id")
-	userID := c.GetString("userID")
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if !hasPermission {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "failed",
-			"message": "permission denied",
-			"data":    nil,
-		})
-	}
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "failed",
-			"message": err.Error(),
-			"data":    nil,
-		})
-	}
 	var input struct {
 		Title   string `json:"title"`
 		Content string `json:"content"`
+		Version int    `json:"version"` // 可选，也可以通过If-Match头传递
 	}
 	if err := c.ShouldBind(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -76,14 +48,50 @@ func UpdateNodeData(c *gin.Context) {
 		})
 		return
 	}
-	updatedNode, err := models.UpdateKnowledgeNode(config.DB, kbID, nodeID, input.Title, input.Content)
+
+	expectedVersion := input.Version
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		version, ok := parseNodeETag(ifMatch)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "failed",
+				"message": "Invalid If-Match header",
+				"data":    nil,
+			})
+			return
+		}
+		expectedVersion = version
+	}
+
+	updatedNode, err := s.Nodes.Update(kbID, nodeID, c.GetString("userID"), input.Title, input.Content, expectedVersion)
+	if errors.Is(err, models.ErrVersionConflict) {
+		// 返回服务器上的最新版本，方便客户端合并
+		current, getErr := s.Nodes.Get(kbID, nodeID)
+		if getErr != nil {
+			c.JSON(nodeErrorStatus(getErr), gin.H{
+				"status":  "failed",
+				"message": getErr.Error(),
+				"data":    nil,
+			})
+			return
+		}
+		c.Header("ETag", nodeETag(current.Version))
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  "conflict",
+			"message": err.Error(),
+			"data":    current,
+		})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(nodeErrorStatus(err), gin.H{
 			"status":  "failed",
 			"message": err.Error(),
 			"data":    nil,
 		})
+		return
 	}
+	c.Header("ETag", nodeETag(updatedNode.Version))
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Updated Knowledge Node " + nodeID,
@@ -91,66 +99,32 @@ func UpdateNodeData(c *gin.Context) {
 	})
 }
 
-func DeleteNodeData(c *gin.Context) {
+func (s *Server) DeleteNodeData(c *gin.Context) {
 	kbID := c.Param("kb_id")
 	nodeID := c.Param("node_id")
-	userID := c.GetString("userID")
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if !hasPermission {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "failed",
-			"message": "permission denied",
-			"data":    nil,
-		})
-	}
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "failed",
-			"message": err.Error(),
-			"data":    nil,
-		})
-	}
-	err = models.DeleteKnowledgeNode(config.DB, kbID, nodeID)
+	err := s.Nodes.Delete(kbID, nodeID, c.GetString("userID"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(nodeErrorStatus(err), gin.H{
 			"status":  "failed",
 			"message": err.Error(),
 			"data":    nil,
 		})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "Deleted Knowledge Node " + nodeID,
+		"message": "Moved Knowledge Node " + nodeID + " to trash",
 		"data":    nil,
 	})
 }
 
 // MoveNode 移动节点到新位置
-func MoveNode(c *gin.Context) {
+func (s *Server) MoveNode(c *gin.Context) {
 	// 获取参数
 	kbID := c.Param("kb_id")
 	dragID := c.Param("node_id") // 要移动的节点ID
-	userID := c.GetString("userID")
 
-	// 1. 验证权限
-	hasPermission, err := models.CheckKBPermission(config.DB, kbID, userID, 0)
-	if err != nil {
-		log.Printf("权限检查失败 - KB: %s, 用户: %s, 错误: %v", kbID, userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "权限验证服务不可用",
-		})
-		return
-	}
-	if !hasPermission {
-		c.JSON(http.StatusForbidden, gin.H{
-			"status":  "denied",
-			"message": "没有修改知识库的权限",
-		})
-		return
-	}
-
-	// 2. 解析请求体
+	// 1. 解析请求体（权限已由中间件校验）
 	var req struct {
 		TargetID string `json:"target_id"` // 目标节点ID
 		Position string `json:"position"`  // 位置类型: before/after/inside
@@ -163,7 +137,7 @@ func MoveNode(c *gin.Context) {
 		return
 	}
 
-	// 3. 验证位置参数
+	// 2. 验证位置参数
 	validPositions := map[string]bool{"before": true, "after": true, "inside": true}
 	if !validPositions[req.Position] {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -173,30 +147,20 @@ func MoveNode(c *gin.Context) {
 		return
 	}
 
-	// 4. 执行移动操作
-	if err := models.MoveNode(config.DB, kbID, dragID, req.TargetID, req.Position); err != nil {
+	// 3. 执行移动操作
+	if err := s.Nodes.Move(kbID, dragID, req.TargetID, req.Position); err != nil {
 		log.Printf("节点移动失败 - KB: %s, 节点: %s, 目标: %s, 位置: %s, 错误: %v",
 			kbID, dragID, req.TargetID, req.Position, err)
 
-		status := http.StatusInternalServerError
-		errorMsg := err.Error()
-
-		// 对特定错误提供更友好的提示
-		if strings.Contains(errorMsg, "converting NULL") {
-			errorMsg = "系统数据处理错误，请联系管理员"
-		} else if strings.Contains(errorMsg, "cannot move") {
-			status = http.StatusBadRequest
-		}
-
-		c.JSON(status, gin.H{
+		c.JSON(nodeErrorStatus(err), gin.H{
 			"status":  "failed",
-			"message": errorMsg,
+			"message": err.Error(),
 		})
 		return
 	}
 
-	// 5. 获取更新后的树结构
-	tree, err := models.GetKnowledgeTree(config.DB, kbID)
+	// 4. 获取更新后的树结构
+	tree, err := s.Nodes.Tree(kbID)
 	if err != nil {
 		log.Printf("获取树结构失败 - KB: %s, 错误: %v", kbID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -207,7 +171,7 @@ func MoveNode(c *gin.Context) {
 		return
 	}
 
-	// 6. 记录成功日志
+	// 5. 记录成功日志
 	log.Printf("节点移动成功 - KB: %s, 节点: %s → 目标: %s (%s)",
 		kbID, dragID, req.TargetID, req.Position)
 
@@ -223,3 +187,37 @@ func MoveNode(c *gin.Context) {
 		"data": tree,
 	})
 }
+
+// nodeETag 由节点版本号生成ETag
+func nodeETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseNodeETag 解析If-Match头中的版本号，"*"表示不检查版本
+func parseNodeETag(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return 0, true
+	}
+	value = strings.TrimPrefix(value, "W/")
+	value = strings.Trim(value, `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// nodeErrorStatus 将节点操作的错误映射为HTTP状态码
+func nodeErrorStatus(err error) int {
+	if errors.Is(err, models.ErrNodeNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, models.ErrVersionConflict) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, models.ErrMoveIntoSelf) || errors.Is(err, models.ErrMoveIntoFile) || errors.Is(err, models.ErrInvalidPosition) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
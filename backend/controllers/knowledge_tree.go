@@ -2,16 +2,15 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
-	"knowledge_master_backend/config"
 	"knowledge_master_backend/models"
 	"net/http"
 )
 
 // 获取知识库树形结构
-func GetKnowledgeTree(c *gin.Context) {
+func (s *Server) GetKnowledgeTree(c *gin.Context) {
 	kbID := c.Param("kb_id")
 
-	nodes, err := models.GetKnowledgeTree(config.DB, kbID)
+	nodes, err := s.Nodes.Tree(kbID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "failed",
@@ -28,9 +27,8 @@ func GetKnowledgeTree(c *gin.Context) {
 }
 
 // 添加节点
-func AddKnowledgeNode(c *gin.Context) {
+func (s *Server) AddKnowledgeNode(c *gin.Context) {
 	kbID := c.Param("kb_id")
-	userID := c.GetString("userID") // 从中间件获取
 
 	var input struct {
 		ParentID string `json:"parent_id"`
@@ -48,13 +46,16 @@ func AddKnowledgeNode(c *gin.Context) {
 		return
 	}
 
-	// 验证用户是否有权限操作该知识库
-	if !checkKBPermission(kbID, userID) {
-		c.JSON(http.StatusForbidden, gin.H{
-			"status":  "failed",
-			"message": "No permission",
-		})
-		return
+	// 父节点必须属于同一知识库
+	if input.ParentID != "" {
+		if _, err := s.Nodes.Get(kbID, input.ParentID); err != nil {
+			c.JSON(nodeErrorStatus(err), gin.H{
+				"status":  "failed",
+				"message": "Invalid parent node",
+				"error":   err.Error(),
+			})
+			return
+		}
 	}
 
 	node := &models.KnowledgeNode{
@@ -64,7 +65,7 @@ func AddKnowledgeNode(c *gin.Context) {
 		Content:  input.Content,
 	}
 
-	newNode, err := models.AddKnowledgeNode(config.DB, kbID, node)
+	newNode, err := s.Nodes.Add(kbID, node)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "failed",
@@ -79,7 +80,3 @@ func AddKnowledgeNode(c *gin.Context) {
 		"data":   newNode,
 	})
 }
-
-func checkKBPermission(kbID, userID string) bool {
-	return true
-}
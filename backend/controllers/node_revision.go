@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"net/http"
+)
+
+// diff接口中表示节点当前版本的特殊修订ID
+const currentRevision = "current"
+
+// 获取节点修订历史
+func GetNodeRevisions(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+
+	if _, err := models.GetKnowledgeNode(config.DB, kbID, nodeID); err != nil {
+		c.JSON(nodeErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	revisions, err := models.GetNodeRevisions(config.DB, kbID, nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get revisions",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Revisions retrieved",
+		"data":    revisions,
+	})
+}
+
+// 获取单个修订的完整内容
+func GetNodeRevision(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+	revisionID := c.Param("revision_id")
+
+	rev, err := models.GetNodeRevision(config.DB, kbID, nodeID, revisionID)
+	if err != nil {
+		c.JSON(revisionErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Revision retrieved",
+		"data":    rev,
+	})
+}
+
+// 比较两个修订的Markdown内容，from/to为修订ID或current（节点当前内容）
+func DiffNodeRevisions(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+	fromID := c.Query("from")
+	toID := c.DefaultQuery("to", currentRevision)
+
+	if fromID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "from is required",
+		})
+		return
+	}
+
+	from, err := loadRevisionForDiff(kbID, nodeID, fromID)
+	if err != nil {
+		c.JSON(revisionErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+	to, err := loadRevisionForDiff(kbID, nodeID, toID)
+	if err != nil {
+		c.JSON(revisionErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	lines := utils.DiffLines(from.Content, to.Content)
+	added, removed := 0, 0
+	for _, line := range lines {
+		switch line.Op {
+		case utils.DiffInsert:
+			added++
+		case utils.DiffDelete:
+			removed++
+		}
+	}
+
+	// 内容只在lines中返回一次
+	from.Content, to.Content = "", ""
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Diff computed",
+		"data": gin.H{
+			"from":          from,
+			"to":            to,
+			"title_changed": from.Title != to.Title,
+			"added":         added,
+			"removed":       removed,
+			"lines":         lines,
+		},
+	})
+}
+
+// loadRevisionForDiff 加载修订，current表示节点当前版本
+func loadRevisionForDiff(kbID, nodeID, revisionID string) (*models.NodeRevision, error) {
+	if revisionID != currentRevision {
+		return models.GetNodeRevision(config.DB, kbID, nodeID, revisionID)
+	}
+
+	node, err := models.GetKnowledgeNode(config.DB, kbID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.NodeRevision{
+		RevisionID: currentRevision,
+		NodeID:     node.NodeID,
+		Title:      node.Title,
+		Content:    node.Content,
+		CreatedAt:  node.UpdatedAt,
+	}, nil
+}
+
+// 将节点恢复为指定修订，作为新的最新版本
+func RestoreNodeRevision(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+	revisionID := c.Param("revision_id")
+	userID := c.GetString("userID")
+
+	node, err := models.RestoreNodeRevision(config.DB, kbID, nodeID, revisionID, userID)
+	if err != nil {
+		c.JSON(revisionErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Restored revision " + revisionID,
+		"data":    node,
+	})
+}
+
+// revisionErrorStatus 将修订操作的错误映射为HTTP状态码
+func revisionErrorStatus(err error) int {
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		return http.StatusNotFound
+	}
+	return nodeErrorStatus(err)
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 从跳转到提供方到回调的期限
+const oidcLoginStateTTL = 10 * time.Minute
+
+// oidcEnabled 未配置单点登录时返回404
+func (s *Server) oidcEnabled(c *gin.Context) bool {
+	if s.OIDC == nil || s.Identities == nil {
+		c.JSON(http.StatusNotFound, apiResponse{
+			Status:  "failed",
+			Message: "未配置单点登录",
+			Data:    nil,
+		})
+		return false
+	}
+	return true
+}
+
+// OIDCAuthorize 开始单点登录，返回提供方登录页的地址
+// 前端跳转到该地址，提供方登录后带着code和state重定向到配置的redirect_url。
+func (s *Server) OIDCAuthorize(c *gin.Context) {
+	if !s.oidcEnabled(c) {
+		return
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("生成单点登录状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法开始单点登录", Data: nil})
+		return
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("生成单点登录状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法开始单点登录", Data: nil})
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		log.Printf("生成单点登录状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法开始单点登录", Data: nil})
+		return
+	}
+
+	authURL, err := s.OIDC.AuthCodeURL(c.Request.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		log.Printf("读取单点登录提供方配置失败: %v", err)
+		c.JSON(http.StatusBadGateway, apiResponse{
+			Status:  "failed",
+			Message: "单点登录服务暂时不可用",
+			Data:    nil,
+		})
+		return
+	}
+	err = s.Identities.SaveLoginState(utils.HashToken(state), &models.OIDCLoginState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginStateTTL),
+	})
+	if err != nil {
+		log.Printf("保存单点登录状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法开始单点登录", Data: nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "请前往单点登录页面",
+		Data: gin.H{
+			"authorization_url": authURL,
+			"state":             state,
+			"expires_in":        int(oidcLoginStateTTL.Seconds()),
+		},
+	})
+}
+
+// OIDCCallback 完成单点登录：兑换授权码、校验ID令牌，找到或创建用户后签发本服务的令牌
+// 外部身份第一次登录时按提供方验证过的邮箱关联已有用户，没有时创建用户和用户资料。
+// 用户启用了两步验证时与密码登录一样先返回登录验证令牌。
+func (s *Server) OIDCCallback(c *gin.Context) {
+	if !s.oidcEnabled(c) {
+		return
+	}
+	var input struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	state, err := s.Identities.TakeLoginState(utils.HashToken(input.State))
+	if errors.Is(err, models.ErrLoginStateInvalid) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "单点登录已失效，请重新登录",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("读取单点登录状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{Status: "failed", Message: "无法完成单点登录", Data: nil})
+		return
+	}
+
+	ctx := c.Request.Context()
+	rawToken, err := s.OIDC.Exchange(ctx, input.Code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("兑换授权码失败: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, oidc.ErrExchangeFailed) {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, apiResponse{Status: "failed", Message: "单点登录失败", Data: nil})
+		return
+	}
+	claims, err := s.OIDC.VerifyIDToken(ctx, rawToken, state.Nonce)
+	if err != nil {
+		log.Printf("校验ID令牌失败: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, apiResponse{Status: "failed", Message: "单点登录失败", Data: nil})
+		return
+	}
+
+	user, status, message := s.oidcUser(claims)
+	if user == nil {
+		c.JSON(status, apiResponse{Status: "failed", Message: message, Data: nil})
+		return
+	}
+	s.finishLogin(c, user)
+}
+
+// oidcUser 找到外部身份关联的用户，未关联时按邮箱关联或创建用户
+// 失败时user为nil，status和message为返回给客户端的状态码和信息。
+func (s *Server) oidcUser(claims *oidc.Claims) (user *models.User, status int, message string) {
+	issuer := s.OIDC.Issuer()
+	userID, err := s.Identities.FindUser(issuer, claims.Subject)
+	if err == nil {
+		user, err = s.Users.GetCredentials(userID)
+		if err != nil {
+			log.Printf("查询单点登录用户失败 - 用户: %s, 错误: %v", userID, err)
+			return nil, http.StatusInternalServerError, "无法完成单点登录"
+		}
+		return user, 0, ""
+	}
+	if !errors.Is(err, models.ErrIdentityNotFound) {
+		log.Printf("查询外部身份失败: %v", err)
+		return nil, http.StatusInternalServerError, "无法完成单点登录"
+	}
+
+	// 只有提供方验证过的邮箱才能用于关联或创建用户，否则可以冒用他人的邮箱
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return nil, http.StatusForbidden, "单点登录账号的邮箱未经验证，无法登录"
+	}
+
+	user, err = s.Users.GetByEmail(email)
+	switch {
+	case err == nil:
+		// 本地邮箱未验证时，账号可能是他人抢先注册的，关联后清除其密码、会话、访问令牌和两步验证
+		if !user.EmailVerified {
+			if err := s.Users.SetPassword(user.UserID, ""); err != nil {
+				log.Printf("清除未验证账号的密码失败 - 用户: %s, 错误: %v", user.UserID, err)
+				return nil, http.StatusInternalServerError, "无法完成单点登录"
+			}
+			if _, err := s.Sessions.RevokeAll(user.UserID); err != nil {
+				log.Printf("撤销未验证账号的会话失败 - 用户: %s, 错误: %v", user.UserID, err)
+				return nil, http.StatusInternalServerError, "无法完成单点登录"
+			}
+			if _, err := s.AccessTokens.RevokeAll(user.UserID); err != nil {
+				log.Printf("撤销未验证账号的访问令牌失败 - 用户: %s, 错误: %v", user.UserID, err)
+				return nil, http.StatusInternalServerError, "无法完成单点登录"
+			}
+			// 抢先注册的人设置的两步验证会把真正的主人挡在外面
+			if err := s.TwoFactor.Disable(user.UserID); err != nil && !errors.Is(err, models.ErrTOTPNotEnrolled) {
+				log.Printf("清除未验证账号的两步验证失败 - 用户: %s, 错误: %v", user.UserID, err)
+				return nil, http.StatusInternalServerError, "无法完成单点登录"
+			}
+		}
+	case errors.Is(err, models.ErrUserNotFound):
+		if user, err = s.createOIDCUser(email, claims); err != nil {
+			log.Printf("创建单点登录用户失败: %v", err)
+			return nil, http.StatusInternalServerError, "无法创建用户"
+		}
+	default:
+		log.Printf("查询用户失败: %v", err)
+		return nil, http.StatusInternalServerError, "无法完成单点登录"
+	}
+
+	if err := s.Identities.Link(issuer, claims.Subject, user.UserID, email); err != nil {
+		log.Printf("关联外部身份失败 - 用户: %s, 错误: %v", user.UserID, err)
+		return nil, http.StatusInternalServerError, "无法完成单点登录"
+	}
+	// 能走到这里的邮箱都经过提供方验证，可以认领发往它的邀请
+	if err := s.Users.MarkEmailVerified(user.UserID, user.Email); err != nil {
+		log.Printf("标记邮箱已验证失败 - 用户: %s, 错误: %v", user.UserID, err)
+	} else {
+		s.claimInvites(user.UserID, user.Email)
+	}
+	return s.reloadUser(user), 0, ""
+}
+
+// createOIDCUser 第一次单点登录时创建用户和用户资料，用户没有密码，只能通过单点登录或重置密码登录
+func (s *Server) createOIDCUser(email string, claims *oidc.Claims) (*models.User, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+	if username == "" {
+		username = email[:strings.Index(email+"@", "@")]
+	}
+	avatar := claims.Picture
+	if avatar == "" {
+		avatar = "https://avatar.iran.liara.run/public"
+	}
+
+	user, err := s.Users.Create(email, "", username)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Users.CreateProfile(&models.UserProfile{
+		UserID:      user.UserID,
+		Email:       user.Email,
+		Username:    user.Username,
+		Description: "是否尝试留下些什么...",
+		Website:     "http://example.com",
+		AvatarURI:   avatar,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// reloadUser 重新读取用户以返回最新的邮箱验证状态，失败时沿用原来的信息
+func (s *Server) reloadUser(user *models.User) *models.User {
+	if fresh, err := s.Users.GetCredentials(user.UserID); err == nil {
+		return fresh
+	}
+	return user
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/oidc/oidctest"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type oidcLoginData struct {
+	tokenData
+	User struct {
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		Username      string `json:"username"`
+		EmailVerified bool   `json:"email_verified"`
+	} `json:"user"`
+}
+
+func TestOIDCLogin(t *testing.T) {
+	utils.InitJWT("0123456789abcdef0123456789abcdef", 15*time.Minute)
+	srv := NewServer(repository.NewMemory(), nil)
+	srv.Mailer = &recordingMailer{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+	r.POST("/login", srv.Login)
+	r.GET("/oidc/authorize", srv.OIDCAuthorize)
+	r.POST("/oidc/callback", srv.OIDCCallback)
+	api := r.Group("/api", middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, nil))
+	api.PUT("/user/password", srv.ChangePassword)
+	api.POST("/user/2fa/enroll", srv.EnrollTwoFactor)
+	api.POST("/user/2fa/confirm", srv.ConfirmTwoFactor)
+	api.POST("/user/2fa/disable", srv.DisableTwoFactor)
+	api.POST("/user/2fa/recovery-codes", srv.RegenerateRecoveryCodes)
+
+	if code := doJSON(t, r, "GET", "/oidc/authorize", "", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("authorize without OIDC: status %d, want 404", code)
+	}
+
+	idp := oidctest.NewProvider(t, "km", "secret")
+	provider, err := oidc.New(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "km",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/oidc/callback",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OIDC = provider
+
+	// login 走完一次单点登录，返回回调的状态码和数据
+	login := func(identity oidctest.Identity) (oidcLoginData, int) {
+		t.Helper()
+		var started struct {
+			Data struct {
+				AuthorizationURL string `json:"authorization_url"`
+				State            string `json:"state"`
+			} `json:"data"`
+		}
+		if code := doJSON(t, r, "GET", "/oidc/authorize", "", nil, &started); code != http.StatusOK {
+			t.Fatalf("authorize: status %d", code)
+		}
+		callback, err := idp.Login(started.Data.AuthorizationURL, identity)
+		if err != nil {
+			t.Fatalf("provider login: %v", err)
+		}
+		if callback.Query().Get("state") != started.Data.State {
+			t.Fatalf("state = %q, want %q", callback.Query().Get("state"), started.Data.State)
+		}
+		var resp struct {
+			Data oidcLoginData `json:"data"`
+		}
+		body := gin.H{"code": callback.Query().Get("code"), "state": started.Data.State}
+		status := doJSON(t, r, "POST", "/oidc/callback", "", body, &resp)
+		return resp.Data, status
+	}
+
+	// 第一次登录创建用户，邮箱视为已验证
+	ann := oidctest.Identity{Subject: "ann", Email: "ann@example.com", EmailVerified: true, Name: "Ann", PreferredUsername: "ann.a"}
+	first, code := login(ann)
+	if code != http.StatusOK || first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("first login: status %d, data %+v", code, first)
+	}
+	if first.User.Email != "ann@example.com" || first.User.Username != "ann.a" || !first.User.EmailVerified {
+		t.Fatalf("created user = %+v", first.User)
+	}
+	if userID, _, err := utils.ParseToken(first.Token); err != nil || userID != first.User.ID {
+		t.Fatalf("issued token: user %q, err %v", userID, err)
+	}
+	if _, err := srv.Users.GetProfile(first.User.ID); err != nil {
+		t.Fatalf("profile of created user: %v", err)
+	}
+
+	// 没有密码的用户不能修改密码，只能通过邮件设置；两步验证改用验证码确认身份
+	h := authorized(r, first.Token)
+	if code := doJSON(t, h, "PUT", "/api/user/password", "", gin.H{"current_password": "x", "new_password": "secret1"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("change password without a password: status %d, want 400", code)
+	}
+	var enrolled struct {
+		Data struct {
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/enroll", "", nil, &enrolled); code != http.StatusOK {
+		t.Fatalf("enroll: status %d", code)
+	}
+	step := utils.TOTPStep(time.Now())
+	codeAt := func(offset int64) string {
+		code, err := utils.TOTPCode(enrolled.Data.Secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	var confirmed struct {
+		Data struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/confirm", "", gin.H{"code": codeAt(0)}, &confirmed); code != http.StatusOK {
+		t.Fatalf("confirm: status %d", code)
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/recovery-codes", "", gin.H{}, nil); code != http.StatusBadRequest {
+		t.Fatalf("regenerate recovery codes without a code: status %d, want 400", code)
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/recovery-codes", "", gin.H{"code": confirmed.Data.RecoveryCodes[0]}, nil); code != http.StatusOK {
+		t.Fatalf("regenerate recovery codes with a recovery code: status %d", code)
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/disable", "", gin.H{"code": codeAt(1)}, nil); code != http.StatusOK {
+		t.Fatalf("disable 2FA with a code: status %d", code)
+	}
+
+	// 之后按外部身份找到同一个用户，即使提供方上的邮箱变了
+	ann.Email = "ann@new.example.com"
+	again, code := login(ann)
+	if code != http.StatusOK || again.User.ID != first.User.ID {
+		t.Fatalf("second login: status %d, user %+v, want %s", code, again.User, first.User.ID)
+	}
+
+	// state只能使用一次
+	var started struct {
+		Data struct {
+			AuthorizationURL string `json:"authorization_url"`
+			State            string `json:"state"`
+		} `json:"data"`
+	}
+	doJSON(t, r, "GET", "/oidc/authorize", "", nil, &started)
+	callback, _ := idp.Login(started.Data.AuthorizationURL, ann)
+	body := gin.H{"code": callback.Query().Get("code"), "state": started.Data.State}
+	if code := doJSON(t, r, "POST", "/oidc/callback", "", body, nil); code != http.StatusOK {
+		t.Fatalf("callback: status %d", code)
+	}
+	if code := doJSON(t, r, "POST", "/oidc/callback", "", body, nil); code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: status %d, want 400", code)
+	}
+	if code := doJSON(t, r, "POST", "/oidc/callback", "", gin.H{"code": "x", "state": "unknown"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown state: status %d, want 400", code)
+	}
+
+	// 提供方未验证的邮箱不能用于关联或创建用户
+	if _, code := login(oidctest.Identity{Subject: "eve", Email: "eve@example.com"}); code != http.StatusForbidden {
+		t.Fatalf("unverified email: status %d, want 403", code)
+	}
+
+	// 已注册但邮箱未验证的用户按邮箱关联，原密码不再可用
+	credentials := gin.H{"email": "bob@example.com", "password": "secret1", "username": "bob"}
+	var registered struct {
+		Data struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, r, "POST", "/register", "", credentials, &registered); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	// 抢先注册的人创建的访问令牌和两步验证同样被清除
+	squatterID := registered.Data.User.ID
+	_, err = srv.AccessTokens.Create(&models.AccessToken{
+		UserID: squatterID,
+		Name:   "backdoor",
+		Prefix: "kmpat_backdoor",
+		Scopes: []models.AccessTokenScope{models.ScopeAdmin},
+	}, utils.HashToken("kmpat_backdoor"))
+	if err == nil {
+		err = srv.TwoFactor.Enroll(squatterID, "JBSWY3DPEHPK3PXP")
+	}
+	if err == nil {
+		err = srv.TwoFactor.Enable(squatterID, 1, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, code := login(oidctest.Identity{Subject: "bob", Email: "bob@example.com", EmailVerified: true})
+	if code != http.StatusOK || bob.User.ID != registered.Data.User.ID || !bob.User.EmailVerified {
+		t.Fatalf("linking login: status %d, user %+v, want %s", code, bob.User, registered.Data.User.ID)
+	}
+	if code := doJSON(t, r, "POST", "/login", "", credentials, nil); code != http.StatusUnauthorized {
+		t.Fatalf("password login after linking an unverified account: status %d, want 401", code)
+	}
+	if tokens, _ := srv.AccessTokens.ListForUser(squatterID); len(tokens) != 0 {
+		t.Fatalf("access tokens after linking an unverified account: %+v", tokens)
+	}
+	if _, err := srv.TwoFactor.Get(squatterID); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("2FA after linking an unverified account: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/exchange"
+	"knowledge_master_backend/models"
+	"net/http"
+)
+
+// 渲染文档的内容安全策略：只允许内嵌样式、内联图片和打印脚本
+const renderCSP = "default-src 'none'; img-src data: http: https:; style-src 'unsafe-inline'; script-src 'unsafe-inline'"
+
+// 将节点及其子树渲染为自包含的HTML文档
+// print=true 时打开后自动弹出打印对话框（可另存为PDF），download=true 时作为附件下载。
+func RenderNode(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+
+	root, err := models.GetKnowledgeSubtree(config.DB, kbID, nodeID)
+	if err != nil {
+		c.JSON(nodeErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	renderer := exchange.NewHTMLRenderer(exchange.NewImageInliner(c.Request.Context()))
+	if err := renderer.RenderNode(&buf, root, c.Query("print") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to render node",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	writeHTMLDocument(c, root.Title, buf.Bytes(), c.Query("download") == "true")
+}
+
+// exportHTML 将整个知识库导出为单个HTML文档
+func exportHTML(c *gin.Context, kb *models.KnowledgeBase) {
+	tree, err := models.GetKnowledgeTree(config.DB, kb.KBID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get knowledge tree",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	renderer := exchange.NewHTMLRenderer(exchange.NewImageInliner(c.Request.Context()))
+	if err := renderer.RenderTree(&buf, kb.Name, tree, c.Query("print") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to render knowledge base",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	writeHTMLDocument(c, kb.Name, buf.Bytes(), true)
+}
+
+func writeHTMLDocument(c *gin.Context, title string, doc []byte, download bool) {
+	const contentType = "text/html; charset=utf-8"
+	c.Header("Content-Security-Policy", renderCSP)
+	if download {
+		setAttachmentHeaders(c, contentType, exchange.SanitizeFileName(title)+".html")
+	}
+	c.Data(http.StatusOK, contentType, doc)
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// 全文检索节点
+func SearchNodes(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "q is required",
+		})
+		return
+	}
+
+	nodeType := c.Query("type")
+	if nodeType != "" && nodeType != "folder" && nodeType != "file" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"message": "type must be folder or file",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultSearchPageSize)))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	hits, total, err := models.SearchNodes(config.DB, models.SearchParams{
+		UserID:   c.GetString("userID"),
+		Query:    q,
+		KBID:     c.Query("kb_id"),
+		NodeType: nodeType,
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Search failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Search completed",
+		"data": gin.H{
+			"hits":      hits,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
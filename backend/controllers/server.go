@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"knowledge_master_backend/mail"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/storage"
+	"time"
+)
+
+const (
+	// 默认的刷新令牌有效期
+	defaultRefreshTTL = 30 * 24 * time.Hour
+	// 默认的前端地址，用于邮件中的链接
+	defaultLinkBaseURL = "http://localhost:3000"
+)
+
+// Server 用户、账号安全、登录会话、知识库、成员和节点相关的处理函数及其依赖
+// 依赖由调用者注入，测试中可以使用repository.NewMemory。
+// 其他处理函数（邀请、回收站、修订记录、快照、备份、搜索、附件、导入导出）仍是包级函数，直接使用config.DB。
+type Server struct {
+	Users      repository.UserRepository
+	KBs        repository.KnowledgeBaseRepository
+	Members    repository.MemberRepository
+	Nodes      repository.NodeRepository
+	Sessions   repository.SessionRepository
+	Tokens     repository.UserTokenRepository
+	TwoFactor  repository.TwoFactorRepository
+	Identities repository.IdentityRepository
+	// AccessTokens 个人访问令牌，供脚本和集成使用
+	AccessTokens repository.AccessTokenRepository
+	Storage      storage.Storage
+
+	// Mailer 发送验证邮箱和重置密码的邮件，默认只打印到日志
+	Mailer mail.Sender
+	// LinkBaseURL 邮件中链接指向的前端地址
+	LinkBaseURL string
+
+	// OIDC 单点登录的提供方，为nil时不启用单点登录
+	OIDC *oidc.Provider
+
+	// RefreshTTL 刷新令牌的有效期，每次刷新后重新计算
+	RefreshTTL time.Duration
+
+	// ClaimInvites 邮箱验证后认领发往该邮箱的知识库邀请，返回认领的数量；为nil时不认领
+	ClaimInvites func(userID, email string) (int, error)
+}
+
+func NewServer(repos *repository.Repositories, store storage.Storage) *Server {
+	return &Server{
+		Users:        repos.Users,
+		KBs:          repos.KBs,
+		Members:      repos.Members,
+		Nodes:        repos.Nodes,
+		Sessions:     repos.Sessions,
+		Tokens:       repos.Tokens,
+		TwoFactor:    repos.TwoFactor,
+		Identities:   repos.Identities,
+		AccessTokens: repos.AccessTokens,
+		Storage:      store,
+		Mailer:       &mail.Log{},
+		LinkBaseURL:  defaultLinkBaseURL,
+		RefreshTTL:   defaultRefreshTTL,
+	}
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestRouter 使用内存存取实现的路由，请求头X-User-ID代替登录
+func newTestRouter(srv *Server) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+
+	api := r.Group("/api", func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User-ID"))
+	})
+	api.GET("/user/info", srv.GetUserInfo)
+	api.POST("/knowledge-bases", srv.CreateKnowledgeBase)
+	api.GET("/knowledge-bases/:kb_id/tree", srv.GetKnowledgeTree)
+	api.POST("/knowledge-bases/:kb_id/tree", srv.AddKnowledgeNode)
+	api.POST("/knowledge-bases/:kb_id/nodes/:node_id/move", srv.MoveNode)
+	return r
+}
+
+func doJSON(t *testing.T, r http.Handler, method, path, userID string, body interface{}, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", userID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestServerWithMemoryRepositories(t *testing.T) {
+	srv := NewServer(repository.NewMemory(), nil)
+	r := newTestRouter(srv)
+
+	var registered struct {
+		Data struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"data"`
+	}
+	input := gin.H{"email": "a@example.com", "password": "secret1", "username": "alice"}
+	if code := doJSON(t, r, "POST", "/register", "", input, &registered); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	if code := doJSON(t, r, "POST", "/register", "", input, nil); code != http.StatusConflict {
+		t.Fatalf("register twice: status %d, want 409", code)
+	}
+	userID := registered.Data.User.ID
+
+	var info struct {
+		Data UserInfoResponse `json:"data"`
+	}
+	if code := doJSON(t, r, "GET", "/api/user/info", userID, nil, &info); code != http.StatusOK {
+		t.Fatalf("user info: status %d", code)
+	}
+	if info.Data.Email != "a@example.com" || info.Data.Username != "alice" {
+		t.Fatalf("user info = %+v", info.Data)
+	}
+
+	var kb struct {
+		Data struct {
+			KBID string `json:"kb_id"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, r, "POST", "/api/knowledge-bases", userID, gin.H{"name": "notes"}, &kb); code != http.StatusCreated {
+		t.Fatalf("create knowledge base: status %d", code)
+	}
+	treePath := "/api/knowledge-bases/" + kb.Data.KBID + "/tree"
+
+	add := func(parentID, typ, name string) string {
+		var node struct {
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		body := gin.H{"parent_id": parentID, "type": typ, "name": name}
+		if code := doJSON(t, r, "POST", treePath, userID, body, &node); code != http.StatusCreated {
+			t.Fatalf("add %s: status %d", name, code)
+		}
+		return node.Data.ID
+	}
+	folder := add("", "folder", "folder")
+	child := add(folder, "file", "child")
+	file := add("", "file", "file")
+
+	movePath := func(nodeID string) string {
+		return "/api/knowledge-bases/" + kb.Data.KBID + "/nodes/" + nodeID + "/move"
+	}
+	moves := []struct {
+		node, target, position string
+		want                   int
+	}{
+		{folder, child, "inside", http.StatusBadRequest},
+		{file, child, "inside", http.StatusBadRequest},
+		{file, "00000000-0000-4000-8000-000000000000", "after", http.StatusNotFound},
+		{file, folder, "inside", http.StatusOK},
+	}
+	for _, m := range moves {
+		body := gin.H{"target_id": m.target, "position": m.position}
+		if code := doJSON(t, r, "POST", movePath(m.node), userID, body, nil); code != m.want {
+			t.Fatalf("move %s %s %s: status %d, want %d", m.node, m.position, m.target, code, m.want)
+		}
+	}
+
+	var tree struct {
+		Data []struct {
+			Name     string `json:"name"`
+			Children []struct {
+				Name string `json:"name"`
+			} `json:"children"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, r, "GET", treePath, userID, nil, &tree); code != http.StatusOK {
+		t.Fatalf("tree: status %d", code)
+	}
+	if len(tree.Data) != 1 || len(tree.Data[0].Children) != 2 || tree.Data[0].Children[1].Name != "file" {
+		t.Fatalf("tree = %+v", tree.Data)
+	}
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// 保存的User-Agent的最大长度
+const maxUserAgentLength = 512
+
+// startSession 为登录的用户创建会话，返回访问令牌和刷新令牌
+func (s *Server) startSession(c *gin.Context, userID string) (gin.H, error) {
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session, err := s.Sessions.Create(&models.Session{
+		UserID:    userID,
+		Device:    utils.DescribeDevice(userAgent),
+		UserAgent: userAgent,
+		IP:        c.ClientIP(),
+		ExpiresAt: time.Now().Add(s.RefreshTTL),
+	}, utils.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokenResponse(userID, session, refreshToken)
+}
+
+// tokenResponse 为会话生成访问令牌，和刷新令牌一起返回给客户端
+func (s *Server) tokenResponse(userID string, session *models.Session, refreshToken string) (gin.H, error) {
+	token, err := utils.GenerateToken(userID, session.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":              token,
+		"expires_in":         int(utils.AccessTokenTTL() / time.Second),
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": session.ExpiresAt,
+		"session_id":         session.SessionID,
+	}, nil
+}
+
+// RefreshToken 用刷新令牌换取新的访问令牌，刷新令牌同时轮换，旧的立即失效
+func (s *Server) RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求凭证",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	newToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+
+	session, err := s.Sessions.Rotate(utils.HashToken(input.RefreshToken), utils.HashToken(newToken),
+		time.Now().Add(s.RefreshTTL), c.ClientIP())
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		log.Printf("刷新令牌被重复使用，已撤销会话 - IP: %s", c.ClientIP())
+	}
+	if errors.Is(err, models.ErrSessionNotFound) || errors.Is(err, models.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, apiResponse{
+			Status:  "failed",
+			Message: "登录已失效，请重新登录",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("刷新令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+
+	tokens, err := s.tokenResponse(session.UserID, session, newToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "刷新成功",
+		Data:    tokens,
+	})
+}
+
+// Logout 退出当前会话
+func (s *Server) Logout(c *gin.Context) {
+	err := s.Sessions.Revoke(c.GetString("userID"), c.GetString("sessionID"))
+	if err != nil && !errors.Is(err, models.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "退出登录失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "已退出登录",
+		Data:    nil,
+	})
+}
+
+// LogoutAll 退出全部会话，包括当前会话
+func (s *Server) LogoutAll(c *gin.Context) {
+	n, err := s.Sessions.RevokeAll(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "退出登录失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "已退出全部设备",
+		Data:    gin.H{"revoked": n},
+	})
+}
+
+// GetSessions 当前用户的有效会话
+func (s *Server) GetSessions(c *gin.Context) {
+	sessions, err := s.Sessions.ListForUser(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "获取会话失败",
+			Data:    nil,
+		})
+		return
+	}
+	current := c.GetString("sessionID")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == current
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "Sessions retrieved",
+		Data:    sessions,
+	})
+}
+
+// RevokeSession 撤销当前用户的一个会话，该会话的访问令牌和刷新令牌立即失效
+func (s *Server) RevokeSession(c *gin.Context) {
+	err := s.Sessions.Revoke(c.GetString("userID"), c.Param("session_id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, apiResponse{
+			Status:  "failed",
+			Message: err.Error(),
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "Session revoked",
+		Data:    nil,
+	})
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type tokenData struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	SessionID    string `json:"session_id"`
+}
+
+func TestSessions(t *testing.T) {
+	utils.InitJWT("0123456789abcdef0123456789abcdef", 15*time.Minute)
+	srv := NewServer(repository.NewMemory(), nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+	r.POST("/login", srv.Login)
+	r.POST("/refresh", srv.RefreshToken)
+	api := r.Group("/api", middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, nil))
+	api.GET("/user/info", srv.GetUserInfo)
+	api.GET("/user/sessions", srv.GetSessions)
+	api.DELETE("/user/sessions/:session_id", srv.RevokeSession)
+	api.POST("/logout", srv.Logout)
+	api.POST("/logout-all", srv.LogoutAll)
+
+	credentials := gin.H{"email": "s@example.com", "password": "secret1", "username": "sam"}
+	if code := doJSON(t, r, "POST", "/register", "", credentials, nil); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	login := func() tokenData {
+		var resp struct {
+			Data tokenData `json:"data"`
+		}
+		if code := doJSON(t, r, "POST", "/login", "", credentials, &resp); code != http.StatusOK {
+			t.Fatalf("login: status %d", code)
+		}
+		return resp.Data
+	}
+	refresh := func(refreshToken string) (tokenData, int) {
+		var resp struct {
+			Data tokenData `json:"data"`
+		}
+		code := doJSON(t, r, "POST", "/refresh", "", gin.H{"refresh_token": refreshToken}, &resp)
+		return resp.Data, code
+	}
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	first := login()
+	if call("GET", "/api/user/info", first.Token) != http.StatusOK {
+		t.Fatal("access token of a new session rejected")
+	}
+
+	// 刷新后旧的刷新令牌失效，再次使用会撤销整个会话
+	rotated, code := refresh(first.RefreshToken)
+	if code != http.StatusOK || rotated.SessionID != first.SessionID || rotated.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: status %d, %+v", code, rotated)
+	}
+	if _, code := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, want 401", code)
+	}
+	if call("GET", "/api/user/info", rotated.Token) != http.StatusUnauthorized {
+		t.Fatal("access token still accepted after refresh token reuse")
+	}
+
+	second := login()
+	third := login()
+	var sessions struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Current bool   `json:"current"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, authorized(r, second.Token), "GET", "/api/user/sessions", "", nil, &sessions); code != http.StatusOK {
+		t.Fatalf("list sessions: status %d", code)
+	}
+	if len(sessions.Data) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(sessions.Data))
+	}
+	for _, s := range sessions.Data {
+		if s.Current != (s.ID == second.SessionID) {
+			t.Fatalf("current flag of session %s is %v", s.ID, s.Current)
+		}
+	}
+	if code := call("DELETE", "/api/user/sessions/"+third.SessionID, second.Token); code != http.StatusOK {
+		t.Fatalf("revoke session: status %d", code)
+	}
+	if call("GET", "/api/user/info", third.Token) != http.StatusUnauthorized {
+		t.Fatal("access token of a revoked session accepted")
+	}
+	if _, code := refresh(third.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("refresh revoked session: status %d, want 401", code)
+	}
+
+	if code := call("POST", "/api/logout", second.Token); code != http.StatusOK {
+		t.Fatalf("logout: status %d", code)
+	}
+	if call("GET", "/api/user/info", second.Token) != http.StatusUnauthorized {
+		t.Fatal("access token accepted after logout")
+	}
+
+	fourth, fifth := login(), login()
+	if code := call("POST", "/api/logout-all", fourth.Token); code != http.StatusOK {
+		t.Fatalf("logout-all: status %d", code)
+	}
+	for _, s := range []tokenData{fourth, fifth} {
+		if call("GET", "/api/user/info", s.Token) != http.StatusUnauthorized {
+			t.Fatal("access token accepted after logout-all")
+		}
+	}
+}
+
+// authorized 在每个请求上加上访问令牌
+func authorized(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
+	"knowledge_master_backend/models"
+	"net/http"
+)
+
+// 获取知识库回收站中的节点
+func GetNodeTrash(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	items, err := models.GetTrashedNodes(config.DB, kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get trash",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Trash retrieved",
+		"data":    items,
+	})
+}
+
+// 从回收站恢复节点子树
+func RestoreTrashedNode(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+
+	node, err := models.RestoreTrashedNode(config.DB, kbID, nodeID)
+	if err != nil {
+		c.JSON(trashErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Restored Knowledge Node " + nodeID,
+		"data":    node,
+	})
+}
+
+// 彻底删除回收站中的节点子树（需要管理权限）
+func PurgeTrashedNode(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	nodeID := c.Param("node_id")
+
+	if err := models.PurgeTrashedNode(config.DB, kbID, nodeID); err != nil {
+		c.JSON(trashErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Purged Knowledge Node " + nodeID,
+		"data":    nil,
+	})
+}
+
+// 清空知识库回收站
+func EmptyNodeTrash(c *gin.Context) {
+	kbID := c.Param("kb_id")
+
+	purged, err := models.EmptyNodeTrash(config.DB, kbID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to empty trash",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Trash emptied",
+		"data":    gin.H{"purged": purged},
+	})
+}
+
+// 获取当前用户回收站中的知识库
+func GetKnowledgeBaseTrash(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	kbs, err := models.GetTrashedKnowledgeBases(config.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "Failed to get trash",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Trash retrieved",
+		"data":    kbs,
+	})
+}
+
+// 从回收站恢复知识库（仅所有者）
+func RestoreKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	if !requireTrashedKBOwner(c, kbID) {
+		return
+	}
+
+	kb, err := models.RestoreKnowledgeBase(config.DB, kbID)
+	if err != nil {
+		c.JSON(trashErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Restored Knowledge Base " + kbID,
+		"data":    kb,
+	})
+}
+
+// 彻底删除回收站中的知识库（仅所有者）
+func PurgeKnowledgeBase(c *gin.Context) {
+	kbID := c.Param("kb_id")
+	if !requireTrashedKBOwner(c, kbID) {
+		return
+	}
+
+	if err := models.PurgeKnowledgeBase(config.DB, kbID); err != nil {
+		c.JSON(trashErrorStatus(err), gin.H{
+			"status":  "failed",
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Purged Knowledge Base " + kbID,
+		"data":    nil,
+	})
+}
+
+// requireTrashedKBOwner 已删除的知识库不经过权限中间件，这里单独校验所有权
+func requireTrashedKBOwner(c *gin.Context, kbID string) bool {
+	ok, err := models.IsTrashedKnowledgeBaseOwner(config.DB, kbID, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "权限验证服务不可用",
+		})
+		return false
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "failed",
+			"message": models.ErrTrashItemNotFound.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// trashErrorStatus 将回收站操作的错误映射为HTTP状态码
+func trashErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrTrashItemNotFound),
+		errors.Is(err, models.ErrNodeNotFound),
+		errors.Is(err, models.ErrKnowledgeBaseNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
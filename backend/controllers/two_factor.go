@@ -0,0 +1,477 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// 验证器应用中显示的服务名
+	totpIssuer = "Knowledge Master"
+	// 每次生成的恢复码数量
+	recoveryCodeCount = 10
+	// 密码验证通过后完成两步验证的期限
+	loginChallengeTTL = 5 * time.Minute
+	// 每个登录验证令牌最多尝试的次数，超过后需要重新输入密码
+	loginChallengeAttempts = 5
+)
+
+// generateRecoveryCodes 生成恢复码，返回明文（只展示一次）和用于保存的哈希
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// checkSecondFactor 校验TOTP验证码或恢复码，usedRecovery表示使用了恢复码
+// 未启用两步验证时返回models.ErrTOTPNotEnrolled，
+// 验证码或恢复码无效、已使用时返回models.ErrRecoveryCodeInvalid或models.ErrTOTPCodeUsed。
+func (s *Server) checkSecondFactor(userID, code string) (usedRecovery bool, err error) {
+	totp, err := s.TwoFactor.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	if !totp.Enabled {
+		return false, models.ErrTOTPNotEnrolled
+	}
+	if step, ok := utils.VerifyTOTP(totp.Secret, code, time.Now()); ok {
+		return false, s.TwoFactor.UseStep(userID, step)
+	}
+	return true, s.TwoFactor.UseRecoveryCode(userID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+}
+
+// isInvalidSecondFactor 是否是验证码错误，而不是服务器错误
+func isInvalidSecondFactor(err error) bool {
+	return errors.Is(err, models.ErrRecoveryCodeInvalid) || errors.Is(err, models.ErrTOTPCodeUsed)
+}
+
+// startLoginChallenge 密码验证通过但需要两步验证时，返回登录验证令牌而不是会话
+func (s *Server) startLoginChallenge(c *gin.Context, userID string) {
+	challenge, err := utils.GenerateRandomToken(32)
+	if err == nil {
+		err = s.TwoFactor.CreateChallenge(utils.HashToken(challenge), userID, time.Now().Add(loginChallengeTTL))
+	}
+	if err != nil {
+		log.Printf("创建登录验证失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "请输入两步验证码",
+		Data: gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int(loginChallengeTTL / time.Second),
+		},
+	})
+}
+
+// LoginTwoFactor 用TOTP验证码或恢复码完成两步验证，通过后创建会话
+func (s *Server) LoginTwoFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	challengeHash := utils.HashToken(input.ChallengeToken)
+	userID, err := s.TwoFactor.AttemptChallenge(challengeHash, loginChallengeAttempts)
+	if errors.Is(err, models.ErrChallengeInvalid) {
+		c.JSON(http.StatusUnauthorized, apiResponse{
+			Status:  "failed",
+			Message: "验证已失效，请重新登录",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("校验登录验证失败: %v", err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "两步验证失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	usedRecovery, err := s.checkSecondFactor(userID, input.Code)
+	if err == nil {
+		// 同一个令牌只能换取一个会话
+		err = s.TwoFactor.CompleteChallenge(challengeHash)
+	}
+	if isInvalidSecondFactor(err) {
+		c.JSON(http.StatusUnauthorized, apiResponse{
+			Status:  "failed",
+			Message: "验证码不正确",
+			Data:    nil,
+		})
+		return
+	}
+	if errors.Is(err, models.ErrChallengeInvalid) || errors.Is(err, models.ErrTOTPNotEnrolled) {
+		c.JSON(http.StatusUnauthorized, apiResponse{
+			Status:  "failed",
+			Message: "验证已失效，请重新登录",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("两步验证失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "两步验证失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	user, err := s.Users.GetCredentials(userID)
+	var tokens gin.H
+	if err == nil {
+		tokens, err = s.startSession(c, userID)
+	}
+	if err != nil {
+		log.Printf("创建会话失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "无法生成访问凭证，请检查后端服务器运行情况",
+			Data:    nil,
+		})
+		return
+	}
+
+	user.Password = "******"
+	tokens["user"] = user
+	if usedRecovery {
+		left, _ := s.TwoFactor.RecoveryCodesLeft(userID)
+		tokens["recovery_codes_left"] = left
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "登录成功",
+		Data:    tokens,
+	})
+}
+
+// GetTwoFactorStatus 当前用户两步验证的状态
+func (s *Server) GetTwoFactorStatus(c *gin.Context) {
+	userID := c.GetString("userID")
+	totp, err := s.TwoFactor.Get(userID)
+	if err != nil && !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "获取两步验证状态失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	enabled := totp != nil && totp.Enabled
+	data := gin.H{"enabled": enabled, "recovery_codes_left": 0}
+	if enabled {
+		data["enabled_at"] = totp.EnabledAt
+		if data["recovery_codes_left"], err = s.TwoFactor.RecoveryCodesLeft(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, apiResponse{
+				Status:  "failed",
+				Message: "获取两步验证状态失败",
+				Data:    nil,
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "Two-factor status retrieved",
+		Data:    data,
+	})
+}
+
+// EnrollTwoFactor 生成新的TOTP密钥，用第一个验证码确认后才启用
+func (s *Server) EnrollTwoFactor(c *gin.Context) {
+	user, err := s.Users.GetCredentials(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, apiResponse{
+			Status:  "failed",
+			Message: "User not found",
+			Data:    nil,
+		})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err == nil {
+		err = s.TwoFactor.Enroll(user.UserID, secret)
+	}
+	if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		c.JSON(http.StatusConflict, apiResponse{
+			Status:  "failed",
+			Message: "两步验证已经启用",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("生成两步验证密钥失败 - 用户: %s, 错误: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "生成两步验证密钥失败",
+			Data:    nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "请用验证器应用扫描二维码，并输入显示的验证码完成设置",
+		Data: gin.H{
+			"secret":      secret,
+			"otpauth_uri": utils.TOTPURI(totpIssuer, user.Email, secret),
+		},
+	})
+}
+
+// ConfirmTwoFactor 用验证器应用显示的第一个验证码确认密钥，启用两步验证并返回恢复码
+func (s *Server) ConfirmTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+
+	userID := c.GetString("userID")
+	totp, err := s.TwoFactor.Get(userID)
+	if errors.Is(err, models.ErrTOTPNotEnrolled) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "请先生成两步验证密钥",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "启用两步验证失败",
+			Data:    nil,
+		})
+		return
+	}
+	if totp.Enabled {
+		c.JSON(http.StatusConflict, apiResponse{
+			Status:  "failed",
+			Message: "两步验证已经启用",
+			Data:    nil,
+		})
+		return
+	}
+	step, ok := utils.VerifyTOTP(totp.Secret, input.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "验证码不正确",
+			Data:    nil,
+		})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = s.TwoFactor.Enable(userID, step, hashes)
+	}
+	if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		c.JSON(http.StatusConflict, apiResponse{
+			Status:  "failed",
+			Message: "两步验证已经启用",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("启用两步验证失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "启用两步验证失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "两步验证已启用，请妥善保存恢复码，它们只显示这一次",
+		Data:    gin.H{"recovery_codes": codes},
+	})
+}
+
+// checkPassword 校验当前用户的密码，不正确时已写入响应并返回false
+// 通过OIDC创建的账号没有密码，这时不校验并返回passwordless为true，由调用方改用两步验证码确认身份。
+func (s *Server) checkPassword(c *gin.Context, password string) (passwordless, ok bool) {
+	user, err := s.Users.GetCredentials(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, apiResponse{
+			Status:  "failed",
+			Message: "User not found",
+			Data:    nil,
+		})
+		return false, false
+	}
+	if user.Password == "" {
+		return true, true
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "当前密码不正确",
+			Data:    nil,
+		})
+		return false, false
+	}
+	return false, true
+}
+
+// DisableTwoFactor 关闭两步验证，需要密码和验证码（或恢复码），没有密码的账号只需要验证码
+func (s *Server) DisableTwoFactor(c *gin.Context) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+	if _, ok := s.checkPassword(c, input.Password); !ok {
+		return
+	}
+
+	userID := c.GetString("userID")
+	_, err := s.checkSecondFactor(userID, input.Code)
+	if err == nil {
+		err = s.TwoFactor.Disable(userID)
+	}
+	if errors.Is(err, models.ErrTOTPNotEnrolled) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "未启用两步验证",
+			Data:    nil,
+		})
+		return
+	}
+	if isInvalidSecondFactor(err) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "验证码不正确",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("关闭两步验证失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "关闭两步验证失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "两步验证已关闭",
+		Data:    nil,
+	})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部失效
+func (s *Server) RegenerateRecoveryCodes(c *gin.Context) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"` // 没有密码的账号用验证码（或恢复码）确认身份
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "无效的请求参数",
+			Data:    gin.H{"details": err.Error()},
+		})
+		return
+	}
+	passwordless, ok := s.checkPassword(c, input.Password)
+	if !ok {
+		return
+	}
+
+	userID := c.GetString("userID")
+	var codes, hashes []string
+	var err error
+	if passwordless {
+		_, err = s.checkSecondFactor(userID, input.Code)
+	}
+	if err == nil {
+		codes, hashes, err = generateRecoveryCodes()
+	}
+	if err == nil {
+		err = s.TwoFactor.ReplaceRecoveryCodes(userID, hashes)
+	}
+	if isInvalidSecondFactor(err) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "验证码不正确",
+			Data:    nil,
+		})
+		return
+	}
+	if errors.Is(err, models.ErrTOTPNotEnrolled) {
+		c.JSON(http.StatusBadRequest, apiResponse{
+			Status:  "failed",
+			Message: "未启用两步验证",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("生成恢复码失败 - 用户: %s, 错误: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "生成恢复码失败",
+			Data:    nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apiResponse{
+		Status:  "success",
+		Message: "已生成新的恢复码，之前的恢复码已失效",
+		Data:    gin.H{"recovery_codes": codes},
+	})
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/utils"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type loginData struct {
+	tokenData
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	RecoveryCodesLeft int    `json:"recovery_codes_left"`
+}
+
+func TestTwoFactor(t *testing.T) {
+	utils.InitJWT("0123456789abcdef0123456789abcdef", 15*time.Minute)
+	srv := NewServer(repository.NewMemory(), nil)
+	srv.Mailer = &recordingMailer{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", srv.Register)
+	r.POST("/login", srv.Login)
+	r.POST("/login/2fa", srv.LoginTwoFactor)
+	api := r.Group("/api", middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, nil))
+	api.GET("/user/info", srv.GetUserInfo)
+	api.GET("/user/2fa", srv.GetTwoFactorStatus)
+	api.POST("/user/2fa/enroll", srv.EnrollTwoFactor)
+	api.POST("/user/2fa/confirm", srv.ConfirmTwoFactor)
+	api.POST("/user/2fa/disable", srv.DisableTwoFactor)
+	api.POST("/user/2fa/recovery-codes", srv.RegenerateRecoveryCodes)
+
+	credentials := gin.H{"email": "t@example.com", "password": "secret1", "username": "tom"}
+	if code := doJSON(t, r, "POST", "/register", "", credentials, nil); code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	login := func() loginData {
+		var resp struct {
+			Data loginData `json:"data"`
+		}
+		if code := doJSON(t, r, "POST", "/login", "", credentials, &resp); code != http.StatusOK {
+			t.Fatalf("login: status %d", code)
+		}
+		return resp.Data
+	}
+	complete := func(challenge, code string) (loginData, int) {
+		var resp struct {
+			Data loginData `json:"data"`
+		}
+		status := doJSON(t, r, "POST", "/login/2fa", "", gin.H{"challenge_token": challenge, "code": code}, &resp)
+		return resp.Data, status
+	}
+
+	session := login()
+	if session.TwoFactorRequired || session.Token == "" {
+		t.Fatalf("login without 2FA = %+v", session)
+	}
+	h := authorized(r, session.Token)
+
+	var enrolled struct {
+		Data struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/enroll", "", nil, &enrolled); code != http.StatusOK {
+		t.Fatalf("enroll: status %d", code)
+	}
+	secret := enrolled.Data.Secret
+	if !strings.HasPrefix(enrolled.Data.OTPAuthURI, "otpauth://totp/Knowledge%20Master:t@example.com?") ||
+		!strings.Contains(enrolled.Data.OTPAuthURI, "secret="+secret) {
+		t.Fatalf("otpauth URI = %q", enrolled.Data.OTPAuthURI)
+	}
+	// 固定时间步，测试跨过30秒的边界时结果不变
+	step := utils.TOTPStep(time.Now())
+	codeAt := func(offset int64) string {
+		code, err := utils.TOTPCode(secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// 密钥确认前登录不需要两步验证
+	if login().TwoFactorRequired {
+		t.Fatal("2FA required before the secret was confirmed")
+	}
+	wrong := "000000"
+	if wrong == codeAt(0) {
+		wrong = "111111"
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/confirm", "", gin.H{"code": wrong}, nil); code != http.StatusBadRequest {
+		t.Fatalf("confirm with a wrong code: status %d, want 400", code)
+	}
+	var confirmed struct {
+		Data struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/confirm", "", gin.H{"code": codeAt(0)}, &confirmed); code != http.StatusOK {
+		t.Fatalf("confirm: status %d", code)
+	}
+	recoveryCodes := confirmed.Data.RecoveryCodes
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(recoveryCodes))
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/enroll", "", nil, nil); code != http.StatusConflict {
+		t.Fatalf("enroll when enabled: status %d, want 409", code)
+	}
+
+	// 启用后登录只返回验证令牌；确认时用过的验证码不能再次使用
+	challenge := login()
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" || challenge.Token != "" {
+		t.Fatalf("login with 2FA = %+v", challenge)
+	}
+	if _, code := complete(challenge.ChallengeToken, codeAt(0)); code != http.StatusUnauthorized {
+		t.Fatalf("replayed code: status %d, want 401", code)
+	}
+	done, code := complete(challenge.ChallengeToken, codeAt(1))
+	if code != http.StatusOK || done.Token == "" {
+		t.Fatalf("complete with TOTP: status %d, %+v", code, done)
+	}
+	if doJSON(t, authorized(r, done.Token), "GET", "/api/user/info", "", nil, nil) != http.StatusOK {
+		t.Fatal("token issued after 2FA rejected")
+	}
+	if _, code := complete(challenge.ChallengeToken, recoveryCodes[0]); code != http.StatusUnauthorized {
+		t.Fatalf("reuse completed challenge: status %d, want 401", code)
+	}
+
+	// 错误次数用完后令牌失效，即使之后输入正确的恢复码
+	challenge = login()
+	for i := 0; i < loginChallengeAttempts; i++ {
+		if _, code := complete(challenge.ChallengeToken, "aaaaa-aaaaa"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong code: status %d, want 401", code)
+		}
+	}
+	if _, code := complete(challenge.ChallengeToken, recoveryCodes[0]); code != http.StatusUnauthorized {
+		t.Fatalf("challenge after too many attempts: status %d, want 401", code)
+	}
+
+	// 恢复码忽略大小写和空白，只能使用一次
+	challenge = login()
+	done, code = complete(challenge.ChallengeToken, " "+strings.ToUpper(recoveryCodes[0])+" ")
+	if code != http.StatusOK || done.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Fatalf("complete with recovery code: status %d, %+v", code, done)
+	}
+	if _, code := complete(login().ChallengeToken, recoveryCodes[0]); code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: status %d, want 401", code)
+	}
+
+	var status struct {
+		Data struct {
+			Enabled           bool `json:"enabled"`
+			RecoveryCodesLeft int  `json:"recovery_codes_left"`
+		} `json:"data"`
+	}
+	doJSON(t, h, "GET", "/api/user/2fa", "", nil, &status)
+	if !status.Data.Enabled || status.Data.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Fatalf("status = %+v", status.Data)
+	}
+
+	if code := doJSON(t, h, "POST", "/api/user/2fa/recovery-codes", "", gin.H{"password": "wrong1"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("regenerate with a wrong password: status %d, want 400", code)
+	}
+	var regenerated struct {
+		Data struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"data"`
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/recovery-codes", "", gin.H{"password": "secret1"}, &regenerated); code != http.StatusOK {
+		t.Fatalf("regenerate recovery codes: status %d", code)
+	}
+	if _, code := complete(login().ChallengeToken, recoveryCodes[1]); code != http.StatusUnauthorized {
+		t.Fatalf("replaced recovery code: status %d, want 401", code)
+	}
+	recoveryCodes = regenerated.Data.RecoveryCodes
+
+	// 关闭需要密码和验证码
+	if code := doJSON(t, h, "POST", "/api/user/2fa/disable", "", gin.H{"password": "secret1", "code": "bbbbb-bbbbb"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("disable with a wrong code: status %d, want 400", code)
+	}
+	if code := doJSON(t, h, "POST", "/api/user/2fa/disable", "", gin.H{"password": "secret1", "code": recoveryCodes[0]}, nil); code != http.StatusOK {
+		t.Fatalf("disable: status %d", code)
+	}
+	if session := login(); session.TwoFactorRequired || session.Token == "" {
+		t.Fatalf("login after disabling 2FA = %+v", session)
+	}
+}
@@ -1,21 +1,26 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"knowledge_master_backend/config"
+	"io"
 	"knowledge_master_backend/models"
 	"knowledge_master_backend/utils"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 )
 
 type UserInfoResponse struct {
-	UserID    string `json:"user_id"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	AvatarURI string `json:"avatar_uri"`
+	UserID        string            `json:"user_id"`
+	Username      string            `json:"username"`
+	Email         string            `json:"email"`
+	AvatarURI     string            `json:"avatar_uri"`
+	Avatars       models.AvatarURLs `json:"avatars"`
+	EmailVerified bool              `json:"email_verified"` // 是否已通过验证邮件确认邮箱
 }
 
-func GetUserInfo(c *gin.Context) {
+func (s *Server) GetUserInfo(c *gin.Context) {
 	// 从上下文中获取userID
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -28,7 +33,7 @@ func GetUserInfo(c *gin.Context) {
 	}
 
 	// 查询用户信息
-	user, err := models.GetUserByID(config.DB, userID.(string))
+	user, err := s.Users.GetByID(userID.(string))
 	if err != nil {
 		c.JSON(http.StatusNotFound, apiResponse{
 			Status:  "failed",
@@ -37,19 +42,28 @@ func GetUserInfo(c *gin.Context) {
 		})
 		return
 	}
+
+	// size参数指定需要的头像边长，返回不小于它的最小尺寸
+	avatarURI := user.AvatarURI
+	if size, err := strconv.Atoi(c.Query("size")); err == nil && size > 0 {
+		avatarURI = avatarForSize(user, size)
+	}
+
 	c.JSON(http.StatusOK, apiResponse{
 		Status:  "success",
 		Message: "User successfully retrieved",
 		Data: UserInfoResponse{
-			UserID:    user.UserID,
-			Username:  user.Username,
-			Email:     user.Email,
-			AvatarURI: user.AvatarURI,
+			UserID:        user.UserID,
+			Username:      user.Username,
+			Email:         user.Email,
+			AvatarURI:     avatarURI,
+			Avatars:       user.Avatars,
+			EmailVerified: user.EmailVerified,
 		},
 	})
 }
 
-func GetUserProfile(c *gin.Context) {
+func (s *Server) GetUserProfile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusInternalServerError, apiResponse{
@@ -57,8 +71,9 @@ func GetUserProfile(c *gin.Context) {
 			Message: "Authentication failed",
 			Data:    nil,
 		})
+		return
 	}
-	profile, err := models.GetUserProfile(config.DB, userID.(string))
+	profile, err := s.Users.GetProfile(userID.(string))
 	if err != nil {
 		c.JSON(http.StatusNotFound, apiResponse{
 			Status:  "failed",
@@ -74,7 +89,7 @@ func GetUserProfile(c *gin.Context) {
 	})
 }
 
-func UpdateUserProfile(c *gin.Context) {
+func (s *Server) UpdateUserProfile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusInternalServerError, apiResponse{
@@ -82,6 +97,7 @@ func UpdateUserProfile(c *gin.Context) {
 			Message: "Authentication failed",
 			Data:    nil,
 		})
+		return
 	}
 	NewProfile := models.UserProfile{}
 	err := c.BindJSON(&NewProfile)
@@ -91,14 +107,20 @@ func UpdateUserProfile(c *gin.Context) {
 			Message: "Invalid request body",
 			Data:    nil,
 		})
+		return
 	}
-	err = models.UpdateUserProfile(config.DB, userID.(string), &NewProfile)
+	err = s.Users.UpdateProfile(userID.(string), &NewProfile)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, apiResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, apiResponse{
 			Status:  "failed",
 			Message: "User profile update failed",
 			Data:    nil,
 		})
+		return
 	}
 	c.JSON(http.StatusOK, apiResponse{
 		Status:  "success",
@@ -107,7 +129,12 @@ func UpdateUserProfile(c *gin.Context) {
 	})
 }
 
-func UploadAvatar(c *gin.Context) {
+// 上传头像
+// 图片会被裁剪为正方形并生成多个尺寸，原来上传的头像文件会被删除。
+func (s *Server) UploadAvatar(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, utils.FileKindImage.MaxSize+1<<20)
 	avatar_image, err := c.FormFile("avatar")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, apiResponse{
@@ -115,18 +142,84 @@ func UploadAvatar(c *gin.Context) {
 			Message: "Invalid request body",
 			Data:    nil,
 		})
+		return
+	}
+	if err := utils.CheckFileSize(utils.FileKindImage, avatar_image.Size); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, apiResponse{
+			Status:  "failed",
+			Message: err.Error(),
+			Data:    nil,
+		})
+		return
+	}
+
+	data, err := readFormFile(avatar_image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, apiResponse{
+			Status:  "failed",
+			Message: "Upload avatar failed",
+			Data:    nil,
+		})
+		return
 	}
-	url, err := utils.UploadAvatarToOSS(avatar_image)
+
+	avatar, err := utils.UploadAvatar(s.Storage, userID, data)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrUnsupportedImage) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, apiResponse{
+			Status:  "failed",
+			Message: "Upload avatar failed: " + err.Error(),
+			Data:    nil,
+		})
+		return
+	}
+
+	oldKeys, err := s.Users.SetAvatar(userID, avatar.URI, avatar.URLs, avatar.Keys)
 	if err != nil {
+		utils.DeleteObjects(s.Storage, avatar.Keys)
 		c.JSON(http.StatusInternalServerError, apiResponse{
 			Status:  "failed",
 			Message: "Upload avatar failed",
 			Data:    nil,
 		})
+		return
 	}
+	utils.DeleteObjects(s.Storage, oldKeys)
+
 	c.JSON(http.StatusOK, apiResponse{
 		Status:  "success",
 		Message: "Upload avatar successfully",
-		Data:    url,
+		Data: gin.H{
+			"avatar_uri": avatar.URI,
+			"avatars":    avatar.URLs,
+		},
 	})
 }
+
+// readFormFile 读取上传文件的全部内容
+func readFormFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// avatarForSize 返回不小于size的最小尺寸的头像地址，没有合适的尺寸时返回avatar_uri
+func avatarForSize(user *models.User, size int) string {
+	best, bestURL := 0, user.AvatarURI
+	for s, url := range user.Avatars {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < size {
+			continue
+		}
+		if best == 0 || n < best {
+			best, bestURL = n, url
+		}
+	}
+	return bestURL
+}
@@ -0,0 +1,114 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+)
+
+// legacyKB design/kb_data.json 中的嵌套格式
+type legacyKB struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	TreeData    []*legacyNode `json:"treeData"`
+}
+
+type legacyNode struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Content  string        `json:"content"`
+	Children []*legacyNode `json:"children"`
+}
+
+// WriteBackup 将备份写为带缩进的JSON
+func WriteBackup(w io.Writer, backup *models.KBBackup) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(backup)
+}
+
+// ParseBackup 解析备份文件，返回其中的一个或多个知识库
+// 支持带schema_version的备份格式，以及design/kb_data.json的treeData嵌套格式（单个对象或数组）。
+// treeData格式中的ID不是UUID，恢复时只能重新生成ID。
+func ParseBackup(data []byte) ([]*models.KBBackup, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty file", models.ErrInvalidBackup)
+	}
+
+	if data[0] == '[' {
+		var kbs []*legacyKB
+		if err := json.Unmarshal(data, &kbs); err != nil {
+			return nil, fmt.Errorf("%w: %v", models.ErrInvalidBackup, err)
+		}
+		backups := make([]*models.KBBackup, 0, len(kbs))
+		for _, kb := range kbs {
+			backups = append(backups, fromLegacyKB(kb))
+		}
+		return backups, nil
+	}
+
+	var probe struct {
+		SchemaVersion int             `json:"schema_version"`
+		TreeData      json.RawMessage `json:"treeData"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidBackup, err)
+	}
+
+	switch {
+	case probe.SchemaVersion > models.BackupSchemaVersion:
+		return nil, fmt.Errorf("%w: schema version %d is newer than supported version %d",
+			models.ErrInvalidBackup, probe.SchemaVersion, models.BackupSchemaVersion)
+	case probe.SchemaVersion > 0:
+		var backup models.KBBackup
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return nil, fmt.Errorf("%w: %v", models.ErrInvalidBackup, err)
+		}
+		return []*models.KBBackup{&backup}, nil
+	case probe.TreeData != nil:
+		var kb legacyKB
+		if err := json.Unmarshal(data, &kb); err != nil {
+			return nil, fmt.Errorf("%w: %v", models.ErrInvalidBackup, err)
+		}
+		return []*models.KBBackup{fromLegacyKB(&kb)}, nil
+	}
+	return nil, fmt.Errorf("%w: missing schema_version", models.ErrInvalidBackup)
+}
+
+func fromLegacyKB(kb *legacyKB) *models.KBBackup {
+	backup := &models.KBBackup{
+		KnowledgeBase: models.BackupKB{
+			KBID:        kb.ID,
+			Name:        kb.Name,
+			Description: kb.Description,
+		},
+	}
+	flattenLegacyNodes(backup, "", kb.TreeData)
+	return backup
+}
+
+func flattenLegacyNodes(backup *models.KBBackup, parentID string, nodes []*legacyNode) {
+	for i, n := range nodes {
+		nodeType := n.Type
+		if nodeType == "" {
+			nodeType = "file"
+			if len(n.Children) > 0 {
+				nodeType = "folder"
+			}
+		}
+		backup.Nodes = append(backup.Nodes, models.BackupNode{
+			NodeID:    n.ID,
+			ParentID:  parentID,
+			Type:      nodeType,
+			Title:     clampTitle(n.Name),
+			Content:   n.Content,
+			SortOrder: i + 1,
+		})
+		flattenLegacyNodes(backup, n.ID, n.Children)
+	}
+}
@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"knowledge_master_backend/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpBackupNodes 每个节点一行：ID、父节点ID、类型、标题和排序
+func dumpBackupNodes(nodes []models.BackupNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "%s<%s %s %q %d\n", n.NodeID, n.ParentID, n.Type, n.Title, n.SortOrder)
+	}
+	return b.String()
+}
+
+func TestParseBackupRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	backup := &models.KBBackup{
+		SchemaVersion: models.BackupSchemaVersion,
+		ExportedAt:    at,
+		KnowledgeBase: models.BackupKB{KBID: "kb", Name: "notes", OwnerID: "u1", OwnerEmail: "a@example.com", CollaborationMode: "private", CreatedAt: at, UpdatedAt: at},
+		Members:       []models.BackupMember{{UserID: "u1", Email: "a@example.com", Role: models.RoleOwner, JoinedAt: at}},
+		Nodes: []models.BackupNode{
+			{NodeID: "n1", Type: "folder", Title: "a", SortOrder: 1, Version: 2, CreatedAt: at, UpdatedAt: at},
+			{NodeID: "n2", ParentID: "n1", Type: "file", Title: "b", Content: "# b", SortOrder: 1, Version: 1, CreatedAt: at, UpdatedAt: at},
+		},
+		Attachments: []models.BackupAttachment{{Kind: "attachment", NodeID: "n2", URL: "/files/x.png"}},
+	}
+	var buf bytes.Buffer
+	if err := WriteBackup(&buf, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ParseBackup(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	if err := WriteBackup(&again, backups[0]); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != buf.String() {
+		t.Errorf("round trip:\n%s\nwant:\n%s", again.String(), buf.String())
+	}
+}
+
+func TestParseBackupLegacyTreeData(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       []string // 每个知识库的名称和节点
+	}{
+		{
+			name: "single object",
+			data: `{"id": "kb-1", "name": "Design", "treeData": [
+				{"id": "1", "name": "Folder", "children": [
+					{"id": "1-1", "name": "Leaf", "content": "text"},
+					{"id": "1-2", "name": "Typed", "type": "folder"}
+				]},
+				{"id": "2", "name": "  multi\nline  "}
+			]}`,
+			want: []string{"Design\n" +
+				"1< folder \"Folder\" 1\n" +
+				"1-1<1 file \"Leaf\" 1\n" +
+				"1-2<1 folder \"Typed\" 2\n" +
+				"2< file \"multi line\" 2\n"},
+		},
+		{
+			name: "array",
+			data: `[{"id": "a", "name": "A", "treeData": [{"id": "x", "name": "X"}]}, {"id": "b", "name": "B", "treeData": []}]`,
+			want: []string{"A\nx< file \"X\" 1\n", "B\n"},
+		},
+		{
+			name: "untitled",
+			data: `{"id": "kb-2", "name": "N", "treeData": [{"id": "1", "name": ""}]}`,
+			want: []string{"N\n1< file \"Untitled\" 1\n"},
+		},
+	}
+	for _, tt := range tests {
+		backups, err := ParseBackup([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(backups) != len(tt.want) {
+			t.Errorf("%s: got %d backups, want %d", tt.name, len(backups), len(tt.want))
+			continue
+		}
+		for i, b := range backups {
+			if got := b.KnowledgeBase.Name + "\n" + dumpBackupNodes(b.Nodes); got != tt.want[i] {
+				t.Errorf("%s: backup %d:\n%s\nwant:\n%s", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseBackupInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "  \n",
+		"not json":       "<opml/>",
+		"no version":     `{"knowledge_base": {"name": "x"}, "nodes": []}`,
+		"newer version":  fmt.Sprintf(`{"schema_version": %d}`, models.BackupSchemaVersion+1),
+		"bad field":      `{"schema_version": 1, "nodes": {}}`,
+		"bad tree":       `{"treeData": "x"}`,
+		"bad array":      `[1, 2]`,
+		"truncated":      `{"schema_version": 1, "nodes": [`,
+		"version string": `{"schema_version": "1"}`,
+	}
+	for name, data := range tests {
+		_, err := ParseBackup([]byte(data))
+		if !errors.Is(err, models.ErrInvalidBackup) {
+			t.Errorf("%s: err = %v, want ErrInvalidBackup", name, err)
+		}
+	}
+}
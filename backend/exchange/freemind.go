@@ -0,0 +1,232 @@
+package exchange
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"strings"
+	"unicode"
+)
+
+// FreeMind / Freeplane 的 .mm 文件
+// 思维导图只有一个中心主题：导出时以知识库名称作为中心主题，导入时中心主题成为一个目录节点。
+type mmMap struct {
+	XMLName xml.Name `xml:"map"`
+	Version string   `xml:"version,attr"`
+	Node    *mmNode  `xml:"node"`
+}
+
+type mmNode struct {
+	ID          string          `xml:"ID,attr,omitempty"`
+	Text        string          `xml:"TEXT,attr,omitempty"`
+	Created     int64           `xml:"CREATED,attr,omitempty"`
+	Modified    int64           `xml:"MODIFIED,attr,omitempty"`
+	RichContent []mmRichContent `xml:"richcontent"`
+	Attributes  []mmAttribute   `xml:"attribute"`
+	Nodes       []*mmNode       `xml:"node"`
+}
+
+type mmRichContent struct {
+	Type string `xml:"TYPE,attr"`
+	HTML string `xml:",innerxml"`
+}
+
+type mmAttribute struct {
+	Name  string `xml:"NAME,attr"`
+	Value string `xml:"VALUE,attr"`
+}
+
+// WriteFreeMind 将知识库的树导出为FreeMind思维导图
+func WriteFreeMind(w io.Writer, kb *models.KnowledgeBase, tree []*models.KnowledgeNode) error {
+	root := &mmNode{
+		ID:       mmID(kb.KBID),
+		Text:     kb.Name,
+		Created:  kb.CreatedAt.UnixMilli(),
+		Modified: kb.UpdatedAt.UnixMilli(),
+		Nodes:    toMMNodes(tree),
+	}
+	if kb.Description != "" {
+		root.RichContent = []mmRichContent{{Type: "NOTE", HTML: textToHTML(kb.Description)}}
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(mmMap{Version: "1.0.1", Node: root}); err != nil {
+		return fmt.Errorf("failed to encode FreeMind map: %w", err)
+	}
+	return enc.Close()
+}
+
+func toMMNodes(nodes []*models.KnowledgeNode) []*mmNode {
+	result := make([]*mmNode, 0, len(nodes))
+	for _, node := range nodes {
+		n := &mmNode{
+			ID:         mmID(node.NodeID),
+			Text:       node.Title,
+			Created:    node.CreatedAt.UnixMilli(),
+			Modified:   node.UpdatedAt.UnixMilli(),
+			Attributes: []mmAttribute{{Name: "type", Value: node.Type}},
+			Nodes:      toMMNodes(node.Children),
+		}
+		if node.Content != "" {
+			n.RichContent = []mmRichContent{{Type: "NOTE", HTML: textToHTML(node.Content)}}
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// mmID FreeMind的节点ID必须以字母开头
+func mmID(id string) string {
+	return "ID_" + strings.ReplaceAll(id, "-", "")
+}
+
+// ParseFreeMind 解析FreeMind/Freeplane思维导图，中心主题成为唯一的顶层节点
+func ParseFreeMind(r io.Reader) ([]*models.KnowledgeNode, *ImportReport, error) {
+	var m mmMap
+	if err := newXMLDecoder(r).Decode(&m); err != nil {
+		return nil, nil, fmt.Errorf("not a valid FreeMind file: %w", err)
+	}
+	if m.Node == nil {
+		return nil, nil, fmt.Errorf("not a valid FreeMind file: map has no root node")
+	}
+
+	nodes := fromMMNodes([]*mmNode{m.Node})
+	if countNodes(nodes) > maxImportEntries {
+		return nil, nil, ErrImportTooLarge
+	}
+	return nodes, newImportReport(), nil
+}
+
+func fromMMNodes(mmNodes []*mmNode) []*models.KnowledgeNode {
+	nodes := make([]*models.KnowledgeNode, 0, len(mmNodes))
+	for _, n := range mmNodes {
+		title, note := n.Text, ""
+		for _, rc := range n.RichContent {
+			switch strings.ToUpper(rc.Type) {
+			case "NODE":
+				if title == "" {
+					title = htmlToText(rc.HTML)
+				}
+			case "NOTE":
+				note = htmlToText(rc.HTML)
+			}
+		}
+
+		nodeType := ""
+		for _, attr := range n.Attributes {
+			if attr.Name == "type" {
+				nodeType = attr.Value
+			}
+		}
+
+		nodes = append(nodes, newImportedNode(title, note, nodeType, fromMMNodes(n.Nodes)))
+	}
+	return nodes
+}
+
+// textToHTML 将Markdown文本按行转换为FreeMind备注使用的HTML
+// 每行一个段落，行首空格和空行用不换行空格保留，便于导入时还原。
+func textToHTML(text string) string {
+	var b strings.Builder
+	b.WriteString("<html><head></head><body>")
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		b.WriteString("<p>")
+		b.WriteString(strings.Repeat("&#160;", len(line)-len(trimmed)))
+		xml.EscapeText(&b, []byte(trimmed))
+		if line == "" {
+			b.WriteString("&#160;")
+		}
+		b.WriteString("</p>")
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+// htmlToText 提取HTML片段中的文本，块级元素和<br>转换为换行
+func htmlToText(fragment string) string {
+	dec := xml.NewDecoder(strings.NewReader(fragment))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var b strings.Builder
+	atLineStart := true
+	newline := func() {
+		if !atLineStart {
+			b.WriteByte('\n')
+			atLineStart = true
+		}
+	}
+	pre := 0
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "head", "style", "script":
+				dec.Skip()
+			case "br":
+				b.WriteByte('\n')
+				atLineStart = true
+			case "pre":
+				pre++
+				newline()
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6":
+				newline()
+			}
+		case xml.EndElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "pre":
+				pre--
+				newline()
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6":
+				// 空段落也占一行，对应导出时的空行
+				b.WriteByte('\n')
+				atLineStart = true
+			}
+		case xml.CharData:
+			s := string(t)
+			if pre == 0 {
+				s = collapseHTMLSpace(s)
+				if atLineStart {
+					s = strings.TrimLeft(s, " ")
+				}
+			}
+			if s != "" {
+				b.WriteString(s)
+				atLineStart = strings.HasSuffix(s, "\n")
+			}
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(b.String(), "\u00a0", " "), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// collapseHTMLSpace 按HTML规则将连续的ASCII空白折叠为一个空格，不换行空格保持不变
+func collapseHTMLSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			if !space {
+				b.WriteByte(' ')
+				space = true
+			}
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
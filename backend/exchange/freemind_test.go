@@ -0,0 +1,57 @@
+package exchange
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFreeMindRoundTrip(t *testing.T) {
+	kb, tree := exportFixture()
+	var buf bytes.Buffer
+	if err := WriteFreeMind(&buf, kb, tree); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, report, err := ParseFreeMind(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dumpTree(nodes, ""), dumpTree(mindMapRoot(kb, tree), ""); got != want {
+		t.Errorf("round trip:\n%s\nwant:\n%s", got, want)
+	}
+	if len(report.Skipped) != 0 || len(report.Errors) != 0 {
+		t.Errorf("report = %+v, want no issues", report)
+	}
+}
+
+func TestParseFreeMindRichContent(t *testing.T) {
+	// Freeplane把格式化的标题和备注保存为HTML
+	doc := `<map version="freeplane 1.9.0"><node ID="ID_1">
+  <richcontent TYPE="NODE"><html><head><style>p{}</style></head><body><p>Rich   <b>title</b></p></body></html></richcontent>
+  <richcontent TYPE="NOTE"><html><body><p>one<br/>two</p><ul><li>item</li></ul><pre>  code
+  block</pre></body></html></richcontent>
+</node></map>`
+	nodes, _, err := ParseFreeMind(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `file "Rich title" "one\ntwo\nitem\n  code\n  block"
+`
+	if got := dumpTree(nodes, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseFreeMindMalformed(t *testing.T) {
+	for name, doc := range map[string]string{
+		"empty":     "",
+		"no root":   `<map version="1.0.1"></map>`,
+		"truncated": `<map version="1.0.1"><node TEXT="a"><node TEXT="b">`,
+		"wrong tag": `<opml version="2.0"><body/></opml>`,
+	} {
+		if _, _, err := ParseFreeMind(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: parsed malformed FreeMind map", name)
+		}
+	}
+}
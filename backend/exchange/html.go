@@ -0,0 +1,202 @@
+package exchange
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"html"
+	"io"
+	"knowledge_master_backend/models"
+	"time"
+)
+
+// HTMLRenderer 将节点或子树渲染为单个自包含的HTML文档，可以离线查看，也可以直接打印为PDF
+// Markdown按GFM解析（表格、任务列表、删除线、自动链接），代码块用内联样式高亮，
+// 公式预渲染为MathML，远程图片内联为data URI；与前端一样不渲染Markdown中的原始HTML。
+type HTMLRenderer struct {
+	md     goldmark.Markdown
+	images *ImageInliner
+}
+
+// NewHTMLRenderer images为nil时保留图片的原地址
+func NewHTMLRenderer(images *ImageInliner) *HTMLRenderer {
+	r := &HTMLRenderer{images: images}
+	r.md = goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			mathExtension{},
+			highlighting.NewHighlighting(highlighting.WithStyle("github")),
+		),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(r, 1000)),
+		),
+	)
+	return r
+}
+
+// RenderNode 渲染一个节点及其全部子节点，节点标题作为文档标题，子节点组成目录
+func (r *HTMLRenderer) RenderNode(w io.Writer, root *models.KnowledgeNode, autoPrint bool) error {
+	return r.render(w, root.Title, root.Content, root.Children, root.UpdatedAt, autoPrint)
+}
+
+// RenderTree 渲染整个知识库，title为文档标题
+func (r *HTMLRenderer) RenderTree(w io.Writer, title string, roots []*models.KnowledgeNode, autoPrint bool) error {
+	return r.render(w, title, "", roots, time.Now(), autoPrint)
+}
+
+func (r *HTMLRenderer) render(w io.Writer, title, content string, sections []*models.KnowledgeNode,
+	updatedAt time.Time, autoPrint bool) error {
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"zh-CN\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1">` + "\n")
+	b.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	b.WriteString("<style>" + documentCSS + "</style>\n")
+	if autoPrint {
+		b.WriteString(`<script>window.addEventListener("load", function () { window.print(); });</script>` + "\n")
+	}
+	b.WriteString("</head>\n<body>\n<header>\n")
+	b.WriteString("<h1>" + html.EscapeString(title) + "</h1>\n")
+	b.WriteString(`<p class="meta">更新于 ` + updatedAt.Local().Format("2006-01-02 15:04") + "</p>\n")
+	b.WriteString("</header>\n")
+
+	if len(sections) > 0 {
+		b.WriteString("<nav class=\"toc\">\n<h2>目录</h2>\n")
+		writeTOC(&b, sections)
+		b.WriteString("</nav>\n")
+	}
+
+	b.WriteString("<main>\n")
+	if err := r.convert(&b, content, 1); err != nil {
+		return err
+	}
+	for _, node := range sections {
+		if err := r.writeSection(&b, node, 0); err != nil {
+			return err
+		}
+	}
+	b.WriteString("</main>\n</body>\n</html>\n")
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func writeTOC(b *bytes.Buffer, nodes []*models.KnowledgeNode) {
+	b.WriteString("<ol>\n")
+	for _, node := range nodes {
+		b.WriteString(`<li><a href="#` + nodeAnchor(node) + `">` + html.EscapeString(node.Title) + "</a>")
+		if len(node.Children) > 0 {
+			b.WriteString("\n")
+			writeTOC(b, node.Children)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ol>\n")
+}
+
+// writeSection 写入一个节点，节点标题的级别随深度递增，内容中的标题排在节点标题之下
+func (r *HTMLRenderer) writeSection(b *bytes.Buffer, node *models.KnowledgeNode, depth int) error {
+	level := min(depth+2, 6)
+	fmt.Fprintf(b, "<section class=\"node depth-%d\" id=\"%s\">\n<h%d>%s</h%d>\n",
+		depth, nodeAnchor(node), level, html.EscapeString(node.Title), level)
+	if err := r.convert(b, node.Content, level); err != nil {
+		return fmt.Errorf("failed to render node %s: %w", node.NodeID, err)
+	}
+	for _, child := range node.Children {
+		if err := r.writeSection(b, child, depth+1); err != nil {
+			return err
+		}
+	}
+	b.WriteString("</section>\n")
+	return nil
+}
+
+func nodeAnchor(node *models.KnowledgeNode) string {
+	return "node-" + html.EscapeString(node.NodeID)
+}
+
+// headingOffsetKey 内容中标题级别需要增加的值
+var headingOffsetKey = parser.NewContextKey()
+
+func (r *HTMLRenderer) convert(b *bytes.Buffer, content string, headingOffset int) error {
+	if content == "" {
+		return nil
+	}
+	ctx := parser.NewContext()
+	ctx.Set(headingOffsetKey, headingOffset)
+	b.WriteString(`<div class="content">` + "\n")
+	if err := r.md.Convert([]byte(content), b, parser.WithContext(ctx)); err != nil {
+		return err
+	}
+	b.WriteString("</div>\n")
+	return nil
+}
+
+// Transform 下移内容中的标题级别，并把图片替换为data URI
+func (r *HTMLRenderer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	offset, _ := pc.Get(headingOffsetKey).(int)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			n.Level = min(n.Level+offset, 6)
+		case *ast.Image:
+			if r.images != nil {
+				if uri, ok := r.images.Inline(string(n.Destination)); ok {
+					n.Destination = []byte(uri)
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// documentCSS 文档的内嵌样式，@media print 部分用于打印和另存为PDF
+const documentCSS = `
+:root { color-scheme: light; }
+body { margin: 0 auto; max-width: 860px; padding: 32px 24px; color: #1f2328; background: #fff;
+  font: 16px/1.7 -apple-system, "Segoe UI", "PingFang SC", "Hiragino Sans GB", "Microsoft YaHei", "Noto Sans CJK SC", sans-serif; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 24px; }
+header h1 { margin: 0 0 4px; font-size: 2em; }
+.meta { margin: 0 0 12px; color: #656d76; font-size: 0.875em; }
+h1, h2, h3, h4, h5, h6 { line-height: 1.3; margin: 1.4em 0 0.6em; }
+section.depth-0 > h2 { padding-bottom: 0.3em; border-bottom: 1px solid #d0d7de; }
+.toc ol { list-style: none; padding-left: 1.2em; margin: 0; }
+.toc > ol { padding-left: 0; }
+.toc a { color: inherit; text-decoration: none; }
+.toc a:hover { text-decoration: underline; }
+a { color: #0969da; }
+p, ul, ol, blockquote, table, pre { margin: 0 0 1em; }
+blockquote { padding: 0 1em; color: #656d76; border-left: 0.25em solid #d0d7de; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 0.875em;
+  padding: 0.15em 0.35em; background: #eff1f3; border-radius: 4px; }
+pre { padding: 12px 16px; overflow: auto; border-radius: 6px; background: #f6f8fa; }
+pre code { padding: 0; background: none; font-size: 0.875em; }
+table { border-collapse: collapse; display: block; overflow: auto; }
+th, td { padding: 6px 13px; border: 1px solid #d0d7de; }
+tr:nth-child(2n) { background: #f6f8fa; }
+img { max-width: 100%; }
+hr { border: 0; border-top: 1px solid #d0d7de; margin: 24px 0; }
+li > input[type=checkbox] { margin-right: 0.4em; }
+.math { overflow-x: auto; margin: 0 0 1em; }
+math[display=block] { margin: 0.5em 0; }
+.math-error { color: #cf222e; }
+@page { size: A4; margin: 18mm 16mm; }
+@media print {
+  body { max-width: none; padding: 0; font-size: 11pt; }
+  a { color: inherit; text-decoration: none; }
+  .toc { break-after: page; }
+  section.depth-0 + section.depth-0 { break-before: page; }
+  h1, h2, h3, h4, h5, h6 { break-after: avoid; }
+  pre, table, img, blockquote, .math { break-inside: avoid; }
+  pre { white-space: pre-wrap; overflow: visible; }
+  table { display: table; }
+}
+`
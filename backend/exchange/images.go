@@ -0,0 +1,140 @@
+package exchange
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxRemoteImageSize  = 5 << 20  // 单张图片
+	maxRemoteImageTotal = 50 << 20 // 一个文档中内联的图片总量
+	maxRemoteImages     = 20       // 一个文档中最多下载的图片数，失败的下载同样计数
+	remoteImageTimeout  = 10 * time.Second
+	remoteImageDeadline = 30 * time.Second // 一个文档中全部下载的总时长
+)
+
+// 可以作为data URI内联的图片类型，与goldmark在安全模式下允许的data URI一致
+var remoteImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ImageInliner 下载http(s)图片并转换为data URI，同一地址只下载一次
+// 只连接公网地址，避免通过图片链接访问内网服务；下载失败或超出大小、数量、时长限制的图片保留原地址。
+type ImageInliner struct {
+	client   *http.Client
+	ctx      context.Context
+	deadline time.Time
+	budget   int64
+	fetches  int
+	cache    map[string]string
+}
+
+// NewImageInliner 创建一个文档使用的ImageInliner，ctx取消（如客户端断开）后不再下载
+func NewImageInliner(ctx context.Context) *ImageInliner {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicAddressOnly}
+	return &ImageInliner{
+		client: &http.Client{
+			Timeout: remoteImageTimeout,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ResponseHeaderTimeout: remoteImageTimeout,
+			},
+		},
+		ctx:      ctx,
+		deadline: time.Now().Add(remoteImageDeadline),
+		budget:   maxRemoteImageTotal,
+		cache:    make(map[string]string),
+	}
+}
+
+// Inline 返回图片的data URI，无法内联时ok为false
+func (in *ImageInliner) Inline(src string) (uri string, ok bool) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return "", false
+	}
+	if uri, cached := in.cache[src]; cached {
+		return uri, uri != ""
+	}
+
+	uri, err := in.fetch(src)
+	if err != nil {
+		uri = ""
+	}
+	in.cache[src] = uri
+	return uri, uri != ""
+}
+
+func (in *ImageInliner) fetch(src string) (string, error) {
+	limit := int64(maxRemoteImageSize)
+	if in.budget < limit {
+		limit = in.budget
+	}
+	if limit <= 0 || in.fetches >= maxRemoteImages {
+		return "", fmt.Errorf("image budget exhausted")
+	}
+	deadline := time.Now().Add(remoteImageTimeout)
+	if in.deadline.Before(deadline) {
+		deadline = in.deadline
+	}
+	if !deadline.After(time.Now()) {
+		return "", fmt.Errorf("image deadline exceeded")
+	}
+	in.fetches++
+
+	ctx, cancel := context.WithDeadline(in.ctx, deadline)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > limit {
+		return "", fmt.Errorf("image is larger than %d bytes", limit)
+	}
+
+	// 以内容识别出的类型为准，不信任响应头
+	contentType := http.DetectContentType(data)
+	if !remoteImageTypes[contentType] {
+		return "", fmt.Errorf("unsupported image type %s", contentType)
+	}
+
+	in.budget -= int64(len(data))
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// publicAddressOnly 拒绝连接回环、内网、链路本地等非公网地址（在DNS解析之后检查，重定向同样受限）
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
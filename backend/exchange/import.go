@@ -0,0 +1,139 @@
+package exchange
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"strings"
+	"unicode"
+)
+
+// ImportIssue 导入过程中被跳过或出错的一个文件
+type ImportIssue struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport 导入结果
+type ImportReport struct {
+	Created int           `json:"created"`
+	Skipped []ImportIssue `json:"skipped"`
+	Errors  []ImportIssue `json:"errors"`
+}
+
+func newImportReport() *ImportReport {
+	return &ImportReport{
+		Skipped: make([]ImportIssue, 0),
+		Errors:  make([]ImportIssue, 0),
+	}
+}
+
+func (r *ImportReport) skip(path, reason string) {
+	r.Skipped = append(r.Skipped, ImportIssue{Path: path, Reason: reason})
+}
+
+func (r *ImportReport) fail(path, reason string) {
+	r.Errors = append(r.Errors, ImportIssue{Path: path, Reason: reason})
+}
+
+// naturalLess 按“自然顺序”比较文件名：数字部分按数值比较，其余部分忽略大小写
+// 这样 "2 xx" 排在 "10 xx" 之前，与文件管理器中看到的顺序一致。
+func naturalLess(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		if unicode.IsDigit(ar[i]) && unicode.IsDigit(br[j]) {
+			si := i
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			sj := j
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+
+		ca, cb := unicode.ToLower(ar[i]), unicode.ToLower(br[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(ar)-i != len(br)-j {
+		return len(ar)-i < len(br)-j
+	}
+	return a < b
+}
+
+// maxTitleRunes 与knowledge_nodes.title的长度限制一致
+const maxTitleRunes = 255
+
+// newImportedNode 创建导入的节点；有子节点的总是folder，未指定类型的叶子节点为file
+func newImportedNode(title, content, nodeType string, children []*models.KnowledgeNode) *models.KnowledgeNode {
+	if len(children) > 0 {
+		nodeType = "folder"
+	} else if nodeType != "folder" {
+		nodeType = "file"
+	}
+	return &models.KnowledgeNode{
+		Type:     nodeType,
+		Title:    clampTitle(title),
+		Content:  content,
+		Children: children,
+	}
+}
+
+// clampTitle 去掉标题中的换行并限制长度，空标题使用默认名称
+func clampTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if title == "" {
+		return "Untitled"
+	}
+	if runes := []rune(title); len(runes) > maxTitleRunes {
+		title = string(runes[:maxTitleRunes])
+	}
+	return title
+}
+
+// countNodes 统计节点树中的节点数量
+func countNodes(nodes []*models.KnowledgeNode) int {
+	n := len(nodes)
+	for _, node := range nodes {
+		n += countNodes(node.Children)
+	}
+	return n
+}
+
+// newXMLDecoder 创建限制读取大小的XML解码器，除UTF-8外只支持ISO-8859-1
+func newXMLDecoder(r io.Reader) *xml.Decoder {
+	dec := xml.NewDecoder(io.LimitReader(r, maxImportTotalSize))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "utf8", "us-ascii":
+			return input, nil
+		case "iso-8859-1", "latin1":
+			data, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+			runes := make([]rune, len(data))
+			for i, b := range data {
+				runes[i] = rune(b)
+			}
+			return strings.NewReader(string(runes)), nil
+		}
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return dec
+}
@@ -0,0 +1,63 @@
+package exchange
+
+import (
+	"fmt"
+	"knowledge_master_backend/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportFixture 导出测试使用的知识库：空目录、多级目录，以及带有缩进、空行和XML特殊字符的内容
+func exportFixture() (*models.KnowledgeBase, []*models.KnowledgeNode) {
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	kb := &models.KnowledgeBase{
+		KBID:        "3f6c1d2e-0000-4000-8000-000000000001",
+		Name:        "Team <Notes> & more",
+		Description: "shared notes",
+		CreatedAt:   created,
+		UpdatedAt:   created,
+	}
+	tree := []*models.KnowledgeNode{
+		{NodeID: "n1", Type: "folder", Title: "Guides", Children: []*models.KnowledgeNode{
+			{NodeID: "n2", Type: "file", Title: "Install & run", Content: "# Install\n\n  go build ./...\n\nUse <kbd>Ctrl</kbd> & \"quotes\""},
+			{NodeID: "n3", Type: "folder", Title: "Deep", Children: []*models.KnowledgeNode{
+				{NodeID: "n4", Type: "file", Title: "中文笔记", Content: "第一行\n第二行"},
+			}},
+		}},
+		{NodeID: "n5", Type: "folder", Title: "Empty folder"},
+		{NodeID: "n6", Type: "file", Title: "Empty file"},
+	}
+	return kb, tree
+}
+
+// dumpTree 以缩进表示层级输出节点的类型、标题和内容，便于比较导入结果
+func dumpTree(nodes []*models.KnowledgeNode, indent string) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "%s%s %q %q\n", indent, n.Type, n.Title, n.Content)
+		b.WriteString(dumpTree(n.Children, indent+"  "))
+	}
+	return b.String()
+}
+
+// mindMapRoot 思维导图只有一个中心主题，导入后知识库成为唯一的顶层目录
+func mindMapRoot(kb *models.KnowledgeBase, tree []*models.KnowledgeNode) []*models.KnowledgeNode {
+	return []*models.KnowledgeNode{{Type: "folder", Title: kb.Name, Content: kb.Description, Children: tree}}
+}
+
+func TestClampTitle(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"  a \n b\t", "a b"},
+		{"", "Untitled"},
+		{" \n ", "Untitled"},
+		{strings.Repeat("字", maxTitleRunes+10), strings.Repeat("字", maxTitleRunes)},
+	}
+	for _, tt := range tests {
+		if got := clampTitle(tt.in); got != tt.want {
+			t.Errorf("clampTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
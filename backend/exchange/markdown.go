@@ -0,0 +1,167 @@
+package exchange
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FolderIndexFile 目录节点自身的元数据（和内容）保存在目录下的这个文件中
+const FolderIndexFile = "_index.md"
+
+// MarkdownExporter 将知识库节点逐个写入zip，目录节点对应文件夹，其余节点对应.md文件
+// 文件节点也可以有子节点，这时子节点写入和.md文件同名的文件夹中。
+// 节点必须按先序写入（父节点在子节点之前），可以直接配合models.WalkKnowledgeTree使用。
+type MarkdownExporter struct {
+	zw    *zip.Writer
+	dirs  map[string]string          // 已创建文件夹的节点ID -> 在压缩包中的路径
+	files map[string]fileNode        // 文件节点ID -> 位置，第一个子节点写入时才创建文件夹
+	names map[string]map[string]bool // 父目录路径 -> 已使用的名称
+}
+
+// fileNode 已写入的文件节点所在的目录、文件名（不含扩展名）和修改时间
+type fileNode struct {
+	parentDir string
+	base      string
+	modified  time.Time
+}
+
+func NewMarkdownExporter(w io.Writer) *MarkdownExporter {
+	return &MarkdownExporter{
+		zw:    zip.NewWriter(w),
+		dirs:  make(map[string]string),
+		files: make(map[string]fileNode),
+		names: make(map[string]map[string]bool),
+	}
+}
+
+// WriteNode 写入一个节点
+func (e *MarkdownExporter) WriteNode(node *models.KnowledgeNode) error {
+	parentDir := ""
+	if node.ParentID != "" {
+		dir, err := e.parentDir(node)
+		if err != nil {
+			return err
+		}
+		parentDir = dir
+	}
+
+	base := fmt.Sprintf("%03d - %s", node.SortOrder, SanitizeFileName(node.Title))
+
+	if node.Type == "folder" {
+		dir := path.Join(parentDir, e.uniqueName(parentDir, base, "")) + "/"
+		e.dirs[node.NodeID] = dir
+		if _, err := e.zw.CreateHeader(&zip.FileHeader{Name: dir, Modified: node.UpdatedAt}); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+		return e.writeFile(path.Join(dir, FolderIndexFile), node)
+	}
+
+	name := e.uniqueName(parentDir, base, ".md")
+	e.files[node.NodeID] = fileNode{parentDir: parentDir, base: strings.TrimSuffix(name, ".md"), modified: node.UpdatedAt}
+	return e.writeFile(path.Join(parentDir, name), node)
+}
+
+// parentDir 返回父节点对应的文件夹，父节点是文件时在.md文件旁边创建同名文件夹
+func (e *MarkdownExporter) parentDir(node *models.KnowledgeNode) (string, error) {
+	if dir, ok := e.dirs[node.ParentID]; ok {
+		return dir, nil
+	}
+	file, ok := e.files[node.ParentID]
+	if !ok {
+		return "", fmt.Errorf("parent of node %s has not been exported", node.NodeID)
+	}
+	dir := path.Join(file.parentDir, e.uniqueName(file.parentDir, file.base, "")) + "/"
+	e.dirs[node.ParentID] = dir
+	if _, err := e.zw.CreateHeader(&zip.FileHeader{Name: dir, Modified: file.modified}); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// Close 写入zip目录并结束压缩包，不会关闭底层的io.Writer
+func (e *MarkdownExporter) Close() error {
+	return e.zw.Close()
+}
+
+func (e *MarkdownExporter) writeFile(name string, node *models.KnowledgeNode) error {
+	w, err := e.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: node.UpdatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", name, err)
+	}
+
+	if _, err := io.WriteString(w, FrontMatter(node)); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", name, err)
+	}
+	if _, err := io.WriteString(w, node.Content); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", name, err)
+	}
+	return nil
+}
+
+// uniqueName 在同一目录下为重名节点追加序号
+func (e *MarkdownExporter) uniqueName(dir, base, ext string) string {
+	used := e.names[dir]
+	if used == nil {
+		used = make(map[string]bool)
+		e.names[dir] = used
+	}
+
+	name := base + ext
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// FrontMatter 生成节点的YAML front matter
+func FrontMatter(node *models.KnowledgeNode) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	b.WriteString("id: " + node.NodeID + "\n")
+	b.WriteString("type: " + node.Type + "\n")
+	b.WriteString("title: " + strconv.Quote(node.Title) + "\n")
+	b.WriteString("sort_order: " + strconv.Itoa(node.SortOrder) + "\n")
+	b.WriteString("created_at: " + node.CreatedAt.UTC().Format(time.RFC3339) + "\n")
+	b.WriteString("updated_at: " + node.UpdatedAt.UTC().Format(time.RFC3339) + "\n")
+	b.WriteString("---\n\n")
+	return b.String()
+}
+
+// SanitizeFileName 去掉在常见文件系统中不合法的字符，并限制长度
+func SanitizeFileName(title string) string {
+	const maxRunes = 100
+
+	var b strings.Builder
+	n := 0
+	for _, r := range title {
+		if n >= maxRunes {
+			break
+		}
+		switch {
+		case r < 0x20 || r == 0x7f:
+			continue
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+		n++
+	}
+
+	name := strings.Trim(b.String(), " .")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
@@ -0,0 +1,559 @@
+package exchange
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/storage"
+	"knowledge_master_backend/utils"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 导入限制，防止超大文件或压缩炸弹
+const (
+	maxImportEntries   = 20000
+	maxImportFileSize  = 10 << 20  // 单个Markdown文件
+	maxImportTotalSize = 200 << 20 // 解压后的总大小
+)
+
+var ErrImportTooLarge = errors.New("archive is too large to import")
+
+var (
+	// 导出时添加的 "001 - " 排序前缀
+	sortPrefixPattern = regexp.MustCompile(`^\d+ - `)
+	// ![alt](path "title")
+	markdownImagePattern = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(\s+"[^"]*")?\s*\)`)
+	// Obsidian 嵌入 ![[image.png|alias]]
+	wikiEmbedPattern = regexp.MustCompile(`!\[\[([^\]|#]+)(#[^\]|]*)?(\|([^\]]*))?\]\]`)
+	// Obsidian 图片尺寸写法 ![[image.png|100]] / ![[image.png|100x200]]
+	imageSizePattern = regexp.MustCompile(`^\d+(x\d+)?$`)
+)
+
+// imageExtensions 按扩展名判断嵌入的是图片，实际类型上传前再按内容识别
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+	".bmp":  true,
+	".svg":  true,
+}
+
+// front matter 中由导出写入、导入时读取的字段
+var knownFrontMatterKeys = map[string]bool{
+	"id": true, "type": true, "title": true, "sort_order": true, "created_at": true, "updated_at": true,
+}
+
+// mdEntry 解析过程中的目录或文件
+type mdEntry struct {
+	node     *models.KnowledgeNode
+	name     string // 去掉扩展名的文件名，用于同级排序
+	dir      string // 所在目录在压缩包中的路径
+	children []*mdEntry
+	assets   bool // 目录中直接包含非Markdown文件
+	isDir    bool // 压缩包中的目录
+	indexed  bool // 目录中有_index.md
+}
+
+// markdownImport 一次Markdown压缩包导入的解析状态
+type markdownImport struct {
+	report    *ImportReport
+	dirs      map[string]*mdEntry
+	notes     []*mdEntry
+	assets    map[string]*zip.File
+	byBase    map[string][]string // 小写文件名 -> 资源路径，用于解析Obsidian的短链接
+	used      map[string]bool     // 被笔记引用过的资源
+	totalRead int64
+
+	store     storage.Storage
+	keyPrefix string
+	stored    map[imageRef]string // 已上传的图片 -> 访问地址
+	images    []models.ImportAttachment
+}
+
+// imageRef 笔记引用的一张图片，每个笔记引用的图片单独保存为该节点的附件
+type imageRef struct {
+	node  *models.KnowledgeNode
+	asset string
+}
+
+// ParseMarkdownZip 解析包含Markdown文件和目录的zip（含Obsidian库），返回待插入的节点树
+// 目录成为folder节点，.md文件成为file节点（front matter中的type优先），同级按文件名自然排序。
+// 笔记之间的[[wikilink]]原样保留；相对路径图片和![[图片]]嵌入的图片以keyPrefix开头的key上传到store，
+// 链接改写为图片的地址，返回的附件需要在节点插入后随节点一起保存。
+// 无法导入的单个文件记录在报告中，不会中断整个导入。返回错误时调用方负责删除已上传的图片。
+func ParseMarkdownZip(r io.ReaderAt, size int64, store storage.Storage, keyPrefix string) ([]*models.KnowledgeNode, []models.ImportAttachment, *ImportReport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not a valid zip archive: %w", err)
+	}
+	if len(zr.File) > maxImportEntries {
+		return nil, nil, nil, ErrImportTooLarge
+	}
+
+	root := &mdEntry{}
+	imp := &markdownImport{
+		report:    newImportReport(),
+		dirs:      map[string]*mdEntry{"": root},
+		assets:    make(map[string]*zip.File),
+		byBase:    make(map[string][]string),
+		used:      make(map[string]bool),
+		store:     store,
+		keyPrefix: keyPrefix,
+		stored:    make(map[imageRef]string),
+	}
+
+	hidden := make(map[string]bool)
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		isDir := strings.HasSuffix(name, "/") || f.FileInfo().IsDir()
+		// 先检查再去掉结尾的"/"，以"/"开头的绝对路径不能被当作相对路径导入
+		if unsafePath(name) {
+			imp.report.fail(f.Name, "unsafe path")
+			continue
+		}
+		name = strings.TrimRight(name, "/")
+		if name == "" {
+			continue
+		}
+		name = path.Clean(name)
+
+		if p := hiddenPrefix(name); p != "" {
+			if !hidden[p] {
+				hidden[p] = true
+				imp.report.skip(p, "hidden file or directory")
+			}
+			continue
+		}
+
+		if isDir {
+			imp.ensureDir(name)
+			continue
+		}
+
+		ext := strings.ToLower(path.Ext(name))
+		if ext != ".md" && ext != ".markdown" {
+			imp.assets[name] = f
+			imp.ensureDir(parentDir(name)).assets = true
+			base := strings.ToLower(path.Base(name))
+			imp.byBase[base] = append(imp.byBase[base], name)
+			continue
+		}
+
+		if err := imp.addNote(f, name); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for _, note := range imp.notes {
+		content, err := imp.rewriteImages(note.node, note.dir)
+		if err != nil {
+			return nil, imp.images, nil, err
+		}
+		note.node.Content = content
+	}
+
+	assetPaths := make([]string, 0, len(imp.assets))
+	for p := range imp.assets {
+		if !imp.used[p] {
+			assetPaths = append(assetPaths, p)
+		}
+	}
+	sort.Strings(assetPaths)
+	for _, p := range assetPaths {
+		imp.report.skip(p, "not a Markdown file")
+	}
+
+	return buildNodes(root), imp.images, imp.report, nil
+}
+
+// ensureDir 返回目录对应的条目，不存在时连同上级目录一起创建
+func (imp *markdownImport) ensureDir(dir string) *mdEntry {
+	if entry, ok := imp.dirs[dir]; ok {
+		return entry
+	}
+
+	parent := imp.ensureDir(parentDir(dir))
+	base := path.Base(dir)
+	entry := &mdEntry{
+		node:  &models.KnowledgeNode{Type: "folder", Title: clampTitle(titleFromName(base))},
+		name:  base,
+		dir:   parentDir(dir),
+		isDir: true,
+	}
+	parent.children = append(parent.children, entry)
+	imp.dirs[dir] = entry
+	return entry
+}
+
+// addNote 读取一个Markdown文件；文件本身的问题记入报告，只有超出总大小限制时返回错误
+func (imp *markdownImport) addNote(f *zip.File, name string) error {
+	if f.UncompressedSize64 > maxImportFileSize {
+		imp.report.fail(name, "file is larger than 10MB")
+		return nil
+	}
+	data, err := imp.readFile(f, maxImportFileSize)
+	if err != nil {
+		if errors.Is(err, ErrImportTooLarge) {
+			return err
+		}
+		imp.report.fail(name, err.Error())
+		return nil
+	}
+	if !utf8.Valid(data) {
+		imp.report.fail(name, "file is not valid UTF-8 text")
+		return nil
+	}
+
+	fields, body := parseFrontMatter(string(data))
+	dir := parentDir(name)
+	parent := imp.ensureDir(dir)
+	base := path.Base(name)
+
+	// 目录自身的元数据和内容
+	if strings.EqualFold(base, FolderIndexFile) && parent.node != nil {
+		if title := fields["title"]; title != "" {
+			parent.node.Title = clampTitle(title)
+		}
+		parent.node.Content = body
+		parent.indexed = true
+		imp.notes = append(imp.notes, &mdEntry{node: parent.node, dir: dir})
+		return nil
+	}
+
+	node := &models.KnowledgeNode{
+		Type:    "file",
+		Title:   clampTitle(titleFromName(strings.TrimSuffix(base, path.Ext(base)))),
+		Content: body,
+	}
+	if title := fields["title"]; title != "" {
+		node.Title = clampTitle(title)
+	}
+	switch t := fields["type"]; t {
+	case "", "file", "folder":
+		if t != "" {
+			node.Type = t
+		}
+	default:
+		imp.report.fail(name, fmt.Sprintf("unknown node type %q, imported as file", t))
+	}
+
+	entry := &mdEntry{node: node, name: strings.TrimSuffix(base, path.Ext(base)), dir: dir}
+	parent.children = append(parent.children, entry)
+	imp.notes = append(imp.notes, entry)
+	return nil
+}
+
+// readFile 读取压缩包中的文件，同时累计解压总量
+func (imp *markdownImport) readFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+
+	imp.totalRead += int64(len(data))
+	if imp.totalRead > maxImportTotalSize {
+		return nil, ErrImportTooLarge
+	}
+	return data, nil
+}
+
+// rewriteImages 上传笔记引用的压缩包内图片并改写链接，无法处理的链接保持原样
+func (imp *markdownImport) rewriteImages(node *models.KnowledgeNode, dir string) (string, error) {
+	var failure error
+
+	content := markdownImagePattern.ReplaceAllStringFunc(node.Content, func(m string) string {
+		sub := markdownImagePattern.FindStringSubmatch(m)
+		target := sub[2]
+		if strings.Contains(target, "://") || strings.HasPrefix(target, "data:") ||
+			strings.HasPrefix(target, "/") || strings.HasPrefix(target, "#") {
+			return m
+		}
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+
+		asset := path.Join(dir, target)
+		if _, ok := imp.assets[asset]; !ok {
+			return m
+		}
+		uri, err := imp.storeImage(node, asset)
+		if err != nil {
+			failure = err
+		}
+		if uri == "" {
+			return m
+		}
+		return "![" + sub[1] + "](" + uri + sub[3] + ")"
+	})
+	if failure != nil {
+		return "", failure
+	}
+
+	content = wikiEmbedPattern.ReplaceAllStringFunc(content, func(m string) string {
+		sub := wikiEmbedPattern.FindStringSubmatch(m)
+		target := strings.TrimSpace(sub[1])
+		if !imageExtensions[strings.ToLower(path.Ext(target))] {
+			return m // 嵌入的是笔记或其它文件
+		}
+
+		asset := imp.resolveEmbed(dir, target)
+		if asset == "" {
+			return m
+		}
+		uri, err := imp.storeImage(node, asset)
+		if err != nil {
+			failure = err
+		}
+		if uri == "" {
+			return m
+		}
+
+		alt := strings.TrimSpace(sub[4])
+		if alt == "" || imageSizePattern.MatchString(alt) {
+			alt = strings.TrimSuffix(path.Base(target), path.Ext(target))
+		}
+		return "![" + alt + "](" + uri + ")"
+	})
+	if failure != nil {
+		return "", failure
+	}
+
+	return content, nil
+}
+
+// resolveEmbed 按Obsidian的规则查找嵌入的文件：相对当前目录、库根目录，最后按文件名匹配
+func (imp *markdownImport) resolveEmbed(dir, target string) string {
+	for _, candidate := range []string{path.Join(dir, target), path.Clean(target)} {
+		if _, ok := imp.assets[candidate]; ok {
+			return candidate
+		}
+	}
+	if matches := imp.byBase[strings.ToLower(path.Base(target))]; len(matches) > 0 {
+		return matches[0]
+	}
+	return ""
+}
+
+// storeImage 把图片上传为节点的附件并返回访问地址；不能上传时记入报告并返回空字符串
+func (imp *markdownImport) storeImage(node *models.KnowledgeNode, asset string) (string, error) {
+	ref := imageRef{node: node, asset: asset}
+	if url, ok := imp.stored[ref]; ok {
+		return url, nil
+	}
+	first := !imp.used[asset]
+	imp.used[asset] = true
+
+	f := imp.assets[asset]
+	if !imageExtensions[strings.ToLower(path.Ext(asset))] {
+		if first {
+			imp.report.skip(asset, "only images are imported as attachments, link kept")
+		}
+		return "", nil
+	}
+	if err := utils.CheckFileSize(utils.FileKindImage, int64(f.UncompressedSize64)); err != nil {
+		if first {
+			imp.report.fail(asset, err.Error()+", link kept")
+		}
+		return "", nil
+	}
+
+	data, err := imp.readFile(f, utils.FileKindImage.MaxSize)
+	if err != nil {
+		if errors.Is(err, ErrImportTooLarge) {
+			return "", err
+		}
+		if first {
+			imp.report.fail(asset, err.Error())
+		}
+		return "", nil
+	}
+	// 按内容识别类型，SVG等可能被浏览器当作网页执行的文件不作为图片上传
+	contentType, ext, kind := utils.SniffFile(data, asset)
+	if kind != utils.FileKindImage {
+		if first {
+			imp.report.fail(asset, "not a supported image type, link kept")
+		}
+		return "", nil
+	}
+
+	name, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate file name: %w", err)
+	}
+	key := imp.keyPrefix + name + ext
+	if err := imp.store.Put(key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return "", fmt.Errorf("failed to store image %s: %w", asset, err)
+	}
+
+	url := imp.store.URL(key)
+	imp.stored[ref] = url
+	imp.images = append(imp.images, models.ImportAttachment{
+		Node: node,
+		Attachment: &models.Attachment{
+			FileName:    path.Base(asset),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			URL:         url,
+			StorageKey:  key,
+		},
+	})
+	return url, nil
+}
+
+// buildNodes 按文件名自然排序并生成节点树
+// 只存放图片等附件、不含任何笔记的目录（如Obsidian的附件目录）不会成为节点。
+// 和同级.md文件同名且没有_index.md的目录是导出时文件节点的子节点，合并到该文件节点下。
+func buildNodes(entry *mdEntry) []*models.KnowledgeNode {
+	files := make(map[string]*mdEntry)
+	for _, child := range entry.children {
+		if !child.isDir {
+			files[strings.ToLower(child.name)] = child
+		}
+	}
+	children := make([]*mdEntry, 0, len(entry.children))
+	for _, child := range entry.children {
+		if file := files[strings.ToLower(child.name)]; child.isDir && !child.indexed && file != nil {
+			file.children = append(file.children, child.children...)
+			continue
+		}
+		children = append(children, child)
+	}
+	entry.children = children
+
+	sort.SliceStable(entry.children, func(i, j int) bool {
+		return naturalLess(entry.children[i].name, entry.children[j].name)
+	})
+
+	nodes := make([]*models.KnowledgeNode, 0, len(entry.children))
+	for _, child := range entry.children {
+		child.node.Children = buildNodes(child)
+		if child.assets && len(child.node.Children) == 0 && child.node.Content == "" {
+			continue
+		}
+		nodes = append(nodes, child.node)
+	}
+	return nodes
+}
+
+// parseFrontMatter 读取文件开头的YAML front matter中的顶层标量字段
+// 只包含导出字段（id/type/title等）时从正文中去掉；含有其它字段（如Obsidian的tags）时原样保留在正文中。
+func parseFrontMatter(text string) (map[string]string, string) {
+	fields := make(map[string]string)
+	text = strings.TrimPrefix(text, "\ufeff")
+
+	normalized := strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(normalized, "---\n") {
+		return fields, text
+	}
+
+	lines := strings.Split(normalized, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "---" || lines[i] == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return fields, text
+	}
+
+	onlyKnown := true
+	for _, line := range lines[1:end] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "-") {
+			onlyKnown = false
+			continue
+		}
+		key = strings.TrimSpace(key)
+		fields[key] = unquoteYAML(strings.TrimSpace(value))
+		if !knownFrontMatterKeys[key] {
+			onlyKnown = false
+		}
+	}
+
+	if !onlyKnown {
+		return fields, text
+	}
+	body := strings.Join(lines[end+1:], "\n")
+	return fields, strings.TrimPrefix(body, "\n")
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+			return value[1 : len(value)-1]
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
+
+// titleFromName 由文件名得到节点标题，去掉导出时添加的排序前缀
+func titleFromName(name string) string {
+	title := strings.TrimSpace(sortPrefixPattern.ReplaceAllString(name, ""))
+	if title == "" {
+		return name
+	}
+	return title
+}
+
+func parentDir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// unsafePath 拒绝绝对路径（含Windows盘符）和包含..的路径
+func unsafePath(name string) bool {
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenPrefix 返回路径中第一个隐藏文件或目录（含macOS的__MACOSX）为止的前缀
+func hiddenPrefix(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package exchange
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/storage"
+	"strings"
+	"testing"
+)
+
+// zipEntry 压缩包中的一个文件，名称以"/"结尾时为目录
+type zipEntry struct {
+	name, content string
+}
+
+// newZip 在内存中生成压缩包
+func newZip(t *testing.T, entries ...zipEntry) (*bytes.Reader, int64) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes()), int64(buf.Len())
+}
+
+// pngImage 一张1x1的PNG图片
+func pngImage(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// nodeTitles 以缩进表示层级列出节点的类型和标题
+func nodeTitles(nodes []*models.KnowledgeNode, indent string) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(indent + n.Type + " " + n.Title + "\n")
+		b.WriteString(nodeTitles(n.Children, indent+"  "))
+	}
+	return b.String()
+}
+
+func findNode(nodes []*models.KnowledgeNode, title string) *models.KnowledgeNode {
+	for _, n := range nodes {
+		if n.Title == title {
+			return n
+		}
+		if found := findNode(n.Children, title); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func issuePaths(issues []ImportIssue) map[string]string {
+	paths := make(map[string]string, len(issues))
+	for _, issue := range issues {
+		paths[issue.Path] = issue.Reason
+	}
+	return paths
+}
+
+func newTestStorage(t *testing.T) *storage.Local {
+	t.Helper()
+	store, err := storage.NewLocal(storage.LocalConfig{Dir: t.TempDir(), BaseURL: "/files"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestParseMarkdownZipTree(t *testing.T) {
+	r, size := newZip(t,
+		zipEntry{"notes/", ""},
+		zipEntry{"notes/_index.md", "---\ntitle: My Notes\n---\nintro"},
+		zipEntry{"notes/10 - b.md", "b"},
+		zipEntry{"notes/2 - a.md", "a"},
+		zipEntry{"notes/deep/nested/c.md", "---\ntype: folder\n---\n"},
+		zipEntry{"vault.md", "---\ntags: [x]\n---\nSee [[other note|alias]]"},
+		zipEntry{"file.md", "parent file"},
+		zipEntry{"file/child.md", "child of a file"},
+		zipEntry{"attachments/unused.txt", "text"},
+		zipEntry{".obsidian/app.json", "{}"},
+		zipEntry{".obsidian/themes/x.css", ""},
+		zipEntry{"../evil.md", "escape"},
+		zipEntry{"a/../../evil2.md", "escape"},
+		zipEntry{"/abs.md", "absolute"},
+		zipEntry{"C:/win.md", "drive"},
+		zipEntry{"bad.md", "\xff\xfe"},
+		zipEntry{"odd.md", "---\ntype: widget\n---\nbody"},
+	)
+	nodes, attachments, report, err := ParseMarkdownZip(r, size, newTestStorage(t), "kb/test/import/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 0 {
+		t.Fatalf("attachments = %+v, want none", attachments)
+	}
+
+	want := `file file
+  file child
+folder My Notes
+  file a
+  file b
+  folder deep
+    folder nested
+      folder c
+file odd
+file vault
+`
+	if got := nodeTitles(nodes, ""); got != want {
+		t.Fatalf("tree:\n%s\nwant:\n%s", got, want)
+	}
+	if n := findNode(nodes, "My Notes"); n.Content != "intro" {
+		t.Errorf("folder content = %q, want the _index.md body", n.Content)
+	}
+	// 含有导出以外字段的front matter原样保留，wiki链接不改写
+	if n := findNode(nodes, "vault"); n.Content != "---\ntags: [x]\n---\nSee [[other note|alias]]" {
+		t.Errorf("vault note content = %q", n.Content)
+	}
+
+	errs := issuePaths(report.Errors)
+	for _, p := range []string{"../evil.md", "a/../../evil2.md", "/abs.md", "C:/win.md"} {
+		if errs[p] != "unsafe path" {
+			t.Errorf("%s: error %q, want unsafe path", p, errs[p])
+		}
+	}
+	if !strings.Contains(errs["bad.md"], "UTF-8") {
+		t.Errorf("bad.md: error %q, want invalid UTF-8", errs["bad.md"])
+	}
+	if !strings.Contains(errs["odd.md"], "unknown node type") {
+		t.Errorf("odd.md: error %q, want unknown node type", errs["odd.md"])
+	}
+	skipped := issuePaths(report.Skipped)
+	if skipped[".obsidian"] == "" || len(report.Skipped) != 2 {
+		t.Errorf("skipped = %+v, want .obsidian once and the unused attachment", report.Skipped)
+	}
+	if skipped["attachments/unused.txt"] != "not a Markdown file" {
+		t.Errorf("unused attachment: %q", skipped["attachments/unused.txt"])
+	}
+}
+
+func TestParseMarkdownZipImages(t *testing.T) {
+	pic := pngImage(t)
+	r, size := newZip(t,
+		zipEntry{"img/pic.png", pic},
+		zipEntry{"img/evil.svg", `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`},
+		zipEntry{"img/fake.png", "not an image"},
+		zipEntry{"img/doc.pdf", "%PDF-1.4"},
+		zipEntry{"notes/note.md", `![alt](../img/pic.png "title") ![[pic.png|100]] ![[evil.svg]] ![f](../img/fake.png) ![d](../img/doc.pdf) ![m](missing.png) ![r](https://example.com/a.png) ![[other note]]`},
+		zipEntry{"other.md", "![](img/pic.png)"},
+	)
+	store := newTestStorage(t)
+	nodes, attachments, report, err := ParseMarkdownZip(r, size, store, "kb/test/import/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个笔记引用的图片各自成为该节点的附件，同一笔记中重复引用只上传一次
+	note, other := findNode(nodes, "note"), findNode(nodes, "other")
+	if len(attachments) != 2 || attachments[0].Node != note || attachments[1].Node != other {
+		t.Fatalf("attachments = %+v, want one for each note", attachments)
+	}
+	for _, a := range attachments {
+		if !strings.HasPrefix(a.Attachment.StorageKey, "kb/test/import/") || !strings.HasSuffix(a.Attachment.StorageKey, ".png") ||
+			a.Attachment.ContentType != "image/png" || a.Attachment.FileName != "pic.png" || a.Attachment.Size != int64(len(pic)) {
+			t.Errorf("attachment = %+v", a.Attachment)
+		}
+		rc, err := store.Get(a.Attachment.StorageKey)
+		if err != nil {
+			t.Fatalf("stored image: %v", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != pic {
+			t.Errorf("stored image differs from the archive")
+		}
+	}
+
+	url := attachments[0].Attachment.URL
+	want := `![alt](` + url + ` "title") ![pic](` + url + `) ![[evil.svg]] ![f](../img/fake.png) ![d](../img/doc.pdf) ![m](missing.png) ![r](https://example.com/a.png) ![[other note]]`
+	if note.Content != want {
+		t.Errorf("note content:\n%s\nwant:\n%s", note.Content, want)
+	}
+	if other.Content != "![]("+attachments[1].Attachment.URL+")" {
+		t.Errorf("other content = %q", other.Content)
+	}
+
+	errs := issuePaths(report.Errors)
+	if !strings.Contains(errs["img/evil.svg"], "link kept") || !strings.Contains(errs["img/fake.png"], "link kept") {
+		t.Errorf("errors = %+v, want the SVG and the fake PNG reported", report.Errors)
+	}
+	if skipped := issuePaths(report.Skipped); len(skipped) != 1 || !strings.HasPrefix(skipped["img/doc.pdf"], "only images") {
+		t.Errorf("skipped = %+v, want only the PDF", report.Skipped)
+	}
+	// 附件目录不会成为节点
+	if findNode(nodes, "img") != nil {
+		t.Errorf("attachment folder imported as a node:\n%s", nodeTitles(nodes, ""))
+	}
+}
+
+func TestParseMarkdownZipInvalid(t *testing.T) {
+	data := []byte("not a zip archive")
+	if _, _, _, err := ParseMarkdownZip(bytes.NewReader(data), int64(len(data)), newTestStorage(t), ""); err == nil {
+		t.Fatal("parsed a file that is not a zip archive")
+	}
+}
@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"bytes"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"html"
+	"knowledge_master_backend/utils"
+)
+
+// mathExtension 解析与remark-math相同的公式语法并渲染为MathML：
+// 独占一行的 $$ 开始和结束公式块，行内用 $...$（或 $$...$$ 表示行内的展示公式）。
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 701)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 500)))
+}
+
+var (
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+	kindMathInline = ast.NewNodeKind("MathInline")
+)
+
+type mathBlock struct {
+	ast.BaseBlock
+	tex    bytes.Buffer
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex.String()}, nil)
+}
+
+type mathInline struct {
+	ast.BaseInline
+	tex     []byte
+	display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.tex)}, nil)
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	newline := 0
+	if line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	node := &mathBlock{}
+	rest := bytes.TrimSpace(line[pos+2:])
+	// $$ x $$ 写在同一行
+	if len(rest) >= 2 && bytes.HasSuffix(rest, []byte("$$")) {
+		node.tex.Write(rest[:len(rest)-2])
+		node.closed = true
+	} else if len(rest) > 0 {
+		node.tex.Write(rest)
+		node.tex.WriteByte('\n')
+	}
+	reader.Advance(segment.Len() - newline)
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	newline := 0
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	trimmed := bytes.TrimSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		n.tex.Write(trimmed[:len(trimmed)-2])
+		reader.Advance(segment.Len() - newline)
+		return parser.Close
+	}
+	n.tex.Write(line)
+	reader.Advance(segment.Len() - newline)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+// Parse 解析行内公式，$ 后不能紧跟空白、结束的 $ 前不能是空白且后面不能是数字，
+// 这样 "$5 和 $10" 这样的普通文本不会被当作公式。
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+
+	if bytes.HasPrefix(line, []byte("$$")) {
+		end := bytes.Index(line[2:], []byte("$$"))
+		if end <= 0 {
+			return nil
+		}
+		block.Advance(end + 4)
+		return &mathInline{tex: bytes.TrimSpace(line[2 : end+2]), display: true}
+	}
+
+	if len(line) < 3 || isMathSpace(line[1]) {
+		return nil
+	}
+	for i := 2; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if isMathSpace(line[i-1]) || i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+				continue
+			}
+			block.Advance(i + 1)
+			return &mathInline{tex: line[1:i]}
+		}
+	}
+	return nil
+}
+
+func isMathSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathBlock, renderMathBlock)
+	reg.Register(kindMathInline, renderMathInline)
+}
+
+// 无法解析的公式按原样显示为代码
+func renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	tex := node.(*mathBlock).tex.String()
+	if out, err := utils.TeXToMathML(tex, true); err == nil {
+		w.WriteString(`<div class="math">` + out + "</div>\n")
+	} else {
+		w.WriteString(`<pre class="math-error" title="` + html.EscapeString(err.Error()) + `">` + html.EscapeString(tex) + "</pre>\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*mathInline)
+	if out, err := utils.TeXToMathML(string(n.tex), n.display); err == nil {
+		w.WriteString(out)
+	} else {
+		w.WriteString(`<code class="math-error" title="` + html.EscapeString(err.Error()) + `">` + html.EscapeString(string(n.tex)) + "</code>")
+	}
+	return ast.WalkSkipChildren, nil
+}
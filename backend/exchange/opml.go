@@ -0,0 +1,95 @@
+package exchange
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"time"
+)
+
+// OPML 2.0 大纲，节点内容保存在大多数大纲工具通用的 _note 属性中
+type opmlDocument struct {
+	XMLName xml.Name     `xml:"opml"`
+	Version string       `xml:"version,attr"`
+	Head    opmlHead     `xml:"head"`
+	Body    opmlOutlines `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlOutlines struct {
+	Outlines []*opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Text     string         `xml:"text,attr"`
+	Title    string         `xml:"title,attr,omitempty"`
+	Note     string         `xml:"_note,attr,omitempty"`
+	NodeType string         `xml:"nodeType,attr,omitempty"` // 保留folder/file，便于重新导入
+	Outlines []*opmlOutline `xml:"outline"`
+}
+
+// WriteOPML 将知识库的树导出为OPML
+func WriteOPML(w io.Writer, kb *models.KnowledgeBase, tree []*models.KnowledgeNode) error {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       kb.Name,
+			DateCreated: kb.CreatedAt.UTC().Format(time.RFC1123Z),
+		},
+		Body: opmlOutlines{Outlines: toOPMLOutlines(tree)},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode OPML: %w", err)
+	}
+	return enc.Close()
+}
+
+func toOPMLOutlines(nodes []*models.KnowledgeNode) []*opmlOutline {
+	outlines := make([]*opmlOutline, 0, len(nodes))
+	for _, node := range nodes {
+		outlines = append(outlines, &opmlOutline{
+			Text:     node.Title,
+			Note:     node.Content,
+			NodeType: node.Type,
+			Outlines: toOPMLOutlines(node.Children),
+		})
+	}
+	return outlines
+}
+
+// ParseOPML 解析OPML大纲，body下的每个顶层outline成为一个顶层节点
+func ParseOPML(r io.Reader) ([]*models.KnowledgeNode, *ImportReport, error) {
+	var doc opmlDocument
+	if err := newXMLDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("not a valid OPML file: %w", err)
+	}
+
+	nodes := fromOPMLOutlines(doc.Body.Outlines)
+	if countNodes(nodes) > maxImportEntries {
+		return nil, nil, ErrImportTooLarge
+	}
+	return nodes, newImportReport(), nil
+}
+
+func fromOPMLOutlines(outlines []*opmlOutline) []*models.KnowledgeNode {
+	nodes := make([]*models.KnowledgeNode, 0, len(outlines))
+	for _, o := range outlines {
+		title := o.Text
+		if title == "" {
+			title = o.Title
+		}
+		nodes = append(nodes, newImportedNode(title, o.Note, o.NodeType, fromOPMLOutlines(o.Outlines)))
+	}
+	return nodes
+}
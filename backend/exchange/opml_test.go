@@ -0,0 +1,59 @@
+package exchange
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOPMLRoundTrip(t *testing.T) {
+	kb, tree := exportFixture()
+	var buf bytes.Buffer
+	if err := WriteOPML(&buf, kb, tree); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, report, err := ParseOPML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dumpTree(nodes, ""), dumpTree(tree, ""); got != want {
+		t.Errorf("round trip:\n%s\nwant:\n%s", got, want)
+	}
+	if len(report.Skipped) != 0 || len(report.Errors) != 0 {
+		t.Errorf("report = %+v, want no issues", report)
+	}
+}
+
+func TestParseOPMLFallbacks(t *testing.T) {
+	// 其它工具导出的OPML可能只有title属性、没有nodeType
+	doc := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<opml version="1.0"><head><title>x</title></head><body>
+  <outline title="Caf` + "\xe9" + `"><outline text="leaf" _note="body"/></outline>
+  <outline text=""/>
+</body></opml>`
+	nodes, _, err := ParseOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `folder "Café" ""
+  file "leaf" "body"
+file "Untitled" ""
+`
+	if got := dumpTree(nodes, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseOPMLMalformed(t *testing.T) {
+	for name, doc := range map[string]string{
+		"empty":     "",
+		"truncated": `<opml version="2.0"><body><outline text="a">`,
+		"not xml":   "# just markdown",
+		"charset":   `<?xml version="1.0" encoding="Shift_JIS"?><opml><body/></opml>`,
+	} {
+		if _, _, err := ParseOPML(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: parsed malformed OPML", name)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package exchange
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"knowledge_master_backend/models"
+	"strings"
+)
+
+// XMind 文件是一个zip：XMind 2020及以后的版本读取content.json，XMind 8读取content.xml。
+// 导出时两者都写入；导入时优先读取content.json。和FreeMind一样，中心主题对应知识库本身。
+
+type xmindSheet struct {
+	ID        string      `json:"id"`
+	Class     string      `json:"class"`
+	Title     string      `json:"title"`
+	RootTopic *xmindTopic `json:"rootTopic"`
+}
+
+type xmindTopic struct {
+	ID       string         `json:"id"`
+	Class    string         `json:"class,omitempty"`
+	Title    string         `json:"title"`
+	Notes    *xmindNotes    `json:"notes,omitempty"`
+	Labels   []string       `json:"labels,omitempty"`
+	Children *xmindChildren `json:"children,omitempty"`
+}
+
+type xmindNotes struct {
+	Plain *xmindPlain `json:"plain,omitempty"`
+}
+
+type xmindPlain struct {
+	Content string `json:"content"`
+}
+
+type xmindChildren struct {
+	Attached []*xmindTopic `json:"attached,omitempty"`
+	Detached []*xmindTopic `json:"detached,omitempty"`
+}
+
+// XMind 8 的 content.xml
+type xmindXMLContent struct {
+	XMLName xml.Name        `xml:"urn:xmind:xmap:xmlns:content:2.0 xmap-content"`
+	Version string          `xml:"version,attr"`
+	Sheets  []xmindXMLSheet `xml:"sheet"`
+}
+
+type xmindXMLSheet struct {
+	ID    string         `xml:"id,attr"`
+	Topic *xmindXMLTopic `xml:"topic"`
+	Title string         `xml:"title"`
+}
+
+type xmindXMLTopic struct {
+	ID       string            `xml:"id,attr"`
+	Title    string            `xml:"title"`
+	Notes    *xmindXMLNotes    `xml:"notes,omitempty"`
+	Labels   *xmindXMLLabels   `xml:"labels,omitempty"`
+	Children *xmindXMLChildren `xml:"children,omitempty"`
+}
+
+type xmindXMLNotes struct {
+	Plain string `xml:"plain"`
+}
+
+type xmindXMLLabels struct {
+	Labels []string `xml:"label"`
+}
+
+type xmindXMLChildren struct {
+	Topics []xmindXMLTopics `xml:"topics"`
+}
+
+type xmindXMLTopics struct {
+	Type   string           `xml:"type,attr"`
+	Topics []*xmindXMLTopic `xml:"topic"`
+}
+
+// xmindFolderLabel 导出时给目录节点加上的标签，用于重新导入时还原节点类型
+const xmindFolderLabel = "folder"
+
+// WriteXMind 将知识库的树导出为XMind文件
+func WriteXMind(w io.Writer, kb *models.KnowledgeBase, tree []*models.KnowledgeNode) error {
+	root := &xmindTopic{
+		ID:       kb.KBID,
+		Class:    "topic",
+		Title:    kb.Name,
+		Children: &xmindChildren{Attached: toXMindTopics(tree)},
+	}
+	if kb.Description != "" {
+		root.Notes = &xmindNotes{Plain: &xmindPlain{Content: kb.Description}}
+	}
+	sheet := &xmindSheet{ID: "sheet-" + kb.KBID, Class: "sheet", Title: kb.Name, RootTopic: root}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"content.json", func(w io.Writer) error {
+			return json.NewEncoder(w).Encode([]*xmindSheet{sheet})
+		}},
+		{"content.xml", func(w io.Writer) error {
+			if _, err := io.WriteString(w, xml.Header); err != nil {
+				return err
+			}
+			return xml.NewEncoder(w).Encode(xmindXMLContent{
+				Version: "2.0",
+				Sheets:  []xmindXMLSheet{{ID: sheet.ID, Topic: toXMindXMLTopic(root), Title: sheet.Title}},
+			})
+		}},
+		{"metadata.json", func(w io.Writer) error {
+			_, err := io.WriteString(w, `{"creator":{"name":"Knowledge Master"}}`)
+			return err
+		}},
+		{"manifest.json", func(w io.Writer) error {
+			_, err := io.WriteString(w, `{"file-entries":{"content.json":{},"metadata.json":{}}}`)
+			return err
+		}},
+		{"META-INF/manifest.xml", func(w io.Writer) error {
+			_, err := io.WriteString(w, xml.Header+
+				`<manifest xmlns="urn:xmind:xmap:xmlns:manifest:1.0">`+
+				`<file-entry full-path="content.xml" media-type="text/xml"/>`+
+				`<file-entry full-path="META-INF/" media-type=""/>`+
+				`<file-entry full-path="META-INF/manifest.xml" media-type="text/xml"/>`+
+				`</manifest>`)
+			return err
+		}},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", f.name, err)
+		}
+		if err := f.write(fw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func toXMindTopics(nodes []*models.KnowledgeNode) []*xmindTopic {
+	topics := make([]*xmindTopic, 0, len(nodes))
+	for _, node := range nodes {
+		t := &xmindTopic{ID: node.NodeID, Title: node.Title}
+		if node.Content != "" {
+			t.Notes = &xmindNotes{Plain: &xmindPlain{Content: node.Content}}
+		}
+		if node.Type == "folder" {
+			t.Labels = []string{xmindFolderLabel}
+		}
+		if len(node.Children) > 0 {
+			t.Children = &xmindChildren{Attached: toXMindTopics(node.Children)}
+		}
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+func toXMindXMLTopic(t *xmindTopic) *xmindXMLTopic {
+	x := &xmindXMLTopic{ID: t.ID, Title: t.Title}
+	if t.Notes != nil && t.Notes.Plain != nil {
+		x.Notes = &xmindXMLNotes{Plain: t.Notes.Plain.Content}
+	}
+	if len(t.Labels) > 0 {
+		x.Labels = &xmindXMLLabels{Labels: t.Labels}
+	}
+	if t.Children != nil && len(t.Children.Attached) > 0 {
+		topics := make([]*xmindXMLTopic, 0, len(t.Children.Attached))
+		for _, child := range t.Children.Attached {
+			topics = append(topics, toXMindXMLTopic(child))
+		}
+		x.Children = &xmindXMLChildren{Topics: []xmindXMLTopics{{Type: "attached", Topics: topics}}}
+	}
+	return x
+}
+
+// ParseXMind 解析XMind文件，每个画布的中心主题成为一个顶层节点
+func ParseXMind(r io.ReaderAt, size int64) ([]*models.KnowledgeNode, *ImportReport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a valid XMind file: %w", err)
+	}
+
+	var jsonFile, xmlFile *zip.File
+	for _, f := range zr.File {
+		switch f.Name {
+		case "content.json":
+			jsonFile = f
+		case "content.xml":
+			xmlFile = f
+		}
+	}
+
+	var nodes []*models.KnowledgeNode
+	switch {
+	case jsonFile != nil:
+		nodes, err = parseXMindJSON(jsonFile)
+	case xmlFile != nil:
+		nodes, err = parseXMindXML(xmlFile)
+	default:
+		err = fmt.Errorf("not a valid XMind file: content.json or content.xml is missing")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if countNodes(nodes) > maxImportEntries {
+		return nil, nil, ErrImportTooLarge
+	}
+	return nodes, newImportReport(), nil
+}
+
+func parseXMindJSON(f *zip.File) ([]*models.KnowledgeNode, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content.json: %w", err)
+	}
+	defer rc.Close()
+
+	var sheets []*xmindSheet
+	if err := json.NewDecoder(io.LimitReader(rc, maxImportTotalSize)).Decode(&sheets); err != nil {
+		return nil, fmt.Errorf("not a valid XMind file: %w", err)
+	}
+
+	nodes := make([]*models.KnowledgeNode, 0, len(sheets))
+	for _, sheet := range sheets {
+		if sheet.RootTopic != nil {
+			nodes = append(nodes, fromXMindTopic(sheet.RootTopic))
+		}
+	}
+	return nodes, nil
+}
+
+func fromXMindTopic(t *xmindTopic) *models.KnowledgeNode {
+	var children []*models.KnowledgeNode
+	if t.Children != nil {
+		// 自由主题（detached）排在普通子主题之后
+		for _, child := range append(t.Children.Attached, t.Children.Detached...) {
+			children = append(children, fromXMindTopic(child))
+		}
+	}
+
+	note := ""
+	if t.Notes != nil && t.Notes.Plain != nil {
+		note = t.Notes.Plain.Content
+	}
+	return newImportedNode(t.Title, note, xmindNodeType(t.Labels), children)
+}
+
+func parseXMindXML(f *zip.File) ([]*models.KnowledgeNode, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content.xml: %w", err)
+	}
+	defer rc.Close()
+
+	var content xmindXMLContent
+	if err := newXMLDecoder(rc).Decode(&content); err != nil {
+		return nil, fmt.Errorf("not a valid XMind file: %w", err)
+	}
+
+	nodes := make([]*models.KnowledgeNode, 0, len(content.Sheets))
+	for _, sheet := range content.Sheets {
+		if sheet.Topic != nil {
+			nodes = append(nodes, fromXMindXMLTopic(sheet.Topic))
+		}
+	}
+	return nodes, nil
+}
+
+func fromXMindXMLTopic(t *xmindXMLTopic) *models.KnowledgeNode {
+	var children []*models.KnowledgeNode
+	if t.Children != nil {
+		for _, group := range t.Children.Topics {
+			for _, child := range group.Topics {
+				children = append(children, fromXMindXMLTopic(child))
+			}
+		}
+	}
+
+	note := ""
+	if t.Notes != nil {
+		note = strings.TrimSpace(t.Notes.Plain)
+	}
+	var labels []string
+	if t.Labels != nil {
+		labels = t.Labels.Labels
+	}
+	return newImportedNode(t.Title, note, xmindNodeType(labels), children)
+}
+
+func xmindNodeType(labels []string) string {
+	for _, label := range labels {
+		if label == xmindFolderLabel {
+			return "folder"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package exchange
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// withoutEntry 复制zip文件并去掉其中一个条目
+func withoutEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		if f.Name == name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXMindRoundTrip(t *testing.T) {
+	kb, tree := exportFixture()
+	var buf bytes.Buffer
+	if err := WriteXMind(&buf, kb, tree); err != nil {
+		t.Fatal(err)
+	}
+	want := dumpTree(mindMapRoot(kb, tree), "")
+
+	// 新版XMind读取content.json，XMind 8只有content.xml
+	for name, data := range map[string][]byte{
+		"content.json": buf.Bytes(),
+		"content.xml":  withoutEntry(t, buf.Bytes(), "content.json"),
+	} {
+		nodes, report, err := ParseXMind(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := dumpTree(nodes, ""); got != want {
+			t.Errorf("%s round trip:\n%s\nwant:\n%s", name, got, want)
+		}
+		if len(report.Skipped) != 0 || len(report.Errors) != 0 {
+			t.Errorf("%s: report = %+v, want no issues", name, report)
+		}
+	}
+}
+
+func TestParseXMindMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"not a zip":    []byte("<xmap-content/>"),
+		"no content":   newZipBytes(t, zipEntry{"metadata.json", "{}"}),
+		"invalid json": newZipBytes(t, zipEntry{"content.json", `[{"rootTopic":`}),
+		"json object":  newZipBytes(t, zipEntry{"content.json", `{"rootTopic":{}}`}),
+		"invalid xml":  newZipBytes(t, zipEntry{"content.xml", `<xmap-content xmlns="urn:xmind:xmap:xmlns:content:2.0"><sheet>`}),
+		"wrong xmlns":  newZipBytes(t, zipEntry{"content.xml", `<xmap-content><sheet><topic><title>a</title></topic></sheet></xmap-content>`}),
+	}
+	for name, data := range tests {
+		if _, _, err := ParseXMind(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: parsed malformed XMind file", name)
+		}
+	}
+}
+
+func newZipBytes(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	r, size := newZip(t, entries...)
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
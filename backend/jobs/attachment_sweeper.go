@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"database/sql"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/storage"
+	"log"
+	"time"
+)
+
+// 每轮最多清理的附件数量
+const attachmentSweepBatch = 500
+
+// StartAttachmentSweeper 定期删除所属节点或知识库已被彻底删除的附件（存储中的文件和记录）
+func StartAttachmentSweeper(db *sql.DB, store storage.Storage, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			sweepOrphanedAttachments(db, store)
+			<-ticker.C
+		}
+	}()
+}
+
+func sweepOrphanedAttachments(db *sql.DB, store storage.Storage) {
+	attachments, err := models.GetOrphanedAttachments(db, attachmentSweepBatch)
+	if err != nil {
+		log.Printf("清理附件失败: %v", err)
+		return
+	}
+
+	removed := 0
+	for _, a := range attachments {
+		// 文件删除失败时保留记录，下一轮重试
+		if err := store.Delete(a.StorageKey); err != nil {
+			log.Printf("删除附件文件失败 - Key: %s, 错误: %v", a.StorageKey, err)
+			continue
+		}
+		if err := models.DeleteAttachmentRecord(db, a.AttachmentID); err != nil {
+			log.Printf("删除附件记录失败 - ID: %s, 错误: %v", a.AttachmentID, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("附件清理完成 - 删除: %d", removed)
+	}
+}
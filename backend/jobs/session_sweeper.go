@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"database/sql"
+	"knowledge_master_backend/models"
+	"log"
+	"time"
+)
+
+const (
+	// 撤销的会话保留的时间，期间仍能识别重复使用的刷新令牌
+	revokedSessionRetention = 7 * 24 * time.Hour
+	// 已使用或已过期的邮件令牌保留的时间
+	userTokenRetention = 24 * time.Hour
+	// 过期的个人访问令牌在列表中保留的时间，便于用户知道哪些脚本需要更换令牌
+	accessTokenRetention = 30 * 24 * time.Hour
+)
+
+// StartSessionSweeper 定期删除已过期和撤销已久的登录会话，以及不再有效的邮件令牌、登录验证、单点登录状态和过期已久的个人访问令牌
+func StartSessionSweeper(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			sweepStaleSessions(db)
+			sweepStaleUserTokens(db)
+			sweepStaleLoginChallenges(db)
+			sweepStaleOIDCLoginStates(db)
+			sweepStaleAccessTokens(db)
+			<-ticker.C
+		}
+	}()
+}
+
+func sweepStaleSessions(db *sql.DB) {
+	n, err := models.DeleteStaleSessions(db, time.Now().Add(-revokedSessionRetention))
+	if err != nil {
+		log.Printf("清理会话失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("会话清理完成 - 删除: %d", n)
+	}
+}
+
+func sweepStaleUserTokens(db *sql.DB) {
+	n, err := models.DeleteStaleUserTokens(db, time.Now().Add(-userTokenRetention))
+	if err != nil {
+		log.Printf("清理邮件令牌失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("邮件令牌清理完成 - 删除: %d", n)
+	}
+}
+
+func sweepStaleLoginChallenges(db *sql.DB) {
+	n, err := models.DeleteStaleLoginChallenges(db, time.Now())
+	if err != nil {
+		log.Printf("清理登录验证失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("登录验证清理完成 - 删除: %d", n)
+	}
+}
+
+func sweepStaleOIDCLoginStates(db *sql.DB) {
+	n, err := models.DeleteStaleOIDCLoginStates(db, time.Now())
+	if err != nil {
+		log.Printf("清理单点登录状态失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("单点登录状态清理完成 - 删除: %d", n)
+	}
+}
+
+func sweepStaleAccessTokens(db *sql.DB) {
+	n, err := models.DeleteStaleAccessTokens(db, time.Now().Add(-accessTokenRetention))
+	if err != nil {
+		log.Printf("清理访问令牌失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("访问令牌清理完成 - 删除: %d", n)
+	}
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"database/sql"
+	"knowledge_master_backend/models"
+	"log"
+	"time"
+)
+
+// StartTrashPurger 定期彻底删除在回收站中超过保留期的节点和知识库
+func StartTrashPurger(db *sql.DB, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeExpiredTrash(db, retention)
+			<-ticker.C
+		}
+	}()
+}
+
+func purgeExpiredTrash(db *sql.DB, retention time.Duration) {
+	nodes, kbs, err := models.PurgeExpiredTrash(db, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("清理回收站失败: %v", err)
+		return
+	}
+	if nodes > 0 || kbs > 0 {
+		log.Printf("回收站清理完成 - 节点: %d, 知识库: %d", nodes, kbs)
+	}
+}
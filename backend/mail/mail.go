@@ -0,0 +1,50 @@
+// Package mail 发送邮件，SMTP用于生产环境，log只把邮件打印到控制台，用于开发
+package mail
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Sender 发送邮件
+type Sender interface {
+	Send(msg Message) error
+}
+
+// Config 邮件配置，Driver为smtp或log
+type Config struct {
+	Driver string     `mapstructure:"driver"`
+	From   string     `mapstructure:"from"` // 发件人，如 Knowledge Master <no-reply@example.com>
+	SMTP   SMTPConfig `mapstructure:"smtp"`
+	// LinkBaseURL 邮件中链接指向的前端地址，如 https://km.example.com
+	LinkBaseURL string `mapstructure:"link_base_url"`
+}
+
+// New 根据配置创建发件器
+func New(cfg Config) (Sender, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTP(cfg.SMTP, cfg.From)
+	case "log", "":
+		return &Log{From: cfg.From}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
+
+// Log 把邮件打印到日志而不发送，开发时可以直接从控制台复制邮件中的链接
+type Log struct {
+	From string
+}
+
+func (l *Log) Send(msg Message) error {
+	log.Printf("邮件（未发送）\nFrom: %s\nTo: %s\nSubject: %s\n\n%s", l.From, msg.To, msg.Subject, strings.TrimRight(msg.Text, "\n"))
+	return nil
+}
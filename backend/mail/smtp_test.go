@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP 只接受一封邮件的最小SMTP服务器，记录收到的命令和邮件内容
+type fakeSMTP struct {
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) (*fakeSMTP, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeSMTP{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			f.commands = append(f.commands, line)
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO":
+				tp.PrintfLine("250-fake")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				tp.PrintfLine("235 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := io.ReadAll(tp.DotReader())
+				if err != nil {
+					return
+				}
+				f.data = string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return f, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSMTPSend(t *testing.T) {
+	server, port := startFakeSMTP(t)
+	sender, err := NewSMTP(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "user",
+		Password: "pass",
+		TLS:      "none",
+	}, "Knowledge Master <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := strings.Repeat("请打开下面的链接验证邮箱。", 10) + "\nhttps://km.example.com/verify-email?token=abc\n"
+	err = sender.Send(Message{To: "Ann <ann@example.com>", Subject: "验证你的邮箱", Text: text})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+
+	want := []string{"AUTH PLAIN", "MAIL FROM:<no-reply@example.com>", "RCPT TO:<ann@example.com>", "DATA", "QUIT"}
+	got := strings.Join(server.commands, "\n")
+	for _, cmd := range want {
+		if !strings.Contains(got, cmd) {
+			t.Fatalf("command %q not sent, got:\n%s", cmd, got)
+		}
+	}
+
+	headerEnd := strings.Index(server.data, "\n\n")
+	if headerEnd < 0 {
+		t.Fatalf("no header in %q", server.data)
+	}
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(server.data[:headerEnd+2]))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "验证你的邮箱" {
+		t.Fatalf("Subject = %q, %v", subject, err)
+	}
+	if header.Get("To") != `"Ann" <ann@example.com>` {
+		t.Fatalf("To = %q", header.Get("To"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(server.data[headerEnd+2:]), "\n")
+	for i, line := range lines {
+		if len(line) > 76 {
+			t.Fatalf("body line %d is %d characters long", i, len(line))
+		}
+	}
+	body, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != text {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	if s, err := New(Config{Driver: "log"}); err != nil {
+		t.Fatalf("log driver: %v", err)
+	} else if _, ok := s.(*Log); !ok {
+		t.Fatalf("log driver created %T", s)
+	}
+	if _, err := New(Config{Driver: "smtp", From: "no-reply@example.com"}); err == nil {
+		t.Fatal("smtp driver without a host accepted")
+	}
+	if _, err := New(Config{Driver: "pigeon"}); err == nil {
+		t.Fatal("unknown driver accepted")
+	}
+}
@@ -2,15 +2,68 @@ package main
 
 import (
 	"knowledge_master_backend/config"
+	"knowledge_master_backend/controllers"
+	"knowledge_master_backend/jobs"
+	"knowledge_master_backend/migrations"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/repository"
 	"knowledge_master_backend/routes"
+	"knowledge_master_backend/utils"
 	"log"
+	"os"
+	"time"
 )
 
 func main() {
-	if err := config.InitDB(); err != nil {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.App = cfg
+
+	if err := config.InitLogging(cfg.Log); err != nil {
+		log.Fatal("Logging initialization failed:", err)
+	}
+	if err := config.InitDB(cfg.Database); err != nil {
 		log.Fatal("Database connection failed:", err)
 	}
 
-	r := routes.SetupRoutes()
-	r.Run(":8084") // 默认监听 8080 端口
+	if runCommand(args) {
+		return
+	}
+
+	if err := migrations.Check(config.DB); err != nil {
+		log.Fatalf("%v\nrun \"%s migrate up\" before starting the server", err, os.Args[0])
+	}
+
+	if err := config.InitStorage(cfg.Storage); err != nil {
+		log.Fatal("Storage initialization failed:", err)
+	}
+	if err := config.InitMail(cfg.Mail); err != nil {
+		log.Fatal("Mail initialization failed:", err)
+	}
+	utils.InitJWT(cfg.JWT.Secret, cfg.JWT.TTL)
+
+	jobs.StartTrashPurger(config.DB, cfg.Trash.Retention(), time.Hour)
+	jobs.StartAttachmentSweeper(config.DB, config.Storage, 10*time.Minute)
+	jobs.StartSessionSweeper(config.DB, time.Hour)
+
+	srv := controllers.NewServer(repository.NewPostgres(config.DB), config.Storage)
+	srv.RefreshTTL = cfg.JWT.RefreshTTL
+	srv.Mailer = config.Mailer
+	srv.LinkBaseURL = cfg.Mail.LinkBaseURL
+	if cfg.OIDC.Issuer != "" {
+		if srv.OIDC, err = oidc.New(cfg.OIDC, nil); err != nil {
+			log.Fatal("OIDC initialization failed:", err)
+		}
+	}
+	srv.ClaimInvites = func(userID, email string) (int, error) {
+		return models.ClaimEmailInvites(config.DB, userID, email)
+	}
+
+	r := routes.SetupRoutes(cfg, srv)
+	if err := r.Run(cfg.Server.Addr); err != nil {
+		log.Fatal(err)
+	}
 }
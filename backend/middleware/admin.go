@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// AdminMiddleware 只允许配置中列出的系统管理员访问，需要放在AuthMiddleware之后
+func AdminMiddleware(adminIDs []string) gin.HandlerFunc {
+	admins := make(map[string]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+	return func(c *gin.Context) {
+		if !admins[c.GetString("userID")] {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  "failed",
+				"message": "administrator privileges required",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,13 +1,24 @@
 package middleware
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
 	"knowledge_master_backend/utils"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// 最后活动时间的更新间隔，避免每个请求都写数据库
+const sessionTouchInterval = time.Minute
+
+// AuthMiddleware 校验访问令牌，并确认令牌所属的会话没有被撤销或过期
+// 也接受个人访问令牌：scopes的键为 "METHOD 完整路由"，值为该路由所需的权限范围，
+// 未登记的路由（如修改密码、管理令牌）只能用登录会话访问。
+func AuthMiddleware(sessions repository.SessionRepository, tokens repository.AccessTokenRepository, scopes map[string]models.AccessTokenScope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从Header获取token
 		authHeader := c.GetHeader("Authorization")
@@ -31,8 +42,13 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(tokenParts[1], models.AccessTokenPrefix) {
+			authenticateAccessToken(c, tokens, scopes, tokenParts[1])
+			return
+		}
+
 		// 验证token
-		userID, err := utils.ParseToken(tokenParts[1])
+		userID, sessionID, err := utils.ParseToken(tokenParts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"status":  "failed",
@@ -42,8 +58,88 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 将userID存入上下文
+		// 检查会话
+		session, err := sessions.Get(sessionID)
+		if errors.Is(err, models.ErrSessionNotFound) || (err == nil && session.UserID != userID) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status":  "failed",
+				"message": "Session has been revoked or expired",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("会话检查失败 - 会话: %s, 错误: %v", sessionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  "failed",
+				"message": "权限验证服务不可用",
+			})
+			c.Abort()
+			return
+		}
+		if time.Since(session.LastSeenAt) > sessionTouchInterval {
+			if err := sessions.Touch(sessionID); err != nil {
+				log.Printf("更新会话活动时间失败 - 会话: %s, 错误: %v", sessionID, err)
+			}
+		}
+
+		// 将userID和会话存入上下文
 		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
 		c.Next()
 	}
 }
+
+// authenticateAccessToken 校验个人访问令牌及其权限范围和可以访问的知识库
+// 限制了知识库的令牌只能访问带 :kb_id 的路由。
+func authenticateAccessToken(c *gin.Context, tokens repository.AccessTokenRepository, scopes map[string]models.AccessTokenScope, raw string) {
+	token, err := tokens.GetByHash(utils.HashToken(raw))
+	if errors.Is(err, models.ErrAccessTokenNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "failed",
+			"message": "Invalid or expired token",
+		})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		log.Printf("访问令牌检查失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "failed",
+			"message": "权限验证服务不可用",
+		})
+		c.Abort()
+		return
+	}
+
+	required, ok := scopes[c.Request.Method+" "+c.FullPath()]
+	if !ok || !token.Allows(required) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":  "failed",
+			"message": "Access token scope does not allow this request",
+		})
+		c.Abort()
+		return
+	}
+	if len(token.KBIDs) > 0 {
+		if kbID := c.Param("kb_id"); kbID == "" || !token.AllowsKB(kbID) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  "failed",
+				"message": "Access token is not allowed to access this knowledge base",
+			})
+			c.Abort()
+			return
+		}
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > sessionTouchInterval {
+		if err := tokens.Touch(token.TokenID); err != nil {
+			log.Printf("更新访问令牌使用时间失败 - 令牌: %s, 错误: %v", token.TokenID, err)
+		}
+	}
+
+	// 使用个人访问令牌时没有会话
+	c.Set("userID", token.UserID)
+	c.Set("accessTokenID", token.TokenID)
+	c.Next()
+}
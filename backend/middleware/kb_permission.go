@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"log"
+	"net/http"
+)
+
+// KBPermissionMiddleware 按路由表校验当前用户对 :kb_id 知识库的权限
+// permissions 的键为 "METHOD 完整路由"，未登记的路由一律拒绝访问。
+func KBPermissionMiddleware(kbs repository.KnowledgeBaseRepository, permissions map[string]models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		required, ok := permissions[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  "failed",
+				"message": "permission denied",
+			})
+			c.Abort()
+			return
+		}
+
+		kbID := c.Param("kb_id")
+		userID := c.GetString("userID")
+
+		role, err := kbs.Role(kbID, userID)
+		if errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status":  "failed",
+				"message": "Knowledge base not found",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("权限检查失败 - KB: %s, 用户: %s, 错误: %v", kbID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  "failed",
+				"message": "权限验证服务不可用",
+			})
+			c.Abort()
+			return
+		}
+
+		if !models.RoleAllows(role, required) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  "failed",
+				"message": "permission denied",
+			})
+			c.Abort()
+			return
+		}
+
+		// 将角色存入上下文，供后续处理函数使用
+		c.Set("kbRole", role)
+		c.Next()
+	}
+}
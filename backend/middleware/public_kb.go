@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"log"
+	"net/http"
+)
+
+// PublicKBMiddleware 仅允许匿名访问公开的知识库
+// 非公开知识库与不存在的知识库一样返回404，避免泄露其存在。
+func PublicKBMiddleware(kbs repository.KnowledgeBaseRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kbID := c.Param("kb_id")
+
+		kb, err := kbs.Get(kbID)
+		if err != nil && !errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+			log.Printf("公开知识库检查失败 - KB: %s, 错误: %v", kbID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  "failed",
+				"message": "权限验证服务不可用",
+			})
+			c.Abort()
+			return
+		}
+		if kb == nil || !kb.IsPublic {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status":  "failed",
+				"message": "Knowledge base not found",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("kbRole", models.RoleViewer)
+		c.Next()
+	}
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS user_profiles;
+DROP TABLE IF EXISTS knowledge_nodes;
+DROP TABLE IF EXISTS kb_members;
+DROP TABLE IF EXISTS knowledge_bases;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_kb_members_user;
+DROP INDEX IF EXISTS idx_kb_members_kb_user;
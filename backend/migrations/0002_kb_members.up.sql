@@ -0,0 +1,3 @@
+-- 每个用户在同一知识库中只能有一条成员记录
+CREATE UNIQUE INDEX IF NOT EXISTS idx_kb_members_kb_user ON kb_members(kb_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_kb_members_user ON kb_members(user_id);
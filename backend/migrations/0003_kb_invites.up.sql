@@ -0,0 +1,18 @@
+-- 知识库邀请：链接邀请保存令牌哈希，邮箱邀请在对应邮箱注册后自动认领
+CREATE TABLE kb_invites (
+    invite_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kb_id UUID REFERENCES knowledge_bases(kb_id) ON DELETE CASCADE,
+    token_hash TEXT UNIQUE,           -- 链接邀请的令牌SHA-256，邮箱邀请为NULL
+    email VARCHAR(255),               -- 邮箱邀请的目标邮箱，链接邀请为NULL
+    role VARCHAR(20) NOT NULL,        -- 接受后获得的角色
+    max_uses INTEGER,                 -- NULL表示不限次数
+    use_count INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    CHECK ((token_hash IS NULL) <> (email IS NULL))
+);
+
+CREATE INDEX idx_kb_invites_kb ON kb_invites(kb_id);
+CREATE INDEX idx_kb_invites_email ON kb_invites(LOWER(email)) WHERE email IS NOT NULL;
@@ -0,0 +1,12 @@
+-- 节点修订历史：每次更新前保存节点原有的标题和内容
+CREATE TABLE node_revisions (
+    revision_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    node_id UUID REFERENCES knowledge_nodes(node_id) ON DELETE CASCADE,
+    kb_id UUID REFERENCES knowledge_bases(kb_id) ON DELETE CASCADE,
+    revision_number INTEGER NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    author_id UUID REFERENCES users(user_id) ON DELETE SET NULL, -- 发起本次更新的用户
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (node_id, revision_number)
+);
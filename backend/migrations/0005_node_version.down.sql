@@ -0,0 +1 @@
+ALTER TABLE knowledge_nodes DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+-- 节点版本号，用于乐观并发控制，每次更新标题或内容时加一
+ALTER TABLE knowledge_nodes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,12 @@
+-- 回收站中的节点和知识库会被彻底删除
+DELETE FROM knowledge_nodes WHERE deleted_at IS NOT NULL;
+DELETE FROM knowledge_bases WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_kb_trash;
+DROP INDEX IF EXISTS idx_nodes_trash;
+
+ALTER TABLE knowledge_bases DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE knowledge_nodes
+    DROP COLUMN IF EXISTS trash_root_id,
+    DROP COLUMN IF EXISTS deleted_by,
+    DROP COLUMN IF EXISTS deleted_at;
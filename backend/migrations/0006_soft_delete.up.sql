@@ -0,0 +1,10 @@
+-- 回收站：删除只打上deleted_at标记，过期后由后台任务彻底清理
+ALTER TABLE knowledge_nodes
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    ADD COLUMN trash_root_id UUID; -- 同一次删除的子树共享其根节点ID
+
+ALTER TABLE knowledge_bases ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_nodes_trash ON knowledge_nodes(kb_id, trash_root_id) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_kb_trash ON knowledge_bases(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_nodes_content_trgm;
+DROP INDEX IF EXISTS idx_nodes_title_trgm;
+DROP INDEX IF EXISTS idx_nodes_search;
+ALTER TABLE knowledge_nodes DROP COLUMN IF EXISTS search_vector;
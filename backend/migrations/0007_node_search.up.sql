@@ -0,0 +1,15 @@
+-- 节点全文检索：标题权重A，内容权重B，由数据库自动维护
+-- 使用simple配置，不做词干化，对中英文混排内容都保持可预期的结果
+ALTER TABLE knowledge_nodes ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(content, '')), 'B')
+    ) STORED;
+
+CREATE INDEX idx_nodes_search ON knowledge_nodes USING GIN (search_vector);
+
+-- 标题和内容的子串匹配（中文等无法按空格分词的文字）使用三元组索引，
+-- 与上面的全文索引组合成BitmapOr，检索不需要扫描全表
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_nodes_title_trgm ON knowledge_nodes USING GIN (title gin_trgm_ops);
+CREATE INDEX idx_nodes_content_trgm ON knowledge_nodes USING GIN (content gin_trgm_ops);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS kb_snapshot_nodes;
+DROP TABLE IF EXISTS kb_snapshots;
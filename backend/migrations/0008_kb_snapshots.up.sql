@@ -0,0 +1,26 @@
+-- 知识库快照：保存某一时刻全部节点的结构和内容
+CREATE TABLE kb_snapshots (
+    snapshot_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kb_id UUID REFERENCES knowledge_bases(kb_id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    node_count INTEGER NOT NULL DEFAULT 0,
+    created_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 快照中的节点，node_id/parent_id保留原值，不设外键，节点被彻底删除后仍可恢复
+CREATE TABLE kb_snapshot_nodes (
+    snapshot_id UUID REFERENCES kb_snapshots(snapshot_id) ON DELETE CASCADE,
+    node_id UUID NOT NULL,
+    parent_id UUID,
+    node_type VARCHAR(20) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    sort_order INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (snapshot_id, node_id)
+);
+
+CREATE INDEX idx_kb_snapshots_kb ON kb_snapshots(kb_id);
@@ -0,0 +1,2 @@
+-- 存储中的附件文件不会被删除
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,18 @@
+-- 节点附件，文件保存在对象存储的 kb/<kb_id>/ 前缀下
+-- 节点或知识库被彻底删除后node_id/kb_id被置空，由后台任务删除存储中的文件和这条记录
+CREATE TABLE attachments (
+    attachment_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kb_id UUID REFERENCES knowledge_bases(kb_id) ON DELETE SET NULL,
+    node_id UUID REFERENCES knowledge_nodes(node_id) ON DELETE SET NULL,
+    storage_key VARCHAR(512) NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    uploaded_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_kb ON attachments(kb_id);
+CREATE INDEX idx_attachments_node ON attachments(node_id);
+CREATE INDEX idx_attachments_orphaned ON attachments(created_at) WHERE kb_id IS NULL OR node_id IS NULL;
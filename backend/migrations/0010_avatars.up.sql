@@ -0,0 +1,5 @@
+-- 头像的多个尺寸，avatar_uri 保留为最大尺寸的地址
+-- avatars: {"64": url, "128": url, "512": url}
+-- avatar_keys: 当前头像在对象存储中的文件，更换头像后删除
+ALTER TABLE user_profiles ADD COLUMN avatars JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE user_profiles ADD COLUMN avatar_keys TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,16 @@
+-- 邮箱验证时间，NULL表示未验证
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- 邮件中发送的一次性令牌（邮箱验证、重置密码），只保存令牌ID的哈希
+-- 令牌本身带有签名和过期时间，伪造或过期的令牌不用查库即可拒绝
+CREATE TABLE user_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,      -- verify_email / reset_password
+    email VARCHAR(255) NOT NULL,       -- 发送令牌时的邮箱
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_user_tokens_user ON user_tokens(user_id, purpose);
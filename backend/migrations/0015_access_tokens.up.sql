@@ -0,0 +1,16 @@
+-- 个人访问令牌，供脚本和集成使用，只保存令牌的哈希
+-- scopes为read、write、admin中的若干项；kb_ids为空数组时可以访问用户的全部知识库
+CREATE TABLE personal_access_tokens (
+    token_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,               -- 令牌开头的几个字符，便于用户辨认
+    scopes TEXT[] NOT NULL,
+    kb_ids UUID[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP WITH TIME ZONE,      -- NULL表示不过期
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_personal_access_tokens_user ON personal_access_tokens(user_id);
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// AccessTokenScope 个人访问令牌的权限范围，admin包含write，write包含read
+type AccessTokenScope string
+
+const (
+	ScopeRead  AccessTokenScope = "read"  // 读取知识库和节点
+	ScopeWrite AccessTokenScope = "write" // 创建、修改和删除节点
+	ScopeAdmin AccessTokenScope = "admin" // 管理知识库本身、成员和邀请
+)
+
+// AccessTokenPrefix 个人访问令牌的前缀，用于和JWT区分
+const AccessTokenPrefix = "kmpat_"
+
+var ErrAccessTokenNotFound = errors.New("access token not found or expired")
+
+var scopeRank = map[AccessTokenScope]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// ValidScope 是否是可以授予的权限范围
+func ValidScope(scope AccessTokenScope) bool {
+	_, ok := scopeRank[scope]
+	return ok
+}
+
+// AccessToken 个人访问令牌，令牌本身只在创建时返回一次
+type AccessToken struct {
+	TokenID    string             `json:"id"`
+	UserID     string             `json:"-"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []AccessTokenScope `json:"scopes"`
+	KBIDs      []string           `json:"kb_ids"` // 为空时可以访问用户的全部知识库
+	ExpiresAt  *time.Time         `json:"expires_at"`
+	LastUsedAt *time.Time         `json:"last_used_at"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// Allows 令牌的权限范围是否满足scope
+func (t *AccessToken) Allows(scope AccessTokenScope) bool {
+	required, ok := scopeRank[scope]
+	if !ok {
+		return false
+	}
+	for _, s := range t.Scopes {
+		if scopeRank[s] >= required {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsKB 令牌是否可以访问知识库，没有限制知识库时总是可以
+func (t *AccessToken) AllowsKB(kbID string) bool {
+	if len(t.KBIDs) == 0 {
+		return true
+	}
+	for _, id := range t.KBIDs {
+		if id == kbID {
+			return true
+		}
+	}
+	return false
+}
+
+const accessTokenColumns = `token_id, user_id, name, token_prefix, scopes, kb_ids, expires_at, last_used_at, created_at`
+
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (*AccessToken, error) {
+	var t AccessToken
+	var scopes, kbIDs pq.StringArray
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.TokenID, &t.UserID, &t.Name, &t.Prefix, &scopes, &kbIDs, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		t.Scopes = append(t.Scopes, AccessTokenScope(s))
+	}
+	t.KBIDs = []string(kbIDs)
+	if t.KBIDs == nil {
+		t.KBIDs = []string{}
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+// CreateAccessToken 保存个人访问令牌，tokenHash为令牌的哈希
+func CreateAccessToken(db *sql.DB, t *AccessToken, tokenHash string) (*AccessToken, error) {
+	scopes := make([]string, len(t.Scopes))
+	for i, s := range t.Scopes {
+		scopes[i] = string(s)
+	}
+	kbIDs := t.KBIDs
+	if kbIDs == nil {
+		kbIDs = []string{}
+	}
+	query := `
+        INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, kb_ids, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING ` + accessTokenColumns
+	token, err := scanAccessToken(db.QueryRow(query, t.UserID, t.Name, tokenHash, t.Prefix, pq.Array(scopes), pq.Array(kbIDs), t.ExpiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create access token: %w", err)
+	}
+	return token, nil
+}
+
+// ListAccessTokens 用户的个人访问令牌（包括已过期的），最新创建的在前
+func ListAccessTokens(db *sql.DB, userID string) ([]AccessToken, error) {
+	rows, err := db.Query(`
+        SELECT `+accessTokenColumns+`
+        FROM personal_access_tokens
+        WHERE user_id = $1
+        ORDER BY created_at DESC, token_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		t, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access token: %w", err)
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAccessTokenByHash 按哈希查找未过期的个人访问令牌，不存在或已过期时返回ErrAccessTokenNotFound
+func GetAccessTokenByHash(db *sql.DB, tokenHash string) (*AccessToken, error) {
+	query := `
+        SELECT ` + accessTokenColumns + `
+        FROM personal_access_tokens
+        WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())`
+	token, err := scanAccessToken(db.QueryRow(query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccessTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	return token, nil
+}
+
+// DeleteAccessToken 撤销用户的个人访问令牌，不属于该用户时返回ErrAccessTokenNotFound
+func DeleteAccessToken(db *sql.DB, userID, tokenID string) error {
+	if !uuidPattern.MatchString(tokenID) {
+		return ErrAccessTokenNotFound
+	}
+	result, err := db.Exec("DELETE FROM personal_access_tokens WHERE token_id = $1 AND user_id = $2", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete access token: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// DeleteUserAccessTokens 撤销用户的全部个人访问令牌，返回撤销的数量
+func DeleteUserAccessTokens(db *sql.DB, userID string) (int, error) {
+	result, err := db.Exec("DELETE FROM personal_access_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete access tokens: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// TouchAccessToken 更新最后使用时间
+func TouchAccessToken(db *sql.DB, tokenID string) error {
+	if _, err := db.Exec("UPDATE personal_access_tokens SET last_used_at = NOW() WHERE token_id = $1", tokenID); err != nil {
+		return fmt.Errorf("failed to touch access token: %w", err)
+	}
+	return nil
+}
+
+// DeleteStaleAccessTokens 删除在before之前过期的个人访问令牌
+func DeleteStaleAccessTokens(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM personal_access_tokens WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete access tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
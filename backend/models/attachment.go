@@ -0,0 +1,169 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Attachment 上传到知识库节点的文件，文件本身保存在对象存储中
+type Attachment struct {
+	AttachmentID string    `json:"id"`
+	KBID         string    `json:"kb_id"`
+	NodeID       string    `json:"node_id"`
+	FileName     string    `json:"file_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	URL          string    `json:"url"`
+	Markdown     string    `json:"markdown"` // 可以直接插入节点内容的Markdown
+	StorageKey   string    `json:"-"`
+	UploadedBy   string    `json:"uploaded_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const attachmentColumns = `
+        attachment_id, kb_id, node_id, storage_key, url, file_name,
+        content_type, size, uploaded_by, created_at`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*Attachment, error) {
+	var a Attachment
+	var kbID, nodeID, uploadedBy sql.NullString
+	if err := row.Scan(
+		&a.AttachmentID,
+		&kbID,
+		&nodeID,
+		&a.StorageKey,
+		&a.URL,
+		&a.FileName,
+		&a.ContentType,
+		&a.Size,
+		&uploadedBy,
+		&a.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	a.KBID = kbID.String
+	a.NodeID = nodeID.String
+	a.UploadedBy = uploadedBy.String
+	a.Markdown = attachmentMarkdown(&a)
+	return &a, nil
+}
+
+// attachmentMarkdown 图片生成图片语法，其他文件生成链接
+func attachmentMarkdown(a *Attachment) string {
+	name := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(a.FileName)
+	link := "[" + name + "](" + a.URL + ")"
+	if strings.HasPrefix(a.ContentType, "image/") {
+		return "!" + link
+	}
+	return link
+}
+
+// CreateAttachment 保存附件记录，节点不存在或已在回收站中时返回ErrNodeNotFound
+func CreateAttachment(db *sql.DB, a *Attachment) (*Attachment, error) {
+	row := db.QueryRow(`
+        INSERT INTO attachments
+        (kb_id, node_id, storage_key, url, file_name, content_type, size, uploaded_by)
+        SELECT kb_id, node_id, $3, $4, $5, $6, $7, $8
+        FROM knowledge_nodes
+        WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
+        RETURNING`+attachmentColumns,
+		a.KBID, a.NodeID, a.StorageKey, a.URL, a.FileName, a.ContentType, a.Size,
+		sql.NullString{String: a.UploadedBy, Valid: a.UploadedBy != ""},
+	)
+	created, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return created, nil
+}
+
+// GetAttachments 获取知识库的附件，nodeID不为空时只返回该节点的附件
+func GetAttachments(db *sql.DB, kbID, nodeID string) ([]Attachment, error) {
+	rows, err := db.Query(`
+        SELECT`+attachmentColumns+`
+        FROM attachments
+        WHERE kb_id = $1 AND ($2 = '' OR node_id::text = $2)
+        ORDER BY created_at DESC`,
+		kbID, nodeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := make([]Attachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return attachments, nil
+}
+
+// DeleteAttachment 删除附件记录并返回它，调用方负责删除存储中的文件
+func DeleteAttachment(db *sql.DB, kbID, attachmentID string) (*Attachment, error) {
+	row := db.QueryRow(`
+        DELETE FROM attachments
+        WHERE kb_id = $1 AND attachment_id = $2
+        RETURNING`+attachmentColumns,
+		kbID, attachmentID,
+	)
+	a, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return a, nil
+}
+
+// GetOrphanedAttachments 获取所属节点或知识库已被彻底删除的附件
+func GetOrphanedAttachments(db *sql.DB, limit int) ([]Attachment, error) {
+	rows, err := db.Query(`
+        SELECT`+attachmentColumns+`
+        FROM attachments
+        WHERE kb_id IS NULL OR node_id IS NULL
+        ORDER BY created_at
+        LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orphaned attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return attachments, nil
+}
+
+// DeleteAttachmentRecord 按ID删除附件记录
+func DeleteAttachmentRecord(db *sql.DB, attachmentID string) error {
+	if _, err := db.Exec("DELETE FROM attachments WHERE attachment_id = $1", attachmentID); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
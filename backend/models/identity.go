@@ -0,0 +1,94 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrIdentityNotFound  = errors.New("external identity is not linked to any user")
+	ErrIdentityTaken     = errors.New("external identity is already linked to a user")
+	ErrLoginStateInvalid = errors.New("single sign-on state is invalid or expired")
+)
+
+// OIDCLoginState 进行中的单点登录，回调时用于校验ID令牌和兑换授权码
+type OIDCLoginState struct {
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// FindIdentityUser 查找外部身份关联的用户并记录登录时间，未关联时返回ErrIdentityNotFound
+func FindIdentityUser(db *sql.DB, issuer, subject string) (string, error) {
+	var userID string
+	err := db.QueryRow(`
+        UPDATE user_identities SET last_login_at = NOW()
+        WHERE issuer = $1 AND subject = $2
+        RETURNING user_id`,
+		issuer, subject,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrIdentityNotFound
+		}
+		return "", fmt.Errorf("failed to find identity: %w", err)
+	}
+	return userID, nil
+}
+
+// LinkIdentity 把外部身份关联到用户，已关联时返回ErrIdentityTaken
+func LinkIdentity(db *sql.DB, issuer, subject, userID, email string) error {
+	_, err := db.Exec(
+		"INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, $4)",
+		issuer, subject, userID, email,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrIdentityTaken
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+// SaveOIDCLoginState 保存单点登录的状态，stateHash为state参数的哈希
+func SaveOIDCLoginState(db *sql.DB, stateHash string, s *OIDCLoginState) error {
+	_, err := db.Exec(
+		"INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)",
+		stateHash, s.Nonce, s.CodeVerifier, s.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save login state: %w", err)
+	}
+	return nil
+}
+
+// TakeOIDCLoginState 取出并删除单点登录的状态，不存在或已过期时返回ErrLoginStateInvalid
+func TakeOIDCLoginState(db *sql.DB, stateHash string) (*OIDCLoginState, error) {
+	s := &OIDCLoginState{}
+	err := db.QueryRow(
+		"DELETE FROM oidc_login_states WHERE state_hash = $1 RETURNING nonce, code_verifier, expires_at",
+		stateHash,
+	).Scan(&s.Nonce, &s.CodeVerifier, &s.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLoginStateInvalid
+		}
+		return nil, fmt.Errorf("failed to get login state: %w", err)
+	}
+	if !s.ExpiresAt.After(time.Now()) {
+		return nil, ErrLoginStateInvalid
+	}
+	return s, nil
+}
+
+// DeleteStaleOIDCLoginStates 删除在before之前过期的单点登录状态
+func DeleteStaleOIDCLoginStates(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM oidc_login_states WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete login states: %w", err)
+	}
+	return result.RowsAffected()
+}
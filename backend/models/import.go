@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrImportTargetNotFolder 只能导入到目录节点下
+var ErrImportTargetNotFolder = errors.New("can only import nodes into folders")
+
+// ImportAttachment 随导入的节点一起保存的附件，节点插入后才有NodeID
+type ImportAttachment struct {
+	Node       *KnowledgeNode
+	Attachment *Attachment
+}
+
+// ImportKnowledgeNodes 在一个事务中将一组节点树和它们引用的附件插入知识库，返回创建的节点数量
+// 节点按Children递归插入，同级顺序即切片顺序；顶层节点排在parentID（为空表示根目录）现有子节点之后。
+// 成功后每个节点的NodeID、ParentID、SortOrder等字段都会被填充。
+func ImportKnowledgeNodes(db *sql.DB, kbID, parentID string, nodes []*KnowledgeNode, attachments []ImportAttachment) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := lockKnowledgeBase(tx, kbID); err != nil {
+		return 0, err
+	}
+
+	var start int
+	if parentID == "" {
+		err = tx.QueryRow(`
+            SELECT COALESCE(MAX(sort_order), 0)
+            FROM knowledge_nodes
+            WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL`,
+			kbID,
+		).Scan(&start)
+	} else {
+		var nodeType string
+		err = tx.QueryRow(
+			"SELECT node_type FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL",
+			kbID, parentID,
+		).Scan(&nodeType)
+		if err == sql.ErrNoRows {
+			return 0, ErrNodeNotFound
+		}
+		if err == nil && nodeType != "folder" {
+			return 0, ErrImportTargetNotFolder
+		}
+		if err == nil {
+			err = tx.QueryRow(`
+                SELECT COALESCE(MAX(sort_order), 0)
+                FROM knowledge_nodes
+                WHERE kb_id = $1 AND parent_id = $2 AND deleted_at IS NULL`,
+				kbID, parentID,
+			).Scan(&start)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate sort order: %w", err)
+	}
+
+	count, err := insertNodesInTx(tx, kbID, parentID, start, nodes)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, a := range attachments {
+		a.Attachment.KBID = kbID
+		a.Attachment.NodeID = a.Node.NodeID
+		_, err := tx.Exec(`
+            INSERT INTO attachments
+            (kb_id, node_id, storage_key, url, file_name, content_type, size, uploaded_by)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			kbID, a.Node.NodeID, a.Attachment.StorageKey, a.Attachment.URL, a.Attachment.FileName,
+			a.Attachment.ContentType, a.Attachment.Size,
+			sql.NullString{String: a.Attachment.UploadedBy, Valid: a.Attachment.UploadedBy != ""},
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create attachment: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return count, nil
+}
+
+// insertNodesInTx 递归插入同一父节点下的一组节点，sort_order从start+1开始
+func insertNodesInTx(tx *sql.Tx, kbID, parentID string, start int, nodes []*KnowledgeNode) (int, error) {
+	count := 0
+	parent := sql.NullString{String: parentID, Valid: parentID != ""}
+	for i, node := range nodes {
+		node.KBID = kbID
+		node.ParentID = parentID
+		node.SortOrder = start + i + 1
+
+		err := tx.QueryRow(`
+            INSERT INTO knowledge_nodes
+            (kb_id, parent_id, node_type, title, content, sort_order)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            RETURNING node_id, version, created_at, updated_at`,
+			kbID, parent, node.Type, node.Title, node.Content, node.SortOrder,
+		).Scan(&node.NodeID, &node.Version, &node.CreatedAt, &node.UpdatedAt)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert node %q: %w", node.Title, err)
+		}
+		count++
+
+		n, err := insertNodesInTx(tx, kbID, node.NodeID, 0, node.Children)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
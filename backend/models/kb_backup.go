@@ -0,0 +1,322 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BackupSchemaVersion 当前备份格式的版本号，格式发生不兼容变化时递增
+const BackupSchemaVersion = 1
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var (
+	ErrInvalidBackup  = errors.New("invalid backup")
+	ErrBackupConflict = errors.New("knowledge base or nodes from the backup already exist")
+)
+
+// KBBackup 单个知识库的完整备份
+type KBBackup struct {
+	SchemaVersion int                `json:"schema_version"`
+	ExportedAt    time.Time          `json:"exported_at"`
+	KnowledgeBase BackupKB           `json:"knowledge_base"`
+	Members       []BackupMember     `json:"members"`
+	Nodes         []BackupNode       `json:"nodes"` // 先序排列，父节点在子节点之前
+	Attachments   []BackupAttachment `json:"attachments"`
+}
+
+type BackupKB struct {
+	KBID              string    `json:"kb_id"`
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	OwnerID           string    `json:"owner_id"`
+	OwnerEmail        string    `json:"owner_email"`
+	IsPublic          bool      `json:"is_public"`
+	CollaborationMode string    `json:"collaboration_mode"`
+	CoverImageURL     string    `json:"cover_image_url"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// BackupMember 成员，恢复时先按user_id、再按email匹配用户
+type BackupMember struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type BackupNode struct {
+	NodeID    string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Type      string    `json:"type"`
+	Title     string    `json:"name"`
+	Content   string    `json:"content"`
+	SortOrder int       `json:"sort_order"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BackupAttachment 备份引用的外部文件，文件本身不包含在备份中
+type BackupAttachment struct {
+	Kind   string `json:"kind"` // cover_image/attachment
+	NodeID string `json:"node_id,omitempty"`
+	URL    string `json:"url"`
+}
+
+// GetKBBackup 读取知识库的完整备份（不含回收站中的节点）
+func GetKBBackup(db *sql.DB, kbID string) (*KBBackup, error) {
+	kb, err := GetKnowledgeBaseById(db, kbID)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &KBBackup{
+		SchemaVersion: BackupSchemaVersion,
+		ExportedAt:    time.Now().UTC(),
+		KnowledgeBase: BackupKB{
+			KBID:              kb.KBID,
+			Name:              kb.Name,
+			Description:       kb.Description,
+			OwnerID:           kb.OwnerID,
+			IsPublic:          kb.IsPublic,
+			CollaborationMode: kb.CollaborationMode,
+			CoverImageURL:     kb.CoverImageURL,
+			CreatedAt:         kb.CreatedAt,
+			UpdatedAt:         kb.UpdatedAt,
+		},
+		Members:     make([]BackupMember, 0),
+		Nodes:       make([]BackupNode, 0),
+		Attachments: make([]BackupAttachment, 0),
+	}
+
+	if kb.CoverImageURL != "" {
+		backup.Attachments = append(backup.Attachments, BackupAttachment{Kind: "cover_image", URL: kb.CoverImageURL})
+	}
+
+	members, err := GetKBMembers(db, kbID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.UserID == kb.OwnerID {
+			backup.KnowledgeBase.OwnerEmail = m.Email
+		}
+		backup.Members = append(backup.Members, BackupMember{
+			UserID:   m.UserID,
+			Email:    m.Email,
+			Role:     m.Role,
+			JoinedAt: m.JoinedAt,
+		})
+	}
+
+	attachments, err := GetAttachments(db, kbID, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		backup.Attachments = append(backup.Attachments, BackupAttachment{Kind: "attachment", NodeID: a.NodeID, URL: a.URL})
+	}
+
+	err = WalkKnowledgeTree(db, kbID, func(node *KnowledgeNode, _ int) error {
+		backup.Nodes = append(backup.Nodes, BackupNode{
+			NodeID:    node.NodeID,
+			ParentID:  node.ParentID,
+			Type:      node.Type,
+			Title:     node.Title,
+			Content:   node.Content,
+			SortOrder: node.SortOrder,
+			Version:   node.Version,
+			CreatedAt: node.CreatedAt,
+			UpdatedAt: node.UpdatedAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// RestoreKBBackup 在一个事务中从备份创建知识库
+// keepIDs为true时使用备份中原有的知识库ID和节点ID（已存在时返回ErrBackupConflict），
+// 否则生成新的ID并重新映射父子关系，ownerID成为新知识库的所有者。
+// 备份文件不可信，其中的成员不会直接加入，而是为每个邮箱创建不过期的单次邀请并在invited中返回，
+// 没有邮箱或角色无效的成员会被跳过并在skipped中返回。
+func RestoreKBBackup(db *sql.DB, backup *KBBackup, ownerID string, keepIDs bool) (kb *KnowledgeBase, invited, skipped []string, err error) {
+	order, err := backupInsertOrder(backup.Nodes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if keepIDs {
+		if !uuidPattern.MatchString(backup.KnowledgeBase.KBID) {
+			return nil, nil, nil, fmt.Errorf("%w: knowledge base id is not a UUID", ErrInvalidBackup)
+		}
+		for _, n := range order {
+			if !uuidPattern.MatchString(n.NodeID) {
+				return nil, nil, nil, fmt.Errorf("%w: node id %q is not a UUID", ErrInvalidBackup, n.NodeID)
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	meta := backup.KnowledgeBase
+	if !IsValidCollaborationMode(meta.CollaborationMode) {
+		meta.CollaborationMode = CollaborationPrivate
+	}
+	createdAt, updatedAt := backupTime(meta.CreatedAt), backupTime(meta.UpdatedAt)
+
+	kbID := sql.NullString{String: meta.KBID, Valid: keepIDs}
+	err = tx.QueryRow(`
+        INSERT INTO knowledge_bases
+        (kb_id, name, description, owner_id, is_public, collaboration_mode, cover_image_url, created_at, updated_at)
+        VALUES (COALESCE($1::uuid, uuid_generate_v4()), $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING kb_id`,
+		kbID, meta.Name, meta.Description, ownerID,
+		meta.CollaborationMode == CollaborationPublic, meta.CollaborationMode,
+		sql.NullString{String: meta.CoverImageURL, Valid: meta.CoverImageURL != ""},
+		createdAt, updatedAt,
+	).Scan(&meta.KBID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, nil, nil, ErrBackupConflict
+		}
+		return nil, nil, nil, fmt.Errorf("failed to create knowledge base: %w", err)
+	}
+
+	// 只有恢复者直接成为成员（OWNER），备份中的其他成员只能通过邮箱邀请加入，
+	// 邀请在对方验证邮箱后才会生效；备份中的其他所有者降为编辑者
+	if _, err := addMemberInTx(tx, meta.KBID, ownerID, RoleOwner); err != nil {
+		return nil, nil, nil, err
+	}
+	var ownerEmail string
+	if err := tx.QueryRow(`SELECT email FROM users WHERE user_id = $1`, ownerID).Scan(&ownerEmail); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get owner: %w", err)
+	}
+	invites, skipped := backupInvites(backup.Members, ownerEmail)
+	for _, m := range invites {
+		_, err := tx.Exec(`
+            INSERT INTO kb_invites (kb_id, email, role, max_uses, created_by)
+            VALUES ($1, $2, $3, 1, $4)`,
+			meta.KBID, m.Email, m.Role, ownerID,
+		)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to invite member %s: %w", m.Email, err)
+		}
+		invited = append(invited, m.Email)
+	}
+
+	// 按父节点在前的顺序插入节点
+	ids := make(map[string]string, len(order))
+	for _, n := range order {
+		parentID := sql.NullString{}
+		if n.ParentID != "" {
+			parentID = sql.NullString{String: ids[n.ParentID], Valid: true}
+		}
+		nodeID := sql.NullString{String: n.NodeID, Valid: keepIDs}
+		version := n.Version
+		if version < 1 {
+			version = 1
+		}
+
+		var newID string
+		err := tx.QueryRow(`
+            INSERT INTO knowledge_nodes
+            (node_id, kb_id, parent_id, node_type, title, content, sort_order, version, created_at, updated_at)
+            VALUES (COALESCE($1::uuid, uuid_generate_v4()), $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            RETURNING node_id`,
+			nodeID, meta.KBID, parentID, n.Type, n.Title, n.Content, n.SortOrder, version,
+			backupTime(n.CreatedAt), backupTime(n.UpdatedAt),
+		).Scan(&newID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return nil, nil, nil, ErrBackupConflict
+			}
+			return nil, nil, nil, fmt.Errorf("failed to restore node %s: %w", n.NodeID, err)
+		}
+		ids[n.NodeID] = newID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	kb, err = GetKnowledgeBaseById(db, meta.KBID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return kb, invited, skipped, nil
+}
+
+// backupInvites 返回需要邀请的成员：邮箱统一为小写并去重，跳过恢复者本人，OWNER降为EDITOR
+// 没有邮箱或角色无效的成员在skipped中返回。
+func backupInvites(members []BackupMember, ownerEmail string) (invites []BackupMember, skipped []string) {
+	seenEmails := map[string]bool{strings.ToLower(ownerEmail): true}
+	for _, m := range members {
+		email := strings.ToLower(strings.TrimSpace(m.Email))
+		if seenEmails[email] {
+			continue
+		}
+		if email == "" || !IsValidRole(m.Role) {
+			skipped = append(skipped, m.Email)
+			continue
+		}
+		seenEmails[email] = true
+		role := m.Role
+		if role == RoleOwner {
+			role = RoleEditor
+		}
+		invites = append(invites, BackupMember{UserID: m.UserID, Email: email, Role: role, JoinedAt: m.JoinedAt})
+	}
+	return invites, skipped
+}
+
+// backupInsertOrder 校验节点并返回父节点在前的插入顺序
+// 节点ID重复、父节点不存在或存在环时返回ErrInvalidBackup。
+func backupInsertOrder(nodes []BackupNode) ([]*BackupNode, error) {
+	children := make(map[string][]*BackupNode)
+	seen := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		n := &nodes[i]
+		if n.NodeID == "" || seen[n.NodeID] {
+			return nil, fmt.Errorf("%w: missing or duplicate node id %q", ErrInvalidBackup, n.NodeID)
+		}
+		if strings.TrimSpace(n.Title) == "" || n.Type == "" {
+			return nil, fmt.Errorf("%w: node %s has no name or type", ErrInvalidBackup, n.NodeID)
+		}
+		seen[n.NodeID] = true
+		children[n.ParentID] = append(children[n.ParentID], n)
+	}
+
+	order := make([]*BackupNode, 0, len(nodes))
+	queue := children[""]
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		order = append(order, n)
+		queue = append(queue, children[n.NodeID]...)
+	}
+	if len(order) != len(nodes) {
+		return nil, fmt.Errorf("%w: some nodes have a missing parent or form a cycle", ErrInvalidBackup)
+	}
+	return order, nil
+}
+
+// backupTime 备份中缺少的时间使用当前时间
+func backupTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupInsertOrder(t *testing.T) {
+	node := func(id, parent string) BackupNode {
+		return BackupNode{NodeID: id, ParentID: parent, Type: "file", Title: "n " + id}
+	}
+	tests := []struct {
+		name  string
+		nodes []BackupNode
+		want  string // 插入顺序，为空时应返回ErrInvalidBackup
+	}{
+		{"empty", nil, ""},
+		{"preorder", []BackupNode{node("a", ""), node("b", "a"), node("c", "")}, "a c b"},
+		{"children before parents", []BackupNode{node("c", "b"), node("b", "a"), node("a", "")}, "a b c"},
+		{"legacy ids", []BackupNode{node("1", ""), node("1-1", "1"), node("1-1-1", "1-1")}, "1 1-1 1-1-1"},
+		{"duplicate id", []BackupNode{node("a", ""), node("a", "")}, ""},
+		{"missing id", []BackupNode{node("", "")}, ""},
+		{"missing parent", []BackupNode{node("a", ""), node("b", "x")}, ""},
+		{"cycle", []BackupNode{node("a", ""), node("b", "c"), node("c", "b")}, ""},
+		{"self parent", []BackupNode{node("a", "a")}, ""},
+		{"no title", []BackupNode{{NodeID: "a", Type: "file", Title: "  "}}, ""},
+		{"no type", []BackupNode{{NodeID: "a", Title: "a"}}, ""},
+	}
+	for _, tt := range tests {
+		order, err := backupInsertOrder(tt.nodes)
+		if tt.want == "" && len(tt.nodes) > 0 {
+			if !errors.Is(err, ErrInvalidBackup) {
+				t.Errorf("%s: err = %v, want ErrInvalidBackup", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		ids := make([]string, 0, len(order))
+		for _, n := range order {
+			ids = append(ids, n.NodeID)
+		}
+		if got := strings.Join(ids, " "); got != tt.want {
+			t.Errorf("%s: order = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackupInvites(t *testing.T) {
+	members := []BackupMember{
+		{UserID: "u1", Email: "Owner@Example.com", Role: RoleOwner},
+		{UserID: "u2", Email: "co-owner@example.com", Role: RoleOwner},
+		{UserID: "u3", Email: " Editor@Example.com ", Role: RoleEditor},
+		{UserID: "u4", Email: "editor@example.com", Role: RoleViewer},
+		{UserID: "u5", Email: "viewer@example.com", Role: RoleViewer},
+		{UserID: "u6", Email: "", Role: RoleViewer},
+		{UserID: "u7", Email: "admin@example.com", Role: "ADMIN"},
+	}
+	invites, skipped := backupInvites(members, "owner@example.com")
+
+	want := []BackupMember{
+		{UserID: "u2", Email: "co-owner@example.com", Role: RoleEditor},
+		{UserID: "u3", Email: "editor@example.com", Role: RoleEditor},
+		{UserID: "u5", Email: "viewer@example.com", Role: RoleViewer},
+	}
+	if !reflect.DeepEqual(invites, want) {
+		t.Errorf("invites = %+v, want %+v", invites, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"", "admin@example.com"}) {
+		t.Errorf("skipped = %q", skipped)
+	}
+}
+
+func TestRestoreKBBackupValidatesIDs(t *testing.T) {
+	const kbID = "0b6f3c1e-5d2a-4c8e-9f10-2a3b4c5d6e7f"
+	const nodeID = "7d9e1f20-3a4b-4c5d-8e6f-708192a3b4c5"
+	tests := []struct {
+		name   string
+		backup *KBBackup
+	}{
+		{"kb id", &KBBackup{KnowledgeBase: BackupKB{KBID: "kb-1"}}},
+		{"node id", &KBBackup{
+			KnowledgeBase: BackupKB{KBID: kbID},
+			Nodes:         []BackupNode{{NodeID: nodeID, Type: "folder", Title: "a"}, {NodeID: "1-1", ParentID: nodeID, Type: "file", Title: "b"}},
+		}},
+		{"sql in id", &KBBackup{
+			KnowledgeBase: BackupKB{KBID: kbID},
+			Nodes:         []BackupNode{{NodeID: nodeID + "'; --", Type: "file", Title: "a"}},
+		}},
+		{"cycle", &KBBackup{
+			KnowledgeBase: BackupKB{KBID: kbID},
+			Nodes:         []BackupNode{{NodeID: nodeID, ParentID: nodeID, Type: "file", Title: "a"}},
+		}},
+	}
+	for _, tt := range tests {
+		// 校验在访问数据库之前完成
+		_, _, _, err := RestoreKBBackup(nil, tt.backup, "user", true)
+		if !errors.Is(err, ErrInvalidBackup) {
+			t.Errorf("%s: err = %v, want ErrInvalidBackup", tt.name, err)
+		}
+	}
+}
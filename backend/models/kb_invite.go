@@ -0,0 +1,280 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteInvalid  = errors.New("invite has expired, been revoked or used up")
+)
+
+type KBInvite struct {
+	InviteID  string     `json:"invite_id"`
+	KBID      string     `json:"kb_id"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role"`
+	MaxUses   *int       `json:"max_uses"`
+	UseCount  int        `json:"use_count"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Token     string     `json:"token,omitempty"` // 仅在创建时返回一次
+}
+
+const inviteColumns = `
+        invite_id, kb_id, COALESCE(email, ''), role, max_uses, use_count,
+        expires_at, COALESCE(created_by::text, ''), created_at, revoked_at
+`
+
+func scanInvite(row interface{ Scan(...interface{}) error }) (*KBInvite, error) {
+	var inv KBInvite
+	var maxUses sql.NullInt64
+	var expiresAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&inv.InviteID,
+		&inv.KBID,
+		&inv.Email,
+		&inv.Role,
+		&maxUses,
+		&inv.UseCount,
+		&expiresAt,
+		&inv.CreatedBy,
+		&inv.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		inv.MaxUses = &n
+	}
+	if expiresAt.Valid {
+		inv.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		inv.RevokedAt = &revokedAt.Time
+	}
+	return &inv, nil
+}
+
+// usable 判断邀请当前是否仍可使用
+func (inv *KBInvite) usable(now time.Time) bool {
+	if inv.RevokedAt != nil {
+		return false
+	}
+	if inv.ExpiresAt != nil && !now.Before(*inv.ExpiresAt) {
+		return false
+	}
+	if inv.MaxUses != nil && inv.UseCount >= *inv.MaxUses {
+		return false
+	}
+	return true
+}
+
+// CreateKBLinkInvite 创建链接邀请，tokenHash为令牌的哈希值
+func CreateKBLinkInvite(db *sql.DB, kbID, createdBy, tokenHash, role string, maxUses *int, expiresAt *time.Time) (*KBInvite, error) {
+	query := `
+        INSERT INTO kb_invites (kb_id, token_hash, role, max_uses, expires_at, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + inviteColumns
+
+	inv, err := scanInvite(db.QueryRow(query, kbID, tokenHash, role, maxUses, expiresAt, createdBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invite: %w", err)
+	}
+	return inv, nil
+}
+
+// CreateKBEmailInvite 创建邮箱邀请，等待该邮箱注册后认领
+func CreateKBEmailInvite(db *sql.DB, kbID, createdBy, email, role string, expiresAt *time.Time) (*KBInvite, error) {
+	query := `
+        INSERT INTO kb_invites (kb_id, email, role, max_uses, expires_at, created_by)
+        VALUES ($1, $2, $3, 1, $4, $5)
+        RETURNING ` + inviteColumns
+
+	inv, err := scanInvite(db.QueryRow(query, kbID, strings.ToLower(email), role, expiresAt, createdBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invite: %w", err)
+	}
+	return inv, nil
+}
+
+// GetKBInvites 获取知识库的全部邀请
+func GetKBInvites(db *sql.DB, kbID string) ([]KBInvite, error) {
+	query := `
+        SELECT ` + inviteColumns + `
+        FROM kb_invites
+        WHERE kb_id = $1
+        ORDER BY created_at DESC
+    `
+	rows, err := db.Query(query, kbID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := make([]KBInvite, 0)
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		invites = append(invites, *inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return invites, nil
+}
+
+// RevokeKBInvite 撤销邀请
+func RevokeKBInvite(db *sql.DB, kbID, inviteID string) error {
+	result, err := db.Exec(`
+        UPDATE kb_invites
+        SET revoked_at = NOW()
+        WHERE kb_id = $1 AND invite_id = $2 AND revoked_at IS NULL`,
+		kbID, inviteID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke invite: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// AcceptKBInvite 使用链接邀请令牌加入知识库
+// 已是成员的用户不会被降级，也不消耗邀请次数。
+func AcceptKBInvite(db *sql.DB, tokenHash, userID string) (*KBMember, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvite(tx.QueryRow(`
+        SELECT `+inviteColumns+`
+        FROM kb_invites
+        WHERE token_hash = $1
+        FOR UPDATE`,
+		tokenHash,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInviteNotFound
+		}
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	if !inv.usable(time.Now()) {
+		return nil, ErrInviteInvalid
+	}
+
+	joined, err := addMemberInTx(tx, inv.KBID, userID, inv.Role)
+	if err != nil {
+		return nil, err
+	}
+	if joined {
+		_, err = tx.Exec("UPDATE kb_invites SET use_count = use_count + 1 WHERE invite_id = $1", inv.InviteID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update invite usage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return GetKBMember(db, inv.KBID, userID)
+}
+
+// ClaimEmailInvites 认领发送到该邮箱的全部有效邀请，返回加入的知识库数量
+func ClaimEmailInvites(db *sql.DB, userID, email string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        SELECT `+inviteColumns+`
+        FROM kb_invites
+        WHERE LOWER(email) = LOWER($1)
+        FOR UPDATE`,
+		email,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query invites: %w", err)
+	}
+
+	var pending []*KBInvite
+	now := time.Now()
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		if inv.usable(now) {
+			pending = append(pending, inv)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	claimed := 0
+	for _, inv := range pending {
+		joined, err := addMemberInTx(tx, inv.KBID, userID, inv.Role)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("UPDATE kb_invites SET use_count = use_count + 1 WHERE invite_id = $1", inv.InviteID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update invite usage: %w", err)
+		}
+		if joined {
+			claimed++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return claimed, nil
+}
+
+// addMemberInTx 在事务内添加成员，用户已是成员时返回false
+func addMemberInTx(tx *sql.Tx, kbID, userID, role string) (bool, error) {
+	result, err := tx.Exec(`
+        INSERT INTO kb_members (kb_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (kb_id, user_id) DO NOTHING`,
+		kbID, userID, role,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
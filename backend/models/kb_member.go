@@ -0,0 +1,408 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// 知识库成员角色
+const (
+	RoleOwner  = "OWNER"  // 可管理
+	RoleEditor = "EDITOR" // 可编辑
+	RoleViewer = "VIEWER" // 只读
+)
+
+// Permission 表示访问知识库所需的权限级别
+type Permission int
+
+const (
+	PermissionRead   Permission = iota + 1 // 读取知识库和节点
+	PermissionWrite                        // 增删改节点
+	PermissionManage                       // 修改、删除知识库本身
+)
+
+var (
+	ErrKnowledgeBaseNotFound = errors.New("knowledge base not found")
+	ErrNodeNotFound          = errors.New("knowledge node not found")
+)
+
+// roleRank 角色的权限等级，数值越大权限越高
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// permissionRole 每种权限所需的最低角色
+var permissionRole = map[Permission]string{
+	PermissionRead:   RoleViewer,
+	PermissionWrite:  RoleEditor,
+	PermissionManage: RoleOwner,
+}
+
+// RoleAllows 判断角色是否满足所需权限
+func RoleAllows(role string, perm Permission) bool {
+	required, ok := permissionRole[perm]
+	if !ok {
+		return false
+	}
+	return roleRank[role] >= roleRank[required]
+}
+
+// GetKBRole 解析用户在知识库中的角色
+// 知识库所有者视为OWNER，其次取kb_members中的角色，公开知识库的其他用户视为VIEWER。
+// 用户没有任何角色时返回空字符串；知识库不存在时返回ErrKnowledgeBaseNotFound。
+func GetKBRole(db *sql.DB, kbID, userID string) (string, error) {
+	query := `
+        SELECT k.owner_id, COALESCE(k.is_public, FALSE), m.role
+        FROM knowledge_bases k
+        LEFT JOIN kb_members m ON m.kb_id = k.kb_id AND m.user_id = $2
+        WHERE k.kb_id = $1 AND k.deleted_at IS NULL
+    `
+	rows, err := db.Query(query, kbID, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query role: %w", err)
+	}
+	defer rows.Close()
+
+	found := false
+	role := ""
+	for rows.Next() {
+		var ownerID, memberRole sql.NullString
+		var isPublic bool
+		if err := rows.Scan(&ownerID, &isPublic, &memberRole); err != nil {
+			return "", fmt.Errorf("failed to scan role: %w", err)
+		}
+		found = true
+
+		candidate := ""
+		switch {
+		case ownerID.Valid && ownerID.String == userID:
+			candidate = RoleOwner
+		case memberRole.Valid:
+			candidate = memberRole.String
+		case isPublic:
+			candidate = RoleViewer
+		}
+		if roleRank[candidate] > roleRank[role] {
+			role = candidate
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	if !found {
+		return "", ErrKnowledgeBaseNotFound
+	}
+	return role, nil
+}
+
+// CheckKBPermission 检查用户对知识库是否拥有指定权限
+func CheckKBPermission(db *sql.DB, kbID string, userID string, perm Permission) (bool, error) {
+	role, err := GetKBRole(db, kbID, userID)
+	if err != nil {
+		return false, err
+	}
+	return RoleAllows(role, perm), nil
+}
+
+var (
+	ErrMemberNotFound   = errors.New("member not found")
+	ErrMemberExists     = errors.New("user is already a member of this knowledge base")
+	ErrLastOwner        = errors.New("knowledge base must keep at least one owner")
+	ErrPrimaryOwner     = errors.New("the primary owner must transfer ownership first")
+	ErrTransferToMember = errors.New("ownership can only be transferred to an existing member")
+	ErrNotPrimaryOwner  = errors.New("only the primary owner can transfer ownership")
+)
+
+type KBMember struct {
+	MemberID string    `json:"member_id"`
+	KBID     string    `json:"kb_id"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email,omitempty"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// IsValidRole 判断角色名是否合法
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+const memberColumns = `
+        m.member_id, m.kb_id, m.user_id, COALESCE(u.username, ''), u.email, m.role, m.joined_at
+`
+
+func scanMember(row interface{ Scan(...interface{}) error }) (*KBMember, error) {
+	var m KBMember
+	err := row.Scan(&m.MemberID, &m.KBID, &m.UserID, &m.Username, &m.Email, &m.Role, &m.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetKBMembers 获取知识库的成员列表
+func GetKBMembers(db *sql.DB, kbID string) ([]KBMember, error) {
+	query := `
+        SELECT ` + memberColumns + `
+        FROM kb_members m
+        JOIN users u ON u.user_id = m.user_id
+        WHERE m.kb_id = $1
+        ORDER BY m.joined_at
+    `
+	rows, err := db.Query(query, kbID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query members: %w", err)
+	}
+	defer rows.Close()
+
+	members := make([]KBMember, 0)
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return members, nil
+}
+
+// GetKBMember 获取知识库中的单个成员
+func GetKBMember(db *sql.DB, kbID, userID string) (*KBMember, error) {
+	query := `
+        SELECT ` + memberColumns + `
+        FROM kb_members m
+        JOIN users u ON u.user_id = m.user_id
+        WHERE m.kb_id = $1 AND m.user_id = $2
+    `
+	m, err := scanMember(db.QueryRow(query, kbID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMemberNotFound
+		}
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+	return m, nil
+}
+
+// AddKBMember 将用户加入知识库
+func AddKBMember(db *sql.DB, kbID, userID, role string) (*KBMember, error) {
+	_, err := db.Exec(
+		"INSERT INTO kb_members (kb_id, user_id, role) VALUES ($1, $2, $3)",
+		kbID, userID, role,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrMemberExists
+		}
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+	return GetKBMember(db, kbID, userID)
+}
+
+// lockKnowledgeBase 锁定知识库行，串行化同一知识库的成员变更，返回主所有者ID
+func lockKnowledgeBase(tx *sql.Tx, kbID string) (string, error) {
+	var ownerID sql.NullString
+	err := tx.QueryRow(
+		"SELECT owner_id FROM knowledge_bases WHERE kb_id = $1 AND deleted_at IS NULL FOR UPDATE",
+		kbID,
+	).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrKnowledgeBaseNotFound
+		}
+		return "", fmt.Errorf("failed to lock knowledge base: %w", err)
+	}
+	return ownerID.String, nil
+}
+
+// checkOwnerRemovable 确认移除或降级某个OWNER后知识库仍有所有者
+func checkOwnerRemovable(tx *sql.Tx, kbID, userID, primaryOwnerID, currentRole string) error {
+	if currentRole != RoleOwner {
+		return nil
+	}
+	if userID == primaryOwnerID {
+		return ErrPrimaryOwner
+	}
+
+	var owners int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM kb_members WHERE kb_id = $1 AND role = $2",
+		kbID, RoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// getMemberRoleInTx 获取成员当前角色（事务内）
+func getMemberRoleInTx(tx *sql.Tx, kbID, userID string) (string, error) {
+	var role string
+	err := tx.QueryRow(
+		"SELECT role FROM kb_members WHERE kb_id = $1 AND user_id = $2",
+		kbID, userID,
+	).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMemberNotFound
+		}
+		return "", fmt.Errorf("failed to get member role: %w", err)
+	}
+	return role, nil
+}
+
+// UpdateKBMemberRole 修改成员角色
+func UpdateKBMemberRole(db *sql.DB, kbID, userID, role string) (*KBMember, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	primaryOwnerID, err := lockKnowledgeBase(tx, kbID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentRole, err := getMemberRoleInTx(tx, kbID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != RoleOwner {
+		if err := checkOwnerRemovable(tx, kbID, userID, primaryOwnerID, currentRole); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE kb_members SET role = $1 WHERE kb_id = $2 AND user_id = $3",
+		role, kbID, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update member role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return GetKBMember(db, kbID, userID)
+}
+
+// RemoveKBMember 将成员移出知识库
+func RemoveKBMember(db *sql.DB, kbID, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	primaryOwnerID, err := lockKnowledgeBase(tx, kbID)
+	if err != nil {
+		return err
+	}
+
+	currentRole, err := getMemberRoleInTx(tx, kbID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkOwnerRemovable(tx, kbID, userID, primaryOwnerID, currentRole); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM kb_members WHERE kb_id = $1 AND user_id = $2", kbID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// TransferKBOwnership 将知识库的主所有权从fromUserID转移给另一个成员
+// 只有主所有者本人可以转移，其他OWNER调用时返回ErrNotPrimaryOwner，避免绕过对主所有者的保护；
+// 新所有者被提升为OWNER，原所有者降为EDITOR，并在同一事务中更新knowledge_bases.owner_id。
+func TransferKBOwnership(db *sql.DB, kbID, fromUserID, toUserID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	primaryOwnerID, err := lockKnowledgeBase(tx, kbID)
+	if err != nil {
+		return err
+	}
+	// 主所有者的账号被删除后owner_id为空，这时其他OWNER可以接手
+	if primaryOwnerID != "" && primaryOwnerID != fromUserID {
+		return ErrNotPrimaryOwner
+	}
+	if primaryOwnerID == toUserID {
+		return nil
+	}
+
+	if _, err := getMemberRoleInTx(tx, kbID, toUserID); err != nil {
+		if errors.Is(err, ErrMemberNotFound) {
+			return ErrTransferToMember
+		}
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE kb_members SET role = $1 WHERE kb_id = $2 AND user_id = $3",
+		RoleOwner, kbID, toUserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to promote new owner: %w", err)
+	}
+
+	if primaryOwnerID != "" {
+		_, err = tx.Exec(
+			"UPDATE kb_members SET role = $1 WHERE kb_id = $2 AND user_id = $3",
+			RoleEditor, kbID, primaryOwnerID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to demote previous owner: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE knowledge_bases SET owner_id = $1, updated_at = NOW() WHERE kb_id = $2",
+		toUserID, kbID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update knowledge base owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// isUniqueViolation 判断是否为唯一约束冲突
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"knowledge_master_backend/migrations"
+	"os"
+	"testing"
+)
+
+// openTestDB 连接KM_TEST_DATABASE_DSN指定的测试数据库，未设置时跳过测试
+// 测试会执行迁移并留下测试数据，不要指向生产库。
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("KM_TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("KM_TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func newTestUser(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var b [6]byte
+	rand.Read(b[:])
+	var userID string
+	err := db.QueryRow(
+		"INSERT INTO users (email, password_hash) VALUES ($1, 'hash') RETURNING user_id",
+		"models-test-"+hex.EncodeToString(b[:])+"@example.com",
+	).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+func TestTransferKBOwnershipRequiresPrimaryOwner(t *testing.T) {
+	db := openTestDB(t)
+	owner, coOwner, editor := newTestUser(t, db), newTestUser(t, db), newTestUser(t, db)
+	var kbID string
+	if err := db.QueryRow("INSERT INTO knowledge_bases (name, owner_id) VALUES ('kb', $1) RETURNING kb_id", owner).Scan(&kbID); err != nil {
+		t.Fatal(err)
+	}
+	for userID, role := range map[string]string{owner: RoleOwner, coOwner: RoleOwner, editor: RoleEditor} {
+		if _, err := AddKBMember(db, kbID, userID, role); err != nil {
+			t.Fatalf("AddKBMember: %v", err)
+		}
+	}
+	primaryOwner := func() string {
+		t.Helper()
+		var ownerID string
+		if err := db.QueryRow("SELECT owner_id FROM knowledge_bases WHERE kb_id = $1", kbID).Scan(&ownerID); err != nil {
+			t.Fatal(err)
+		}
+		return ownerID
+	}
+
+	// 其他OWNER同样拥有管理权限，但不能把主所有权转给自己或他人
+	if err := TransferKBOwnership(db, kbID, coOwner, coOwner); !errors.Is(err, ErrNotPrimaryOwner) {
+		t.Fatalf("co-owner takeover: err = %v, want ErrNotPrimaryOwner", err)
+	}
+	if err := TransferKBOwnership(db, kbID, coOwner, editor); !errors.Is(err, ErrNotPrimaryOwner) {
+		t.Fatalf("co-owner transfer: err = %v, want ErrNotPrimaryOwner", err)
+	}
+	if got := primaryOwner(); got != owner {
+		t.Fatalf("owner = %s after rejected transfers, want %s", got, owner)
+	}
+
+	if err := TransferKBOwnership(db, kbID, owner, editor); err != nil {
+		t.Fatalf("TransferKBOwnership: %v", err)
+	}
+	if got := primaryOwner(); got != editor {
+		t.Fatalf("owner = %s, want %s", got, editor)
+	}
+	if role, _ := GetKBRole(db, kbID, owner); role != RoleEditor {
+		t.Fatalf("previous owner role = %q, want EDITOR", role)
+	}
+}
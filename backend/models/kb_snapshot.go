@@ -0,0 +1,451 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// KBSnapshot 知识库在某一时刻的完整快照
+type KBSnapshot struct {
+	SnapshotID  string    `json:"snapshot_id"`
+	KBID        string    `json:"kb_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	NodeCount   int       `json:"node_count"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SnapshotDiffEntry 快照与当前树之间某个节点的差异
+type SnapshotDiffEntry struct {
+	NodeID         string `json:"id"`
+	Type           string `json:"type"`
+	Title          string `json:"name"`
+	OldTitle       string `json:"old_name,omitempty"`
+	OldParentID    string `json:"old_parent_id,omitempty"`
+	NewParentID    string `json:"new_parent_id,omitempty"`
+	TitleChanged   bool   `json:"title_changed,omitempty"`
+	ContentChanged bool   `json:"content_changed,omitempty"`
+}
+
+// SnapshotDiff 以快照为旧版本、当前树为新版本的差异
+type SnapshotDiff struct {
+	Added   []SnapshotDiffEntry `json:"added"`   // 快照之后新增的节点
+	Removed []SnapshotDiffEntry `json:"removed"` // 快照之后被删除的节点
+	Moved   []SnapshotDiffEntry `json:"moved"`   // 父节点或同级顺序发生变化
+	Edited  []SnapshotDiffEntry `json:"edited"`  // 标题或内容发生变化
+}
+
+// flatNode 快照比较和恢复时使用的扁平节点
+type flatNode struct {
+	NodeID    string
+	ParentID  string
+	Type      string
+	Title     string
+	Content   string
+	SortOrder int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Deleted   bool
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadFlatNodes 执行查询并读取扁平节点，查询需按flatNode字段顺序返回列
+func loadFlatNodes(q queryer, query string, args ...interface{}) ([]*flatNode, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*flatNode
+	for rows.Next() {
+		var n flatNode
+		var parentID sql.NullString
+		if err := rows.Scan(
+			&n.NodeID,
+			&parentID,
+			&n.Type,
+			&n.Title,
+			&n.Content,
+			&n.SortOrder,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+			&n.Deleted,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		n.ParentID = parentID.String
+		nodes = append(nodes, &n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return nodes, nil
+}
+
+const liveNodesQuery = `
+        SELECT node_id, parent_id, node_type, title, COALESCE(content, ''), sort_order,
+               created_at, updated_at, deleted_at IS NOT NULL
+        FROM knowledge_nodes
+        WHERE kb_id = $1
+`
+
+const snapshotNodesQuery = `
+        SELECT sn.node_id, sn.parent_id, sn.node_type, sn.title, COALESCE(sn.content, ''), sn.sort_order,
+               sn.created_at, sn.updated_at, FALSE
+        FROM kb_snapshot_nodes sn
+        JOIN kb_snapshots s ON s.snapshot_id = sn.snapshot_id
+        WHERE s.kb_id = $1 AND s.snapshot_id = $2
+`
+
+// CreateKBSnapshot 为知识库当前未删除的全部节点创建快照
+func CreateKBSnapshot(db *sql.DB, kbID, name, description, createdBy string) (*KBSnapshot, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	creator := sql.NullString{String: createdBy, Valid: createdBy != ""}
+	var snap KBSnapshot
+	err = tx.QueryRow(`
+        INSERT INTO kb_snapshots (kb_id, name, description, created_by)
+        VALUES ($1, $2, $3, $4)
+        RETURNING snapshot_id, kb_id, name, COALESCE(description, ''), COALESCE(created_by::text, ''), created_at`,
+		kbID, name, description, creator,
+	).Scan(&snap.SnapshotID, &snap.KBID, &snap.Name, &snap.Description, &snap.CreatedBy, &snap.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	result, err := tx.Exec(`
+        INSERT INTO kb_snapshot_nodes
+        (snapshot_id, node_id, parent_id, node_type, title, content, sort_order, created_at, updated_at)
+        SELECT $1, node_id, parent_id, node_type, title, content, sort_order, created_at, updated_at
+        FROM knowledge_nodes
+        WHERE kb_id = $2 AND deleted_at IS NULL`,
+		snap.SnapshotID, kbID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy nodes: %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	snap.NodeCount = int(count)
+
+	_, err = tx.Exec("UPDATE kb_snapshots SET node_count = $1 WHERE snapshot_id = $2", snap.NodeCount, snap.SnapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update node count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &snap, nil
+}
+
+// GetKBSnapshots 获取知识库的快照列表
+func GetKBSnapshots(db *sql.DB, kbID string) ([]KBSnapshot, error) {
+	rows, err := db.Query(`
+        SELECT snapshot_id, kb_id, name, COALESCE(description, ''), node_count,
+               COALESCE(created_by::text, ''), created_at
+        FROM kb_snapshots
+        WHERE kb_id = $1
+        ORDER BY created_at DESC`,
+		kbID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := make([]KBSnapshot, 0)
+	for rows.Next() {
+		var snap KBSnapshot
+		if err := rows.Scan(
+			&snap.SnapshotID,
+			&snap.KBID,
+			&snap.Name,
+			&snap.Description,
+			&snap.NodeCount,
+			&snap.CreatedBy,
+			&snap.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+// DeleteKBSnapshot 删除快照
+func DeleteKBSnapshot(db *sql.DB, kbID, snapshotID string) error {
+	result, err := db.Exec("DELETE FROM kb_snapshots WHERE kb_id = $1 AND snapshot_id = $2", kbID, snapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrSnapshotNotFound
+	}
+	return nil
+}
+
+// snapshotExists 检查快照是否属于该知识库
+func snapshotExists(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, kbID, snapshotID string) error {
+	var exists bool
+	err := q.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM kb_snapshots WHERE kb_id = $1 AND snapshot_id = $2)",
+		kbID, snapshotID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check snapshot: %w", err)
+	}
+	if !exists {
+		return ErrSnapshotNotFound
+	}
+	return nil
+}
+
+// DiffKBSnapshot 比较快照与当前知识库树
+func DiffKBSnapshot(db *sql.DB, kbID, snapshotID string) (*SnapshotDiff, error) {
+	if err := snapshotExists(db, kbID, snapshotID); err != nil {
+		return nil, err
+	}
+
+	oldNodes, err := loadFlatNodes(db, snapshotNodesQuery, kbID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := loadFlatNodes(db, liveNodesQuery+" AND deleted_at IS NULL", kbID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffFlatNodes(oldNodes, current), nil
+}
+
+// diffFlatNodes 计算两组扁平节点之间的新增、删除、移动和编辑
+func diffFlatNodes(oldNodes, newNodes []*flatNode) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		Added:   make([]SnapshotDiffEntry, 0),
+		Removed: make([]SnapshotDiffEntry, 0),
+		Moved:   make([]SnapshotDiffEntry, 0),
+		Edited:  make([]SnapshotDiffEntry, 0),
+	}
+
+	oldByID := indexFlatNodes(oldNodes)
+	newByID := indexFlatNodes(newNodes)
+	oldPos := siblingPositions(oldNodes, newByID)
+	newPos := siblingPositions(newNodes, oldByID)
+
+	for _, n := range newNodes {
+		if _, ok := oldByID[n.NodeID]; !ok {
+			diff.Added = append(diff.Added, SnapshotDiffEntry{
+				NodeID:      n.NodeID,
+				Type:        n.Type,
+				Title:       n.Title,
+				NewParentID: n.ParentID,
+			})
+		}
+	}
+
+	for _, o := range oldNodes {
+		n, ok := newByID[o.NodeID]
+		if !ok {
+			diff.Removed = append(diff.Removed, SnapshotDiffEntry{
+				NodeID:      o.NodeID,
+				Type:        o.Type,
+				Title:       o.Title,
+				OldParentID: o.ParentID,
+			})
+			continue
+		}
+
+		entry := SnapshotDiffEntry{NodeID: n.NodeID, Type: n.Type, Title: n.Title}
+		if o.ParentID != n.ParentID || oldPos[o.NodeID] != newPos[n.NodeID] {
+			moved := entry
+			moved.OldParentID = o.ParentID
+			moved.NewParentID = n.ParentID
+			diff.Moved = append(diff.Moved, moved)
+		}
+		if o.Title != n.Title || o.Content != n.Content {
+			edited := entry
+			edited.OldTitle = o.Title
+			edited.TitleChanged = o.Title != n.Title
+			edited.ContentChanged = o.Content != n.Content
+			diff.Edited = append(diff.Edited, edited)
+		}
+	}
+
+	return diff
+}
+
+func indexFlatNodes(nodes []*flatNode) map[string]*flatNode {
+	byID := make(map[string]*flatNode, len(nodes))
+	for _, n := range nodes {
+		byID[n.NodeID] = n
+	}
+	return byID
+}
+
+// siblingPositions 计算节点在同级中的相对位置
+// 只考虑在另一侧也位于同一父节点下的兄弟，避免新增或删除的兄弟被误判为移动。
+func siblingPositions(nodes []*flatNode, other map[string]*flatNode) map[string]int {
+	children := make(map[string][]*flatNode)
+	for _, n := range nodes {
+		if o, ok := other[n.NodeID]; ok && o.ParentID == n.ParentID {
+			children[n.ParentID] = append(children[n.ParentID], n)
+		}
+	}
+
+	positions := make(map[string]int)
+	for _, siblings := range children {
+		sortFlatNodes(siblings)
+		for i, n := range siblings {
+			positions[n.NodeID] = i
+		}
+	}
+	return positions
+}
+
+func sortFlatNodes(nodes []*flatNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].SortOrder != nodes[j].SortOrder {
+			return nodes[i].SortOrder < nodes[j].SortOrder
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+}
+
+// RestoreKBSnapshot 在一个事务中将知识库的节点恢复为快照时的状态
+// 快照中的节点恢复原有的父节点、顺序、标题和内容（已被彻底删除的按原ID重建），
+// 快照之后新增的节点移入回收站；内容有变化的节点会先保存一条修订记录。
+func RestoreKBSnapshot(db *sql.DB, kbID, snapshotID, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := lockKnowledgeBase(tx, kbID); err != nil {
+		return err
+	}
+	if err := snapshotExists(tx, kbID, snapshotID); err != nil {
+		return err
+	}
+
+	snapNodes, err := loadFlatNodes(tx, snapshotNodesQuery, kbID, snapshotID)
+	if err != nil {
+		return err
+	}
+	existing, err := loadFlatNodes(tx, liveNodesQuery+" FOR UPDATE", kbID)
+	if err != nil {
+		return err
+	}
+	existingByID := indexFlatNodes(existing)
+	snapByID := indexFlatNodes(snapNodes)
+
+	// 1. 重建已被彻底删除的节点，父节点稍后统一设置以避免外键顺序问题
+	for _, n := range snapNodes {
+		if _, ok := existingByID[n.NodeID]; ok {
+			continue
+		}
+		_, err := tx.Exec(`
+            INSERT INTO knowledge_nodes
+            (node_id, kb_id, parent_id, node_type, title, content, sort_order, created_at, updated_at)
+            VALUES ($1, $2, NULL, $3, $4, $5, $6, $7, NOW())`,
+			n.NodeID, kbID, n.Type, n.Title, n.Content, n.SortOrder, n.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to recreate node %s: %w", n.NodeID, err)
+		}
+	}
+
+	// 2. 恢复快照中每个节点的结构和内容
+	for _, n := range snapNodes {
+		if cur, ok := existingByID[n.NodeID]; ok && !cur.Deleted &&
+			(cur.Title != n.Title || cur.Content != n.Content) {
+			if err := saveRevisionInTx(tx, kbID, n.NodeID, userID); err != nil {
+				return err
+			}
+		}
+
+		parentID := sql.NullString{String: n.ParentID, Valid: n.ParentID != ""}
+		_, err := tx.Exec(`
+            UPDATE knowledge_nodes
+            SET parent_id = $1, node_type = $2, title = $3, content = $4, sort_order = $5,
+                deleted_at = NULL, deleted_by = NULL, trash_root_id = NULL,
+                version = version + 1, updated_at = NOW()
+            WHERE kb_id = $6 AND node_id = $7`,
+			parentID, n.Type, n.Title, n.Content, n.SortOrder, kbID, n.NodeID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to restore node %s: %w", n.NodeID, err)
+		}
+	}
+
+	// 3. 不在快照中的节点全部留在（或移入）回收站，并重新计算回收站根节点
+	trashed := make(map[string]*flatNode)
+	for _, n := range existing {
+		if _, ok := snapByID[n.NodeID]; !ok {
+			trashed[n.NodeID] = n
+		}
+	}
+	for _, n := range trashed {
+		root := n
+		for {
+			parent, ok := trashed[root.ParentID]
+			if !ok {
+				break
+			}
+			root = parent
+		}
+
+		deleter := sql.NullString{String: userID, Valid: userID != ""}
+		_, err := tx.Exec(`
+            UPDATE knowledge_nodes
+            SET deleted_at = COALESCE(deleted_at, NOW()),
+                deleted_by = CASE WHEN deleted_at IS NULL THEN $1 ELSE deleted_by END,
+                trash_root_id = $2
+            WHERE kb_id = $3 AND node_id = $4`,
+			deleter, root.NodeID, kbID, n.NodeID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to trash node %s: %w", n.NodeID, err)
+		}
+	}
+
+	_, err = tx.Exec("UPDATE knowledge_bases SET updated_at = NOW() WHERE kb_id = $1", kbID)
+	if err != nil {
+		return fmt.Errorf("failed to touch knowledge base: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -18,27 +18,57 @@ type KnowledgeBase struct {
 	CollaborationMode string    `json:"collaboration_mode"`
 }
 
-// 创建知识库
-func CreateKnowledgeBase(db *sql.DB, name, description, ownerID string) (*KnowledgeBase, error) {
-	query := `
-		INSERT INTO knowledge_bases 
-		(name, description, owner_id) 
-		VALUES ($1, $2, $3)
-		RETURNING kb_id, name, description, owner_id, created_at, updated_at
-	`
+// 知识库协作模式
+const (
+	CollaborationPrivate = "PRIVATE"
+	CollaborationTeam    = "TEAM"
+	CollaborationPublic  = "PUBLIC"
+)
+
+// IsValidCollaborationMode 判断协作模式是否合法
+func IsValidCollaborationMode(mode string) bool {
+	return mode == CollaborationPrivate || mode == CollaborationTeam || mode == CollaborationPublic
+}
 
+const kbColumns = `
+		k.kb_id, k.name, COALESCE(k.description, ''), k.owner_id, COALESCE(k.is_public, FALSE),
+		k.created_at, k.updated_at, COALESCE(k.cover_image_url, ''), COALESCE(k.collaboration_mode, 'PRIVATE')
+`
+
+func scanKnowledgeBase(row interface{ Scan(...interface{}) error }) (*KnowledgeBase, error) {
 	var kb KnowledgeBase
-	err := db.QueryRow(query, name, description, ownerID).Scan(
+	var ownerID sql.NullString
+	err := row.Scan(
 		&kb.KBID,
 		&kb.Name,
 		&kb.Description,
-		&kb.OwnerID,
+		&ownerID,
+		&kb.IsPublic,
 		&kb.CreatedAt,
 		&kb.UpdatedAt,
+		&kb.CoverImageURL,
+		&kb.CollaborationMode,
 	)
 	if err != nil {
 		return nil, err
 	}
+	kb.OwnerID = ownerID.String
+	return &kb, nil
+}
+
+// 创建知识库
+func CreateKnowledgeBase(db *sql.DB, name, description, ownerID string) (*KnowledgeBase, error) {
+	query := `
+		INSERT INTO knowledge_bases AS k
+		(name, description, owner_id) 
+		VALUES ($1, $2, $3)
+		RETURNING ` + kbColumns + `
+	`
+
+	kb, err := scanKnowledgeBase(db.QueryRow(query, name, description, ownerID))
+	if err != nil {
+		return nil, err
+	}
 
 	// 自动添加创建者为OWNER
 	_, err = db.Exec(
@@ -50,16 +80,17 @@ func CreateKnowledgeBase(db *sql.DB, name, description, ownerID string) (*Knowle
 		return nil, err
 	}
 
-	return &kb, nil
+	return kb, nil
 }
 
 // 获取用户的知识库列表
 func GetUserKnowledgeBases(db *sql.DB, userID string) ([]KnowledgeBase, error) {
 	query := `
-		SELECT k.kb_id, k.name, k.description, k.owner_id, k.created_at, k.updated_at
+		SELECT ` + kbColumns + `
 		FROM knowledge_bases k
-		LEFT JOIN kb_members m ON k.kb_id = m.kb_id
-		WHERE k.owner_id = $1 OR m.user_id = $1
+		WHERE k.deleted_at IS NULL
+		  AND (k.owner_id = $1
+		   OR EXISTS (SELECT 1 FROM kb_members m WHERE m.kb_id = k.kb_id AND m.user_id = $1))
 		ORDER BY k.updated_at DESC
 	`
 	rows, err := db.Query(query, userID)
@@ -70,19 +101,11 @@ func GetUserKnowledgeBases(db *sql.DB, userID string) ([]KnowledgeBase, error) {
 
 	var kbs []KnowledgeBase
 	for rows.Next() {
-		var kb KnowledgeBase
-		err := rows.Scan(
-			&kb.KBID,
-			&kb.Name,
-			&kb.Description,
-			&kb.OwnerID,
-			&kb.CreatedAt,
-			&kb.UpdatedAt,
-		)
+		kb, err := scanKnowledgeBase(rows)
 		if err != nil {
 			return nil, err
 		}
-		kbs = append(kbs, kb)
+		kbs = append(kbs, *kb)
 	}
 
 	return kbs, nil
@@ -90,9 +113,9 @@ func GetUserKnowledgeBases(db *sql.DB, userID string) ([]KnowledgeBase, error) {
 
 func GetKnowledgeBaseById(db *sql.DB, kbID string) (*KnowledgeBase, error) {
 	query := `
-        SELECT k.kb_id, k.name, k.description, k.owner_id, k.created_at, k.updated_at
+        SELECT ` + kbColumns + `
         FROM knowledge_bases k
-        WHERE k.kb_id = $1
+        WHERE k.kb_id = $1 AND k.deleted_at IS NULL
         ORDER BY k.updated_at DESC
     `
 	rows, err := db.Query(query, kbID)
@@ -103,18 +126,10 @@ func GetKnowledgeBaseById(db *sql.DB, kbID string) (*KnowledgeBase, error) {
 
 	// 检查是否有结果
 	if !rows.Next() {
-		return nil, fmt.Errorf("knowledge base not found with id: %s", kbID)
+		return nil, ErrKnowledgeBaseNotFound
 	}
 
-	var kb KnowledgeBase
-	err = rows.Scan(
-		&kb.KBID,
-		&kb.Name,
-		&kb.Description,
-		&kb.OwnerID,
-		&kb.CreatedAt,
-		&kb.UpdatedAt,
-	)
+	kb, err := scanKnowledgeBase(rows)
 	if err != nil {
 		return nil, fmt.Errorf("scan failed: %w", err)
 	}
@@ -124,39 +139,95 @@ func GetKnowledgeBaseById(db *sql.DB, kbID string) (*KnowledgeBase, error) {
 		return nil, fmt.Errorf("multiple knowledge bases found with same id: %s", kbID)
 	}
 
-	return &kb, nil
+	return kb, nil
 }
 
-// UpdateKnowledgeBase updates the name, description, and owner of a knowledge base
-func UpdateKnowledgeBase(db *sql.DB, kbID, name, description, ownerID string) (*KnowledgeBase, error) {
+// UpdateKnowledgeBase updates the name and description of a knowledge base.
+// Ownership changes go through TransferKBOwnership.
+func UpdateKnowledgeBase(db *sql.DB, kbID, name, description string) (*KnowledgeBase, error) {
 	query := `
-        UPDATE knowledge_bases 
+        UPDATE knowledge_bases k
         SET name = $1, 
             description = $2, 
-            owner_id = $3, 
             updated_at = NOW()
-        WHERE kb_id = $4
-        RETURNING kb_id, name, description, owner_id, created_at, updated_at
+        WHERE kb_id = $3 AND deleted_at IS NULL
+        RETURNING ` + kbColumns + `
     `
 
-	var kb KnowledgeBase
-	err := db.QueryRow(query, name, description, ownerID, kbID).Scan(
-		&kb.KBID,
-		&kb.Name,
-		&kb.Description,
-		&kb.OwnerID,
-		&kb.CreatedAt,
-		&kb.UpdatedAt,
-	)
+	kb, err := scanKnowledgeBase(db.QueryRow(query, name, description, kbID))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrKnowledgeBaseNotFound
+		}
 		return nil, fmt.Errorf("failed to update knowledge base: %w", err)
 	}
 
-	return &kb, nil
+	return kb, nil
+}
+
+// UpdateKnowledgeBaseVisibility sets the collaboration mode of a knowledge base.
+// is_public is kept in sync: only PUBLIC bases are readable by everyone.
+func UpdateKnowledgeBaseVisibility(db *sql.DB, kbID, mode string) (*KnowledgeBase, error) {
+	query := `
+        UPDATE knowledge_bases k
+        SET collaboration_mode = $1,
+            is_public = $2,
+            updated_at = NOW()
+        WHERE kb_id = $3 AND deleted_at IS NULL
+        RETURNING ` + kbColumns + `
+    `
+
+	kb, err := scanKnowledgeBase(db.QueryRow(query, mode, mode == CollaborationPublic, kbID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrKnowledgeBaseNotFound
+		}
+		return nil, fmt.Errorf("failed to update visibility: %w", err)
+	}
+
+	return kb, nil
 }
 
-// DeleteKnowledgeBase deletes a knowledge base by its ID
+// IsKnowledgeBasePublic reports whether a knowledge base can be read without an account.
+func IsKnowledgeBasePublic(db *sql.DB, kbID string) (bool, error) {
+	var isPublic bool
+	err := db.QueryRow(
+		"SELECT COALESCE(is_public, FALSE) FROM knowledge_bases WHERE kb_id = $1 AND deleted_at IS NULL",
+		kbID,
+	).Scan(&isPublic)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrKnowledgeBaseNotFound
+		}
+		return false, fmt.Errorf("failed to query visibility: %w", err)
+	}
+	return isPublic, nil
+}
+
+// DeleteKnowledgeBase moves a knowledge base to the trash.
+// Members and nodes are kept so the base can be restored until it is purged.
 func DeleteKnowledgeBase(db *sql.DB, kbID string) error {
+	result, err := db.Exec(
+		"UPDATE knowledge_bases SET deleted_at = NOW() WHERE kb_id = $1 AND deleted_at IS NULL",
+		kbID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete knowledge base: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrKnowledgeBaseNotFound
+	}
+
+	return nil
+}
+
+// PurgeKnowledgeBase permanently deletes a trashed knowledge base by its ID
+func PurgeKnowledgeBase(db *sql.DB, kbID string) error {
 	// Start a transaction to ensure atomicity
 	tx, err := db.Begin()
 	if err != nil {
@@ -170,8 +241,8 @@ func DeleteKnowledgeBase(db *sql.DB, kbID string) error {
 		return fmt.Errorf("failed to delete knowledge base members: %w", err)
 	}
 
-	// Delete the knowledge base itself
-	result, err := tx.Exec("DELETE FROM knowledge_bases WHERE kb_id = $1", kbID)
+	// Delete the knowledge base itself, nodes are removed by ON DELETE CASCADE
+	result, err := tx.Exec("DELETE FROM knowledge_bases WHERE kb_id = $1 AND deleted_at IS NOT NULL", kbID)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to delete knowledge base: %w", err)
@@ -186,7 +257,7 @@ func DeleteKnowledgeBase(db *sql.DB, kbID string) error {
 
 	if rowsAffected == 0 {
 		tx.Rollback()
-		return fmt.Errorf("knowledge base not found with id: %s", kbID)
+		return ErrKnowledgeBaseNotFound
 	}
 
 	// Commit the transaction if everything succeeded
@@ -2,11 +2,23 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"sort"
 	"time"
 )
 
+// ErrVersionConflict 节点已被他人修改，客户端提交的版本号已过期
+var ErrVersionConflict = errors.New("node has been modified by someone else")
+
+// 移动节点的错误
+var (
+	ErrMoveIntoSelf    = errors.New("cannot move a node into itself or its own descendant")
+	ErrMoveIntoFile    = errors.New("can only move nodes into folders")
+	ErrInvalidPosition = errors.New("position must be one of before/after/inside")
+)
+
 type KnowledgeNode struct {
 	NodeID    string           `json:"id"`
 	KBID      string           `json:"-"`
@@ -16,33 +28,43 @@ type KnowledgeNode struct {
 	Content   string           `json:"content,omitempty"`
 	Children  []*KnowledgeNode `json:"children,omitempty"`
 	SortOrder int              `json:"sort_order"` // 改为公开字段
+	Version   int              `json:"version"`    // 乐观锁版本号
 	CreatedAt time.Time        `json:"-"`
 	UpdatedAt time.Time        `json:"-"`
 }
 
-// 获取知识库的树形结构
-func GetKnowledgeTree(db *sql.DB, kbID string) ([]*KnowledgeNode, error) {
-	query := `
+// nodeTreeCTE 从根节点递归查询知识库中未删除的节点
+// sort_path 为从根到节点的排序键，按它排序即得到先序遍历（父节点总在子节点之前）。
+const nodeTreeCTE = `
         WITH RECURSIVE node_tree AS (
             SELECT 
                 node_id, parent_id, node_type, title, content, 
-                sort_order, created_at, updated_at,
-                0 AS level
+                sort_order, version, created_at, updated_at,
+                0 AS level,
+                ARRAY[lpad((sort_order::bigint + 2147483648)::text, 10, '0') || node_id::text] AS sort_path
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id IS NULL
+            WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
             
             UNION ALL
             
             SELECT 
                 n.node_id, n.parent_id, n.node_type, n.title, n.content,
-                n.sort_order, n.created_at, n.updated_at,
-                t.level + 1
+                n.sort_order, n.version, n.created_at, n.updated_at,
+                t.level + 1,
+                t.sort_path || (lpad((n.sort_order::bigint + 2147483648)::text, 10, '0') || n.node_id::text)
             FROM knowledge_nodes n
             JOIN node_tree t ON n.parent_id = t.node_id
-            WHERE n.kb_id = $1
+            WHERE n.kb_id = $1 AND n.deleted_at IS NULL
         )
-        SELECT * FROM node_tree
-        ORDER BY level, parent_id, sort_order
+`
+
+// 获取知识库的树形结构
+func GetKnowledgeTree(db *sql.DB, kbID string) ([]*KnowledgeNode, error) {
+	query := nodeTreeCTE + `
+        SELECT node_id, parent_id, node_type, title, content,
+               sort_order, version, created_at, updated_at, level
+        FROM node_tree
+        ORDER BY level, parent_id, sort_order, created_at, node_id
     `
 
 	rows, err := db.Query(query, kbID)
@@ -64,6 +86,7 @@ func GetKnowledgeTree(db *sql.DB, kbID string) ([]*KnowledgeNode, error) {
 			&node.Title,
 			&node.Content,
 			&node.SortOrder,
+			&node.Version,
 			&node.CreatedAt,
 			&node.UpdatedAt,
 			new(int), // level (ignored)
@@ -96,23 +119,144 @@ func GetKnowledgeTree(db *sql.DB, kbID string) ([]*KnowledgeNode, error) {
 		}
 	}
 
-	// 对每个父节点的子节点进行排序
+	// 对每个父节点的子节点进行排序，sort_order相同的保持查询结果中的顺序
 	for _, node := range nodeMap {
 		if len(node.Children) > 0 {
-			sort.Slice(node.Children, func(i, j int) bool {
+			sort.SliceStable(node.Children, func(i, j int) bool {
 				return node.Children[i].SortOrder < node.Children[j].SortOrder
 			})
 		}
 	}
 
 	// 对根节点排序
-	sort.Slice(rootNodes, func(i, j int) bool {
+	sort.SliceStable(rootNodes, func(i, j int) bool {
 		return rootNodes[i].SortOrder < rootNodes[j].SortOrder
 	})
 
 	return rootNodes, nil
 }
 
+// WalkKnowledgeTree 按先序逐个读取知识库中的节点并交给fn处理，不在内存中构建整棵树
+// 父节点总在子节点之前，同级节点按sort_order排序；fn返回错误时停止遍历。
+func WalkKnowledgeTree(db *sql.DB, kbID string, fn func(node *KnowledgeNode, depth int) error) error {
+	query := nodeTreeCTE + `
+        SELECT node_id, parent_id, node_type, title, COALESCE(content, ''),
+               sort_order, version, created_at, updated_at, level
+        FROM node_tree
+        ORDER BY sort_path
+    `
+
+	rows, err := db.Query(query, kbID)
+	if err != nil {
+		return fmt.Errorf("failed to query tree: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var node KnowledgeNode
+		var parentID sql.NullString
+		var depth int
+		if err := rows.Scan(
+			&node.NodeID,
+			&parentID,
+			&node.Type,
+			&node.Title,
+			&node.Content,
+			&node.SortOrder,
+			&node.Version,
+			&node.CreatedAt,
+			&node.UpdatedAt,
+			&depth,
+		); err != nil {
+			return fmt.Errorf("failed to scan node: %w", err)
+		}
+		node.KBID = kbID
+		node.ParentID = parentID.String
+
+		if err := fn(&node, depth); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return nil
+}
+
+// GetKnowledgeSubtree 获取以nodeID为根的子树（不含回收站中的节点），子节点按sort_order排序
+func GetKnowledgeSubtree(db *sql.DB, kbID, nodeID string) (*KnowledgeNode, error) {
+	query := `
+        WITH RECURSIVE subtree AS (
+            SELECT node_id, parent_id, node_type, title, content,
+                   sort_order, version, created_at, updated_at
+            FROM knowledge_nodes
+            WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
+
+            UNION ALL
+
+            SELECT n.node_id, n.parent_id, n.node_type, n.title, n.content,
+                   n.sort_order, n.version, n.created_at, n.updated_at
+            FROM knowledge_nodes n
+            JOIN subtree s ON n.parent_id = s.node_id
+            WHERE n.kb_id = $1 AND n.deleted_at IS NULL
+        )
+        SELECT node_id, parent_id, node_type, title, COALESCE(content, ''),
+               sort_order, version, created_at, updated_at
+        FROM subtree
+        ORDER BY sort_order
+    `
+
+	rows, err := db.Query(query, kbID, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subtree: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*KnowledgeNode
+	nodeMap := make(map[string]*KnowledgeNode)
+	for rows.Next() {
+		var node KnowledgeNode
+		var parentID sql.NullString
+		if err := rows.Scan(
+			&node.NodeID,
+			&parentID,
+			&node.Type,
+			&node.Title,
+			&node.Content,
+			&node.SortOrder,
+			&node.Version,
+			&node.CreatedAt,
+			&node.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		node.KBID = kbID
+		node.ParentID = parentID.String
+		nodeMap[node.NodeID] = &node
+		nodes = append(nodes, &node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	root, ok := nodeMap[nodeID]
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+	// 结果已按sort_order排序，依次追加即保持同级顺序
+	for _, node := range nodes {
+		if node == root {
+			continue
+		}
+		if parent, ok := nodeMap[node.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	return root, nil
+}
+
 // 添加节点
 func AddKnowledgeNode(db *sql.DB, kbID string, node *KnowledgeNode) (*KnowledgeNode, error) {
 	// 自动计算sort_order
@@ -125,14 +269,14 @@ func AddKnowledgeNode(db *sql.DB, kbID string, node *KnowledgeNode) (*KnowledgeN
 			err = db.QueryRow(`
                 SELECT COALESCE(MAX(sort_order), 0) + 1 
                 FROM knowledge_nodes 
-                WHERE kb_id = $1 AND parent_id IS NULL`,
+                WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL`,
 				kbID,
 			).Scan(&maxOrder)
 		} else {
 			err = db.QueryRow(`
                 SELECT COALESCE(MAX(sort_order), 0) + 1 
                 FROM knowledge_nodes 
-                WHERE kb_id = $1 AND parent_id = $2`,
+                WHERE kb_id = $1 AND parent_id = $2 AND deleted_at IS NULL`,
 				kbID, node.ParentID,
 			).Scan(&maxOrder)
 		}
@@ -147,10 +291,11 @@ func AddKnowledgeNode(db *sql.DB, kbID string, node *KnowledgeNode) (*KnowledgeN
         INSERT INTO knowledge_nodes 
         (kb_id, parent_id, node_type, title, content, sort_order)
         VALUES ($1, $2, $3, $4, $5, $6)
-        RETURNING node_id, created_at, updated_at
+        RETURNING node_id, version, created_at, updated_at
     `
 
 	var nodeID string
+	var version int
 	var createdAt, updatedAt time.Time
 
 	// 明确处理 parent_id 为空的两种情况
@@ -164,13 +309,14 @@ func AddKnowledgeNode(db *sql.DB, kbID string, node *KnowledgeNode) (*KnowledgeN
 		node.Title,
 		node.Content,
 		node.SortOrder,
-	).Scan(&nodeID, &createdAt, &updatedAt)
+	).Scan(&nodeID, &version, &createdAt, &updatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to add node: %w", err)
 	}
 
 	node.NodeID = nodeID
+	node.Version = version
 	node.CreatedAt = createdAt
 	node.UpdatedAt = updatedAt
 	return node, nil
@@ -184,10 +330,11 @@ func GetKnowledgeNode(db *sql.DB, kbID string, nodeID string) (*KnowledgeNode, e
             title, 
             content, 
             sort_order,
+            version,
             created_at,
             updated_at
         FROM knowledge_nodes
-        WHERE kb_id = $1 AND node_id = $2
+        WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
     `
 
 	var node KnowledgeNode
@@ -199,12 +346,13 @@ func GetKnowledgeNode(db *sql.DB, kbID string, nodeID string) (*KnowledgeNode, e
 		&node.Title,
 		&node.Content,
 		&node.SortOrder,
+		&node.Version,
 		&node.CreatedAt,
 		&node.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("knowledge node not found")
+			return nil, ErrNodeNotFound
 		}
 		return nil, fmt.Errorf("failed to get knowledge node: %w", err)
 	}
@@ -215,76 +363,125 @@ func GetKnowledgeNode(db *sql.DB, kbID string, nodeID string) (*KnowledgeNode, e
 
 	return &node, nil
 }
-func UpdateKnowledgeNode(db *sql.DB, kbID, nodeID, title, content string) (*KnowledgeNode, error) {
+
+// UpdateKnowledgeNode 更新节点标题和内容，更新前的版本会被保存为一条修订记录
+// expectedVersion 大于0时，只有节点当前版本与之相同才会更新，否则返回ErrVersionConflict。
+func UpdateKnowledgeNode(db *sql.DB, kbID, nodeID, authorID, title, content string, expectedVersion int) (*KnowledgeNode, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	node, err := updateKnowledgeNodeInTx(tx, kbID, nodeID, authorID, title, content, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return node, nil
+}
+
+func updateKnowledgeNodeInTx(tx *sql.Tx, kbID, nodeID, authorID, title, content string, expectedVersion int) (*KnowledgeNode, error) {
+	var currentVersion int
+	err := tx.QueryRow(
+		"SELECT version FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		kbID, nodeID,
+	).Scan(&currentVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to lock node: %w", err)
+	}
+	if expectedVersion > 0 && expectedVersion != currentVersion {
+		return nil, ErrVersionConflict
+	}
+
+	if err := saveRevisionInTx(tx, kbID, nodeID, authorID); err != nil {
+		return nil, err
+	}
+
 	query := `
         UPDATE knowledge_nodes
         SET title = $1, 
             content = $2, 
+            version = version + 1,
             updated_at = CURRENT_TIMESTAMP
         WHERE kb_id = $3 AND node_id = $4
-        RETURNING node_id, kb_id, parent_id, node_type, title, content, sort_order, created_at, updated_at
+        RETURNING node_id, kb_id, parent_id, node_type, title, content, sort_order, version, created_at, updated_at
     `
 
 	var node KnowledgeNode
-	err := db.QueryRow(query, title, content, kbID, nodeID).Scan(
+	var parentID sql.NullString
+	err = tx.QueryRow(query, title, content, kbID, nodeID).Scan(
 		&node.NodeID,
 		&node.KBID,
-		&node.ParentID,
+		&parentID,
 		&node.Type,
 		&node.Title,
 		&node.Content,
 		&node.SortOrder,
+		&node.Version,
 		&node.CreatedAt,
 		&node.UpdatedAt,
 	)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
 		return nil, fmt.Errorf("failed to update node: %w", err)
 	}
 
-	return &node, nil
-}
+	if parentID.Valid {
+		node.ParentID = parentID.String
+	}
 
-func CheckKBPermission(db *sql.DB, kbID string, userID string, level int) (bool, error) {
-	return true, nil
+	return &node, nil
 }
 
-func DeleteKnowledgeNode(db *sql.DB, kbID string, nodeID string) error {
-	// 先删除所有子节点
-	deleteChildrenQuery := `
+// DeleteKnowledgeNode 将节点及其子树移入回收站
+// 同一次删除的节点共享trash_root_id，恢复和彻底删除都以它为单位。
+func DeleteKnowledgeNode(db *sql.DB, kbID, nodeID, deletedBy string) error {
+	query := `
         WITH RECURSIVE node_tree AS (
             SELECT node_id
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id = $2
+            WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
             
             UNION ALL
             
             SELECT n.node_id
             FROM knowledge_nodes n
             JOIN node_tree t ON n.parent_id = t.node_id
-            WHERE n.kb_id = $1
+            WHERE n.kb_id = $1 AND n.deleted_at IS NULL
         )
-        DELETE FROM knowledge_nodes
+        UPDATE knowledge_nodes
+        SET deleted_at = NOW(), deleted_by = $3, trash_root_id = $2
         WHERE node_id IN (SELECT node_id FROM node_tree)
     `
-	_, err := db.Exec(deleteChildrenQuery, kbID, nodeID)
+	deleter := sql.NullString{String: deletedBy, Valid: deletedBy != ""}
+	result, err := db.Exec(query, kbID, nodeID, deleter)
 	if err != nil {
-		return fmt.Errorf("failed to delete children nodes: %w", err)
+		return fmt.Errorf("failed to delete node: %w", err)
 	}
 
-	// 再删除当前节点
-	deleteNodeQuery := `
-        DELETE FROM knowledge_nodes
-        WHERE kb_id = $1 AND node_id = $2
-    `
-	_, err = db.Exec(deleteNodeQuery, kbID, nodeID)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to delete node: %w", err)
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNodeNotFound
 	}
 
 	return nil
 }
 
+// MoveNode 把节点移动到目标节点之前、之后（before/after）或目标文件夹内（inside）
 func MoveNode(db *sql.DB, kbID string, dragID string, hoverID string, position string) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -292,80 +489,65 @@ func MoveNode(db *sql.DB, kbID string, dragID string, hoverID string, position s
 	}
 	defer tx.Rollback()
 
-	// 1. 获取拖动节点和悬停节点的信息
-	var dragNode struct {
-		NodeID    string
-		ParentID  sql.NullString
-		SortOrder int
-	}
-
+	// 1. 确认拖动节点和目标节点存在
+	var dragExists bool
 	if err := tx.QueryRow(
-		"SELECT node_id, parent_id, sort_order FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2",
+		"SELECT EXISTS(SELECT 1 FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL)",
 		kbID, dragID,
-	).Scan(&dragNode.NodeID, &dragNode.ParentID, &dragNode.SortOrder); err != nil {
+	).Scan(&dragExists); err != nil {
 		return fmt.Errorf("failed to get drag node: %w", err)
 	}
+	if !dragExists {
+		return ErrNodeNotFound
+	}
 
 	var hoverNode struct {
-		NodeID   string
 		ParentID sql.NullString
 		Type     string
 	}
-
 	if err := tx.QueryRow(
-		"SELECT node_id, parent_id, node_type FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2",
+		"SELECT parent_id, node_type FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL",
 		kbID, hoverID,
-	).Scan(&hoverNode.NodeID, &hoverNode.ParentID, &hoverNode.Type); err != nil {
+	).Scan(&hoverNode.ParentID, &hoverNode.Type); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNodeNotFound
+		}
 		return fmt.Errorf("failed to get hover node: %w", err)
 	}
 
 	// 2. 检查移动有效性
-	if dragID == hoverID {
-		return fmt.Errorf("cannot move node to itself")
-	}
-
-	if isDescendant(tx, kbID, dragID, hoverID) {
-		return fmt.Errorf("cannot move a node into its own descendant")
+	if dragID == hoverID || isDescendant(tx, kbID, dragID, hoverID) {
+		return ErrMoveIntoSelf
 	}
 
 	// 3. 处理不同类型的移动
 	switch position {
 	case "before", "after":
 		// 先移动父级到与悬停节点相同
-		newParentID := hoverNode.ParentID
-		if newParentID.Valid {
-			_, err = tx.Exec(`
-                UPDATE knowledge_nodes
-                SET parent_id = $1, updated_at = NOW()
-                WHERE kb_id = $2 AND node_id = $3`,
-				newParentID.String, kbID, dragID,
-			)
-		} else {
-			_, err = tx.Exec(`
-                UPDATE knowledge_nodes
-                SET parent_id = NULL, updated_at = NOW()
-                WHERE kb_id = $1 AND node_id = $2`,
-				kbID, dragID,
-			)
-		}
+		_, err = tx.Exec(`
+            UPDATE knowledge_nodes
+            SET parent_id = $1, updated_at = NOW()
+            WHERE kb_id = $2 AND node_id = $3`,
+			hoverNode.ParentID, kbID, dragID,
+		)
 		if err != nil {
 			return fmt.Errorf("failed to update node parent: %w", err)
 		}
 
 		// 然后处理排序
-		if err := moveAdjacent(tx, kbID, dragID, hoverID, position); err != nil {
+		if err := moveAdjacent(tx, kbID, dragID, hoverID, hoverNode.ParentID, position); err != nil {
 			return err
 		}
 
 	case "inside":
 		if hoverNode.Type != "folder" {
-			return fmt.Errorf("can only move nodes into folders")
+			return ErrMoveIntoFile
 		}
 		if err := moveIntoFolder(tx, kbID, dragID, hoverID); err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("invalid position type: %s", position)
+		return ErrInvalidPosition
 	}
 
 	// 4. 提交事务
@@ -377,108 +559,70 @@ func MoveNode(db *sql.DB, kbID string, dragID string, hoverID string, position s
 }
 
 // 移动到相邻位置 (before/after)
-func moveAdjacent(tx *sql.Tx, kbID, dragID, hoverID, position string) error {
-	// 获取悬停节点的父ID
-	var hoverParentID sql.NullString
-	err := tx.QueryRow(
-		"SELECT parent_id FROM knowledge_nodes WHERE kb_id = $1 AND node_id = $2",
-		kbID, hoverID,
-	).Scan(&hoverParentID)
-	if err != nil {
-		return fmt.Errorf("failed to get hover node parent: %w", err)
-	}
-
-	// 获取兄弟节点当前排序
-	var siblings []struct {
-		NodeID    string
-		SortOrder int
-	}
-
-	var rows *sql.Rows
-	if hoverParentID.Valid {
-		rows, err = tx.Query(`
-            SELECT node_id, sort_order 
-            FROM knowledge_nodes 
-            WHERE kb_id = $1 AND parent_id = $2
-            ORDER BY sort_order`,
-			kbID, hoverParentID.String,
-		)
-	} else {
-		rows, err = tx.Query(`
-            SELECT node_id, sort_order 
-            FROM knowledge_nodes 
-            WHERE kb_id = $1 AND parent_id IS NULL
-            ORDER BY sort_order`,
-			kbID,
-		)
-	}
+// 把拖动节点插入到同级节点中悬停节点的前面或后面，再按新的顺序把同级节点重新编号为1..n。
+func moveAdjacent(tx *sql.Tx, kbID, dragID, hoverID string, parentID sql.NullString, position string) error {
+	rows, err := tx.Query(`
+        SELECT node_id
+        FROM knowledge_nodes
+        WHERE kb_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL AND node_id <> $3
+        ORDER BY sort_order, created_at, node_id`,
+		kbID, parentID, dragID,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to query siblings: %w", err)
 	}
 	defer rows.Close()
 
+	var siblings []string
 	for rows.Next() {
-		var node struct {
-			NodeID    string
-			SortOrder int
-		}
-		if err := rows.Scan(&node.NodeID, &node.SortOrder); err != nil {
+		var id string
+		if err := rows.Scan(&id); err != nil {
 			return fmt.Errorf("failed to scan sibling: %w", err)
 		}
-		siblings = append(siblings, node)
+		siblings = append(siblings, id)
 	}
-
-	// 计算新sort_order
-	var newOrder int
-	found := false
-	for i, sib := range siblings {
-		if sib.NodeID == hoverID {
-			found = true
-			switch position {
-			case "before":
-				if i == 0 {
-					// 如果是第一个节点，则设置比它小的order
-					newOrder = sib.SortOrder - 1
-				} else {
-					// 否则取前一个节点和当前节点的中间值
-					newOrder = (siblings[i-1].SortOrder + sib.SortOrder) / 2
-				}
-			case "after":
-				if i == len(siblings)-1 {
-					// 如果是最后一个节点，则设置比它大的order
-					newOrder = sib.SortOrder + 1
-				} else {
-					// 否则取当前节点和后一个节点的中间值
-					newOrder = (sib.SortOrder + siblings[i+1].SortOrder) / 2
-				}
-			}
-			break
-		}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query siblings: %w", err)
 	}
 
-	if !found {
-		return fmt.Errorf("hover node not found in siblings")
+	ordered, err := insertSibling(siblings, dragID, hoverID, position)
+	if err != nil {
+		return err
 	}
 
-	// 更新节点位置
 	_, err = tx.Exec(`
-        UPDATE knowledge_nodes
-        SET sort_order = $1, updated_at = NOW()
-        WHERE kb_id = $2 AND node_id = $3`,
-		newOrder, kbID, dragID,
+        UPDATE knowledge_nodes n
+        SET sort_order = o.ord::int
+        FROM unnest($1::uuid[]) WITH ORDINALITY AS o(node_id, ord)
+        WHERE n.node_id = o.node_id AND n.kb_id = $2 AND n.sort_order <> o.ord`,
+		pq.Array(ordered), kbID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update node position: %w", err)
 	}
+	return nil
+}
 
-	// 如果order值空间不足，重新排序
-	if newOrder <= 0 || (len(siblings) > 0 && newOrder <= siblings[0].SortOrder) {
-		if err := reorderSiblings(tx, kbID, hoverParentID); err != nil {
-			return fmt.Errorf("failed to reorder siblings: %w", err)
+// insertSibling 把dragID插入到有序的同级节点列表中hoverID的前面或后面
+func insertSibling(siblings []string, dragID, hoverID, position string) ([]string, error) {
+	index := -1
+	for i, id := range siblings {
+		if id == hoverID {
+			index = i
+			break
 		}
 	}
+	if index < 0 {
+		return nil, fmt.Errorf("hover node not found in siblings")
+	}
+	if position == "after" {
+		index++
+	}
 
-	return nil
+	ordered := make([]string, 0, len(siblings)+1)
+	ordered = append(ordered, siblings[:index]...)
+	ordered = append(ordered, dragID)
+	return append(ordered, siblings[index:]...), nil
 }
 
 // 移动到文件夹内
@@ -488,7 +632,7 @@ func moveIntoFolder(tx *sql.Tx, kbID, dragID, hoverID string) error {
 	err := tx.QueryRow(`
         SELECT COALESCE(MAX(sort_order), 0) + 1 
         FROM knowledge_nodes 
-        WHERE kb_id = $1 AND parent_id = $2`,
+        WHERE kb_id = $1 AND parent_id = $2 AND deleted_at IS NULL`,
 		kbID, hoverID,
 	).Scan(&maxOrder)
 	if err != nil {
@@ -511,7 +655,7 @@ func isDescendant(tx *sql.Tx, kbID, parentID, childID string) bool {
         WITH RECURSIVE node_tree AS (
             SELECT node_id, parent_id
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND node_id = $2
+            WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
             
             UNION ALL
             
@@ -542,7 +686,7 @@ func getSiblingsInTx(tx *sql.Tx, kbID, parentID string) ([]struct {
 		query = `
             SELECT node_id, sort_order 
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id IS NULL
+            WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
             ORDER BY sort_order
         `
 		args = []interface{}{kbID}
@@ -551,7 +695,7 @@ func getSiblingsInTx(tx *sql.Tx, kbID, parentID string) ([]struct {
 		query = `
             SELECT node_id, sort_order 
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id = $2
+            WHERE kb_id = $1 AND parent_id = $2 AND deleted_at IS NULL
             ORDER BY sort_order
         `
 		args = []interface{}{kbID, parentID}
@@ -586,39 +730,6 @@ func getSiblingsInTx(tx *sql.Tx, kbID, parentID string) ([]struct {
 	return siblings, nil
 }
 
-// 重新排序兄弟节点
-func reorderSiblings(tx *sql.Tx, kbID string, parentID sql.NullString) error {
-	var err error
-	if parentID.Valid {
-		_, err = tx.Exec(`
-            WITH sorted AS (
-                SELECT node_id, ROW_NUMBER() OVER (ORDER BY sort_order) * 100 AS new_order
-                FROM knowledge_nodes
-                WHERE kb_id = $1 AND parent_id = $2
-            )
-            UPDATE knowledge_nodes n
-            SET sort_order = s.new_order
-            FROM sorted s
-            WHERE n.node_id = s.node_id AND n.kb_id = $1`,
-			kbID, parentID.String,
-		)
-	} else {
-		_, err = tx.Exec(`
-            WITH sorted AS (
-                SELECT node_id, ROW_NUMBER() OVER (ORDER BY sort_order) * 100 AS new_order
-                FROM knowledge_nodes
-                WHERE kb_id = $1 AND parent_id IS NULL
-            )
-            UPDATE knowledge_nodes n
-            SET sort_order = s.new_order
-            FROM sorted s
-            WHERE n.node_id = s.node_id AND n.kb_id = $1`,
-			kbID,
-		)
-	}
-	return err
-}
-
 // GetSiblingNodes 获取同级节点（按sort_order排序）
 func GetSiblingNodes(db *sql.DB, kbID, parentID string) ([]*KnowledgeNode, error) {
 	// Build query based on whether we're looking for root nodes or children
@@ -630,7 +741,7 @@ func GetSiblingNodes(db *sql.DB, kbID, parentID string) ([]*KnowledgeNode, error
 		query = `
             SELECT node_id, parent_id, node_type, title, content, sort_order
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id IS NULL
+            WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
             ORDER BY sort_order
         `
 		args = []interface{}{kbID}
@@ -639,7 +750,7 @@ func GetSiblingNodes(db *sql.DB, kbID, parentID string) ([]*KnowledgeNode, error
 		query = `
             SELECT node_id, parent_id, node_type, title, content, sort_order
             FROM knowledge_nodes
-            WHERE kb_id = $1 AND parent_id = $2
+            WHERE kb_id = $1 AND parent_id = $2 AND deleted_at IS NULL
             ORDER BY sort_order
         `
 		args = []interface{}{kbID, parentID}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// NodeRevision 节点的一条历史版本，保存某次更新之前的标题和内容
+type NodeRevision struct {
+	RevisionID     string    `json:"revision_id"`
+	NodeID         string    `json:"node_id"`
+	RevisionNumber int       `json:"revision_number"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content,omitempty"`
+	AuthorID       string    `json:"author_id"`
+	AuthorName     string    `json:"author_name"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// saveRevisionInTx 将节点当前的标题和内容保存为新的修订记录
+func saveRevisionInTx(tx *sql.Tx, kbID, nodeID, authorID string) error {
+	var title, content string
+	err := tx.QueryRow(`
+        SELECT title, COALESCE(content, '')
+        FROM knowledge_nodes
+        WHERE kb_id = $1 AND node_id = $2
+        FOR UPDATE`,
+		kbID, nodeID,
+	).Scan(&title, &content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNodeNotFound
+		}
+		return fmt.Errorf("failed to lock node: %w", err)
+	}
+
+	author := sql.NullString{String: authorID, Valid: authorID != ""}
+	_, err = tx.Exec(`
+        INSERT INTO node_revisions (node_id, kb_id, revision_number, title, content, author_id)
+        SELECT $1, $2, COALESCE(MAX(revision_number), 0) + 1, $3, $4, $5
+        FROM node_revisions
+        WHERE node_id = $1`,
+		nodeID, kbID, title, content, author,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save revision: %w", err)
+	}
+	return nil
+}
+
+// GetNodeRevisions 获取节点的修订历史（不含内容），按版本号倒序
+func GetNodeRevisions(db *sql.DB, kbID, nodeID string) ([]NodeRevision, error) {
+	query := `
+        SELECT r.revision_id, r.node_id, r.revision_number, r.title,
+               COALESCE(r.author_id::text, ''), COALESCE(u.username, ''), r.created_at
+        FROM node_revisions r
+        LEFT JOIN users u ON u.user_id = r.author_id
+        WHERE r.kb_id = $1 AND r.node_id = $2
+        ORDER BY r.revision_number DESC
+    `
+	rows, err := db.Query(query, kbID, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := make([]NodeRevision, 0)
+	for rows.Next() {
+		var rev NodeRevision
+		if err := rows.Scan(
+			&rev.RevisionID,
+			&rev.NodeID,
+			&rev.RevisionNumber,
+			&rev.Title,
+			&rev.AuthorID,
+			&rev.AuthorName,
+			&rev.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return revisions, nil
+}
+
+// GetNodeRevision 获取单条修订记录（含内容）
+func GetNodeRevision(db *sql.DB, kbID, nodeID, revisionID string) (*NodeRevision, error) {
+	query := `
+        SELECT r.revision_id, r.node_id, r.revision_number, r.title, COALESCE(r.content, ''),
+               COALESCE(r.author_id::text, ''), COALESCE(u.username, ''), r.created_at
+        FROM node_revisions r
+        LEFT JOIN users u ON u.user_id = r.author_id
+        WHERE r.kb_id = $1 AND r.node_id = $2 AND r.revision_id = $3
+    `
+	var rev NodeRevision
+	err := db.QueryRow(query, kbID, nodeID, revisionID).Scan(
+		&rev.RevisionID,
+		&rev.NodeID,
+		&rev.RevisionNumber,
+		&rev.Title,
+		&rev.Content,
+		&rev.AuthorID,
+		&rev.AuthorName,
+		&rev.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, fmt.Errorf("failed to get revision: %w", err)
+	}
+	return &rev, nil
+}
+
+// RestoreNodeRevision 将节点恢复为指定修订的标题和内容
+// 恢复本身也是一次更新，恢复前的版本同样会被保存，不会丢失任何历史。
+func RestoreNodeRevision(db *sql.DB, kbID, nodeID, revisionID, authorID string) (*KnowledgeNode, error) {
+	rev, err := GetNodeRevision(db, kbID, nodeID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateKnowledgeNode(db, kbID, nodeID, authorID, rev.Title, rev.Content, 0)
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// 命中片段中关键词的起止标记，使用私用区字符，转义HTML后再换成<mark>
+const (
+	snippetStart = "\ue000"
+	snippetStop  = "\ue001"
+)
+
+var snippetHeadlineOptions = `StartSel="` + snippetStart + `", StopSel="` + snippetStop + `", MaxFragments=2, MaxWords=30, MinWords=10`
+
+// SearchParams 全文检索参数
+type SearchParams struct {
+	UserID   string
+	Query    string
+	KBID     string // 可选，限定知识库；指定公开知识库时也可检索
+	NodeType string // 可选，folder/file
+	Page     int    // 从1开始
+	PageSize int
+}
+
+// SearchHit 一条检索结果
+type SearchHit struct {
+	NodeID     string   `json:"id"`
+	KBID       string   `json:"kb_id"`
+	KBName     string   `json:"kb_name"`
+	Type       string   `json:"type"`
+	Title      string   `json:"name"`
+	Snippet    string   `json:"snippet"`    // 命中片段，已转义HTML，关键词用<mark>标记
+	Breadcrumb []string `json:"breadcrumb"` // 从根到父节点的标题路径
+	Rank       float64  `json:"rank"`
+}
+
+// searchQuery 检索节点，参数见searchArgs
+// 三个匹配条件分别使用search_vector的全文索引和title、content的三元组索引（见迁移0007），
+// 由BitmapOr组合，TestSearchNodesUsesIndexes用EXPLAIN校验这一点；片段和路径只为当前页的结果计算。
+const searchQuery = `
+        WITH hits AS (
+            SELECT n.node_id, n.updated_at,
+                   ts_rank_cd(n.search_vector, websearch_to_tsquery('simple', $2))
+                       + CASE WHEN n.title ILIKE $3 THEN 1 ELSE 0 END AS rank,
+                   COUNT(*) OVER() AS total
+            FROM knowledge_nodes n
+            JOIN knowledge_bases k ON k.kb_id = n.kb_id
+            WHERE n.deleted_at IS NULL AND k.deleted_at IS NULL
+              AND (n.search_vector @@ websearch_to_tsquery('simple', $2) OR n.title ILIKE $3 OR n.content ILIKE $3)
+              AND (k.owner_id = $1
+                   OR EXISTS (SELECT 1 FROM kb_members m WHERE m.kb_id = k.kb_id AND m.user_id = $1)
+                   OR (k.is_public AND k.kb_id::text = $4))
+              AND ($4 = '' OR n.kb_id::text = $4)
+              AND ($5 = '' OR n.node_type = $5)
+            ORDER BY rank DESC, n.updated_at DESC
+            LIMIT $6 OFFSET $7
+        )
+        SELECT n.node_id, n.kb_id, k.name, n.node_type, n.title,
+               CASE WHEN n.search_vector @@ websearch_to_tsquery('simple', $2)
+                   THEN ts_headline('simple', translate(COALESCE(n.content, ''), $9, ''), websearch_to_tsquery('simple', $2), $8)
+                   ELSE substr(translate(COALESCE(n.content, ''), $9, ''),
+                              GREATEST(strpos(lower(COALESCE(n.content, '')), lower($2)) - 40, 1), 120)
+               END,
+               COALESCE(bc.path, '{}'),
+               h.rank,
+               h.total
+        FROM hits h
+        JOIN knowledge_nodes n ON n.node_id = h.node_id
+        JOIN knowledge_bases k ON k.kb_id = n.kb_id
+        LEFT JOIN LATERAL (
+            WITH RECURSIVE ancestors AS (
+                SELECT p.node_id, p.parent_id, p.title, 1 AS depth
+                FROM knowledge_nodes p
+                WHERE p.node_id = n.parent_id
+
+                UNION ALL
+
+                SELECT p.node_id, p.parent_id, p.title, a.depth + 1
+                FROM knowledge_nodes p
+                JOIN ancestors a ON p.node_id = a.parent_id
+            )
+            SELECT array_agg(title ORDER BY depth DESC) AS path FROM ancestors
+        ) bc ON TRUE
+        ORDER BY h.rank DESC, h.updated_at DESC
+    `
+
+// searchArgs searchQuery的参数
+func searchArgs(params SearchParams) []interface{} {
+	return []interface{}{
+		params.UserID,
+		params.Query,
+		"%" + escapeLike(params.Query) + "%",
+		params.KBID,
+		params.NodeType,
+		params.PageSize,
+		(params.Page - 1) * params.PageSize,
+		snippetHeadlineOptions,
+		snippetStart + snippetStop,
+	}
+}
+
+// SearchNodes 在用户可访问的知识库中检索节点标题和内容，返回当前页结果和总数
+// simple配置按空格和标点分词，无法匹配中文等连续书写的文字中间的词，
+// 因此标题和内容还会按子串匹配，命中片段取自第一次出现的位置。
+func SearchNodes(db *sql.DB, params SearchParams) ([]SearchHit, int, error) {
+	rows, err := db.Query(searchQuery, searchArgs(params)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search nodes: %w", err)
+	}
+	defer rows.Close()
+
+	hits := make([]SearchHit, 0)
+	total := 0
+	for rows.Next() {
+		var hit SearchHit
+		var path pq.StringArray
+		if err := rows.Scan(
+			&hit.NodeID,
+			&hit.KBID,
+			&hit.KBName,
+			&hit.Type,
+			&hit.Title,
+			&hit.Snippet,
+			&path,
+			&hit.Rank,
+			&total,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		hit.Snippet = highlightSnippet(hit.Snippet, params.Query)
+		hit.Breadcrumb = []string(path)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return hits, total, nil
+}
+
+// highlightSnippet 转义片段中的HTML并把关键词标记换成<mark>
+// 按子串命中的片段没有ts_headline的标记，这时标记其中出现的整个查询词。
+func highlightSnippet(snippet, query string) string {
+	if !strings.Contains(snippet, snippetStart) && strings.TrimSpace(query) != "" {
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(strings.TrimSpace(query)))
+		snippet = pattern.ReplaceAllStringFunc(snippet, func(match string) string {
+			return snippetStart + match + snippetStop
+		})
+	}
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// escapeLike 转义LIKE模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		snippet, query, want string
+	}{
+		{"plain " + snippetStart + "word" + snippetStop + " here", "word", "plain <mark>word</mark> here"},
+		{`<img src=x onerror=alert(1)> ` + snippetStart + "hit" + snippetStop, "hit", `&lt;img src=x onerror=alert(1)&gt; <mark>hit</mark>`},
+		{"<mark>fake</mark> " + snippetStart + "a" + snippetStop, "a", "&lt;mark&gt;fake&lt;/mark&gt; <mark>a</mark>"},
+		// 子串命中的片段没有标记，按查询词标记
+		{"知识管理系统的使用说明", "管理", "知识<mark>管理</mark>系统的使用说明"},
+		{"Go & <Rust>", "go", "<mark>Go</mark> &amp; &lt;Rust&gt;"},
+		{"a.b and axb", "a.b", "<mark>a.b</mark> and axb"},
+		{"no match", "other", "no match"},
+	}
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.snippet, tt.query); got != tt.want {
+			t.Errorf("highlightSnippet(%q, %q) = %q, want %q", tt.snippet, tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestSearchNodesUsesIndexes 用EXPLAIN确认检索条件走全文索引和三元组索引，而不是扫描全部节点
+func TestSearchNodesUsesIndexes(t *testing.T) {
+	db := openTestDB(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	// 表中数据很少时全表扫描总是最便宜；关掉后条件若无法走索引，计划中仍然会出现Seq Scan
+	if _, err := tx.Exec("SET LOCAL enable_seqscan = off"); err != nil {
+		t.Fatal(err)
+	}
+	params := SearchParams{UserID: "00000000-0000-4000-8000-000000000000", Query: "知识管理", Page: 1, PageSize: 20}
+	rows, err := tx.Query("EXPLAIN "+searchQuery, searchArgs(params)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var plan strings.Builder
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		plan.WriteString(line + "\n")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []string{"idx_nodes_search", "idx_nodes_title_trgm", "idx_nodes_content_trgm"} {
+		if !strings.Contains(plan.String(), index) {
+			t.Errorf("plan does not use %s:\n%s", index, plan.String())
+		}
+	}
+	if strings.Contains(plan.String(), "Seq Scan on knowledge_nodes") {
+		t.Errorf("plan scans all nodes:\n%s", plan.String())
+	}
+}
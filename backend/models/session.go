@@ -0,0 +1,199 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
+
+// Session 一次登录产生的会话，刷新令牌只保存哈希
+type Session struct {
+	SessionID  string    `json:"id"`
+	UserID     string    `json:"-"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // 是否是发起请求的会话，由处理函数设置
+}
+
+const sessionColumns = `session_id, user_id, device, user_agent, ip, created_at, last_seen_at, expires_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	var s Session
+	err := row.Scan(&s.SessionID, &s.UserID, &s.Device, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSession 创建会话，refreshHash为刷新令牌的哈希
+func CreateSession(db *sql.DB, s *Session, refreshHash string) (*Session, error) {
+	query := `
+        INSERT INTO user_sessions (user_id, refresh_token_hash, device, user_agent, ip, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + sessionColumns
+	session, err := scanSession(db.QueryRow(query, s.UserID, refreshHash, s.Device, s.UserAgent, s.IP, s.ExpiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+// RotateSession 用新的刷新令牌替换旧的，并延长会话的有效期
+// 旧令牌是上一次轮换掉的令牌（同一个刷新令牌第二次使用）时，认为令牌已泄露，撤销整个会话并返回ErrRefreshTokenReused。
+func RotateSession(db *sql.DB, oldHash, newHash string, expiresAt time.Time, ip string) (*Session, error) {
+	query := `
+        UPDATE user_sessions
+        SET refresh_token_hash = $2,
+            previous_token_hash = $1,
+            expires_at = $3,
+            ip = $4,
+            last_seen_at = NOW()
+        WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+        RETURNING ` + sessionColumns
+	session, err := scanSession(db.QueryRow(query, oldHash, newHash, expiresAt, ip))
+	if err == nil {
+		return session, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	result, err := db.Exec(
+		"UPDATE user_sessions SET revoked_at = NOW() WHERE previous_token_hash = $1 AND revoked_at IS NULL",
+		oldHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil, ErrRefreshTokenReused
+	}
+	return nil, ErrSessionNotFound
+}
+
+// GetActiveSession 获取未撤销且未过期的会话
+func GetActiveSession(db *sql.DB, sessionID string) (*Session, error) {
+	if !uuidPattern.MatchString(sessionID) {
+		return nil, ErrSessionNotFound
+	}
+	query := `
+        SELECT ` + sessionColumns + `
+        FROM user_sessions
+        WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+	session, err := scanSession(db.QueryRow(query, sessionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+// TouchSession 更新会话的最后活动时间
+func TouchSession(db *sql.DB, sessionID string) error {
+	_, err := db.Exec("UPDATE user_sessions SET last_seen_at = NOW() WHERE session_id = $1", sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// GetUserSessions 用户的有效会话，最近活动的在前
+func GetUserSessions(db *sql.DB, userID string) ([]Session, error) {
+	query := `
+        SELECT ` + sessionColumns + `
+        FROM user_sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+        ORDER BY last_seen_at DESC, created_at DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession 撤销用户的一个会话
+func RevokeSession(db *sql.DB, userID, sessionID string) error {
+	if !uuidPattern.MatchString(sessionID) {
+		return ErrSessionNotFound
+	}
+	result, err := db.Exec(`
+        UPDATE user_sessions SET revoked_at = NOW()
+        WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`,
+		sessionID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions 撤销用户的全部会话，返回撤销的数量
+func RevokeUserSessions(db *sql.DB, userID string) (int, error) {
+	result, err := db.Exec(`
+        UPDATE user_sessions SET revoked_at = NOW()
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// RevokeOtherSessions 撤销用户除keepSessionID以外的全部会话，返回撤销的数量
+func RevokeOtherSessions(db *sql.DB, userID, keepSessionID string) (int, error) {
+	result, err := db.Exec(`
+        UPDATE user_sessions SET revoked_at = NOW()
+        WHERE user_id = $1 AND session_id::text <> $2 AND revoked_at IS NULL AND expires_at > NOW()`,
+		userID, keepSessionID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// DeleteStaleSessions 删除已过期的会话和在revokedBefore之前撤销的会话
+// 撤销的会话保留一段时间，期间仍能识别被盗用的刷新令牌。
+func DeleteStaleSessions(db *sql.DB, revokedBefore time.Time) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM user_sessions WHERE expires_at < NOW() OR revoked_at < $1",
+		revokedBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
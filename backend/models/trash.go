@@ -0,0 +1,288 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrTrashItemNotFound = errors.New("item not found in trash")
+
+// TrashedNode 回收站中的一次删除（以被删除的子树根节点表示）
+type TrashedNode struct {
+	NodeID         string    `json:"id"`
+	ParentID       string    `json:"parent_id,omitempty"`
+	ParentTitle    string    `json:"parent_name,omitempty"`
+	Type           string    `json:"type"`
+	Title          string    `json:"name"`
+	DescendantsNum int       `json:"descendants"` // 一同删除的子孙节点数量
+	DeletedAt      time.Time `json:"deleted_at"`
+	DeletedBy      string    `json:"deleted_by"`
+}
+
+// TrashedKnowledgeBase 回收站中的知识库
+type TrashedKnowledgeBase struct {
+	KnowledgeBase
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// GetTrashedNodes 获取知识库回收站中的节点
+func GetTrashedNodes(db *sql.DB, kbID string) ([]TrashedNode, error) {
+	query := `
+        SELECT n.node_id, n.parent_id, COALESCE(p.title, ''), n.node_type, n.title,
+               (SELECT COUNT(*) - 1 FROM knowledge_nodes d WHERE d.trash_root_id = n.node_id),
+               n.deleted_at, COALESCE(n.deleted_by::text, '')
+        FROM knowledge_nodes n
+        LEFT JOIN knowledge_nodes p ON p.node_id = n.parent_id
+        WHERE n.kb_id = $1 AND n.deleted_at IS NOT NULL AND n.trash_root_id = n.node_id
+        ORDER BY n.deleted_at DESC
+    `
+	rows, err := db.Query(query, kbID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]TrashedNode, 0)
+	for rows.Next() {
+		var item TrashedNode
+		var parentID sql.NullString
+		if err := rows.Scan(
+			&item.NodeID,
+			&parentID,
+			&item.ParentTitle,
+			&item.Type,
+			&item.Title,
+			&item.DescendantsNum,
+			&item.DeletedAt,
+			&item.DeletedBy,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan trashed node: %w", err)
+		}
+		item.ParentID = parentID.String
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return items, nil
+}
+
+// RestoreTrashedNode 从回收站恢复一次删除的整棵子树
+// 原父节点仍然存在时恢复到原位置，否则恢复到根目录末尾。
+func RestoreTrashedNode(db *sql.DB, kbID, nodeID string) (*KnowledgeNode, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullString
+	err = tx.QueryRow(`
+        SELECT parent_id
+        FROM knowledge_nodes
+        WHERE kb_id = $1 AND node_id = $2 AND trash_root_id = $2 AND deleted_at IS NOT NULL
+        FOR UPDATE`,
+		kbID, nodeID,
+	).Scan(&parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTrashItemNotFound
+		}
+		return nil, fmt.Errorf("failed to get trashed node: %w", err)
+	}
+
+	parentAlive := false
+	if parentID.Valid {
+		err = tx.QueryRow(`
+            SELECT EXISTS(
+                SELECT 1 FROM knowledge_nodes
+                WHERE kb_id = $1 AND node_id = $2 AND deleted_at IS NULL
+            )`,
+			kbID, parentID.String,
+		).Scan(&parentAlive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check parent node: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+        UPDATE knowledge_nodes
+        SET deleted_at = NULL, deleted_by = NULL, trash_root_id = NULL
+        WHERE kb_id = $1 AND trash_root_id = $2`,
+		kbID, nodeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore nodes: %w", err)
+	}
+
+	if parentID.Valid && !parentAlive {
+		_, err = tx.Exec(`
+            UPDATE knowledge_nodes
+            SET parent_id = NULL,
+                sort_order = (
+                    SELECT COALESCE(MAX(sort_order), 0) + 1
+                    FROM knowledge_nodes
+                    WHERE kb_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
+                ),
+                updated_at = NOW()
+            WHERE kb_id = $1 AND node_id = $2`,
+			kbID, nodeID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move node to root: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return GetKnowledgeNode(db, kbID, nodeID)
+}
+
+// PurgeTrashedNode 彻底删除回收站中的一次删除，子孙节点由外键级联删除
+func PurgeTrashedNode(db *sql.DB, kbID, nodeID string) error {
+	result, err := db.Exec(`
+        DELETE FROM knowledge_nodes
+        WHERE kb_id = $1 AND node_id = $2 AND trash_root_id = $2 AND deleted_at IS NOT NULL`,
+		kbID, nodeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to purge node: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTrashItemNotFound
+	}
+	return nil
+}
+
+// EmptyNodeTrash 清空知识库的回收站，返回删除的节点数量
+func EmptyNodeTrash(db *sql.DB, kbID string) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM knowledge_nodes WHERE kb_id = $1 AND deleted_at IS NOT NULL",
+		kbID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to empty trash: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// kbOwnedBy 生成判断用户是否为知识库所有者的SQL条件，userParam为用户ID的占位符
+func kbOwnedBy(userParam string) string {
+	return `
+        (k.owner_id = ` + userParam + ` OR EXISTS (
+            SELECT 1 FROM kb_members m
+            WHERE m.kb_id = k.kb_id AND m.user_id = ` + userParam + ` AND m.role = 'OWNER'
+        ))
+`
+}
+
+// GetTrashedKnowledgeBases 获取用户作为所有者的已删除知识库
+func GetTrashedKnowledgeBases(db *sql.DB, userID string) ([]TrashedKnowledgeBase, error) {
+	query := `
+        SELECT ` + kbColumns + `, k.deleted_at
+        FROM knowledge_bases k
+        WHERE k.deleted_at IS NOT NULL AND ` + kbOwnedBy("$1") + `
+        ORDER BY k.deleted_at DESC
+    `
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	kbs := make([]TrashedKnowledgeBase, 0)
+	for rows.Next() {
+		var item TrashedKnowledgeBase
+		var ownerID sql.NullString
+		if err := rows.Scan(
+			&item.KBID,
+			&item.Name,
+			&item.Description,
+			&ownerID,
+			&item.IsPublic,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.CoverImageURL,
+			&item.CollaborationMode,
+			&item.DeletedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan trashed knowledge base: %w", err)
+		}
+		item.OwnerID = ownerID.String
+		kbs = append(kbs, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+
+	return kbs, nil
+}
+
+// IsTrashedKnowledgeBaseOwner 判断用户是否为某个已删除知识库的所有者
+func IsTrashedKnowledgeBaseOwner(db *sql.DB, kbID, userID string) (bool, error) {
+	query := `
+        SELECT EXISTS(
+            SELECT 1 FROM knowledge_bases k
+            WHERE k.kb_id = $1 AND k.deleted_at IS NOT NULL AND ` + kbOwnedBy("$2") + `
+        )
+    `
+	var ok bool
+	if err := db.QueryRow(query, kbID, userID).Scan(&ok); err != nil {
+		return false, fmt.Errorf("failed to check knowledge base owner: %w", err)
+	}
+	return ok, nil
+}
+
+// RestoreKnowledgeBase 从回收站恢复知识库
+func RestoreKnowledgeBase(db *sql.DB, kbID string) (*KnowledgeBase, error) {
+	result, err := db.Exec(
+		"UPDATE knowledge_bases SET deleted_at = NULL, updated_at = NOW() WHERE kb_id = $1 AND deleted_at IS NOT NULL",
+		kbID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore knowledge base: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, ErrTrashItemNotFound
+	}
+
+	return GetKnowledgeBaseById(db, kbID)
+}
+
+// PurgeExpiredTrash 彻底删除在before之前进入回收站的节点和知识库
+func PurgeExpiredTrash(db *sql.DB, before time.Time) (nodes int64, kbs int64, err error) {
+	result, err := db.Exec(
+		"DELETE FROM knowledge_nodes WHERE deleted_at IS NOT NULL AND deleted_at < $1",
+		before,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to purge nodes: %w", err)
+	}
+	nodes, _ = result.RowsAffected()
+
+	result, err = db.Exec(
+		"DELETE FROM knowledge_bases WHERE deleted_at IS NOT NULL AND deleted_at < $1",
+		before,
+	)
+	if err != nil {
+		return nodes, 0, fmt.Errorf("failed to purge knowledge bases: %w", err)
+	}
+	kbs, _ = result.RowsAffected()
+
+	return nodes, kbs, nil
+}
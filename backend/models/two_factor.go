@@ -0,0 +1,281 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication is not set up")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeUsed        = errors.New("verification code has already been used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or already used")
+	ErrChallengeInvalid    = errors.New("login challenge is invalid, expired or used up")
+)
+
+// TOTP 用户的两步验证密钥
+type TOTP struct {
+	UserID       string
+	Secret       string
+	Enabled      bool
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+// GetTOTP 获取用户的两步验证密钥，没有时返回ErrTOTPNotEnrolled
+func GetTOTP(db *sql.DB, userID string) (*TOTP, error) {
+	t := &TOTP{}
+	err := db.QueryRow(
+		"SELECT user_id, secret, enabled_at, last_used_step FROM user_totp WHERE user_id = $1",
+		userID,
+	).Scan(&t.UserID, &t.Secret, &t.EnabledAt, &t.LastUsedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, fmt.Errorf("failed to get TOTP: %w", err)
+	}
+	t.Enabled = t.EnabledAt != nil
+	return t, nil
+}
+
+// EnrollTOTP 保存新生成的密钥，替换之前未确认的密钥
+// 已启用两步验证时返回ErrTOTPAlreadyEnabled。
+func EnrollTOTP(db *sql.DB, userID, secret string) error {
+	result, err := db.Exec(`
+        INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+        WHERE user_totp.enabled_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enroll TOTP: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTOTP 用第一个验证码（时间步step）确认密钥，启用两步验证并保存恢复码的哈希
+func EnableTOTP(db *sql.DB, userID string, step int64, recoveryHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var enabledAt *time.Time
+	err = tx.QueryRow("SELECT enabled_at FROM user_totp WHERE user_id = $1 FOR UPDATE", userID).Scan(&enabledAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTOTPNotEnrolled
+		}
+		return fmt.Errorf("failed to get TOTP: %w", err)
+	}
+	if enabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	_, err = tx.Exec(
+		"UPDATE user_totp SET enabled_at = NOW(), last_used_step = $2 WHERE user_id = $1",
+		userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep 记录使用了时间步step的验证码，step不大于上次使用的时间步时返回ErrTOTPCodeUsed
+func UseTOTPStep(db *sql.DB, userID string, step int64) error {
+	result, err := db.Exec(`
+        UPDATE user_totp SET last_used_step = $2
+        WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use TOTP code: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+// DisableTOTP 关闭两步验证，删除密钥和全部恢复码
+func DisableTOTP(db *sql.DB, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM user_totp WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTOTPNotEnrolled
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range hashes {
+		_, err := tx.Exec("INSERT INTO user_recovery_codes (code_hash, user_id) VALUES ($1, $2)", hash, userID)
+		if err != nil {
+			return fmt.Errorf("failed to create recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes 用新的恢复码替换用户全部的恢复码，未启用两步验证时返回ErrTOTPNotEnrolled
+func ReplaceRecoveryCodes(db *sql.DB, userID string, hashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRow(
+		"SELECT enabled_at IS NOT NULL FROM user_totp WHERE user_id = $1 FOR UPDATE",
+		userID,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get TOTP: %w", err)
+	}
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode 使用一个恢复码，恢复码不存在或已使用时返回ErrRecoveryCodeInvalid
+func UseRecoveryCode(db *sql.DB, userID, codeHash string) error {
+	result, err := db.Exec(`
+        UPDATE user_recovery_codes SET used_at = NOW()
+        WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL`,
+		codeHash, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// CountRecoveryCodes 用户未使用的恢复码数量
+func CountRecoveryCodes(db *sql.DB, userID string) (int, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+// CreateLoginChallenge 保存等待两步验证的登录，challengeHash为令牌的哈希
+func CreateLoginChallenge(db *sql.DB, challengeHash, userID string, expiresAt time.Time) error {
+	_, err := db.Exec(
+		"INSERT INTO login_challenges (challenge_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		challengeHash, userID, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return nil
+}
+
+// AttemptLoginChallenge 记录一次验证尝试，返回登录的用户
+// 令牌不存在、已使用、已过期或已尝试maxAttempts次时返回ErrChallengeInvalid。
+func AttemptLoginChallenge(db *sql.DB, challengeHash string, maxAttempts int) (string, error) {
+	var userID string
+	err := db.QueryRow(`
+        UPDATE login_challenges SET attempts = attempts + 1
+        WHERE challenge_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2
+        RETURNING user_id`,
+		challengeHash, maxAttempts,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrChallengeInvalid
+		}
+		return "", fmt.Errorf("failed to check login challenge: %w", err)
+	}
+	return userID, nil
+}
+
+// CompleteLoginChallenge 验证通过后使令牌失效，已被使用时返回ErrChallengeInvalid
+func CompleteLoginChallenge(db *sql.DB, challengeHash string) error {
+	result, err := db.Exec(
+		"UPDATE login_challenges SET used_at = NOW() WHERE challenge_hash = $1 AND used_at IS NULL",
+		challengeHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to complete login challenge: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+	return nil
+}
+
+// DeleteStaleLoginChallenges 删除在before之前过期的登录验证
+func DeleteStaleLoginChallenges(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM login_challenges WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete login challenges: %w", err)
+	}
+	return result.RowsAffected()
+}
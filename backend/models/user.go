@@ -2,24 +2,60 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email is already registered")
 )
 
 type User struct {
-	UserID    string `json:"id"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	Username  string `json:"username"`
-	AvatarURI string `json:"avatar_uri"`
+	UserID        string     `json:"id"`
+	Email         string     `json:"email"`
+	Password      string     `json:"password"`
+	Username      string     `json:"username"`
+	AvatarURI     string     `json:"avatar_uri"`
+	Avatars       AvatarURLs `json:"avatars"`
+	EmailVerified bool       `json:"email_verified"`
 }
 
 type UserProfile struct {
-	UserID      string `json:"id"`
-	Email       string `json:"email"`
-	Username    string `json:"username"`
-	Description string `json:"description"`
-	Website     string `json:"website"`
-	AvatarURI   string `json:"avatar_uri"`
+	UserID      string     `json:"id"`
+	Email       string     `json:"email"`
+	Username    string     `json:"username"`
+	Description string     `json:"description"`
+	Website     string     `json:"website"`
+	AvatarURI   string     `json:"avatar_uri"`
+	Avatars     AvatarURLs `json:"avatars"`
+}
+
+// AvatarURLs 头像各个尺寸的地址，key为边长，如 "64"、"128"、"512"
+type AvatarURLs map[string]string
+
+// Scan 从JSONB列读取
+func (a *AvatarURLs) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*a = AvatarURLs{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported avatars value %T", src)
+	}
+	urls := AvatarURLs{}
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return err
+	}
+	*a = urls
+	return nil
 }
 
 func CreateUser(db *sql.DB, email, password, username string) (*User, error) {
@@ -27,17 +63,23 @@ func CreateUser(db *sql.DB, email, password, username string) (*User, error) {
 	var user_id string
 	err := db.QueryRow(query, email, password, username).Scan(&user_id)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
 		return nil, err
 	}
 	return &User{UserID: user_id, Email: email, Username: username}, nil
 }
 
 func GetUserByEmail(db *sql.DB, email string) (*User, error) {
-	query := `SELECT user_id, email, password_hash, username FROM users WHERE email = $1`
+	query := `SELECT user_id, email, password_hash, username, email_verified_at IS NOT NULL FROM users WHERE email = $1`
 	row := db.QueryRow(query, email)
 	user := &User{}
-	err := row.Scan(&user.UserID, &user.Email, &user.Password, &user.Username)
+	err := row.Scan(&user.UserID, &user.Email, &user.Password, &user.Username, &user.EmailVerified)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return user, nil
@@ -45,15 +87,19 @@ func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 
 func GetUserByID(db *sql.DB, userID string) (*User, error) {
 	query := `
-		SELECT user_id, email, username , avatar_uri
-		FROM user_profiles  
-		WHERE user_id = $1
+		SELECT p.user_id, p.email, p.username, p.avatar_uri, p.avatars, u.email_verified_at IS NOT NULL
+		FROM user_profiles p
+		JOIN users u ON u.user_id = p.user_id
+		WHERE p.user_id = $1
 	`
 	row := db.QueryRow(query, userID)
 
 	user := &User{}
-	err := row.Scan(&user.UserID, &user.Email, &user.Username, &user.AvatarURI)
+	err := row.Scan(&user.UserID, &user.Email, &user.Username, &user.AvatarURI, &user.Avatars, &user.EmailVerified)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 
@@ -61,7 +107,7 @@ func GetUserByID(db *sql.DB, userID string) (*User, error) {
 }
 func CreateUserProfile(db *sql.DB, userID string, email string, username string, description string, website string, avatar_uri string) error {
 	query := `
-		INSERT INTO user_profiles (user_id, username,email,description,website,avatar_uri) VALUES ($1,$2,$3,$4,$5,$6)
+		INSERT INTO user_profiles (user_id, email, username, description, website, avatar_uri) VALUES ($1,$2,$3,$4,$5,$6)
 	`
 	_, err := db.Exec(query, userID, email, username, description, website, avatar_uri)
 	if err != nil {
@@ -71,14 +117,17 @@ func CreateUserProfile(db *sql.DB, userID string, email string, username string,
 }
 func GetUserProfile(db *sql.DB, userID string) (*UserProfile, error) {
 	query := `
-		SELECT user_id, username,email,description,website,avatar_uri
+		SELECT user_id, username,email,description,website,avatar_uri,avatars
 		FROM user_profiles 
 		WHERE user_id = $1
 	`
 	row := db.QueryRow(query, userID)
 	Profile := &UserProfile{}
-	err := row.Scan(&Profile.UserID, &Profile.Username, &Profile.Email, &Profile.Description, &Profile.Website, &Profile.AvatarURI)
+	err := row.Scan(&Profile.UserID, &Profile.Username, &Profile.Email, &Profile.Description, &Profile.Website, &Profile.AvatarURI, &Profile.Avatars)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return Profile, nil
@@ -91,11 +140,13 @@ func UpdateUserProfile(db *sql.DB, userID string, user *UserProfile) error {
             username = $1,
             description = $2,
             website = $3,
-            avatar_uri = $4
+            avatar_uri = $4,
+            -- 手动修改了头像地址时，原来的各尺寸地址不再对应
+            avatars = CASE WHEN avatar_uri IS DISTINCT FROM $4 THEN '{}' ELSE avatars END
         WHERE user_id = $5
     `
 
-	_, err := db.Exec(
+	result, err := db.Exec(
 		query,
 		user.Username,
 		user.Description,
@@ -107,6 +158,118 @@ func UpdateUserProfile(db *sql.DB, userID string, user *UserProfile) error {
 	if err != nil {
 		return fmt.Errorf("failed to update user profile: %w", err)
 	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetUserAvatar 保存上传的头像，返回被替换的头像在存储中的文件
+func SetUserAvatar(db *sql.DB, userID, uri string, urls AvatarURLs, keys []string) ([]string, error) {
+	data, err := json.Marshal(urls)
+	if err != nil {
+		return nil, err
+	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldKeys []string
+	err = tx.QueryRow(
+		"SELECT avatar_keys FROM user_profiles WHERE user_id = $1 FOR UPDATE",
+		userID,
+	).Scan(pq.Array(&oldKeys))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE user_profiles
+        SET avatar_uri = $1, avatars = $2, avatar_keys = $3, updated_at = NOW()
+        WHERE user_id = $4`,
+		uri, data, pq.Array(keys), userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update avatar: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return oldKeys, nil
+}
+
+// GetUserEmail 获取用户的登录邮箱
+func GetUserEmail(db *sql.DB, userID string) (string, error) {
+	var email string
+	err := db.QueryRow("SELECT email FROM users WHERE user_id = $1", userID).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	return email, nil
+}
+
+// GetUserCredentials 按用户ID查询登录信息，包含密码哈希和邮箱验证状态
+func GetUserCredentials(db *sql.DB, userID string) (*User, error) {
+	query := `SELECT user_id, email, password_hash, username, email_verified_at IS NOT NULL FROM users WHERE user_id = $1`
+	user := &User{}
+	err := db.QueryRow(query, userID).Scan(&user.UserID, &user.Email, &user.Password, &user.Username, &user.EmailVerified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetUserPassword 修改密码哈希
+func SetUserPassword(db *sql.DB, userID, passwordHash string) error {
+	result, err := db.Exec("UPDATE users SET password_hash = $1 WHERE user_id = $2", passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerified 把用户的邮箱标记为已验证
+// email为验证邮件发往的邮箱，与用户当前的邮箱不同时返回ErrTokenInvalid。
+func MarkEmailVerified(db *sql.DB, userID, email string) error {
+	result, err := db.Exec(`
+        UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+        WHERE user_id = $1 AND email = $2`,
+		userID, email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTokenInvalid
+	}
 	return nil
 }
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 邮件令牌的用途
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+var ErrTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// UserToken 发送到用户邮箱的一次性令牌，令牌本身不保存
+type UserToken struct {
+	UserID    string
+	Purpose   string
+	Email     string // 发送令牌时的邮箱
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// CreateUserToken 保存令牌，同一用户同一用途之前未使用的令牌全部作废
+func CreateUserToken(db *sql.DB, tokenHash string, t *UserToken) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE user_tokens SET used_at = NOW()
+        WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		t.UserID, t.Purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate tokens: %w", err)
+	}
+	_, err = tx.Exec(`
+        INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at)
+        VALUES ($1, $2, $3, $4, $5)`,
+		tokenHash, t.UserID, t.Purpose, t.Email, t.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ConsumeUserToken 使用令牌，令牌不存在、已使用、已作废或已过期时返回ErrTokenInvalid
+func ConsumeUserToken(db *sql.DB, purpose, tokenHash string) (*UserToken, error) {
+	query := `
+        UPDATE user_tokens SET used_at = NOW()
+        WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+        RETURNING user_id, purpose, email, created_at, expires_at`
+	var t UserToken
+	err := db.QueryRow(query, tokenHash, purpose).Scan(&t.UserID, &t.Purpose, &t.Email, &t.CreatedAt, &t.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to consume token: %w", err)
+	}
+	return &t, nil
+}
+
+// LastUserTokenAt 最近一次为用户生成该用途令牌的时间，没有时返回零值
+func LastUserTokenAt(db *sql.DB, userID, purpose string) (time.Time, error) {
+	var last sql.NullTime
+	err := db.QueryRow(
+		"SELECT MAX(created_at) FROM user_tokens WHERE user_id = $1 AND purpose = $2",
+		userID, purpose,
+	).Scan(&last)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last token: %w", err)
+	}
+	return last.Time, nil
+}
+
+// DeleteStaleUserTokens 删除在before之前过期或使用的令牌
+func DeleteStaleUserTokens(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM user_tokens WHERE expires_at < $1 OR used_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
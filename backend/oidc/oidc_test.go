@@ -0,0 +1,146 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"knowledge_master_backend/oidc"
+	"knowledge_master_backend/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirectURL = "https://km.example.com/oidc/callback"
+
+func newProvider(t *testing.T, secret string) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	idp := oidctest.NewProvider(t, "km", secret)
+	p, err := oidc.New(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "km",
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idp, p
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	for _, secret := range []string{"s3cret:with/special chars", ""} {
+		idp, p := newProvider(t, secret)
+		ctx := context.Background()
+
+		verifier, err := oidc.NewCodeVerifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", oidc.CodeChallenge(verifier))
+		if err != nil {
+			t.Fatalf("AuthCodeURL: %v", err)
+		}
+		if !strings.Contains(authURL, "scope=openid+email+profile") {
+			t.Fatalf("default scopes missing in %s", authURL)
+		}
+
+		identity := oidctest.Identity{Subject: "u-1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"}
+		callback, err := idp.Login(authURL, identity)
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if !strings.HasPrefix(callback.String(), redirectURL+"?") || callback.Query().Get("state") != "state-1" {
+			t.Fatalf("callback = %s", callback)
+		}
+		code := callback.Query().Get("code")
+
+		// code_verifier不匹配时提供方拒绝，授权码随之作废
+		if _, err := p.Exchange(ctx, code, "wrong-verifier"); !errors.Is(err, oidc.ErrExchangeFailed) {
+			t.Fatalf("Exchange with a wrong verifier: err = %v, want ErrExchangeFailed", err)
+		}
+		callback, _ = idp.Login(authURL, identity)
+		rawToken, err := p.Exchange(ctx, callback.Query().Get("code"), verifier)
+		if err != nil {
+			t.Fatalf("Exchange (secret %q): %v", secret, err)
+		}
+
+		claims, err := p.VerifyIDToken(ctx, rawToken, "nonce-1")
+		if err != nil {
+			t.Fatalf("VerifyIDToken: %v", err)
+		}
+		if claims.Subject != "u-1" || claims.Email != "ann@example.com" || !claims.EmailVerified || claims.Name != "Ann" {
+			t.Fatalf("claims = %+v", claims)
+		}
+		if _, err := p.VerifyIDToken(ctx, rawToken, "nonce-2"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Fatalf("VerifyIDToken with another nonce: err = %v, want ErrInvalidIDToken", err)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	idp, p := newProvider(t, "secret")
+	ctx := context.Background()
+	identity := oidctest.Identity{Subject: "u-1", Email: "ann@example.com", EmailVerified: true}
+
+	modify := func(fn func(jwt.MapClaims)) string {
+		claims := idp.Claims(identity, "n")
+		fn(claims)
+		return idp.SignIDToken(claims)
+	}
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.Claims(identity, "n")).SignedString([]byte("secret"))
+	cases := map[string]string{
+		"other issuer":   modify(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }),
+		"other audience": modify(func(c jwt.MapClaims) { c["aud"] = []string{"another-client"} }),
+		"other azp":      modify(func(c jwt.MapClaims) { c["aud"] = []string{"km", "x"}; c["azp"] = "x" }),
+		"expired":        modify(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"no subject":     modify(func(c jwt.MapClaims) { delete(c, "sub") }),
+		"HMAC signed":    hmacToken,
+		"garbage":        "not-a-token",
+	}
+	for name, token := range cases {
+		if _, err := p.VerifyIDToken(ctx, token, "n"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+
+	// 受众为数组时包含本客户端即可
+	token := modify(func(c jwt.MapClaims) {
+		c["aud"] = []string{"other", "km"}
+		c["azp"] = "km"
+		c["email_verified"] = "true"
+	})
+	claims, err := p.VerifyIDToken(ctx, token, "n")
+	if err != nil {
+		t.Fatalf("VerifyIDToken with an audience list: %v", err)
+	}
+	if !claims.EmailVerified {
+		t.Fatal("string email_verified not accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp, p := newProvider(t, "secret")
+	ctx := context.Background()
+	identity := oidctest.Identity{Subject: "u-1"}
+
+	if _, err := p.VerifyIDToken(ctx, idp.SignIDToken(idp.Claims(identity, "n")), "n"); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	// 新的kid不在缓存中，但刚读取过JWKS，不会马上重新读取
+	idp.RotateKey()
+	if _, err := p.VerifyIDToken(ctx, idp.SignIDToken(idp.Claims(identity, "n")), "n"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("VerifyIDToken right after rotation: err = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider(t, "km", "")
+	p, err := oidc.New(oidc.Config{Issuer: idp.Issuer() + "/", ClientID: "km", RedirectURL: redirectURL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "c"); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Fatalf("AuthCodeURL with a mismatched issuer: err = %v", err)
+	}
+}
@@ -0,0 +1,1280 @@
+package repository
+
+import (
+	"crypto/rand"
+	"fmt"
+	"knowledge_master_backend/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemory 数据保存在内存中的实现，用于测试
+// 节点树的语义（排序、移动、环检查、子树删除）与PostgreSQL实现一致，但不保存修订记录。
+func NewMemory() *Repositories {
+	m := &memory{
+		users:        make(map[string]*memUser),
+		profiles:     make(map[string]*models.UserProfile),
+		kbs:          make(map[string]*memKB),
+		nodes:        make(map[string]*memNode),
+		sessions:     make(map[string]*memSession),
+		tokens:       make(map[string]*memUserToken),
+		totp:         make(map[string]*models.TOTP),
+		recovery:     make(map[string]*memRecoveryCode),
+		challenges:   make(map[string]*memChallenge),
+		identities:   make(map[string]string),
+		oidcStates:   make(map[string]*models.OIDCLoginState),
+		accessTokens: make(map[string]*memAccessToken),
+	}
+	return &Repositories{
+		Users:        memUsers{m},
+		KBs:          memKnowledgeBases{m},
+		Members:      memMembers{m},
+		Nodes:        memNodes{m},
+		Sessions:     memSessions{m},
+		Tokens:       memUserTokens{m},
+		TwoFactor:    memTwoFactor{m},
+		Identities:   memIdentities{m},
+		AccessTokens: memAccessTokens{m},
+	}
+}
+
+// memory 各个接口共享的数据，所有操作持有同一把锁
+type memory struct {
+	mu           sync.Mutex
+	seq          int64
+	users        map[string]*memUser // key为user_id
+	profiles     map[string]*models.UserProfile
+	kbs          map[string]*memKB
+	nodes        map[string]*memNode
+	sessions     map[string]*memSession
+	tokens       map[string]*memUserToken    // key为令牌的哈希
+	totp         map[string]*models.TOTP     // key为user_id
+	recovery     map[string]*memRecoveryCode // key为恢复码的哈希
+	challenges   map[string]*memChallenge    // key为令牌的哈希
+	identities   map[string]string           // key为issuer和subject，值为user_id
+	oidcStates   map[string]*models.OIDCLoginState
+	accessTokens map[string]*memAccessToken // key为token_id
+}
+
+type memUser struct {
+	user       models.User
+	avatarKeys []string
+}
+
+type memUserToken struct {
+	token models.UserToken
+	used  bool
+}
+
+type memRecoveryCode struct {
+	userID string
+	used   bool
+}
+
+type memChallenge struct {
+	userID    string
+	attempts  int
+	expiresAt time.Time
+	used      bool
+}
+
+type memAccessToken struct {
+	token models.AccessToken
+	hash  string
+	seq   int64
+}
+
+type memKB struct {
+	kb      models.KnowledgeBase
+	members map[string]*memMember // key为user_id
+	deleted bool
+	seq     int64 // 修改顺序，updated_at相同时用于排序
+}
+
+type memMember struct {
+	member models.KBMember // 不含用户名和邮箱，读取时从用户中取
+	seq    int64           // 加入顺序
+}
+
+type memSession struct {
+	session      models.Session
+	refreshHash  string
+	previousHash string
+	revoked      bool
+}
+
+type memNode struct {
+	node    models.KnowledgeNode
+	deleted bool
+}
+
+// tick 返回递增的序号和当前时间
+func (m *memory) tick() (int64, time.Time) {
+	m.seq++
+	return m.seq, time.Now()
+}
+
+// newID 生成随机的UUID（v4）
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+type memUsers struct{ m *memory }
+
+func (r memUsers) Create(email, passwordHash, username string) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.user.Email == email {
+			return nil, models.ErrEmailTaken
+		}
+	}
+	u := &memUser{user: models.User{UserID: newID(), Email: email, Password: passwordHash, Username: username}}
+	r.m.users[u.user.UserID] = u
+	return &models.User{UserID: u.user.UserID, Email: email, Username: username}, nil
+}
+
+func (r memUsers) CreateProfile(p *models.UserProfile) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[p.UserID]; !ok {
+		return fmt.Errorf("failed to create empty profile: %w", models.ErrUserNotFound)
+	}
+	if _, ok := r.m.profiles[p.UserID]; ok {
+		return fmt.Errorf("profile of user %s already exists", p.UserID)
+	}
+	profile := *p
+	profile.Avatars = models.AvatarURLs{}
+	r.m.profiles[p.UserID] = &profile
+	return nil
+}
+
+func (r memUsers) GetByEmail(email string) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.user.Email == email {
+			result := u.user
+			result.AvatarURI, result.Avatars = "", nil
+			return &result, nil
+		}
+	}
+	return nil, models.ErrUserNotFound
+}
+
+func (r memUsers) GetByID(userID string) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	p, ok := r.m.profiles[userID]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+	return &models.User{
+		UserID:        p.UserID,
+		Email:         p.Email,
+		Username:      p.Username,
+		AvatarURI:     p.AvatarURI,
+		Avatars:       copyAvatars(p.Avatars),
+		EmailVerified: r.m.users[userID].user.EmailVerified,
+	}, nil
+}
+
+func (r memUsers) GetProfile(userID string) (*models.UserProfile, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	p, ok := r.m.profiles[userID]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+	profile := *p
+	profile.Avatars = copyAvatars(p.Avatars)
+	return &profile, nil
+}
+
+func (r memUsers) UpdateProfile(userID string, profile *models.UserProfile) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	p, ok := r.m.profiles[userID]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	if p.AvatarURI != profile.AvatarURI {
+		p.Avatars = models.AvatarURLs{}
+	}
+	p.Username = profile.Username
+	p.Description = profile.Description
+	p.Website = profile.Website
+	p.AvatarURI = profile.AvatarURI
+	return nil
+}
+
+func (r memUsers) SetAvatar(userID, uri string, urls models.AvatarURLs, keys []string) ([]string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	p, ok := r.m.profiles[userID]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+	u := r.m.users[userID]
+	oldKeys := u.avatarKeys
+	p.AvatarURI = uri
+	p.Avatars = copyAvatars(urls)
+	u.avatarKeys = append([]string(nil), keys...)
+	return oldKeys, nil
+}
+
+func (r memUsers) GetCredentials(userID string) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u, ok := r.m.users[userID]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+	result := u.user
+	result.AvatarURI, result.Avatars = "", nil
+	return &result, nil
+}
+
+func (r memUsers) SetPassword(userID, passwordHash string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u, ok := r.m.users[userID]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	u.user.Password = passwordHash
+	return nil
+}
+
+func (r memUsers) MarkEmailVerified(userID, email string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u, ok := r.m.users[userID]
+	if !ok || u.user.Email != email {
+		return models.ErrTokenInvalid
+	}
+	u.user.EmailVerified = true
+	return nil
+}
+
+func copyAvatars(urls models.AvatarURLs) models.AvatarURLs {
+	c := make(models.AvatarURLs, len(urls))
+	for k, v := range urls {
+		c[k] = v
+	}
+	return c
+}
+
+type memKnowledgeBases struct{ m *memory }
+
+func (r memKnowledgeBases) Create(name, description, ownerID string) (*models.KnowledgeBase, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	seq, now := r.m.tick()
+	kb := &memKB{
+		kb: models.KnowledgeBase{
+			KBID:              newID(),
+			Name:              name,
+			Description:       description,
+			OwnerID:           ownerID,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+			CollaborationMode: models.CollaborationPrivate,
+		},
+		seq: seq,
+	}
+	kb.members = map[string]*memMember{ownerID: {
+		member: models.KBMember{MemberID: newID(), KBID: kb.kb.KBID, UserID: ownerID, Role: models.RoleOwner, JoinedAt: now},
+		seq:    seq,
+	}}
+	r.m.kbs[kb.kb.KBID] = kb
+	result := kb.kb
+	return &result, nil
+}
+
+func (r memKnowledgeBases) ListForUser(userID string) ([]models.KnowledgeBase, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var found []*memKB
+	for _, kb := range r.m.kbs {
+		if !kb.deleted && (kb.kb.OwnerID == userID || kb.members[userID] != nil) {
+			found = append(found, kb)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq > found[j].seq })
+
+	var kbs []models.KnowledgeBase
+	for _, kb := range found {
+		kbs = append(kbs, kb.kb)
+	}
+	return kbs, nil
+}
+
+// live 返回未删除的知识库，调用者需持有锁
+func (r memKnowledgeBases) live(kbID string) (*memKB, error) {
+	kb, ok := r.m.kbs[kbID]
+	if !ok || kb.deleted {
+		return nil, models.ErrKnowledgeBaseNotFound
+	}
+	return kb, nil
+}
+
+func (r memKnowledgeBases) Get(kbID string) (*models.KnowledgeBase, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, err := r.live(kbID)
+	if err != nil {
+		return nil, err
+	}
+	result := kb.kb
+	return &result, nil
+}
+
+func (r memKnowledgeBases) Update(kbID, name, description string) (*models.KnowledgeBase, error) {
+	return r.modify(kbID, func(kb *models.KnowledgeBase) {
+		kb.Name = name
+		kb.Description = description
+	})
+}
+
+func (r memKnowledgeBases) SetVisibility(kbID, mode string) (*models.KnowledgeBase, error) {
+	return r.modify(kbID, func(kb *models.KnowledgeBase) {
+		kb.CollaborationMode = mode
+		kb.IsPublic = mode == models.CollaborationPublic
+	})
+}
+
+func (r memKnowledgeBases) modify(kbID string, fn func(kb *models.KnowledgeBase)) (*models.KnowledgeBase, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, err := r.live(kbID)
+	if err != nil {
+		return nil, err
+	}
+	fn(&kb.kb)
+	kb.seq, kb.kb.UpdatedAt = r.m.tick()
+	result := kb.kb
+	return &result, nil
+}
+
+func (r memKnowledgeBases) Delete(kbID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, err := r.live(kbID)
+	if err != nil {
+		return err
+	}
+	kb.deleted = true
+	return nil
+}
+
+func (r memKnowledgeBases) Role(kbID, userID string) (string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, err := r.live(kbID)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case kb.kb.OwnerID == userID:
+		return models.RoleOwner, nil
+	case kb.members[userID] != nil:
+		return kb.members[userID].member.Role, nil
+	case kb.kb.IsPublic:
+		return models.RoleViewer, nil
+	}
+	return "", nil
+}
+
+type memMembers struct{ m *memory }
+
+// result 补上用户名和邮箱，调用者需持有锁
+func (r memMembers) result(m *memMember) *models.KBMember {
+	member := m.member
+	if u, ok := r.m.users[member.UserID]; ok {
+		member.Username, member.Email = u.user.Username, u.user.Email
+	}
+	return &member
+}
+
+func (r memMembers) List(kbID string) ([]models.KBMember, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	members := make([]models.KBMember, 0)
+	kb, ok := r.m.kbs[kbID]
+	if !ok {
+		return members, nil
+	}
+	found := make([]*memMember, 0, len(kb.members))
+	for _, m := range kb.members {
+		found = append(found, m)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	for _, m := range found {
+		members = append(members, *r.result(m))
+	}
+	return members, nil
+}
+
+func (r memMembers) Add(kbID, userID, role string) (*models.KBMember, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, ok := r.m.kbs[kbID]
+	if !ok {
+		return nil, models.ErrKnowledgeBaseNotFound
+	}
+	if _, ok := r.m.users[userID]; !ok {
+		return nil, fmt.Errorf("failed to add member: %w", models.ErrUserNotFound)
+	}
+	if kb.members[userID] != nil {
+		return nil, models.ErrMemberExists
+	}
+	seq, now := r.m.tick()
+	m := &memMember{
+		member: models.KBMember{MemberID: newID(), KBID: kbID, UserID: userID, Role: role, JoinedAt: now},
+		seq:    seq,
+	}
+	kb.members[userID] = m
+	return r.result(m), nil
+}
+
+// member 返回未删除知识库中的成员，调用者需持有锁
+func (r memMembers) member(kbID, userID string) (*memKB, *memMember, error) {
+	kb, err := memKnowledgeBases(r).live(kbID)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := kb.members[userID]
+	if m == nil {
+		return nil, nil, models.ErrMemberNotFound
+	}
+	return kb, m, nil
+}
+
+// checkOwnerRemovable 确认移除或降级成员后知识库仍有所有者，调用者需持有锁
+func (r memMembers) checkOwnerRemovable(kb *memKB, m *memMember) error {
+	if m.member.Role != models.RoleOwner {
+		return nil
+	}
+	if m.member.UserID == kb.kb.OwnerID {
+		return models.ErrPrimaryOwner
+	}
+	owners := 0
+	for _, other := range kb.members {
+		if other.member.Role == models.RoleOwner {
+			owners++
+		}
+	}
+	if owners <= 1 {
+		return models.ErrLastOwner
+	}
+	return nil
+}
+
+func (r memMembers) UpdateRole(kbID, userID, role string) (*models.KBMember, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, m, err := r.member(kbID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != models.RoleOwner {
+		if err := r.checkOwnerRemovable(kb, m); err != nil {
+			return nil, err
+		}
+	}
+	m.member.Role = role
+	return r.result(m), nil
+}
+
+func (r memMembers) Remove(kbID, userID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, m, err := r.member(kbID, userID)
+	if err != nil {
+		return err
+	}
+	if err := r.checkOwnerRemovable(kb, m); err != nil {
+		return err
+	}
+	delete(kb.members, userID)
+	return nil
+}
+
+func (r memMembers) TransferOwnership(kbID, fromUserID, toUserID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kb, err := memKnowledgeBases(r).live(kbID)
+	if err != nil {
+		return err
+	}
+	primaryOwnerID := kb.kb.OwnerID
+	// 主所有者的账号被删除后owner_id为空，这时其他OWNER可以接手
+	if primaryOwnerID != "" && primaryOwnerID != fromUserID {
+		return models.ErrNotPrimaryOwner
+	}
+	if primaryOwnerID == toUserID {
+		return nil
+	}
+	to := kb.members[toUserID]
+	if to == nil {
+		return models.ErrTransferToMember
+	}
+
+	to.member.Role = models.RoleOwner
+	if previous := kb.members[primaryOwnerID]; previous != nil {
+		previous.member.Role = models.RoleEditor
+	}
+	kb.kb.OwnerID = toUserID
+	kb.seq, kb.kb.UpdatedAt = r.m.tick()
+	return nil
+}
+
+type memNodes struct{ m *memory }
+
+// live 返回知识库中未删除的节点，调用者需持有锁
+func (r memNodes) live(kbID, nodeID string) (*memNode, error) {
+	n, ok := r.m.nodes[nodeID]
+	if !ok || n.deleted || n.node.KBID != kbID {
+		return nil, models.ErrNodeNotFound
+	}
+	return n, nil
+}
+
+// children 父节点下未删除的子节点，按sort_order、created_at、node_id排序；parentID为空表示根节点
+func (r memNodes) children(kbID, parentID string) []*memNode {
+	var nodes []*memNode
+	for _, n := range r.m.nodes {
+		if n.node.KBID == kbID && n.node.ParentID == parentID && !n.deleted {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i].node, nodes[j].node
+		if a.SortOrder != b.SortOrder {
+			return a.SortOrder < b.SortOrder
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.NodeID < b.NodeID
+	})
+	return nodes
+}
+
+func (r memNodes) nextSortOrder(kbID, parentID string) int {
+	max := 0
+	for _, n := range r.children(kbID, parentID) {
+		if n.node.SortOrder > max {
+			max = n.node.SortOrder
+		}
+	}
+	return max + 1
+}
+
+func (r memNodes) Tree(kbID string) ([]*models.KnowledgeNode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var build func(parentID string) []*models.KnowledgeNode
+	build = func(parentID string) []*models.KnowledgeNode {
+		var nodes []*models.KnowledgeNode
+		for _, n := range r.children(kbID, parentID) {
+			node := n.node
+			node.KBID = ""
+			node.Children = build(node.NodeID)
+			nodes = append(nodes, &node)
+		}
+		return nodes
+	}
+	return build(""), nil
+}
+
+func (r memNodes) Get(kbID, nodeID string) (*models.KnowledgeNode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n, err := r.live(kbID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	node := n.node
+	node.KBID = ""
+	return &node, nil
+}
+
+func (r memNodes) Add(kbID string, node *models.KnowledgeNode) (*models.KnowledgeNode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if node.ParentID != "" {
+		if _, err := r.live(kbID, node.ParentID); err != nil {
+			return nil, fmt.Errorf("failed to add node: %w", err)
+		}
+	}
+	if node.SortOrder == 0 {
+		node.SortOrder = r.nextSortOrder(kbID, node.ParentID)
+	}
+
+	_, now := r.m.tick()
+	node.NodeID = newID()
+	node.Version = 1
+	node.CreatedAt = now
+	node.UpdatedAt = now
+
+	stored := *node
+	stored.KBID = kbID
+	stored.Children = nil
+	r.m.nodes[stored.NodeID] = &memNode{node: stored}
+	return node, nil
+}
+
+func (r memNodes) Update(kbID, nodeID, authorID, title, content string, expectedVersion int) (*models.KnowledgeNode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n, err := r.live(kbID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion > 0 && expectedVersion != n.node.Version {
+		return nil, models.ErrVersionConflict
+	}
+
+	_, now := r.m.tick()
+	n.node.Title = title
+	n.node.Content = content
+	n.node.Version++
+	n.node.UpdatedAt = now
+	node := n.node
+	return &node, nil
+}
+
+func (r memNodes) Delete(kbID, nodeID, deletedBy string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n, err := r.live(kbID, nodeID)
+	if err != nil {
+		return err
+	}
+
+	var trash func(n *memNode)
+	trash = func(n *memNode) {
+		for _, child := range r.children(kbID, n.node.NodeID) {
+			trash(child)
+		}
+		n.deleted = true
+	}
+	trash(n)
+	return nil
+}
+
+func (r memNodes) Move(kbID, dragID, hoverID, position string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	drag, err := r.live(kbID, dragID)
+	if err != nil {
+		return err
+	}
+	hover, err := r.live(kbID, hoverID)
+	if err != nil {
+		return err
+	}
+	if dragID == hoverID || r.isAncestor(kbID, dragID, hoverID) {
+		return models.ErrMoveIntoSelf
+	}
+
+	_, now := r.m.tick()
+	switch position {
+	case "before", "after":
+		parentID := hover.node.ParentID
+		var ordered []*memNode
+		for _, n := range r.children(kbID, parentID) {
+			if n == drag {
+				continue
+			}
+			if n == hover && position == "before" {
+				ordered = append(ordered, drag)
+			}
+			ordered = append(ordered, n)
+			if n == hover && position == "after" {
+				ordered = append(ordered, drag)
+			}
+		}
+		drag.node.ParentID = parentID
+		drag.node.UpdatedAt = now
+		for i, n := range ordered {
+			n.node.SortOrder = i + 1
+		}
+
+	case "inside":
+		if hover.node.Type != "folder" {
+			return models.ErrMoveIntoFile
+		}
+		drag.node.SortOrder = r.nextSortOrder(kbID, hoverID)
+		drag.node.ParentID = hoverID
+		drag.node.UpdatedAt = now
+
+	default:
+		return models.ErrInvalidPosition
+	}
+	return nil
+}
+
+// isAncestor 判断ancestorID是否是nodeID的祖先节点
+func (r memNodes) isAncestor(kbID, ancestorID, nodeID string) bool {
+	for id := nodeID; id != ""; {
+		n, ok := r.m.nodes[id]
+		if !ok || n.node.KBID != kbID {
+			return false
+		}
+		if n.node.ParentID == ancestorID {
+			return true
+		}
+		id = n.node.ParentID
+	}
+	return false
+}
+
+type memSessions struct{ m *memory }
+
+// live 返回未撤销且未过期的会话，调用者需持有锁
+func (r memSessions) live(sessionID string) (*memSession, bool) {
+	s, ok := r.m.sessions[sessionID]
+	if !ok || s.revoked || !s.session.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+	return s, true
+}
+
+func (r memSessions) Create(session *models.Session, refreshHash string) (*models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	_, now := r.m.tick()
+	s := &memSession{session: *session, refreshHash: refreshHash}
+	s.session.SessionID = newID()
+	s.session.CreatedAt = now
+	s.session.LastSeenAt = now
+	s.session.Current = false
+	r.m.sessions[s.session.SessionID] = s
+	result := s.session
+	return &result, nil
+}
+
+func (r memSessions) Rotate(oldHash, newHash string, expiresAt time.Time, ip string) (*models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for id, s := range r.m.sessions {
+		if s.refreshHash != oldHash {
+			continue
+		}
+		if _, ok := r.live(id); !ok {
+			break
+		}
+		_, now := r.m.tick()
+		s.previousHash = oldHash
+		s.refreshHash = newHash
+		s.session.ExpiresAt = expiresAt
+		s.session.IP = ip
+		s.session.LastSeenAt = now
+		result := s.session
+		return &result, nil
+	}
+
+	reused := false
+	for _, s := range r.m.sessions {
+		if s.previousHash == oldHash && !s.revoked {
+			s.revoked = true
+			reused = true
+		}
+	}
+	if reused {
+		return nil, models.ErrRefreshTokenReused
+	}
+	return nil, models.ErrSessionNotFound
+}
+
+func (r memSessions) Get(sessionID string) (*models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	s, ok := r.live(sessionID)
+	if !ok {
+		return nil, models.ErrSessionNotFound
+	}
+	result := s.session
+	return &result, nil
+}
+
+func (r memSessions) Touch(sessionID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if s, ok := r.m.sessions[sessionID]; ok {
+		_, s.session.LastSeenAt = r.m.tick()
+	}
+	return nil
+}
+
+func (r memSessions) ListForUser(userID string) ([]models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	sessions := []models.Session{}
+	for id, s := range r.m.sessions {
+		if _, ok := r.live(id); ok && s.session.UserID == userID {
+			sessions = append(sessions, s.session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if !a.LastSeenAt.Equal(b.LastSeenAt) {
+			return a.LastSeenAt.After(b.LastSeenAt)
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (r memSessions) Revoke(userID, sessionID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	s, ok := r.live(sessionID)
+	if !ok || s.session.UserID != userID {
+		return models.ErrSessionNotFound
+	}
+	s.revoked = true
+	return nil
+}
+
+func (r memSessions) RevokeAll(userID string) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n := 0
+	for id, s := range r.m.sessions {
+		if _, ok := r.live(id); ok && s.session.UserID == userID {
+			s.revoked = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memSessions) RevokeOthers(userID, keepSessionID string) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n := 0
+	for id, s := range r.m.sessions {
+		if _, ok := r.live(id); ok && s.session.UserID == userID && id != keepSessionID {
+			s.revoked = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memUserTokens struct{ m *memory }
+
+func (r memUserTokens) Create(tokenHash string, token *models.UserToken) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[token.UserID]; !ok {
+		return fmt.Errorf("failed to create token: %w", models.ErrUserNotFound)
+	}
+	if _, ok := r.m.tokens[tokenHash]; ok {
+		return fmt.Errorf("token already exists")
+	}
+	for _, t := range r.m.tokens {
+		if t.token.UserID == token.UserID && t.token.Purpose == token.Purpose {
+			t.used = true
+		}
+	}
+	t := &memUserToken{token: *token}
+	_, t.token.CreatedAt = r.m.tick()
+	r.m.tokens[tokenHash] = t
+	return nil
+}
+
+func (r memUserTokens) Consume(purpose, tokenHash string) (*models.UserToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	t, ok := r.m.tokens[tokenHash]
+	if !ok || t.used || t.token.Purpose != purpose || !t.token.ExpiresAt.After(time.Now()) {
+		return nil, models.ErrTokenInvalid
+	}
+	t.used = true
+	result := t.token
+	return &result, nil
+}
+
+func (r memUserTokens) LastIssuedAt(userID, purpose string) (time.Time, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var last time.Time
+	for _, t := range r.m.tokens {
+		if t.token.UserID == userID && t.token.Purpose == purpose && t.token.CreatedAt.After(last) {
+			last = t.token.CreatedAt
+		}
+	}
+	return last, nil
+}
+
+type memTwoFactor struct{ m *memory }
+
+func (r memTwoFactor) Get(userID string) (*models.TOTP, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	t, ok := r.m.totp[userID]
+	if !ok {
+		return nil, models.ErrTOTPNotEnrolled
+	}
+	result := *t
+	return &result, nil
+}
+
+func (r memTwoFactor) Enroll(userID, secret string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[userID]; !ok {
+		return fmt.Errorf("failed to enroll TOTP: %w", models.ErrUserNotFound)
+	}
+	if t, ok := r.m.totp[userID]; ok && t.Enabled {
+		return models.ErrTOTPAlreadyEnabled
+	}
+	r.m.totp[userID] = &models.TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (r memTwoFactor) Enable(userID string, step int64, recoveryHashes []string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	t, ok := r.m.totp[userID]
+	if !ok {
+		return models.ErrTOTPNotEnrolled
+	}
+	if t.Enabled {
+		return models.ErrTOTPAlreadyEnabled
+	}
+	_, now := r.m.tick()
+	t.Enabled, t.EnabledAt, t.LastUsedStep = true, &now, step
+	r.replaceRecoveryCodes(userID, recoveryHashes)
+	return nil
+}
+
+func (r memTwoFactor) UseStep(userID string, step int64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	t, ok := r.m.totp[userID]
+	if !ok || !t.Enabled || t.LastUsedStep >= step {
+		return models.ErrTOTPCodeUsed
+	}
+	t.LastUsedStep = step
+	return nil
+}
+
+func (r memTwoFactor) Disable(userID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.totp[userID]; !ok {
+		return models.ErrTOTPNotEnrolled
+	}
+	delete(r.m.totp, userID)
+	r.replaceRecoveryCodes(userID, nil)
+	return nil
+}
+
+// replaceRecoveryCodes 调用者需持有锁
+func (r memTwoFactor) replaceRecoveryCodes(userID string, hashes []string) {
+	for hash, c := range r.m.recovery {
+		if c.userID == userID {
+			delete(r.m.recovery, hash)
+		}
+	}
+	for _, hash := range hashes {
+		r.m.recovery[hash] = &memRecoveryCode{userID: userID}
+	}
+}
+
+func (r memTwoFactor) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if t, ok := r.m.totp[userID]; !ok || !t.Enabled {
+		return models.ErrTOTPNotEnrolled
+	}
+	r.replaceRecoveryCodes(userID, hashes)
+	return nil
+}
+
+func (r memTwoFactor) UseRecoveryCode(userID, codeHash string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	c, ok := r.m.recovery[codeHash]
+	if !ok || c.used || c.userID != userID {
+		return models.ErrRecoveryCodeInvalid
+	}
+	c.used = true
+	return nil
+}
+
+func (r memTwoFactor) RecoveryCodesLeft(userID string) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n := 0
+	for _, c := range r.m.recovery {
+		if c.userID == userID && !c.used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memTwoFactor) CreateChallenge(challengeHash, userID string, expiresAt time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[userID]; !ok {
+		return fmt.Errorf("failed to create login challenge: %w", models.ErrUserNotFound)
+	}
+	if _, ok := r.m.challenges[challengeHash]; ok {
+		return fmt.Errorf("login challenge already exists")
+	}
+	r.m.challenges[challengeHash] = &memChallenge{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r memTwoFactor) AttemptChallenge(challengeHash string, maxAttempts int) (string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	c, ok := r.m.challenges[challengeHash]
+	if !ok || c.used || !c.expiresAt.After(time.Now()) || c.attempts >= maxAttempts {
+		return "", models.ErrChallengeInvalid
+	}
+	c.attempts++
+	return c.userID, nil
+}
+
+func (r memTwoFactor) CompleteChallenge(challengeHash string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	c, ok := r.m.challenges[challengeHash]
+	if !ok || c.used {
+		return models.ErrChallengeInvalid
+	}
+	c.used = true
+	return nil
+}
+
+type memIdentities struct{ m *memory }
+
+func identityKey(issuer, subject string) string {
+	return issuer + "\n" + subject
+}
+
+func (r memIdentities) FindUser(issuer, subject string) (string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	userID, ok := r.m.identities[identityKey(issuer, subject)]
+	if !ok {
+		return "", models.ErrIdentityNotFound
+	}
+	return userID, nil
+}
+
+func (r memIdentities) Link(issuer, subject, userID, email string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[userID]; !ok {
+		return fmt.Errorf("failed to link identity: %w", models.ErrUserNotFound)
+	}
+	key := identityKey(issuer, subject)
+	if _, ok := r.m.identities[key]; ok {
+		return models.ErrIdentityTaken
+	}
+	r.m.identities[key] = userID
+	return nil
+}
+
+func (r memIdentities) SaveLoginState(stateHash string, state *models.OIDCLoginState) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.oidcStates[stateHash]; ok {
+		return fmt.Errorf("login state already exists")
+	}
+	s := *state
+	r.m.oidcStates[stateHash] = &s
+	return nil
+}
+
+func (r memIdentities) TakeLoginState(stateHash string) (*models.OIDCLoginState, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	s, ok := r.m.oidcStates[stateHash]
+	if !ok {
+		return nil, models.ErrLoginStateInvalid
+	}
+	delete(r.m.oidcStates, stateHash)
+	if !s.ExpiresAt.After(time.Now()) {
+		return nil, models.ErrLoginStateInvalid
+	}
+	return s, nil
+}
+
+type memAccessTokens struct{ m *memory }
+
+func copyAccessToken(t *models.AccessToken) *models.AccessToken {
+	c := *t
+	c.Scopes = append([]models.AccessTokenScope(nil), t.Scopes...)
+	c.KBIDs = append([]string{}, t.KBIDs...)
+	if t.ExpiresAt != nil {
+		expiresAt := *t.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if t.LastUsedAt != nil {
+		lastUsedAt := *t.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
+
+func (r memAccessTokens) Create(token *models.AccessToken, tokenHash string) (*models.AccessToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.users[token.UserID]; !ok {
+		return nil, fmt.Errorf("failed to create access token: %w", models.ErrUserNotFound)
+	}
+	for _, t := range r.m.accessTokens {
+		if t.hash == tokenHash {
+			return nil, fmt.Errorf("failed to create access token: duplicate token")
+		}
+	}
+	seq, now := r.m.tick()
+	t := &memAccessToken{token: *copyAccessToken(token), hash: tokenHash, seq: seq}
+	t.token.TokenID = newID()
+	t.token.CreatedAt = now
+	t.token.LastUsedAt = nil
+	r.m.accessTokens[t.token.TokenID] = t
+	return copyAccessToken(&t.token), nil
+}
+
+func (r memAccessTokens) ListForUser(userID string) ([]models.AccessToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var found []*memAccessToken
+	for _, t := range r.m.accessTokens {
+		if t.token.UserID == userID {
+			found = append(found, t)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq > found[j].seq })
+	tokens := []models.AccessToken{}
+	for _, t := range found {
+		tokens = append(tokens, *copyAccessToken(&t.token))
+	}
+	return tokens, nil
+}
+
+func (r memAccessTokens) GetByHash(tokenHash string) (*models.AccessToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, t := range r.m.accessTokens {
+		if t.hash == tokenHash {
+			if t.token.ExpiresAt != nil && !t.token.ExpiresAt.After(time.Now()) {
+				break
+			}
+			return copyAccessToken(&t.token), nil
+		}
+	}
+	return nil, models.ErrAccessTokenNotFound
+}
+
+func (r memAccessTokens) Revoke(userID, tokenID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	t, ok := r.m.accessTokens[tokenID]
+	if !ok || t.token.UserID != userID {
+		return models.ErrAccessTokenNotFound
+	}
+	delete(r.m.accessTokens, tokenID)
+	return nil
+}
+
+func (r memAccessTokens) RevokeAll(userID string) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	n := 0
+	for id, t := range r.m.accessTokens {
+		if t.token.UserID == userID {
+			delete(r.m.accessTokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memAccessTokens) Touch(tokenID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if t, ok := r.m.accessTokens[tokenID]; ok {
+		now := time.Now()
+		t.token.LastUsedAt = &now
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package repository_test
+
+import (
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/repository/repotest"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repository.Repositories {
+		return repository.NewMemory()
+	})
+}
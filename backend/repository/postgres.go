@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"database/sql"
+	"knowledge_master_backend/models"
+	"time"
+)
+
+// NewPostgres 使用PostgreSQL的实现，SQL都在models包中
+func NewPostgres(db *sql.DB) *Repositories {
+	return &Repositories{
+		Users:        pgUsers{db},
+		KBs:          pgKnowledgeBases{db},
+		Members:      pgMembers{db},
+		Nodes:        pgNodes{db},
+		Sessions:     pgSessions{db},
+		Tokens:       pgUserTokens{db},
+		TwoFactor:    pgTwoFactor{db},
+		Identities:   pgIdentities{db},
+		AccessTokens: pgAccessTokens{db},
+	}
+}
+
+type pgUsers struct{ db *sql.DB }
+
+func (r pgUsers) Create(email, passwordHash, username string) (*models.User, error) {
+	return models.CreateUser(r.db, email, passwordHash, username)
+}
+
+func (r pgUsers) CreateProfile(p *models.UserProfile) error {
+	return models.CreateUserProfile(r.db, p.UserID, p.Email, p.Username, p.Description, p.Website, p.AvatarURI)
+}
+
+func (r pgUsers) GetByEmail(email string) (*models.User, error) {
+	return models.GetUserByEmail(r.db, email)
+}
+
+func (r pgUsers) GetByID(userID string) (*models.User, error) {
+	return models.GetUserByID(r.db, userID)
+}
+
+func (r pgUsers) GetProfile(userID string) (*models.UserProfile, error) {
+	return models.GetUserProfile(r.db, userID)
+}
+
+func (r pgUsers) UpdateProfile(userID string, profile *models.UserProfile) error {
+	return models.UpdateUserProfile(r.db, userID, profile)
+}
+
+func (r pgUsers) SetAvatar(userID, uri string, urls models.AvatarURLs, keys []string) ([]string, error) {
+	return models.SetUserAvatar(r.db, userID, uri, urls, keys)
+}
+
+func (r pgUsers) GetCredentials(userID string) (*models.User, error) {
+	return models.GetUserCredentials(r.db, userID)
+}
+
+func (r pgUsers) SetPassword(userID, passwordHash string) error {
+	return models.SetUserPassword(r.db, userID, passwordHash)
+}
+
+func (r pgUsers) MarkEmailVerified(userID, email string) error {
+	return models.MarkEmailVerified(r.db, userID, email)
+}
+
+type pgKnowledgeBases struct{ db *sql.DB }
+
+func (r pgKnowledgeBases) Create(name, description, ownerID string) (*models.KnowledgeBase, error) {
+	return models.CreateKnowledgeBase(r.db, name, description, ownerID)
+}
+
+func (r pgKnowledgeBases) ListForUser(userID string) ([]models.KnowledgeBase, error) {
+	return models.GetUserKnowledgeBases(r.db, userID)
+}
+
+func (r pgKnowledgeBases) Get(kbID string) (*models.KnowledgeBase, error) {
+	return models.GetKnowledgeBaseById(r.db, kbID)
+}
+
+func (r pgKnowledgeBases) Update(kbID, name, description string) (*models.KnowledgeBase, error) {
+	return models.UpdateKnowledgeBase(r.db, kbID, name, description)
+}
+
+func (r pgKnowledgeBases) SetVisibility(kbID, mode string) (*models.KnowledgeBase, error) {
+	return models.UpdateKnowledgeBaseVisibility(r.db, kbID, mode)
+}
+
+func (r pgKnowledgeBases) Delete(kbID string) error {
+	return models.DeleteKnowledgeBase(r.db, kbID)
+}
+
+func (r pgKnowledgeBases) Role(kbID, userID string) (string, error) {
+	return models.GetKBRole(r.db, kbID, userID)
+}
+
+type pgMembers struct{ db *sql.DB }
+
+func (r pgMembers) List(kbID string) ([]models.KBMember, error) {
+	return models.GetKBMembers(r.db, kbID)
+}
+
+func (r pgMembers) Add(kbID, userID, role string) (*models.KBMember, error) {
+	return models.AddKBMember(r.db, kbID, userID, role)
+}
+
+func (r pgMembers) UpdateRole(kbID, userID, role string) (*models.KBMember, error) {
+	return models.UpdateKBMemberRole(r.db, kbID, userID, role)
+}
+
+func (r pgMembers) Remove(kbID, userID string) error {
+	return models.RemoveKBMember(r.db, kbID, userID)
+}
+
+func (r pgMembers) TransferOwnership(kbID, fromUserID, toUserID string) error {
+	return models.TransferKBOwnership(r.db, kbID, fromUserID, toUserID)
+}
+
+type pgNodes struct{ db *sql.DB }
+
+func (r pgNodes) Tree(kbID string) ([]*models.KnowledgeNode, error) {
+	return models.GetKnowledgeTree(r.db, kbID)
+}
+
+func (r pgNodes) Get(kbID, nodeID string) (*models.KnowledgeNode, error) {
+	return models.GetKnowledgeNode(r.db, kbID, nodeID)
+}
+
+func (r pgNodes) Add(kbID string, node *models.KnowledgeNode) (*models.KnowledgeNode, error) {
+	return models.AddKnowledgeNode(r.db, kbID, node)
+}
+
+func (r pgNodes) Update(kbID, nodeID, authorID, title, content string, expectedVersion int) (*models.KnowledgeNode, error) {
+	return models.UpdateKnowledgeNode(r.db, kbID, nodeID, authorID, title, content, expectedVersion)
+}
+
+func (r pgNodes) Delete(kbID, nodeID, deletedBy string) error {
+	return models.DeleteKnowledgeNode(r.db, kbID, nodeID, deletedBy)
+}
+
+func (r pgNodes) Move(kbID, dragID, hoverID, position string) error {
+	return models.MoveNode(r.db, kbID, dragID, hoverID, position)
+}
+
+type pgSessions struct{ db *sql.DB }
+
+func (r pgSessions) Create(session *models.Session, refreshHash string) (*models.Session, error) {
+	return models.CreateSession(r.db, session, refreshHash)
+}
+
+func (r pgSessions) Rotate(oldHash, newHash string, expiresAt time.Time, ip string) (*models.Session, error) {
+	return models.RotateSession(r.db, oldHash, newHash, expiresAt, ip)
+}
+
+func (r pgSessions) Get(sessionID string) (*models.Session, error) {
+	return models.GetActiveSession(r.db, sessionID)
+}
+
+func (r pgSessions) Touch(sessionID string) error {
+	return models.TouchSession(r.db, sessionID)
+}
+
+func (r pgSessions) ListForUser(userID string) ([]models.Session, error) {
+	return models.GetUserSessions(r.db, userID)
+}
+
+func (r pgSessions) Revoke(userID, sessionID string) error {
+	return models.RevokeSession(r.db, userID, sessionID)
+}
+
+func (r pgSessions) RevokeAll(userID string) (int, error) {
+	return models.RevokeUserSessions(r.db, userID)
+}
+
+func (r pgSessions) RevokeOthers(userID, keepSessionID string) (int, error) {
+	return models.RevokeOtherSessions(r.db, userID, keepSessionID)
+}
+
+type pgUserTokens struct{ db *sql.DB }
+
+func (r pgUserTokens) Create(tokenHash string, token *models.UserToken) error {
+	return models.CreateUserToken(r.db, tokenHash, token)
+}
+
+func (r pgUserTokens) Consume(purpose, tokenHash string) (*models.UserToken, error) {
+	return models.ConsumeUserToken(r.db, purpose, tokenHash)
+}
+
+func (r pgUserTokens) LastIssuedAt(userID, purpose string) (time.Time, error) {
+	return models.LastUserTokenAt(r.db, userID, purpose)
+}
+
+type pgTwoFactor struct{ db *sql.DB }
+
+func (r pgTwoFactor) Get(userID string) (*models.TOTP, error) {
+	return models.GetTOTP(r.db, userID)
+}
+
+func (r pgTwoFactor) Enroll(userID, secret string) error {
+	return models.EnrollTOTP(r.db, userID, secret)
+}
+
+func (r pgTwoFactor) Enable(userID string, step int64, recoveryHashes []string) error {
+	return models.EnableTOTP(r.db, userID, step, recoveryHashes)
+}
+
+func (r pgTwoFactor) UseStep(userID string, step int64) error {
+	return models.UseTOTPStep(r.db, userID, step)
+}
+
+func (r pgTwoFactor) Disable(userID string) error {
+	return models.DisableTOTP(r.db, userID)
+}
+
+func (r pgTwoFactor) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	return models.ReplaceRecoveryCodes(r.db, userID, hashes)
+}
+
+func (r pgTwoFactor) UseRecoveryCode(userID, codeHash string) error {
+	return models.UseRecoveryCode(r.db, userID, codeHash)
+}
+
+func (r pgTwoFactor) RecoveryCodesLeft(userID string) (int, error) {
+	return models.CountRecoveryCodes(r.db, userID)
+}
+
+func (r pgTwoFactor) CreateChallenge(challengeHash, userID string, expiresAt time.Time) error {
+	return models.CreateLoginChallenge(r.db, challengeHash, userID, expiresAt)
+}
+
+func (r pgTwoFactor) AttemptChallenge(challengeHash string, maxAttempts int) (string, error) {
+	return models.AttemptLoginChallenge(r.db, challengeHash, maxAttempts)
+}
+
+func (r pgTwoFactor) CompleteChallenge(challengeHash string) error {
+	return models.CompleteLoginChallenge(r.db, challengeHash)
+}
+
+type pgIdentities struct{ db *sql.DB }
+
+func (r pgIdentities) FindUser(issuer, subject string) (string, error) {
+	return models.FindIdentityUser(r.db, issuer, subject)
+}
+
+func (r pgIdentities) Link(issuer, subject, userID, email string) error {
+	return models.LinkIdentity(r.db, issuer, subject, userID, email)
+}
+
+func (r pgIdentities) SaveLoginState(stateHash string, state *models.OIDCLoginState) error {
+	return models.SaveOIDCLoginState(r.db, stateHash, state)
+}
+
+func (r pgIdentities) TakeLoginState(stateHash string) (*models.OIDCLoginState, error) {
+	return models.TakeOIDCLoginState(r.db, stateHash)
+}
+
+type pgAccessTokens struct{ db *sql.DB }
+
+func (r pgAccessTokens) Create(token *models.AccessToken, tokenHash string) (*models.AccessToken, error) {
+	return models.CreateAccessToken(r.db, token, tokenHash)
+}
+
+func (r pgAccessTokens) ListForUser(userID string) ([]models.AccessToken, error) {
+	return models.ListAccessTokens(r.db, userID)
+}
+
+func (r pgAccessTokens) GetByHash(tokenHash string) (*models.AccessToken, error) {
+	return models.GetAccessTokenByHash(r.db, tokenHash)
+}
+
+func (r pgAccessTokens) Revoke(userID, tokenID string) error {
+	return models.DeleteAccessToken(r.db, userID, tokenID)
+}
+
+func (r pgAccessTokens) RevokeAll(userID string) (int, error) {
+	return models.DeleteUserAccessTokens(r.db, userID)
+}
+
+func (r pgAccessTokens) Touch(tokenID string) error {
+	return models.TouchAccessToken(r.db, tokenID)
+}
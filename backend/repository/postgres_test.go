@@ -0,0 +1,32 @@
+package repository_test
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"knowledge_master_backend/migrations"
+	"knowledge_master_backend/repository"
+	"knowledge_master_backend/repository/repotest"
+	"os"
+	"testing"
+)
+
+// 设置KM_TEST_DATABASE_DSN后对该数据库运行测试，测试会执行迁移并留下测试数据，不要指向生产库
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("KM_TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("KM_TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	repos := repository.NewPostgres(db)
+	repotest.Run(t, func(t *testing.T) *repository.Repositories {
+		return repos
+	})
+}
@@ -0,0 +1,176 @@
+// Package repository 用户、知识库、成员、节点以及登录和账号安全相关数据的存取接口
+// 处理函数只依赖这些接口：生产环境使用PostgreSQL实现，测试中可以使用内存实现。
+// 两个实现必须通过repotest中相同的一组测试，错误使用models中定义的哨兵错误。
+//
+// 邀请、回收站、修订记录、快照、备份、搜索、附件和导入导出不在这里，
+// 对应的处理函数仍然直接调用models中的函数并使用config.DB，只能在PostgreSQL上测试。
+package repository
+
+import (
+	"knowledge_master_backend/models"
+	"time"
+)
+
+// UserRepository 用户和用户资料
+type UserRepository interface {
+	// Create 创建用户，邮箱已注册时返回models.ErrEmailTaken
+	Create(email, passwordHash, username string) (*models.User, error)
+	CreateProfile(profile *models.UserProfile) error
+	// GetByEmail 按登录邮箱查询，返回的用户包含密码哈希
+	GetByEmail(email string) (*models.User, error)
+	// GetByID 查询用户的公开信息（来自用户资料）
+	GetByID(userID string) (*models.User, error)
+	GetProfile(userID string) (*models.UserProfile, error)
+	// UpdateProfile 修改用户名、简介、网站和头像地址，修改头像地址会清空各尺寸的头像
+	UpdateProfile(userID string, profile *models.UserProfile) error
+	// SetAvatar 保存上传的头像，返回被替换的头像在存储中的文件
+	SetAvatar(userID, uri string, urls models.AvatarURLs, keys []string) ([]string, error)
+	// GetCredentials 按用户ID查询登录信息，包含密码哈希和邮箱验证状态
+	GetCredentials(userID string) (*models.User, error)
+	SetPassword(userID, passwordHash string) error
+	// MarkEmailVerified 把邮箱标记为已验证，email与用户当前的邮箱不同时返回models.ErrTokenInvalid
+	MarkEmailVerified(userID, email string) error
+}
+
+// KnowledgeBaseRepository 知识库，已移入回收站的知识库视为不存在
+type KnowledgeBaseRepository interface {
+	// Create 创建知识库，创建者成为OWNER
+	Create(name, description, ownerID string) (*models.KnowledgeBase, error)
+	// ListForUser 用户拥有或参与的知识库，最近修改的在前
+	ListForUser(userID string) ([]models.KnowledgeBase, error)
+	Get(kbID string) (*models.KnowledgeBase, error)
+	Update(kbID, name, description string) (*models.KnowledgeBase, error)
+	// SetVisibility 修改协作模式，只有PUBLIC的知识库is_public为true
+	SetVisibility(kbID, mode string) (*models.KnowledgeBase, error)
+	// Delete 把知识库移入回收站
+	Delete(kbID string) error
+	// Role 用户在知识库中的角色：主所有者为OWNER，其次是成员的角色，公开知识库的其他用户为VIEWER
+	// 没有任何角色时返回空字符串，知识库不存在时返回models.ErrKnowledgeBaseNotFound。
+	Role(kbID, userID string) (string, error)
+}
+
+// MemberRepository 知识库成员
+// 主所有者（知识库的owner_id）不能被移除或降级，只能由本人通过TransferOwnership转给其他成员；
+// 知识库至少保留一个OWNER。
+type MemberRepository interface {
+	// List 知识库的成员，先加入的在前
+	List(kbID string) ([]models.KBMember, error)
+	// Add 添加成员，已是成员时返回models.ErrMemberExists
+	Add(kbID, userID, role string) (*models.KBMember, error)
+	// UpdateRole 修改角色，降级主所有者时返回models.ErrPrimaryOwner，降级最后一个OWNER时返回models.ErrLastOwner
+	UpdateRole(kbID, userID, role string) (*models.KBMember, error)
+	// Remove 移除成员，限制与UpdateRole相同
+	Remove(kbID, userID string) error
+	// TransferOwnership 主所有者fromUserID把主所有权转给成员toUserID，原所有者降为EDITOR
+	// fromUserID不是主所有者时返回models.ErrNotPrimaryOwner，toUserID不是成员时返回models.ErrTransferToMember。
+	TransferOwnership(kbID, fromUserID, toUserID string) error
+}
+
+// NodeRepository 知识库中的节点树
+type NodeRepository interface {
+	// Tree 知识库中未删除的节点组成的树，同级节点按sort_order排序
+	Tree(kbID string) ([]*models.KnowledgeNode, error)
+	Get(kbID, nodeID string) (*models.KnowledgeNode, error)
+	// Add 添加节点，node.SortOrder为0时排在同级节点的最后
+	Add(kbID string, node *models.KnowledgeNode) (*models.KnowledgeNode, error)
+	// Update 修改标题和内容，expectedVersion大于0且与当前版本不同时返回models.ErrVersionConflict
+	Update(kbID, nodeID, authorID, title, content string, expectedVersion int) (*models.KnowledgeNode, error)
+	// Delete 把节点及其子树移入回收站
+	Delete(kbID, nodeID, deletedBy string) error
+	// Move 把节点移动到目标节点之前、之后（before/after）或目标文件夹内（inside）
+	Move(kbID, dragID, hoverID, position string) error
+}
+
+// SessionRepository 登录会话，已撤销或已过期的会话视为不存在
+type SessionRepository interface {
+	// Create 创建会话，refreshHash为刷新令牌的哈希
+	Create(session *models.Session, refreshHash string) (*models.Session, error)
+	// Rotate 用新的刷新令牌替换旧的并把有效期延长到expiresAt
+	// 旧令牌已被轮换过时撤销会话并返回models.ErrRefreshTokenReused。
+	Rotate(oldHash, newHash string, expiresAt time.Time, ip string) (*models.Session, error)
+	Get(sessionID string) (*models.Session, error)
+	// Touch 更新最后活动时间
+	Touch(sessionID string) error
+	// ListForUser 用户的有效会话，最近活动的在前
+	ListForUser(userID string) ([]models.Session, error)
+	Revoke(userID, sessionID string) error
+	// RevokeAll 撤销用户的全部会话，返回撤销的数量
+	RevokeAll(userID string) (int, error)
+	// RevokeOthers 撤销除keepSessionID以外的全部会话，返回撤销的数量
+	RevokeOthers(userID, keepSessionID string) (int, error)
+}
+
+// UserTokenRepository 发送到用户邮箱的一次性令牌（邮箱验证、重置密码），只保存令牌的哈希
+type UserTokenRepository interface {
+	// Create 保存令牌，同一用户同一用途之前未使用的令牌全部作废
+	Create(tokenHash string, token *models.UserToken) error
+	// Consume 使用令牌，令牌不存在、已使用、已作废或已过期时返回models.ErrTokenInvalid
+	Consume(purpose, tokenHash string) (*models.UserToken, error)
+	// LastIssuedAt 最近一次生成该用途令牌的时间，没有时返回零值
+	LastIssuedAt(userID, purpose string) (time.Time, error)
+}
+
+// TwoFactorRepository 两步验证：TOTP密钥、恢复码和等待两步验证的登录
+type TwoFactorRepository interface {
+	// Get 用户的密钥，没有时返回models.ErrTOTPNotEnrolled
+	Get(userID string) (*models.TOTP, error)
+	// Enroll 保存新生成的密钥，替换未确认的密钥；已启用时返回models.ErrTOTPAlreadyEnabled
+	Enroll(userID, secret string) error
+	// Enable 确认密钥并启用两步验证，step为第一个验证码的时间步，recoveryHashes为恢复码的哈希
+	Enable(userID string, step int64, recoveryHashes []string) error
+	// UseStep 记录使用的时间步，不大于上次使用的时间步时返回models.ErrTOTPCodeUsed
+	UseStep(userID string, step int64) error
+	// Disable 删除密钥和恢复码
+	Disable(userID string) error
+	// ReplaceRecoveryCodes 重新生成恢复码，之前的全部失效
+	ReplaceRecoveryCodes(userID string, hashes []string) error
+	// UseRecoveryCode 使用恢复码，不存在或已使用时返回models.ErrRecoveryCodeInvalid
+	UseRecoveryCode(userID, codeHash string) error
+	// RecoveryCodesLeft 未使用的恢复码数量
+	RecoveryCodesLeft(userID string) (int, error)
+
+	CreateChallenge(challengeHash, userID string, expiresAt time.Time) error
+	// AttemptChallenge 记录一次验证尝试并返回登录的用户，超过maxAttempts次后返回models.ErrChallengeInvalid
+	AttemptChallenge(challengeHash string, maxAttempts int) (string, error)
+	// CompleteChallenge 验证通过后使令牌失效
+	CompleteChallenge(challengeHash string) error
+}
+
+// IdentityRepository 单点登录：外部身份与用户的关联，以及进行中的登录
+type IdentityRepository interface {
+	// FindUser 外部身份关联的用户，未关联时返回models.ErrIdentityNotFound
+	FindUser(issuer, subject string) (string, error)
+	// Link 关联外部身份，已关联时返回models.ErrIdentityTaken
+	Link(issuer, subject, userID, email string) error
+	SaveLoginState(stateHash string, state *models.OIDCLoginState) error
+	// TakeLoginState 取出并删除登录状态，不存在或已过期时返回models.ErrLoginStateInvalid
+	TakeLoginState(stateHash string) (*models.OIDCLoginState, error)
+}
+
+// AccessTokenRepository 个人访问令牌，只保存令牌的哈希
+type AccessTokenRepository interface {
+	Create(token *models.AccessToken, tokenHash string) (*models.AccessToken, error)
+	// ListForUser 用户的全部令牌（包括已过期的），最新创建的在前
+	ListForUser(userID string) ([]models.AccessToken, error)
+	// GetByHash 查找未过期的令牌，不存在或已过期时返回models.ErrAccessTokenNotFound
+	GetByHash(tokenHash string) (*models.AccessToken, error)
+	// Revoke 删除用户的令牌，不属于该用户时返回models.ErrAccessTokenNotFound
+	Revoke(userID, tokenID string) error
+	// RevokeAll 删除用户的全部令牌，返回删除的数量
+	RevokeAll(userID string) (int, error)
+	// Touch 更新最后使用时间
+	Touch(tokenID string) error
+}
+
+// Repositories 处理函数使用的全部存取接口
+type Repositories struct {
+	Users        UserRepository
+	KBs          KnowledgeBaseRepository
+	Members      MemberRepository
+	Nodes        NodeRepository
+	Sessions     SessionRepository
+	Tokens       UserTokenRepository
+	TwoFactor    TwoFactorRepository
+	Identities   IdentityRepository
+	AccessTokens AccessTokenRepository
+}
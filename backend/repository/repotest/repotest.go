@@ -0,0 +1,1252 @@
+// Package repotest 所有repository实现都必须通过的一组测试
+package repotest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/repository"
+	"testing"
+	"time"
+)
+
+// Run 对newRepos创建的实现运行全部测试
+// 各个测试使用自己创建的用户和知识库，newRepos可以每次返回同一个数据库上的实现。
+func Run(t *testing.T, newRepos func(t *testing.T) *repository.Repositories) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r *repository.Repositories)
+	}{
+		{"Users", testUsers},
+		{"UserProfile", testUserProfile},
+		{"KnowledgeBases", testKnowledgeBases},
+		{"Roles", testRoles},
+		{"Members", testMembers},
+		{"TransferOwnership", testTransferOwnership},
+		{"AddAndTree", testAddAndTree},
+		{"UpdateVersion", testUpdateVersion},
+		{"DeleteSubtree", testDeleteSubtree},
+		{"MoveAdjacent", testMoveAdjacent},
+		{"MoveAcrossParents", testMoveAcrossParents},
+		{"MoveInside", testMoveInside},
+		{"MoveInvalid", testMoveInvalid},
+		{"MoveWithTiedSortOrder", testMoveWithTiedSortOrder},
+		{"Sessions", testSessions},
+		{"SessionRotation", testSessionRotation},
+		{"RevokeOtherSessions", testRevokeOtherSessions},
+		{"Credentials", testCredentials},
+		{"UserTokens", testUserTokens},
+		{"TwoFactor", testTwoFactor},
+		{"LoginChallenges", testLoginChallenges},
+		{"Identities", testIdentities},
+		{"OIDCLoginStates", testOIDCLoginStates},
+		{"AccessTokens", testAccessTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepos(t))
+		})
+	}
+}
+
+// randomID 生成格式合法但不存在的UUID
+func randomID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func uniqueEmail() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return "repotest-" + hex.EncodeToString(b[:]) + "@example.com"
+}
+
+// newUser 创建用户及其资料
+func newUser(t *testing.T, r *repository.Repositories) *models.User {
+	t.Helper()
+	email := uniqueEmail()
+	user, err := r.Users.Create(email, "hash", "tester")
+	if err != nil {
+		t.Fatalf("Create user: %v", err)
+	}
+	err = r.Users.CreateProfile(&models.UserProfile{
+		UserID:    user.UserID,
+		Email:     user.Email,
+		Username:  user.Username,
+		AvatarURI: "https://example.com/avatar.png",
+	})
+	if err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+	return user
+}
+
+func newKB(t *testing.T, r *repository.Repositories) (*models.User, *models.KnowledgeBase) {
+	t.Helper()
+	user := newUser(t, r)
+	kb, err := r.KBs.Create("kb", "", user.UserID)
+	if err != nil {
+		t.Fatalf("Create knowledge base: %v", err)
+	}
+	return user, kb
+}
+
+func addNode(t *testing.T, r *repository.Repositories, kbID, parentID, typ, title string) *models.KnowledgeNode {
+	t.Helper()
+	node, err := r.Nodes.Add(kbID, &models.KnowledgeNode{ParentID: parentID, Type: typ, Title: title})
+	if err != nil {
+		t.Fatalf("Add %s: %v", title, err)
+	}
+	return node
+}
+
+// shape 把树转换为便于比较的字符串，如 "a(b,c),d"
+func shape(nodes []*models.KnowledgeNode) string {
+	s := ""
+	for i, n := range nodes {
+		if i > 0 {
+			s += ","
+		}
+		s += n.Title
+		if len(n.Children) > 0 {
+			s += "(" + shape(n.Children) + ")"
+		}
+	}
+	return s
+}
+
+func expectTree(t *testing.T, r *repository.Repositories, kbID, want string) []*models.KnowledgeNode {
+	t.Helper()
+	tree, err := r.Nodes.Tree(kbID)
+	if err != nil {
+		t.Fatalf("Tree: %v", err)
+	}
+	if got := shape(tree); got != want {
+		t.Fatalf("tree = %q, want %q", got, want)
+	}
+	return tree
+}
+
+// expectDistinctOrder 同级节点的sort_order必须严格递增
+func expectDistinctOrder(t *testing.T, nodes []*models.KnowledgeNode) {
+	t.Helper()
+	for i := 1; i < len(nodes); i++ {
+		if nodes[i].SortOrder <= nodes[i-1].SortOrder {
+			t.Fatalf("sort_order of %s (%d) is not greater than %s (%d)",
+				nodes[i].Title, nodes[i].SortOrder, nodes[i-1].Title, nodes[i-1].SortOrder)
+		}
+	}
+	for _, n := range nodes {
+		expectDistinctOrder(t, n.Children)
+	}
+}
+
+func testUsers(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+
+	if _, err := r.Users.Create(user.Email, "hash", "other"); !errors.Is(err, models.ErrEmailTaken) {
+		t.Fatalf("Create with taken email: err = %v, want ErrEmailTaken", err)
+	}
+
+	byEmail, err := r.Users.GetByEmail(user.Email)
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	if byEmail.UserID != user.UserID || byEmail.Password != "hash" {
+		t.Fatalf("GetByEmail = %+v", byEmail)
+	}
+
+	byID, err := r.Users.GetByID(user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if byID.Email != user.Email || byID.Username != "tester" || byID.Password != "" {
+		t.Fatalf("GetByID = %+v", byID)
+	}
+
+	if _, err := r.Users.GetByEmail(uniqueEmail()); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("GetByEmail unknown: err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := r.Users.GetByID(randomID()); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("GetByID unknown: err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testUserProfile(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+
+	profile, err := r.Users.GetProfile(user.UserID)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if profile.Email != user.Email || profile.Username != "tester" {
+		t.Fatalf("GetProfile = %+v", profile)
+	}
+
+	old, err := r.Users.SetAvatar(user.UserID, "a.png", models.AvatarURLs{"64": "a-64.png"}, []string{"a-64"})
+	if err != nil {
+		t.Fatalf("SetAvatar: %v", err)
+	}
+	if len(old) != 0 {
+		t.Fatalf("first SetAvatar returned old keys %v", old)
+	}
+	old, err = r.Users.SetAvatar(user.UserID, "b.png", models.AvatarURLs{"64": "b-64.png"}, []string{"b-64"})
+	if err != nil {
+		t.Fatalf("SetAvatar: %v", err)
+	}
+	if len(old) != 1 || old[0] != "a-64" {
+		t.Fatalf("SetAvatar returned old keys %v, want [a-64]", old)
+	}
+
+	got, err := r.Users.GetByID(user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.AvatarURI != "b.png" || got.Avatars["64"] != "b-64.png" {
+		t.Fatalf("avatar = %q %v", got.AvatarURI, got.Avatars)
+	}
+
+	// 保持头像地址不变时各尺寸的头像保留，修改后清空
+	profile.Username = "renamed"
+	profile.AvatarURI = "b.png"
+	if err := r.Users.UpdateProfile(user.UserID, profile); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if got, _ := r.Users.GetProfile(user.UserID); got.Username != "renamed" || len(got.Avatars) != 1 {
+		t.Fatalf("after rename: %+v", got)
+	}
+	profile.AvatarURI = "https://example.com/other.png"
+	if err := r.Users.UpdateProfile(user.UserID, profile); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if got, _ := r.Users.GetProfile(user.UserID); len(got.Avatars) != 0 {
+		t.Fatalf("avatars kept after changing avatar_uri: %v", got.Avatars)
+	}
+
+	if err := r.Users.UpdateProfile(randomID(), profile); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("UpdateProfile unknown: err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := r.Users.SetAvatar(randomID(), "c.png", nil, nil); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("SetAvatar unknown: err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testKnowledgeBases(t *testing.T, r *repository.Repositories) {
+	owner := newUser(t, r)
+	other := newUser(t, r)
+
+	first, err := r.KBs.Create("first", "one", owner.UserID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if first.OwnerID != owner.UserID || first.IsPublic || first.CollaborationMode != models.CollaborationPrivate {
+		t.Fatalf("Create = %+v", first)
+	}
+	second, err := r.KBs.Create("second", "", owner.UserID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	list, err := r.KBs.ListForUser(owner.UserID)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(list) != 2 || list[0].KBID != second.KBID || list[1].KBID != first.KBID {
+		t.Fatalf("ListForUser = %+v, want second then first", list)
+	}
+	if list, _ := r.KBs.ListForUser(other.UserID); len(list) != 0 {
+		t.Fatalf("ListForUser of another user = %+v", list)
+	}
+
+	updated, err := r.KBs.Update(first.KBID, "renamed", "desc")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Name != "renamed" || updated.Description != "desc" {
+		t.Fatalf("Update = %+v", updated)
+	}
+	if list, _ := r.KBs.ListForUser(owner.UserID); len(list) != 2 || list[0].KBID != first.KBID {
+		t.Fatalf("updated knowledge base is not listed first: %+v", list)
+	}
+
+	public, err := r.KBs.SetVisibility(first.KBID, models.CollaborationPublic)
+	if err != nil {
+		t.Fatalf("SetVisibility: %v", err)
+	}
+	if !public.IsPublic || public.CollaborationMode != models.CollaborationPublic {
+		t.Fatalf("SetVisibility PUBLIC = %+v", public)
+	}
+	team, err := r.KBs.SetVisibility(first.KBID, models.CollaborationTeam)
+	if err != nil {
+		t.Fatalf("SetVisibility: %v", err)
+	}
+	if team.IsPublic {
+		t.Fatalf("SetVisibility TEAM kept is_public")
+	}
+
+	if err := r.KBs.Delete(first.KBID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := r.KBs.Get(first.KBID); !errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+		t.Fatalf("Get deleted: err = %v, want ErrKnowledgeBaseNotFound", err)
+	}
+	if err := r.KBs.Delete(first.KBID); !errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+		t.Fatalf("Delete twice: err = %v, want ErrKnowledgeBaseNotFound", err)
+	}
+	if _, err := r.KBs.Update(first.KBID, "x", ""); !errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+		t.Fatalf("Update deleted: err = %v, want ErrKnowledgeBaseNotFound", err)
+	}
+	if list, _ := r.KBs.ListForUser(owner.UserID); len(list) != 1 || list[0].KBID != second.KBID {
+		t.Fatalf("ListForUser after delete = %+v", list)
+	}
+}
+
+func testRoles(t *testing.T, r *repository.Repositories) {
+	owner, kb := newKB(t, r)
+	editor := newUser(t, r)
+	stranger := newUser(t, r)
+	if _, err := r.Members.Add(kb.KBID, editor.UserID, models.RoleEditor); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	role := func(userID string) string {
+		t.Helper()
+		role, err := r.KBs.Role(kb.KBID, userID)
+		if err != nil {
+			t.Fatalf("Role: %v", err)
+		}
+		return role
+	}
+	if got := role(owner.UserID); got != models.RoleOwner {
+		t.Fatalf("owner role = %q", got)
+	}
+	if got := role(editor.UserID); got != models.RoleEditor {
+		t.Fatalf("editor role = %q", got)
+	}
+	if got := role(stranger.UserID); got != "" {
+		t.Fatalf("stranger role in a private knowledge base = %q, want none", got)
+	}
+
+	if _, err := r.KBs.SetVisibility(kb.KBID, models.CollaborationPublic); err != nil {
+		t.Fatalf("SetVisibility: %v", err)
+	}
+	if got := role(stranger.UserID); got != models.RoleViewer {
+		t.Fatalf("stranger role in a public knowledge base = %q, want VIEWER", got)
+	}
+	if got := role(editor.UserID); got != models.RoleEditor {
+		t.Fatalf("editor role in a public knowledge base = %q", got)
+	}
+
+	if _, err := r.KBs.Role(randomID(), owner.UserID); !errors.Is(err, models.ErrKnowledgeBaseNotFound) {
+		t.Fatalf("Role of unknown knowledge base: err = %v, want ErrKnowledgeBaseNotFound", err)
+	}
+}
+
+func testMembers(t *testing.T, r *repository.Repositories) {
+	owner, kb := newKB(t, r)
+	member := newUser(t, r)
+
+	added, err := r.Members.Add(kb.KBID, member.UserID, models.RoleViewer)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if added.UserID != member.UserID || added.Role != models.RoleViewer || added.Email != member.Email {
+		t.Fatalf("Add = %+v", added)
+	}
+	if _, err := r.Members.Add(kb.KBID, member.UserID, models.RoleEditor); !errors.Is(err, models.ErrMemberExists) {
+		t.Fatalf("Add twice: err = %v, want ErrMemberExists", err)
+	}
+
+	list, err := r.Members.List(kb.KBID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].UserID != owner.UserID || list[0].Role != models.RoleOwner || list[1].UserID != member.UserID {
+		t.Fatalf("List = %+v, want the owner then the new member", list)
+	}
+
+	updated, err := r.Members.UpdateRole(kb.KBID, member.UserID, models.RoleOwner)
+	if err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	if updated.Role != models.RoleOwner {
+		t.Fatalf("UpdateRole = %+v", updated)
+	}
+	if _, err := r.Members.UpdateRole(kb.KBID, owner.UserID, models.RoleEditor); !errors.Is(err, models.ErrPrimaryOwner) {
+		t.Fatalf("demote primary owner: err = %v, want ErrPrimaryOwner", err)
+	}
+	if err := r.Members.Remove(kb.KBID, owner.UserID); !errors.Is(err, models.ErrPrimaryOwner) {
+		t.Fatalf("remove primary owner: err = %v, want ErrPrimaryOwner", err)
+	}
+	if _, err := r.Members.UpdateRole(kb.KBID, randomID(), models.RoleEditor); !errors.Is(err, models.ErrMemberNotFound) {
+		t.Fatalf("UpdateRole of non-member: err = %v, want ErrMemberNotFound", err)
+	}
+
+	if err := r.Members.Remove(kb.KBID, member.UserID); err != nil {
+		t.Fatalf("Remove co-owner: %v", err)
+	}
+	if err := r.Members.Remove(kb.KBID, member.UserID); !errors.Is(err, models.ErrMemberNotFound) {
+		t.Fatalf("Remove twice: err = %v, want ErrMemberNotFound", err)
+	}
+	if list, _ := r.Members.List(kb.KBID); len(list) != 1 {
+		t.Fatalf("List after remove = %+v", list)
+	}
+	if list, err := r.Members.List(randomID()); err != nil || len(list) != 0 {
+		t.Fatalf("List of unknown knowledge base = %+v, %v, want empty", list, err)
+	}
+}
+
+func testTransferOwnership(t *testing.T, r *repository.Repositories) {
+	owner, kb := newKB(t, r)
+	coOwner := newUser(t, r)
+	editor := newUser(t, r)
+	stranger := newUser(t, r)
+	if _, err := r.Members.Add(kb.KBID, coOwner.UserID, models.RoleOwner); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := r.Members.Add(kb.KBID, editor.UserID, models.RoleEditor); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// 其他OWNER不能把主所有权转给自己或他人
+	if err := r.Members.TransferOwnership(kb.KBID, coOwner.UserID, coOwner.UserID); !errors.Is(err, models.ErrNotPrimaryOwner) {
+		t.Fatalf("co-owner takeover: err = %v, want ErrNotPrimaryOwner", err)
+	}
+	if err := r.Members.TransferOwnership(kb.KBID, coOwner.UserID, editor.UserID); !errors.Is(err, models.ErrNotPrimaryOwner) {
+		t.Fatalf("co-owner transfer: err = %v, want ErrNotPrimaryOwner", err)
+	}
+	if got, _ := r.KBs.Get(kb.KBID); got.OwnerID != owner.UserID {
+		t.Fatalf("owner changed to %s after rejected transfers", got.OwnerID)
+	}
+
+	if err := r.Members.TransferOwnership(kb.KBID, owner.UserID, stranger.UserID); !errors.Is(err, models.ErrTransferToMember) {
+		t.Fatalf("transfer to non-member: err = %v, want ErrTransferToMember", err)
+	}
+
+	if err := r.Members.TransferOwnership(kb.KBID, owner.UserID, editor.UserID); err != nil {
+		t.Fatalf("TransferOwnership: %v", err)
+	}
+	if got, _ := r.KBs.Get(kb.KBID); got.OwnerID != editor.UserID {
+		t.Fatalf("owner = %s, want %s", got.OwnerID, editor.UserID)
+	}
+	roles := map[string]string{}
+	list, _ := r.Members.List(kb.KBID)
+	for _, m := range list {
+		roles[m.UserID] = m.Role
+	}
+	if roles[editor.UserID] != models.RoleOwner || roles[owner.UserID] != models.RoleEditor || roles[coOwner.UserID] != models.RoleOwner {
+		t.Fatalf("roles after transfer = %v", roles)
+	}
+	if err := r.Members.TransferOwnership(kb.KBID, owner.UserID, owner.UserID); !errors.Is(err, models.ErrNotPrimaryOwner) {
+		t.Fatalf("previous owner transfer: err = %v, want ErrNotPrimaryOwner", err)
+	}
+}
+
+func testAddAndTree(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+
+	a := addNode(t, r, kb.KBID, "", "folder", "a")
+	b := addNode(t, r, kb.KBID, a.NodeID, "file", "b")
+	addNode(t, r, kb.KBID, a.NodeID, "file", "c")
+	addNode(t, r, kb.KBID, "", "file", "d")
+
+	if a.NodeID == "" || a.Version != 1 || a.SortOrder != 1 {
+		t.Fatalf("Add = %+v", a)
+	}
+	tree := expectTree(t, r, kb.KBID, "a(b,c),d")
+	expectDistinctOrder(t, tree)
+
+	got, err := r.Nodes.Get(kb.KBID, b.NodeID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ParentID != a.NodeID || got.Title != "b" || got.Type != "file" {
+		t.Fatalf("Get = %+v", got)
+	}
+
+	// 节点只能在所属的知识库中访问
+	_, otherKB := newKB(t, r)
+	if _, err := r.Nodes.Get(otherKB.KBID, b.NodeID); !errors.Is(err, models.ErrNodeNotFound) {
+		t.Fatalf("Get from another knowledge base: err = %v, want ErrNodeNotFound", err)
+	}
+	expectTree(t, r, otherKB.KBID, "")
+}
+
+func testUpdateVersion(t *testing.T, r *repository.Repositories) {
+	user, kb := newKB(t, r)
+	node := addNode(t, r, kb.KBID, "", "file", "a")
+
+	updated, err := r.Nodes.Update(kb.KBID, node.NodeID, user.UserID, "a2", "body", node.Version)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Version != node.Version+1 || updated.Title != "a2" || updated.Content != "body" {
+		t.Fatalf("Update = %+v", updated)
+	}
+
+	if _, err := r.Nodes.Update(kb.KBID, node.NodeID, user.UserID, "stale", "", node.Version); !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("Update with stale version: err = %v, want ErrVersionConflict", err)
+	}
+	// 版本号为0时不检查
+	forced, err := r.Nodes.Update(kb.KBID, node.NodeID, user.UserID, "a3", "", 0)
+	if err != nil {
+		t.Fatalf("Update without version: %v", err)
+	}
+	if forced.Version != updated.Version+1 {
+		t.Fatalf("version = %d, want %d", forced.Version, updated.Version+1)
+	}
+
+	if _, err := r.Nodes.Update(kb.KBID, randomID(), user.UserID, "x", "", 0); !errors.Is(err, models.ErrNodeNotFound) {
+		t.Fatalf("Update unknown: err = %v, want ErrNodeNotFound", err)
+	}
+}
+
+func testDeleteSubtree(t *testing.T, r *repository.Repositories) {
+	user, kb := newKB(t, r)
+	a := addNode(t, r, kb.KBID, "", "folder", "a")
+	b := addNode(t, r, kb.KBID, a.NodeID, "folder", "b")
+	c := addNode(t, r, kb.KBID, b.NodeID, "file", "c")
+	addNode(t, r, kb.KBID, "", "file", "d")
+
+	if err := r.Nodes.Delete(kb.KBID, a.NodeID, user.UserID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	expectTree(t, r, kb.KBID, "d")
+	for _, n := range []*models.KnowledgeNode{a, b, c} {
+		if _, err := r.Nodes.Get(kb.KBID, n.NodeID); !errors.Is(err, models.ErrNodeNotFound) {
+			t.Fatalf("Get deleted %s: err = %v, want ErrNodeNotFound", n.Title, err)
+		}
+	}
+	if err := r.Nodes.Delete(kb.KBID, c.NodeID, user.UserID); !errors.Is(err, models.ErrNodeNotFound) {
+		t.Fatalf("Delete node of a deleted subtree: err = %v, want ErrNodeNotFound", err)
+	}
+
+	// 新节点排在剩余节点之后
+	e := addNode(t, r, kb.KBID, "", "file", "e")
+	expectTree(t, r, kb.KBID, "d,e")
+	if e.SortOrder <= 1 {
+		t.Fatalf("sort_order of new node = %d", e.SortOrder)
+	}
+}
+
+func testMoveAdjacent(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+	a := addNode(t, r, kb.KBID, "", "file", "a")
+	b := addNode(t, r, kb.KBID, "", "file", "b")
+	c := addNode(t, r, kb.KBID, "", "file", "c")
+	d := addNode(t, r, kb.KBID, "", "file", "d")
+
+	steps := []struct {
+		drag, hover *models.KnowledgeNode
+		position    string
+		want        string
+	}{
+		{d, a, "before", "d,a,b,c"},
+		{a, c, "after", "d,b,c,a"},
+		{b, d, "after", "d,b,c,a"},
+		{c, d, "before", "c,d,b,a"},
+		{d, a, "after", "c,b,a,d"},
+		{b, a, "before", "c,b,a,d"},
+	}
+	for _, s := range steps {
+		if err := r.Nodes.Move(kb.KBID, s.drag.NodeID, s.hover.NodeID, s.position); err != nil {
+			t.Fatalf("Move %s %s %s: %v", s.drag.Title, s.position, s.hover.Title, err)
+		}
+		tree := expectTree(t, r, kb.KBID, s.want)
+		expectDistinctOrder(t, tree)
+	}
+}
+
+func testMoveAcrossParents(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+	f := addNode(t, r, kb.KBID, "", "folder", "f")
+	g := addNode(t, r, kb.KBID, "", "folder", "g")
+	a := addNode(t, r, kb.KBID, f.NodeID, "file", "a")
+	b := addNode(t, r, kb.KBID, f.NodeID, "file", "b")
+	x := addNode(t, r, kb.KBID, g.NodeID, "file", "x")
+
+	if err := r.Nodes.Move(kb.KBID, b.NodeID, x.NodeID, "before"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	expectTree(t, r, kb.KBID, "f(a),g(b,x)")
+
+	moved, err := r.Nodes.Get(kb.KBID, b.NodeID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if moved.ParentID != g.NodeID {
+		t.Fatalf("parent = %q, want %q", moved.ParentID, g.NodeID)
+	}
+
+	// 移动到根节点之间
+	if err := r.Nodes.Move(kb.KBID, a.NodeID, g.NodeID, "before"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	tree := expectTree(t, r, kb.KBID, "f,a,g(b,x)")
+	expectDistinctOrder(t, tree)
+	if moved, _ := r.Nodes.Get(kb.KBID, a.NodeID); moved.ParentID != "" {
+		t.Fatalf("parent of root node = %q", moved.ParentID)
+	}
+}
+
+func testMoveInside(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+	f := addNode(t, r, kb.KBID, "", "folder", "f")
+	addNode(t, r, kb.KBID, f.NodeID, "file", "a")
+	b := addNode(t, r, kb.KBID, "", "file", "b")
+	g := addNode(t, r, kb.KBID, "", "folder", "g")
+
+	if err := r.Nodes.Move(kb.KBID, b.NodeID, f.NodeID, "inside"); err != nil {
+		t.Fatalf("Move inside: %v", err)
+	}
+	expectTree(t, r, kb.KBID, "f(a,b),g")
+
+	// 文件夹连同子树一起移动
+	if err := r.Nodes.Move(kb.KBID, f.NodeID, g.NodeID, "inside"); err != nil {
+		t.Fatalf("Move folder inside: %v", err)
+	}
+	tree := expectTree(t, r, kb.KBID, "g(f(a,b))")
+	expectDistinctOrder(t, tree)
+}
+
+func testMoveInvalid(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+	f := addNode(t, r, kb.KBID, "", "folder", "f")
+	sub := addNode(t, r, kb.KBID, f.NodeID, "folder", "sub")
+	a := addNode(t, r, kb.KBID, sub.NodeID, "file", "a")
+	b := addNode(t, r, kb.KBID, "", "file", "b")
+
+	cases := []struct {
+		name        string
+		drag, hover string
+		position    string
+		want        error
+	}{
+		{"itself", f.NodeID, f.NodeID, "inside", models.ErrMoveIntoSelf},
+		{"into child", f.NodeID, sub.NodeID, "inside", models.ErrMoveIntoSelf},
+		{"next to descendant", f.NodeID, a.NodeID, "before", models.ErrMoveIntoSelf},
+		{"into file", b.NodeID, a.NodeID, "inside", models.ErrMoveIntoFile},
+		{"bad position", b.NodeID, f.NodeID, "above", models.ErrInvalidPosition},
+		{"unknown target", b.NodeID, randomID(), "after", models.ErrNodeNotFound},
+		{"unknown node", randomID(), b.NodeID, "after", models.ErrNodeNotFound},
+	}
+	for _, c := range cases {
+		if err := r.Nodes.Move(kb.KBID, c.drag, c.hover, c.position); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+	// 失败的移动不改变树
+	expectTree(t, r, kb.KBID, "f(sub(a)),b")
+
+	// 已删除的节点不能作为目标
+	if err := r.Nodes.Delete(kb.KBID, b.NodeID, ""); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := r.Nodes.Move(kb.KBID, a.NodeID, b.NodeID, "after"); !errors.Is(err, models.ErrNodeNotFound) {
+		t.Fatalf("Move next to deleted node: err = %v, want ErrNodeNotFound", err)
+	}
+}
+
+// 导入或旧数据中同级节点的sort_order可能相同，移动后应重新编号
+func testMoveWithTiedSortOrder(t *testing.T, r *repository.Repositories) {
+	_, kb := newKB(t, r)
+	for _, title := range []string{"a", "b", "c"} {
+		if _, err := r.Nodes.Add(kb.KBID, &models.KnowledgeNode{Type: "file", Title: title, SortOrder: 1}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	d := addNode(t, r, kb.KBID, "", "file", "d")
+
+	tree, err := r.Nodes.Tree(kb.KBID)
+	if err != nil {
+		t.Fatalf("Tree: %v", err)
+	}
+	hover := tree[1]
+	if err := r.Nodes.Move(kb.KBID, d.NodeID, hover.NodeID, "before"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+
+	tree, err = r.Nodes.Tree(kb.KBID)
+	if err != nil {
+		t.Fatalf("Tree: %v", err)
+	}
+	expectDistinctOrder(t, tree)
+	for i, n := range tree {
+		if n.NodeID == hover.NodeID {
+			if i == 0 || tree[i-1].NodeID != d.NodeID {
+				t.Fatalf("d is not directly before %s: %s", hover.Title, shape(tree))
+			}
+			return
+		}
+	}
+	t.Fatalf("%s missing from tree", hover.Title)
+}
+
+func newSession(t *testing.T, r *repository.Repositories, userID, refreshHash string, ttl time.Duration) *models.Session {
+	t.Helper()
+	session, err := r.Sessions.Create(&models.Session{
+		UserID:    userID,
+		Device:    "Firefox on Linux",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/126.0",
+		IP:        "192.0.2.1",
+		ExpiresAt: time.Now().Add(ttl),
+	}, refreshHash)
+	if err != nil {
+		t.Fatalf("Create session: %v", err)
+	}
+	return session
+}
+
+func testSessions(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+	other := newUser(t, r)
+
+	first := newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	if first.SessionID == "" || first.UserID != user.UserID || first.Device != "Firefox on Linux" || first.IP != "192.0.2.1" {
+		t.Fatalf("Create = %+v", first)
+	}
+	second := newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	newSession(t, r, user.UserID, uniqueEmail(), -time.Minute)
+	newSession(t, r, other.UserID, uniqueEmail(), time.Hour)
+
+	got, err := r.Sessions.Get(first.SessionID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.UserID != user.UserID {
+		t.Fatalf("Get = %+v", got)
+	}
+	if _, err := r.Sessions.Get(randomID()); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Get unknown: err = %v, want ErrSessionNotFound", err)
+	}
+
+	// 过期的会话不列出
+	list, err := r.Sessions.ListForUser(user.UserID)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("ListForUser returned %d sessions, want 2", len(list))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := r.Sessions.Touch(first.SessionID); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	list, _ = r.Sessions.ListForUser(user.UserID)
+	if len(list) != 2 || list[0].SessionID != first.SessionID || !list[0].LastSeenAt.After(first.LastSeenAt) {
+		t.Fatalf("touched session is not listed first: %+v", list)
+	}
+
+	if err := r.Sessions.Revoke(other.UserID, first.SessionID); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Revoke session of another user: err = %v, want ErrSessionNotFound", err)
+	}
+	if err := r.Sessions.Revoke(user.UserID, first.SessionID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := r.Sessions.Get(first.SessionID); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Get revoked: err = %v, want ErrSessionNotFound", err)
+	}
+	if err := r.Sessions.Revoke(user.UserID, first.SessionID); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Revoke twice: err = %v, want ErrSessionNotFound", err)
+	}
+
+	third := newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	n, err := r.Sessions.RevokeAll(user.UserID)
+	if err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("RevokeAll revoked %d sessions, want 2", n)
+	}
+	for _, s := range []*models.Session{second, third} {
+		if _, err := r.Sessions.Get(s.SessionID); !errors.Is(err, models.ErrSessionNotFound) {
+			t.Fatalf("Get after RevokeAll: err = %v, want ErrSessionNotFound", err)
+		}
+	}
+	if list, _ := r.Sessions.ListForUser(other.UserID); len(list) != 1 {
+		t.Fatalf("RevokeAll affected another user: %+v", list)
+	}
+}
+
+func testSessionRotation(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+	hash1, hash2, hash3 := uniqueEmail(), uniqueEmail(), uniqueEmail()
+	session := newSession(t, r, user.UserID, hash1, time.Hour)
+
+	expires := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	rotated, err := r.Sessions.Rotate(hash1, hash2, expires, "198.51.100.7")
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated.SessionID != session.SessionID || rotated.IP != "198.51.100.7" || !rotated.ExpiresAt.Equal(expires) {
+		t.Fatalf("Rotate = %+v", rotated)
+	}
+	if _, err := r.Sessions.Rotate(hash2, hash3, expires, "198.51.100.7"); err != nil {
+		t.Fatalf("Rotate again: %v", err)
+	}
+
+	// 更早的令牌只是无效，上一个令牌再次出现说明已泄露，撤销整个会话
+	if _, err := r.Sessions.Rotate(hash1, uniqueEmail(), expires, ""); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Rotate with old token: err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := r.Sessions.Get(session.SessionID); err != nil {
+		t.Fatalf("Get after using an old token: %v", err)
+	}
+	if _, err := r.Sessions.Rotate(hash2, uniqueEmail(), expires, ""); !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Fatalf("Rotate with reused token: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := r.Sessions.Get(session.SessionID); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Get after reuse: err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := r.Sessions.Rotate(hash3, uniqueEmail(), expires, ""); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Rotate revoked session: err = %v, want ErrSessionNotFound", err)
+	}
+
+	if _, err := r.Sessions.Rotate(uniqueEmail(), uniqueEmail(), expires, ""); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Rotate unknown token: err = %v, want ErrSessionNotFound", err)
+	}
+	expiredHash := uniqueEmail()
+	newSession(t, r, user.UserID, expiredHash, -time.Minute)
+	if _, err := r.Sessions.Rotate(expiredHash, uniqueEmail(), expires, ""); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("Rotate expired session: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testRevokeOtherSessions(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+	other := newUser(t, r)
+	keep := newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	newSession(t, r, user.UserID, uniqueEmail(), time.Hour)
+	newSession(t, r, other.UserID, uniqueEmail(), time.Hour)
+
+	n, err := r.Sessions.RevokeOthers(user.UserID, keep.SessionID)
+	if err != nil {
+		t.Fatalf("RevokeOthers: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("RevokeOthers revoked %d sessions, want 2", n)
+	}
+	list, _ := r.Sessions.ListForUser(user.UserID)
+	if len(list) != 1 || list[0].SessionID != keep.SessionID {
+		t.Fatalf("sessions after RevokeOthers: %+v", list)
+	}
+	if list, _ := r.Sessions.ListForUser(other.UserID); len(list) != 1 {
+		t.Fatalf("RevokeOthers affected another user: %+v", list)
+	}
+
+	// 不保留任何会话时等同于RevokeAll
+	if n, err := r.Sessions.RevokeOthers(user.UserID, ""); err != nil || n != 1 {
+		t.Fatalf("RevokeOthers without a kept session = %d, %v; want 1", n, err)
+	}
+}
+
+func testCredentials(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+
+	creds, err := r.Users.GetCredentials(user.UserID)
+	if err != nil {
+		t.Fatalf("GetCredentials: %v", err)
+	}
+	if creds.Email != user.Email || creds.Password != "hash" || creds.EmailVerified {
+		t.Fatalf("GetCredentials = %+v", creds)
+	}
+	if _, err := r.Users.GetCredentials(randomID()); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("GetCredentials unknown: err = %v, want ErrUserNotFound", err)
+	}
+
+	if err := r.Users.SetPassword(user.UserID, "new-hash"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if byEmail, _ := r.Users.GetByEmail(user.Email); byEmail.Password != "new-hash" {
+		t.Fatalf("password after SetPassword = %q", byEmail.Password)
+	}
+	if err := r.Users.SetPassword(randomID(), "hash"); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("SetPassword unknown: err = %v, want ErrUserNotFound", err)
+	}
+
+	if err := r.Users.MarkEmailVerified(user.UserID, uniqueEmail()); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("MarkEmailVerified with another email: err = %v, want ErrTokenInvalid", err)
+	}
+	if err := r.Users.MarkEmailVerified(user.UserID, user.Email); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	if err := r.Users.MarkEmailVerified(user.UserID, user.Email); err != nil {
+		t.Fatalf("MarkEmailVerified twice: %v", err)
+	}
+	if byID, _ := r.Users.GetByID(user.UserID); !byID.EmailVerified {
+		t.Fatal("GetByID does not report the verified email")
+	}
+	if byEmail, _ := r.Users.GetByEmail(user.Email); !byEmail.EmailVerified {
+		t.Fatal("GetByEmail does not report the verified email")
+	}
+}
+
+func newUserToken(t *testing.T, r *repository.Repositories, user *models.User, purpose string, ttl time.Duration) string {
+	t.Helper()
+	hash := uniqueEmail()
+	err := r.Tokens.Create(hash, &models.UserToken{
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		t.Fatalf("Create token: %v", err)
+	}
+	return hash
+}
+
+func testUserTokens(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+
+	if last, err := r.Tokens.LastIssuedAt(user.UserID, models.TokenPurposeVerifyEmail); err != nil || !last.IsZero() {
+		t.Fatalf("LastIssuedAt without tokens = %v, %v", last, err)
+	}
+
+	verify := newUserToken(t, r, user, models.TokenPurposeVerifyEmail, time.Hour)
+	reset := newUserToken(t, r, user, models.TokenPurposeResetPassword, time.Hour)
+	if last, err := r.Tokens.LastIssuedAt(user.UserID, models.TokenPurposeVerifyEmail); err != nil || last.IsZero() {
+		t.Fatalf("LastIssuedAt = %v, %v", last, err)
+	}
+
+	// 用途不同的令牌不能互换使用
+	if _, err := r.Tokens.Consume(models.TokenPurposeResetPassword, verify); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("Consume with another purpose: err = %v, want ErrTokenInvalid", err)
+	}
+	token, err := r.Tokens.Consume(models.TokenPurposeVerifyEmail, verify)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if token.UserID != user.UserID || token.Email != user.Email || token.Purpose != models.TokenPurposeVerifyEmail {
+		t.Fatalf("Consume = %+v", token)
+	}
+	if _, err := r.Tokens.Consume(models.TokenPurposeVerifyEmail, verify); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("Consume twice: err = %v, want ErrTokenInvalid", err)
+	}
+
+	// 新令牌使同一用途之前的令牌作废，其他用途的令牌不受影响
+	newReset := newUserToken(t, r, user, models.TokenPurposeResetPassword, time.Hour)
+	newUserToken(t, r, user, models.TokenPurposeVerifyEmail, time.Hour)
+	if _, err := r.Tokens.Consume(models.TokenPurposeResetPassword, reset); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("Consume replaced token: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := r.Tokens.Consume(models.TokenPurposeResetPassword, newReset); err != nil {
+		t.Fatalf("Consume new token: %v", err)
+	}
+
+	expired := newUserToken(t, r, user, models.TokenPurposeResetPassword, -time.Minute)
+	if _, err := r.Tokens.Consume(models.TokenPurposeResetPassword, expired); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("Consume expired token: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := r.Tokens.Consume(models.TokenPurposeResetPassword, uniqueEmail()); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("Consume unknown token: err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func testTwoFactor(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+	other := newUser(t, r)
+
+	if _, err := r.TwoFactor.Get(user.UserID); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("Get before enrolling: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+	if err := r.TwoFactor.Enable(user.UserID, 100, nil); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("Enable before enrolling: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+
+	// 未确认的密钥可以被重新生成的密钥替换
+	if err := r.TwoFactor.Enroll(user.UserID, "FIRSTSECRET"); err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if err := r.TwoFactor.Enroll(user.UserID, "SECONDSECRET"); err != nil {
+		t.Fatalf("Enroll again: %v", err)
+	}
+	totp, err := r.TwoFactor.Get(user.UserID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if totp.Secret != "SECONDSECRET" || totp.Enabled {
+		t.Fatalf("Get pending = %+v", totp)
+	}
+	if err := r.TwoFactor.UseStep(user.UserID, 100); !errors.Is(err, models.ErrTOTPCodeUsed) {
+		t.Fatalf("UseStep before enabling: err = %v, want ErrTOTPCodeUsed", err)
+	}
+	if err := r.TwoFactor.ReplaceRecoveryCodes(user.UserID, []string{randomID()}); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("ReplaceRecoveryCodes before enabling: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+
+	codes := []string{randomID(), randomID(), randomID()}
+	if err := r.TwoFactor.Enable(user.UserID, 100, codes); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	totp, _ = r.TwoFactor.Get(user.UserID)
+	if !totp.Enabled || totp.EnabledAt == nil || totp.LastUsedStep != 100 {
+		t.Fatalf("Get enabled = %+v", totp)
+	}
+	if err := r.TwoFactor.Enroll(user.UserID, "THIRDSECRET"); !errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		t.Fatalf("Enroll when enabled: err = %v, want ErrTOTPAlreadyEnabled", err)
+	}
+	if err := r.TwoFactor.Enable(user.UserID, 101, nil); !errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		t.Fatalf("Enable twice: err = %v, want ErrTOTPAlreadyEnabled", err)
+	}
+
+	// 时间步只能前进
+	if err := r.TwoFactor.UseStep(user.UserID, 100); !errors.Is(err, models.ErrTOTPCodeUsed) {
+		t.Fatalf("UseStep with the confirmed step: err = %v, want ErrTOTPCodeUsed", err)
+	}
+	if err := r.TwoFactor.UseStep(user.UserID, 102); err != nil {
+		t.Fatalf("UseStep: %v", err)
+	}
+	if err := r.TwoFactor.UseStep(user.UserID, 101); !errors.Is(err, models.ErrTOTPCodeUsed) {
+		t.Fatalf("UseStep with an earlier step: err = %v, want ErrTOTPCodeUsed", err)
+	}
+
+	if n, err := r.TwoFactor.RecoveryCodesLeft(user.UserID); err != nil || n != 3 {
+		t.Fatalf("RecoveryCodesLeft = %d, %v; want 3", n, err)
+	}
+	if err := r.TwoFactor.UseRecoveryCode(other.UserID, codes[0]); !errors.Is(err, models.ErrRecoveryCodeInvalid) {
+		t.Fatalf("UseRecoveryCode of another user: err = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if err := r.TwoFactor.UseRecoveryCode(user.UserID, codes[0]); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if err := r.TwoFactor.UseRecoveryCode(user.UserID, codes[0]); !errors.Is(err, models.ErrRecoveryCodeInvalid) {
+		t.Fatalf("UseRecoveryCode twice: err = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if n, _ := r.TwoFactor.RecoveryCodesLeft(user.UserID); n != 2 {
+		t.Fatalf("RecoveryCodesLeft after use = %d, want 2", n)
+	}
+
+	newCodes := []string{randomID()}
+	if err := r.TwoFactor.ReplaceRecoveryCodes(user.UserID, newCodes); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	if err := r.TwoFactor.UseRecoveryCode(user.UserID, codes[1]); !errors.Is(err, models.ErrRecoveryCodeInvalid) {
+		t.Fatalf("UseRecoveryCode with a replaced code: err = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if n, _ := r.TwoFactor.RecoveryCodesLeft(user.UserID); n != 1 {
+		t.Fatalf("RecoveryCodesLeft after replace = %d, want 1", n)
+	}
+
+	if err := r.TwoFactor.Disable(user.UserID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if _, err := r.TwoFactor.Get(user.UserID); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("Get after Disable: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+	if n, _ := r.TwoFactor.RecoveryCodesLeft(user.UserID); n != 0 {
+		t.Fatalf("RecoveryCodesLeft after Disable = %d, want 0", n)
+	}
+	if err := r.TwoFactor.Disable(user.UserID); !errors.Is(err, models.ErrTOTPNotEnrolled) {
+		t.Fatalf("Disable twice: err = %v, want ErrTOTPNotEnrolled", err)
+	}
+}
+
+func testLoginChallenges(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+
+	challenge := randomID()
+	if err := r.TwoFactor.CreateChallenge(challenge, user.UserID, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		userID, err := r.TwoFactor.AttemptChallenge(challenge, 3)
+		if err != nil || userID != user.UserID {
+			t.Fatalf("AttemptChallenge %d = %q, %v", i+1, userID, err)
+		}
+	}
+	if _, err := r.TwoFactor.AttemptChallenge(challenge, 3); !errors.Is(err, models.ErrChallengeInvalid) {
+		t.Fatalf("AttemptChallenge after max attempts: err = %v, want ErrChallengeInvalid", err)
+	}
+
+	completed := randomID()
+	r.TwoFactor.CreateChallenge(completed, user.UserID, time.Now().Add(time.Minute))
+	if _, err := r.TwoFactor.AttemptChallenge(completed, 3); err != nil {
+		t.Fatalf("AttemptChallenge: %v", err)
+	}
+	if err := r.TwoFactor.CompleteChallenge(completed); err != nil {
+		t.Fatalf("CompleteChallenge: %v", err)
+	}
+	if err := r.TwoFactor.CompleteChallenge(completed); !errors.Is(err, models.ErrChallengeInvalid) {
+		t.Fatalf("CompleteChallenge twice: err = %v, want ErrChallengeInvalid", err)
+	}
+	if _, err := r.TwoFactor.AttemptChallenge(completed, 3); !errors.Is(err, models.ErrChallengeInvalid) {
+		t.Fatalf("AttemptChallenge after completion: err = %v, want ErrChallengeInvalid", err)
+	}
+
+	expired := randomID()
+	r.TwoFactor.CreateChallenge(expired, user.UserID, time.Now().Add(-time.Minute))
+	if _, err := r.TwoFactor.AttemptChallenge(expired, 3); !errors.Is(err, models.ErrChallengeInvalid) {
+		t.Fatalf("AttemptChallenge expired: err = %v, want ErrChallengeInvalid", err)
+	}
+	if _, err := r.TwoFactor.AttemptChallenge(randomID(), 3); !errors.Is(err, models.ErrChallengeInvalid) {
+		t.Fatalf("AttemptChallenge unknown: err = %v, want ErrChallengeInvalid", err)
+	}
+}
+
+func testIdentities(t *testing.T, r *repository.Repositories) {
+	user := newUser(t, r)
+	other := newUser(t, r)
+	issuer, subject := "https://idp.example.com", randomID()
+
+	if _, err := r.Identities.FindUser(issuer, subject); !errors.Is(err, models.ErrIdentityNotFound) {
+		t.Fatalf("FindUser before linking: err = %v, want ErrIdentityNotFound", err)
+	}
+	if err := r.Identities.Link(issuer, subject, user.UserID, user.Email); err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if userID, err := r.Identities.FindUser(issuer, subject); err != nil || userID != user.UserID {
+		t.Fatalf("FindUser = %q, %v", userID, err)
+	}
+	if err := r.Identities.Link(issuer, subject, other.UserID, other.Email); !errors.Is(err, models.ErrIdentityTaken) {
+		t.Fatalf("Link a linked identity: err = %v, want ErrIdentityTaken", err)
+	}
+
+	// 同一个subject在另一个提供方是另一个身份
+	if _, err := r.Identities.FindUser("https://other.example.com", subject); !errors.Is(err, models.ErrIdentityNotFound) {
+		t.Fatalf("FindUser with another issuer: err = %v, want ErrIdentityNotFound", err)
+	}
+	if err := r.Identities.Link("https://other.example.com", subject, other.UserID, other.Email); err != nil {
+		t.Fatalf("Link with another issuer: %v", err)
+	}
+}
+
+func testOIDCLoginStates(t *testing.T, r *repository.Repositories) {
+	state := randomID()
+	err := r.Identities.SaveLoginState(state, &models.OIDCLoginState{
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("SaveLoginState: %v", err)
+	}
+	got, err := r.Identities.TakeLoginState(state)
+	if err != nil {
+		t.Fatalf("TakeLoginState: %v", err)
+	}
+	if got.Nonce != "nonce" || got.CodeVerifier != "verifier" {
+		t.Fatalf("TakeLoginState = %+v", got)
+	}
+	if _, err := r.Identities.TakeLoginState(state); !errors.Is(err, models.ErrLoginStateInvalid) {
+		t.Fatalf("TakeLoginState twice: err = %v, want ErrLoginStateInvalid", err)
+	}
+
+	expired := randomID()
+	r.Identities.SaveLoginState(expired, &models.OIDCLoginState{Nonce: "n", CodeVerifier: "v", ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, err := r.Identities.TakeLoginState(expired); !errors.Is(err, models.ErrLoginStateInvalid) {
+		t.Fatalf("TakeLoginState expired: err = %v, want ErrLoginStateInvalid", err)
+	}
+}
+
+func testAccessTokens(t *testing.T, r *repository.Repositories) {
+	user, kb := newKB(t, r)
+	other := newUser(t, r)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	first, err := r.AccessTokens.Create(&models.AccessToken{
+		UserID:    user.UserID,
+		Name:      "sync script",
+		Prefix:    "kmpat_abcd",
+		Scopes:    []models.AccessTokenScope{models.ScopeWrite},
+		KBIDs:     []string{kb.KBID},
+		ExpiresAt: &expiresAt,
+	}, "hash-1")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if first.TokenID == "" || first.Name != "sync script" || first.Prefix != "kmpat_abcd" || first.LastUsedAt != nil {
+		t.Fatalf("Create = %+v", first)
+	}
+	if len(first.Scopes) != 1 || first.Scopes[0] != models.ScopeWrite || len(first.KBIDs) != 1 || first.KBIDs[0] != kb.KBID {
+		t.Fatalf("scopes or knowledge bases = %v, %v", first.Scopes, first.KBIDs)
+	}
+	if first.ExpiresAt == nil || !first.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("ExpiresAt = %v, want %v", first.ExpiresAt, expiresAt)
+	}
+	second, err := r.AccessTokens.Create(&models.AccessToken{
+		UserID: user.UserID,
+		Name:   "backup",
+		Prefix: "kmpat_efgh",
+		Scopes: []models.AccessTokenScope{models.ScopeRead},
+	}, "hash-2")
+	if err != nil {
+		t.Fatalf("Create without expiry: %v", err)
+	}
+	if second.ExpiresAt != nil || second.KBIDs == nil || len(second.KBIDs) != 0 {
+		t.Fatalf("Create without expiry = %+v", second)
+	}
+
+	got, err := r.AccessTokens.GetByHash("hash-1")
+	if err != nil || got.TokenID != first.TokenID || got.UserID != user.UserID {
+		t.Fatalf("GetByHash = %+v, %v", got, err)
+	}
+	if _, err := r.AccessTokens.GetByHash("unknown"); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("GetByHash unknown: err = %v, want ErrAccessTokenNotFound", err)
+	}
+
+	if err := r.AccessTokens.Touch(first.TokenID); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	tokens, err := r.AccessTokens.ListForUser(user.UserID)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].TokenID != second.TokenID || tokens[1].TokenID != first.TokenID {
+		t.Fatalf("ListForUser = %+v, want newest first", tokens)
+	}
+	if tokens[1].LastUsedAt == nil {
+		t.Fatal("LastUsedAt not set by Touch")
+	}
+	if tokens, _ := r.AccessTokens.ListForUser(other.UserID); len(tokens) != 0 {
+		t.Fatalf("ListForUser of another user = %+v", tokens)
+	}
+
+	// 只能撤销自己的令牌
+	if err := r.AccessTokens.Revoke(other.UserID, first.TokenID); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("Revoke by another user: err = %v, want ErrAccessTokenNotFound", err)
+	}
+	if err := r.AccessTokens.Revoke(user.UserID, "not-a-uuid"); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("Revoke invalid id: err = %v, want ErrAccessTokenNotFound", err)
+	}
+	if err := r.AccessTokens.Revoke(user.UserID, first.TokenID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := r.AccessTokens.GetByHash("hash-1"); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("GetByHash after Revoke: err = %v, want ErrAccessTokenNotFound", err)
+	}
+
+	// 过期的令牌不能再使用，但仍然列出
+	expired := time.Now().Add(-time.Minute)
+	_, err = r.AccessTokens.Create(&models.AccessToken{
+		UserID:    user.UserID,
+		Name:      "old",
+		Prefix:    "kmpat_ijkl",
+		Scopes:    []models.AccessTokenScope{models.ScopeRead},
+		ExpiresAt: &expired,
+	}, "hash-3")
+	if err != nil {
+		t.Fatalf("Create expired: %v", err)
+	}
+	if _, err := r.AccessTokens.GetByHash("hash-3"); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("GetByHash expired: err = %v, want ErrAccessTokenNotFound", err)
+	}
+	if tokens, _ := r.AccessTokens.ListForUser(user.UserID); len(tokens) != 2 {
+		t.Fatalf("ListForUser after expiry = %d tokens, want 2", len(tokens))
+	}
+
+	// 撤销用户的全部令牌
+	if n, err := r.AccessTokens.RevokeAll(user.UserID); err != nil || n != 2 {
+		t.Fatalf("RevokeAll = %d, %v, want 2", n, err)
+	}
+	if tokens, _ := r.AccessTokens.ListForUser(user.UserID); len(tokens) != 0 {
+		t.Fatalf("ListForUser after RevokeAll = %+v", tokens)
+	}
+	if _, err := r.AccessTokens.GetByHash("hash-2"); !errors.Is(err, models.ErrAccessTokenNotFound) {
+		t.Fatalf("GetByHash after RevokeAll: err = %v, want ErrAccessTokenNotFound", err)
+	}
+}
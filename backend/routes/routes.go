@@ -2,53 +2,235 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"knowledge_master_backend/config"
 	"knowledge_master_backend/controllers"
 	"knowledge_master_backend/middleware"
+	"knowledge_master_backend/models"
+	"knowledge_master_backend/storage"
 )
 
-func SetupRoutes() *gin.Engine {
-	r := gin.Default()
-	controllers.SetupCORS(r)
+const kbPath = "/api/knowledge-bases/:kb_id"
+
+// kbRoutePermissions 知识库下每个路由所需的权限
+var kbRoutePermissions = map[string]models.Permission{
+	"GET " + kbPath + "/":    models.PermissionRead,
+	"PUT " + kbPath + "/":    models.PermissionManage,
+	"DELETE " + kbPath + "/": models.PermissionManage,
+
+	"PUT " + kbPath + "/visibility": models.PermissionManage,
+
+	"GET " + kbPath + "/tree":  models.PermissionRead,
+	"POST " + kbPath + "/tree": models.PermissionWrite,
+
+	"GET " + kbPath + "/nodes/:node_id":       models.PermissionRead,
+	"PUT " + kbPath + "/nodes/:node_id":       models.PermissionWrite,
+	"DELETE " + kbPath + "/nodes/:node_id":    models.PermissionWrite,
+	"POST " + kbPath + "/nodes/:node_id/move": models.PermissionWrite,
+
+	"GET " + kbPath + "/nodes/:node_id/revisions":                       models.PermissionRead,
+	"GET " + kbPath + "/nodes/:node_id/revisions/:revision_id":          models.PermissionRead,
+	"POST " + kbPath + "/nodes/:node_id/revisions/:revision_id/restore": models.PermissionWrite,
+	"GET " + kbPath + "/nodes/:node_id/diff":                            models.PermissionRead,
+	"GET " + kbPath + "/nodes/:node_id/render":                          models.PermissionRead,
+
+	"GET " + kbPath + "/nodes/:node_id/attachments":    models.PermissionRead,
+	"POST " + kbPath + "/nodes/:node_id/attachments":   models.PermissionWrite,
+	"GET " + kbPath + "/attachments":                   models.PermissionRead,
+	"DELETE " + kbPath + "/attachments/:attachment_id": models.PermissionWrite,
+
+	"GET " + kbPath + "/members":          models.PermissionRead,
+	"POST " + kbPath + "/members":         models.PermissionManage,
+	"PUT " + kbPath + "/members/:user_id": models.PermissionManage,
+	// 成员可以退出知识库，移除他人由处理函数再校验
+	"DELETE " + kbPath + "/members/:user_id": models.PermissionRead,
+	"POST " + kbPath + "/transfer-ownership": models.PermissionManage,
+
+	// 彻底删除不可恢复，与清空回收站一样需要管理权限
+	"GET " + kbPath + "/trash":                   models.PermissionRead,
+	"DELETE " + kbPath + "/trash":                models.PermissionManage,
+	"POST " + kbPath + "/trash/:node_id/restore": models.PermissionWrite,
+	"DELETE " + kbPath + "/trash/:node_id":       models.PermissionManage,
+
+	"GET " + kbPath + "/export":  models.PermissionRead,
+	"POST " + kbPath + "/import": models.PermissionWrite,
+	"GET " + kbPath + "/backup":  models.PermissionManage,
+
+	"GET " + kbPath + "/snapshots":                       models.PermissionRead,
+	"POST " + kbPath + "/snapshots":                      models.PermissionWrite,
+	"GET " + kbPath + "/snapshots/:snapshot_id/diff":     models.PermissionRead,
+	"POST " + kbPath + "/snapshots/:snapshot_id/restore": models.PermissionManage,
+	"DELETE " + kbPath + "/snapshots/:snapshot_id":       models.PermissionManage,
+
+	"GET " + kbPath + "/invites":               models.PermissionManage,
+	"POST " + kbPath + "/invites":              models.PermissionManage,
+	"DELETE " + kbPath + "/invites/:invite_id": models.PermissionManage,
+}
+
+// accessTokenRouteScopes 个人访问令牌可以使用的路由及所需的权限范围
+// 知识库下的路由按kbRoutePermissions换算：读取需要read，修改需要write，管理需要admin。
+// 未登记的路由（账号安全、会话和令牌管理等）只能使用登录会话访问。
+var accessTokenRouteScopes = func() map[string]models.AccessTokenScope {
+	scopes := map[string]models.AccessTokenScope{
+		"GET /api/user/info":    models.ScopeRead,
+		"GET /api/user/profile": models.ScopeRead,
+		"GET /api/search":       models.ScopeRead,
+
+		"GET /api/knowledge-bases/":         models.ScopeRead,
+		"POST /api/knowledge-bases/":        models.ScopeWrite,
+		"POST /api/knowledge-bases/restore": models.ScopeAdmin,
+
+		"GET /api/trash/knowledge-bases":                 models.ScopeRead,
+		"POST /api/trash/knowledge-bases/:kb_id/restore": models.ScopeAdmin,
+		"DELETE /api/trash/knowledge-bases/:kb_id":       models.ScopeAdmin,
+	}
+	permissionScope := map[models.Permission]models.AccessTokenScope{
+		models.PermissionRead:   models.ScopeRead,
+		models.PermissionWrite:  models.ScopeWrite,
+		models.PermissionManage: models.ScopeAdmin,
+	}
+	for route, perm := range kbRoutePermissions {
+		scopes[route] = permissionScope[perm]
+	}
+	return scopes
+}()
+
+// SetupRoutes 注册全部路由，用户、知识库和节点的请求由srv处理
+func SetupRoutes(cfg *config.Config, srv *controllers.Server) *gin.Engine {
+	r := gin.New()
+	if cfg.Log.Access {
+		r.Use(gin.Logger())
+	}
+	r.Use(gin.Recovery())
+	controllers.SetupCORS(r, cfg.CORS)
+
+	// 使用本地存储时由服务本身提供上传文件的访问
+	if local, ok := config.Storage.(*storage.Local); ok {
+		r.GET(local.RoutePath()+"/*key", gin.WrapH(local))
+		r.HEAD(local.RoutePath()+"/*key", gin.WrapH(local))
+	}
 
 	public := r.Group("/api")
 	{
-		public.POST("/register", controllers.Register)
-		public.POST("/login", controllers.Login)
+		public.POST("/register", srv.Register)
+		public.POST("/login", srv.Login)
+		public.POST("/login/2fa", srv.LoginTwoFactor)
+		public.GET("/oidc/authorize", srv.OIDCAuthorize)
+		public.POST("/oidc/callback", srv.OIDCCallback)
+		public.POST("/refresh", srv.RefreshToken)
+		public.POST("/verify-email", srv.VerifyEmail)
+		public.POST("/password/forgot", srv.ForgotPassword)
+		public.POST("/password/reset", srv.ResetPassword)
+
+		// 公开知识库的只读访问，无需登录
+		publicKb := public.Group("/public/knowledge-bases/:kb_id")
+		publicKb.Use(middleware.PublicKBMiddleware(srv.KBs))
+		{
+			publicKb.GET("/", srv.GetKnowledgeBaseByID)
+			publicKb.GET("/tree", srv.GetKnowledgeTree)
+			publicKb.GET("/nodes/:node_id", srv.GetNodeData)
+		}
 	}
 
 	api := r.Group("/api")
-	api.Use(middleware.AuthMiddleware())
+	api.Use(middleware.AuthMiddleware(srv.Sessions, srv.AccessTokens, accessTokenRouteScopes))
 	{
 		user := api.Group("/user")
 		{
-			user.GET("/info", controllers.GetUserInfo)
-			user.POST("/avatar", controllers.UploadAvatar)
-			user.GET("/profile", controllers.GetUserProfile)
-			user.PUT("/profile", controllers.UpdateUserProfile)
+			user.GET("/info", srv.GetUserInfo)
+			user.POST("/avatar", srv.UploadAvatar)
+			user.GET("/profile", srv.GetUserProfile)
+			user.PUT("/profile", srv.UpdateUserProfile)
+			user.GET("/sessions", srv.GetSessions)
+			user.DELETE("/sessions/:session_id", srv.RevokeSession)
+			user.PUT("/password", srv.ChangePassword)
+			user.POST("/verify-email/resend", srv.ResendVerificationEmail)
+			user.GET("/2fa", srv.GetTwoFactorStatus)
+			user.POST("/2fa/enroll", srv.EnrollTwoFactor)
+			user.POST("/2fa/confirm", srv.ConfirmTwoFactor)
+			user.POST("/2fa/disable", srv.DisableTwoFactor)
+			user.POST("/2fa/recovery-codes", srv.RegenerateRecoveryCodes)
+			user.GET("/tokens", srv.GetAccessTokens)
+			user.POST("/tokens", srv.CreateAccessToken)
+			user.DELETE("/tokens/:token_id", srv.RevokeAccessToken)
+		}
+
+		api.POST("/logout", srv.Logout)
+		api.POST("/logout-all", srv.LogoutAll)
+
+		api.POST("/invites/:token/accept", controllers.AcceptKBInvite)
+		api.GET("/search", controllers.SearchNodes)
+
+		// 已删除的知识库不再经过知识库权限中间件
+		trash := api.Group("/trash/knowledge-bases")
+		{
+			trash.GET("", controllers.GetKnowledgeBaseTrash)
+			trash.POST("/:kb_id/restore", controllers.RestoreKnowledgeBase)
+			trash.DELETE("/:kb_id", controllers.PurgeKnowledgeBase)
 		}
 
 		kb := api.Group("/knowledge-bases")
 		{
 
-			kb.GET("/", controllers.GetUserKnowledgeBases)
-			kb.POST("/", controllers.CreateKnowledgeBase)
+			kb.GET("/", srv.GetUserKnowledgeBases)
+			kb.POST("/", srv.CreateKnowledgeBase)
+			// 从备份恢复会写入任意知识库和节点ID，只允许系统管理员使用
+			kb.POST("/restore", middleware.AdminMiddleware(cfg.Admin.UserIDs), controllers.RestoreKnowledgeBaseBackup)
 
 			specificKb := kb.Group("/:kb_id")
+			specificKb.Use(middleware.KBPermissionMiddleware(srv.KBs, kbRoutePermissions))
 			{
-				specificKb.GET("/", controllers.GetKnowledgeBaseByID)
-				specificKb.PUT("/", controllers.UpdateKnowledgeBase)
-				specificKb.DELETE("/", controllers.DeleteKnowledgeBase)
+				specificKb.GET("/", srv.GetKnowledgeBaseByID)
+				specificKb.PUT("/", srv.UpdateKnowledgeBase)
+				specificKb.DELETE("/", srv.DeleteKnowledgeBase)
+				specificKb.PUT("/visibility", srv.UpdateKnowledgeBaseVisibility)
 
-				specificKb.GET("/tree", controllers.GetKnowledgeTree)
-				specificKb.POST("/tree", controllers.AddKnowledgeNode)
+				specificKb.GET("/tree", srv.GetKnowledgeTree)
+				specificKb.POST("/tree", srv.AddKnowledgeNode)
 
 				nodes := specificKb.Group("/nodes")
 				{
-					nodes.GET("/:node_id", controllers.GetNodeData)
-					nodes.PUT("/:node_id", controllers.UpdateNodeData)
-					nodes.DELETE("/:node_id", controllers.DeleteNodeData)
-					nodes.POST("/:node_id/move", controllers.MoveNode)
+					nodes.GET("/:node_id", srv.GetNodeData)
+					nodes.PUT("/:node_id", srv.UpdateNodeData)
+					nodes.DELETE("/:node_id", srv.DeleteNodeData)
+					nodes.POST("/:node_id/move", srv.MoveNode)
+
+					nodes.GET("/:node_id/revisions", controllers.GetNodeRevisions)
+					nodes.GET("/:node_id/revisions/:revision_id", controllers.GetNodeRevision)
+					nodes.POST("/:node_id/revisions/:revision_id/restore", controllers.RestoreNodeRevision)
+					nodes.GET("/:node_id/diff", controllers.DiffNodeRevisions)
+					nodes.GET("/:node_id/render", controllers.RenderNode)
+
+					nodes.GET("/:node_id/attachments", controllers.GetNodeAttachments)
+					nodes.POST("/:node_id/attachments", controllers.UploadAttachment)
 				}
+
+				specificKb.GET("/members", srv.GetKBMembers)
+				specificKb.POST("/members", srv.AddKBMember)
+				specificKb.PUT("/members/:user_id", srv.UpdateKBMemberRole)
+				specificKb.DELETE("/members/:user_id", srv.RemoveKBMember)
+				specificKb.POST("/transfer-ownership", srv.TransferKBOwnership)
+
+				specificKb.GET("/trash", controllers.GetNodeTrash)
+				specificKb.DELETE("/trash", controllers.EmptyNodeTrash)
+				specificKb.POST("/trash/:node_id/restore", controllers.RestoreTrashedNode)
+				specificKb.DELETE("/trash/:node_id", controllers.PurgeTrashedNode)
+
+				specificKb.GET("/attachments", controllers.GetKBAttachments)
+				specificKb.DELETE("/attachments/:attachment_id", controllers.DeleteAttachment)
+
+				specificKb.GET("/export", controllers.ExportKnowledgeBase)
+				specificKb.POST("/import", controllers.ImportKnowledgeBase)
+				specificKb.GET("/backup", controllers.BackupKnowledgeBase)
+
+				specificKb.GET("/snapshots", controllers.GetKBSnapshots)
+				specificKb.POST("/snapshots", controllers.CreateKBSnapshot)
+				specificKb.GET("/snapshots/:snapshot_id/diff", controllers.DiffKBSnapshot)
+				specificKb.POST("/snapshots/:snapshot_id/restore", controllers.RestoreKBSnapshot)
+				specificKb.DELETE("/snapshots/:snapshot_id", controllers.DeleteKBSnapshot)
+
+				specificKb.GET("/invites", controllers.GetKBInvites)
+				specificKb.POST("/invites", controllers.CreateKBInvite)
+				specificKb.DELETE("/invites/:invite_id", controllers.RevokeKBInvite)
 			}
 		}
 	}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LocalConfig struct {
+	Dir     string `mapstructure:"dir"`      // 文件保存的目录，默认 ./data/uploads
+	BaseURL string `mapstructure:"base_url"` // 访问地址前缀，默认 /files，可以是完整的URL
+	Secret  string `mapstructure:"secret"`   // 签名地址使用的密钥，为空时每次启动随机生成
+}
+
+// Local 保存在本地磁盘上的存储，由Gin在BaseURL路径下提供访问（与公共读的bucket一致）
+// key以LocalPrivatePrefix开头的文件只能通过SignedURL生成的地址在有效期内访问。
+type Local struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// localContentTypes 按扩展名返回的Content-Type，其他文件一律作为下载，避免在站点域名下执行上传的网页
+var localContentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".pdf":  "application/pdf",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "application/ogg",
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/plain; charset=utf-8",
+	".json": "application/json",
+}
+
+func NewLocal(cfg LocalConfig) (*Local, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "./data/uploads"
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "/files"
+	}
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{dir: dir, baseURL: strings.TrimRight(cfg.BaseURL, "/"), secret: secret}, nil
+}
+
+func (s *Local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put 先写入临时文件再重命名，读取方不会看到写了一半的文件
+func (s *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+func (s *Local) Get(key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *Local) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *Local) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+// LocalPrivatePrefix 只能通过签名地址访问的key前缀
+const LocalPrivatePrefix = "private/"
+
+// SignedURL 返回带有效期和HMAC签名的地址，过期或被篡改的地址会被ServeHTTP拒绝
+func (s *Local) SignedURL(key string, expires time.Duration) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{"expires": {exp}, "signature": {s.sign(key, exp)}}
+	return s.URL(key) + "?" + q.Encode(), nil
+}
+
+func (s *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature 检查签名是否与key和过期时间匹配且尚未过期
+func (s *Local) validSignature(key, expires, sig string, now time.Time) bool {
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > t {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.sign(key, expires)))
+}
+
+// RoutePath 需要在Gin中注册的路径前缀（BaseURL的路径部分）
+func (s *Local) RoutePath() string {
+	if u, err := url.Parse(s.baseURL); err == nil && u.Path != "" {
+		return strings.TrimRight(u.Path, "/")
+	}
+	return "/files"
+}
+
+// ServeHTTP 提供文件下载，请求路径为 RoutePath()/<key>
+func (s *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, s.RoutePath()), "/")
+	name, err := s.path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 带签名的请求总是校验签名，私有文件必须带签名
+	sig := r.URL.Query().Get("signature")
+	private := strings.HasPrefix(key, LocalPrivatePrefix)
+	if sig != "" || private {
+		if !s.validSignature(key, r.URL.Query().Get("expires"), sig, time.Now()) {
+			http.Error(w, "signature expired or invalid", http.StatusForbidden)
+			return
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType, ok := localContentTypes[strings.ToLower(path.Ext(key))]
+	if !ok {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if sig != "" || private {
+		// 签名地址过期后不应再从共享缓存中取到文件
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	t.Helper()
+	s, err := NewLocal(LocalConfig{Dir: t.TempDir(), BaseURL: "/files", Secret: "test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func serve(s *Local, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestLocalPutGetDelete(t *testing.T) {
+	s := newTestLocal(t)
+	if err := s.Put("kb/a/x.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.Get("kb/a/x.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("Get = %q", data)
+	}
+
+	if err := s.Delete("kb/a/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("kb/a/x.txt"); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+	if _, err := s.Get("kb/a/x.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after delete: %v, want ErrNotFound", err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../x", "a/../../x", `a\b`, "a//b"} {
+		if err := s.Put(key, strings.NewReader(""), 0, ""); err == nil {
+			t.Errorf("Put(%q) accepted an invalid key", key)
+		}
+		if _, err := s.SignedURL(key, time.Minute); err == nil {
+			t.Errorf("SignedURL(%q) accepted an invalid key", key)
+		}
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	s := newTestLocal(t)
+	for _, key := range []string{"public.png", LocalPrivatePrefix + "secret.png"} {
+		if err := s.Put(key, strings.NewReader("png"), 3, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signed, err := s.SignedURL(LocalPrivatePrefix+"secret.png", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/files/"+LocalPrivatePrefix+"secret.png" || u.Query().Get("expires") == "" || u.Query().Get("signature") == "" {
+		t.Fatalf("signed url = %s", signed)
+	}
+	q := u.Query()
+
+	w := serve(s, signed)
+	if w.Code != http.StatusOK || w.Body.String() != "png" {
+		t.Fatalf("signed url: status %d, body %q", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+		t.Errorf("signed url Cache-Control = %q", cc)
+	}
+
+	tampered := func(key, value string) string {
+		v := url.Values{}
+		for k := range q {
+			v.Set(k, q.Get(k))
+		}
+		v.Set(key, value)
+		return u.Path + "?" + v.Encode()
+	}
+	expired, _ := s.SignedURL(LocalPrivatePrefix+"secret.png", -time.Minute)
+	otherKey, _ := s.SignedURL("public.png", time.Minute)
+	otherSecret, _ := (&Local{baseURL: "/files", secret: []byte("other")}).SignedURL(LocalPrivatePrefix+"secret.png", time.Minute)
+	forbidden := map[string]string{
+		"unsigned":          "/files/" + LocalPrivatePrefix + "secret.png",
+		"expired":           expired,
+		"extended expiry":   tampered("expires", "99999999999"),
+		"bad signature":     tampered("signature", strings.Repeat("0", 64)),
+		"missing expiry":    u.Path + "?signature=" + q.Get("signature"),
+		"other key":         "/files/" + LocalPrivatePrefix + "secret.png?" + strings.SplitN(otherKey, "?", 2)[1],
+		"other secret":      otherSecret,
+		"garbage signature": tampered("signature", "x"),
+	}
+	for name, target := range forbidden {
+		if w := serve(s, target); w.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, w.Code)
+		}
+	}
+
+	// 公开文件不带签名也可以访问，带了签名时同样校验
+	if w := serve(s, "/files/public.png"); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Errorf("public file: status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	if w := serve(s, otherKey); w.Code != http.StatusOK {
+		t.Errorf("signed public file: status %d", w.Code)
+	}
+	if w := serve(s, "/files/public.png?signature=bad&expires=99999999999"); w.Code != http.StatusForbidden {
+		t.Errorf("public file with bad signature: status %d, want 403", w.Code)
+	}
+}
+
+func TestLocalServeContentType(t *testing.T) {
+	s := newTestLocal(t)
+	for _, key := range []string{"a.png", "page.html", "x.svg"} {
+		if err := s.Put(key, strings.NewReader("<script>alert(1)</script>"), 25, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := serve(s, "/files/a.png"); w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("png headers = %v", w.Header())
+	}
+	// 不在白名单中的类型作为附件下载，不能在站点域名下渲染
+	for _, key := range []string{"page.html", "x.svg"} {
+		w := serve(s, "/files/"+key)
+		if w.Header().Get("Content-Type") != "application/octet-stream" || w.Header().Get("Content-Disposition") != "attachment" ||
+			w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s headers = %v", key, w.Header())
+		}
+	}
+	for _, target := range []string{"/files/missing.png", "/files/../etc/passwd", "/files/", "/files/%2e%2e/x"} {
+		if w := serve(s, target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"io"
+	"time"
+)
+
+type OSSConfig struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	AccessKeySecret string `mapstructure:"access_key_secret"`
+	BucketName      string `mapstructure:"bucket_name"`
+	BaseURL         string `mapstructure:"base_url"`
+}
+
+// OSS 阿里云对象存储
+type OSS struct {
+	bucket  *oss.Bucket
+	baseURL string
+}
+
+func NewOSS(cfg OSSConfig) (*OSS, error) {
+	if cfg.Endpoint == "" || cfg.AccessKeyID == "" || cfg.AccessKeySecret == "" || cfg.BucketName == "" {
+		return nil, fmt.Errorf("OSS配置不完整，请检查配置文件")
+	}
+
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyID, cfg.AccessKeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("初始化OSS客户端失败: %v", err)
+	}
+	bucket, err := client.Bucket(cfg.BucketName)
+	if err != nil {
+		return nil, fmt.Errorf("获取Bucket失败: %v", err)
+	}
+	return &OSS{bucket: bucket, baseURL: cfg.BaseURL}, nil
+}
+
+func (s *OSS) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	err := s.bucket.PutObject(key, r, oss.ContentType(contentType), oss.ContentLength(size))
+	if err != nil {
+		return fmt.Errorf("上传到OSS失败: %v", err)
+	}
+	return nil
+}
+
+func (s *OSS) Get(key string) (io.ReadCloser, error) {
+	body, err := s.bucket.GetObject(key)
+	if err != nil {
+		if e, ok := err.(oss.ServiceError); ok && e.StatusCode == 404 {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("读取OSS文件失败: %v", err)
+	}
+	return body, nil
+}
+
+func (s *OSS) Delete(key string) error {
+	if err := s.bucket.DeleteObject(key); err != nil {
+		return fmt.Errorf("删除OSS文件失败: %v", err)
+	}
+	return nil
+}
+
+func (s *OSS) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (s *OSS) SignedURL(key string, expires time.Duration) (string, error) {
+	url, err := s.bucket.SignURL(key, oss.HTTPGet, int64(expires/time.Second))
+	if err != nil {
+		return "", fmt.Errorf("生成OSS签名地址失败: %v", err)
+	}
+	return url, nil
+}
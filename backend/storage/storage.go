@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("object not found")
+
+// Storage 对象存储，key使用"/"分隔的相对路径，如 kb/<kb_id>/<node_id>/<name>.png
+type Storage interface {
+	// Put 写入对象，已存在时覆盖
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，不存在时返回ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(key string) error
+	// URL 返回对象的公开访问地址
+	URL(key string) string
+	// SignedURL 返回在expires时间内有效的带签名的访问地址
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+// Config 存储配置，Driver为oss、local或s3
+type Config struct {
+	Driver string      `mapstructure:"driver"`
+	OSS    OSSConfig   `mapstructure:"oss"`
+	Local  LocalConfig `mapstructure:"local"`
+	S3     S3Config    `mapstructure:"s3"`
+}
+
+// New 根据配置创建存储
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "oss":
+		return NewOSS(cfg.OSS)
+	case "local", "":
+		return NewLocal(cfg.Local)
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+}
+
+// validKey 拒绝空key、绝对路径和包含 ".." 的key
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
+
+// joinURL 拼接基础地址和key
+func joinURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/" + key
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// AvatarSizes 生成的头像边长（像素），从小到大
+var AvatarSizes = []int{64, 128, 512}
+
+// 允许解码的最大像素数，防止很小的文件解码出巨大的图片
+const maxAvatarPixels = 40_000_000
+
+// ErrUnsupportedImage 无法识别或解码的图片
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// AvatarImage 一个尺寸的头像，Data为PNG编码
+type AvatarImage struct {
+	Size int
+	Data []byte
+}
+
+// ProcessAvatar 解码图片，按EXIF方向旋转，居中裁剪为正方形并缩放为AvatarSizes中的各个尺寸
+// 重新编码为PNG，原图的EXIF等元数据不会保留。
+func ProcessAvatar(data []byte) ([]AvatarImage, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, fmt.Errorf("image dimensions %dx%d are too large", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	// 正方形区域在旋转和翻转后不变，先缩放再调整方向，只需处理缩小后的图片
+	square := centerSquare(src)
+	orientation := exifOrientation(data)
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	avatars := make([]AvatarImage, 0, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Src, nil)
+
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, applyOrientation(dst, orientation)); err != nil {
+			return nil, fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		avatars = append(avatars, AvatarImage{Size: size, Data: buf.Bytes()})
+	}
+	return avatars, nil
+}
+
+// centerSquare 返回图片中间最大的正方形区域
+func centerSquare(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// applyOrientation 按EXIF Orientation（1-8）旋转或翻转图片，使其按正常方向显示
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// 5-8 需要交换宽高
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转90°
+				dx, dy = h-1-y, x
+			case 7: // 沿右上-左下对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转90°
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// exifOrientation 读取JPEG、PNG或WebP中EXIF的Orientation，没有时返回1
+func exifOrientation(data []byte) int {
+	if tiff := findExif(data); tiff != nil {
+		return tiffOrientation(tiff)
+	}
+	return 1
+}
+
+// findExif 返回图片中EXIF数据的TIFF部分
+func findExif(data []byte) []byte {
+	switch {
+	case len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8:
+		// JPEG: 在扫描数据之前的段中查找 APP1 "Exif\0\0"
+		for i := 2; i+4 <= len(data); {
+			if data[i] != 0xFF {
+				return nil
+			}
+			marker := data[i+1]
+			if marker == 0xD8 || marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+				i += 2
+				continue
+			}
+			if marker == 0xDA || marker == 0xD9 {
+				return nil
+			}
+			n := int(binary.BigEndian.Uint16(data[i+2:]))
+			if n < 2 || i+2+n > len(data) {
+				return nil
+			}
+			seg := data[i+4 : i+2+n]
+			if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+				return seg[6:]
+			}
+			i += 2 + n
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+12 <= len(data); {
+			size := binary.BigEndian.Uint32(data[i:])
+			if uint64(i)+12+uint64(size) > uint64(len(data)) {
+				return nil
+			}
+			n := int(size)
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+n]
+			}
+			if string(data[i+4:i+8]) == "IDAT" {
+				return nil
+			}
+			i += 12 + n
+		}
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			size := binary.LittleEndian.Uint32(data[i+4:])
+			if uint64(i)+8+uint64(size) > uint64(len(data)) {
+				return nil
+			}
+			n := int(size)
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+n], []byte("Exif\x00\x00"))
+			}
+			i += 8 + n + n%2
+		}
+	}
+	return nil
+}
+
+// tiffOrientation 从TIFF结构的第一个IFD中读取Orientation（0x0112）
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	// 偏移量来自文件，先按无符号数与长度比较，避免在32位平台上溢出
+	offset := order.Uint32(tiff[4:])
+	if offset < 8 || uint64(offset)+2 > uint64(len(tiff)) {
+		return 1
+	}
+	ifd := int(offset)
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// exifTIFF 生成包含Make和Orientation两项的EXIF（TIFF结构）
+func exifTIFF(order binary.ByteOrder, orientation uint16) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8))           // 第一个IFD的偏移
+	binary.Write(&b, order, uint16(2))           // 两项，Orientation排在第二项
+	binary.Write(&b, order, []uint16{0x010F, 2}) // Make，ASCII
+	binary.Write(&b, order, uint32(4))
+	b.WriteString("abc\x00")
+	binary.Write(&b, order, []uint16{0x0112, 3}) // Orientation，SHORT
+	binary.Write(&b, order, uint32(1))
+	binary.Write(&b, order, []uint16{orientation, 0})
+	binary.Write(&b, order, uint32(0)) // 没有下一个IFD
+	return b.Bytes()
+}
+
+// jpegWithExif 在JPEG的SOI之后插入APP1 EXIF段
+func jpegWithExif(t *testing.T, img image.Image, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	return append(append(append([]byte{}, data[:2]...), append(app1, seg...)...), data[2:]...)
+}
+
+// pngWithExif 在PNG的IHDR之后插入eXIf块
+func pngWithExif(t *testing.T, img image.Image, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	chunk := make([]byte, 8, 12+len(tiff))
+	binary.BigEndian.PutUint32(chunk, uint32(len(tiff)))
+	copy(chunk[4:], "eXIf")
+	chunk = append(chunk, tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+// webpWithExif 只有EXIF块的RIFF容器，用于测试查找EXIF
+func webpWithExif(tiff []byte) []byte {
+	var chunks bytes.Buffer
+	chunks.WriteString("VP8X")
+	binary.Write(&chunks, binary.LittleEndian, uint32(10))
+	chunks.Write(make([]byte, 10))
+	chunks.WriteString("EXIF")
+	binary.Write(&chunks, binary.LittleEndian, uint32(len(tiff)))
+	chunks.Write(tiff)
+	if len(tiff)%2 == 1 {
+		chunks.WriteByte(0)
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+chunks.Len()))
+	b.WriteString("WEBP")
+	b.Write(chunks.Bytes())
+	return b.Bytes()
+}
+
+// letters 把3x2的图片按行输出为字母，每个像素的灰度值对应一个字母
+func letters(img image.Image) string {
+	b := img.Bounds()
+	var rows []string
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			row.WriteByte('A' + gray.Y/40)
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "/")
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A B C
+	// D E F
+	src := image.NewGray(image.Rect(10, 20, 13, 22))
+	for i, p := range []image.Point{{10, 20}, {11, 20}, {12, 20}, {10, 21}, {11, 21}, {12, 21}} {
+		src.SetGray(p.X, p.Y, color.Gray{Y: uint8(i * 40)})
+	}
+	want := map[int]string{
+		0: "ABC/DEF", // 无效值不处理
+		1: "ABC/DEF",
+		2: "CBA/FED",
+		3: "FED/CBA",
+		4: "DEF/ABC",
+		5: "AD/BE/CF",
+		6: "DA/EB/FC",
+		7: "FC/EB/DA",
+		8: "CF/BE/AD",
+		9: "ABC/DEF",
+	}
+	for orientation, w := range want {
+		if got := letters(applyOrientation(src, orientation)); got != w {
+			t.Errorf("orientation %d: %s, want %s", orientation, got, w)
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			tiff := exifTIFF(order, orientation)
+			files := map[string][]byte{
+				"jpeg": jpegWithExif(t, img, tiff),
+				"png":  pngWithExif(t, img, tiff),
+				"webp": webpWithExif(tiff),
+			}
+			for format, data := range files {
+				if got := exifOrientation(data); got != int(orientation) {
+					t.Errorf("%s %v orientation %d: got %d", format, order, orientation, got)
+				}
+			}
+		}
+	}
+
+	var plain bytes.Buffer
+	png.Encode(&plain, img)
+	if got := exifOrientation(plain.Bytes()); got != 1 {
+		t.Errorf("PNG without EXIF: got %d, want 1", got)
+	}
+}
+
+func TestExifOrientationMalformed(t *testing.T) {
+	le := binary.LittleEndian
+	valid := exifTIFF(le, 6)
+	patch := func(offset int, value uint32) []byte {
+		tiff := append([]byte(nil), valid...)
+		le.PutUint32(tiff[offset:], value)
+		return tiff
+	}
+	// IFD声明的项数远多于实际内容，Orientation不在已有的项中
+	manyEntries := append([]byte(nil), valid[:8+2+12]...)
+	le.PutUint16(manyEntries[8:], 0xFFFF)
+	tiffs := map[string][]byte{
+		"empty":             {},
+		"short header":      []byte("II*\x00\x08"),
+		"bad byte order":    append([]byte("XX"), valid[2:]...),
+		"ifd offset max":    patch(4, 0xFFFFFFFF),
+		"ifd offset at end": patch(4, uint32(len(valid))),
+		"ifd offset small":  patch(4, 4),
+		"entry count max":   manyEntries,
+		"truncated entries": valid[:8+2+12+6],
+	}
+	for name, tiff := range tiffs {
+		if got := tiffOrientation(tiff); got != 1 {
+			t.Errorf("%s: got %d, want 1", name, got)
+		}
+	}
+
+	// 段、块的长度超出文件
+	jpegSeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f', 0, 0}
+	jpegShort := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9}
+	pngChunk := append([]byte("\x89PNG\r\n\x1a\n\xFF\xFF\xFF\xFFeXIf"), valid...)
+	webpChunk := append([]byte("RIFF\x00\x00\x00\x00WEBPEXIF\xFF\xFF\xFF\xFF"), valid...)
+	for name, data := range map[string][]byte{
+		"jpeg segment length": jpegSeg,
+		"jpeg length below 2": jpegShort,
+		"jpeg no marker":      {0xFF, 0xD8, 0x00, 0x00, 0x00},
+		"png chunk length":    pngChunk,
+		"webp chunk length":   webpChunk,
+		"not an image":        []byte("hello world"),
+	} {
+		if got := exifOrientation(data); got != 1 {
+			t.Errorf("%s: got %d, want 1", name, got)
+		}
+	}
+
+	// 在每个位置截断带EXIF的文件都不能越界
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for _, data := range [][]byte{jpegWithExif(t, img, valid), pngWithExif(t, img, valid), webpWithExif(valid)} {
+		for n := 0; n <= len(data); n++ {
+			exifOrientation(data[:n])
+			ProcessAvatar(data[:n])
+		}
+	}
+}
+
+func TestCenterSquare(t *testing.T) {
+	tests := []struct {
+		bounds, want image.Rectangle
+	}{
+		{image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10)},
+		{image.Rect(0, 0, 40, 20), image.Rect(10, 0, 30, 20)},
+		{image.Rect(0, 0, 20, 41), image.Rect(0, 10, 20, 30)},
+		{image.Rect(5, 7, 15, 12), image.Rect(7, 7, 12, 12)},
+	}
+	for _, tt := range tests {
+		if got := centerSquare(image.NewGray(tt.bounds)); got != tt.want {
+			t.Errorf("centerSquare(%v) = %v, want %v", tt.bounds, got, tt.want)
+		}
+	}
+}
+
+func TestProcessAvatar(t *testing.T) {
+	// 40x20的图片，左半红色右半蓝色；两侧各10像素的白边会被裁掉
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			switch {
+			case x >= 10 && x < 20:
+				c = color.RGBA{255, 0, 0, 255}
+			case x >= 20 && x < 30:
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	isRed := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r > 0xC000 && g < 0x4000 && b < 0x4000
+	}
+	isBlue := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return b > 0xC000 && r < 0x4000 && g < 0x4000
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		// 处理后左上角和右下角附近像素的颜色
+		topLeftRed, bottomRightBlue bool
+		topLeftBlue, bottomRightRed bool
+	}{
+		{name: "png", data: pngWithExif(t, src, exifTIFF(binary.BigEndian, 1)), topLeftRed: true, bottomRightBlue: true},
+		// 顺时针旋转90°后左半部分（红）到上方
+		{name: "jpeg rotate 90", data: jpegWithExif(t, src, exifTIFF(binary.LittleEndian, 6)), topLeftRed: true, bottomRightBlue: true},
+		// 旋转180°后红蓝互换
+		{name: "png rotate 180", data: pngWithExif(t, src, exifTIFF(binary.LittleEndian, 3)), topLeftBlue: true, bottomRightRed: true},
+		{name: "jpeg mirror", data: jpegWithExif(t, src, exifTIFF(binary.BigEndian, 2)), topLeftBlue: true, bottomRightRed: true},
+	}
+	for _, tt := range tests {
+		avatars, err := ProcessAvatar(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(avatars) != len(AvatarSizes) {
+			t.Fatalf("%s: got %d sizes", tt.name, len(avatars))
+		}
+		for i, a := range avatars {
+			if a.Size != AvatarSizes[i] {
+				t.Errorf("%s: size %d, want %d", tt.name, a.Size, AvatarSizes[i])
+			}
+			img, format, err := image.Decode(bytes.NewReader(a.Data))
+			if err != nil || format != "png" {
+				t.Fatalf("%s: output is not a PNG: %v", tt.name, err)
+			}
+			if b := img.Bounds(); b.Dx() != a.Size || b.Dy() != a.Size {
+				t.Errorf("%s: bounds %v, want %dx%d", tt.name, b, a.Size, a.Size)
+			}
+			tl, br := img.At(a.Size/8, a.Size/8), img.At(a.Size*7/8, a.Size*7/8)
+			if tt.topLeftRed && !isRed(tl) || tt.topLeftBlue && !isBlue(tl) ||
+				tt.bottomRightBlue && !isBlue(br) || tt.bottomRightRed && !isRed(br) {
+				t.Errorf("%s %d: top left %v, bottom right %v", tt.name, a.Size, tl, br)
+			}
+		}
+	}
+}
+
+func TestProcessAvatarRejects(t *testing.T) {
+	if _, err := ProcessAvatar([]byte("<svg/>")); err != ErrUnsupportedImage {
+		t.Errorf("svg: err = %v, want ErrUnsupportedImage", err)
+	}
+
+	// 文件头声明的尺寸超过限制时不解码
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 10000)
+	binary.BigEndian.PutUint32(data[20:], 10000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := ProcessAvatar(data); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("huge image: err = %v", err)
+	}
+}
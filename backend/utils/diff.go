@@ -0,0 +1,171 @@
+package utils
+
+import "strings"
+
+// 差异类型
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// maxDiffEdits Myers算法的最大编辑距离，超过后退化为整段删除+插入。
+// 回溯需要保存每一轮的状态，占用的内存和编辑距离的平方成正比。
+const maxDiffEdits = 1000
+
+// DiffLine 行级差异中的一行
+type DiffLine struct {
+	Op      string `json:"op"`                 // equal/insert/delete
+	OldLine int    `json:"old_line,omitempty"` // 在旧文本中的行号（从1开始）
+	NewLine int    `json:"new_line,omitempty"` // 在新文本中的行号（从1开始）
+	Text    string `json:"text"`
+}
+
+// DiffLines 计算两段文本的行级差异（Myers算法）
+func DiffLines(oldText, newText string) []DiffLine {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// 先剥离公共前缀和后缀，缩小需要比较的范围
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]string, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, DiffEqual)
+	}
+	ops = append(ops, myersOps(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, DiffEqual)
+	}
+
+	// 根据操作序列生成带行号的结果
+	lines := make([]DiffLine, 0, len(ops))
+	x, y := 0, 0
+	for _, op := range ops {
+		switch op {
+		case DiffEqual:
+			lines = append(lines, DiffLine{Op: op, OldLine: x + 1, NewLine: y + 1, Text: a[x]})
+			x++
+			y++
+		case DiffDelete:
+			lines = append(lines, DiffLine{Op: op, OldLine: x + 1, Text: a[x]})
+			x++
+		case DiffInsert:
+			lines = append(lines, DiffLine{Op: op, NewLine: y + 1, Text: b[y]})
+			y++
+		}
+	}
+	return lines
+}
+
+// myersOps 返回把a变为b的最短编辑操作序列
+func myersOps(a, b []string) []string {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return coarseOps(n, m)
+	}
+
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+
+	// v[offset+k] 表示对角线k上能到达的最远x。
+	// trace[d]保存第d轮开始前对角线-d-1..d+1的值，回溯时只会用到这些对角线。
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return coarseOps(n, m)
+	}
+
+	// 回溯得到操作序列（逆序）
+	var ops []string
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		// 快照从对角线-d-1开始
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, DiffEqual)
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, DiffInsert)
+		} else {
+			ops = append(ops, DiffDelete)
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, DiffEqual)
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// coarseOps 整段删除旧内容再插入新内容
+func coarseOps(n, m int) []string {
+	ops := make([]string, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, DiffDelete)
+	}
+	for i := 0; i < m; i++ {
+		ops = append(ops, DiffInsert)
+	}
+	return ops
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		edits    int
+	}{
+		{"equal", "a\nb\nc", "a\nb\nc", 0},
+		{"empty old", "", "a\nb", 2},
+		{"empty new", "a\nb", "", 2},
+		{"insert middle", "a\nc", "a\nb\nc", 1},
+		{"delete middle", "a\nb\nc", "a\nc", 1},
+		{"replace line", "a\nb\nc", "a\nx\nc", 2},
+		{"crlf", "a\r\nb\r\n", "a\nb\n", 0},
+		{"reorder", "a\nb\nc\nd", "b\na\nd\nc", 4},
+		{"classic", "a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := DiffLines(tt.old, tt.new)
+			oldText, newText, edits := applyDiff(t, lines)
+			if oldText != strings.Join(splitLines(tt.old), "\n") || newText != strings.Join(splitLines(tt.new), "\n") {
+				t.Fatalf("diff does not reproduce the input: old %q, new %q", oldText, newText)
+			}
+			if edits != tt.edits {
+				t.Fatalf("edits = %d, want %d", edits, tt.edits)
+			}
+		})
+	}
+}
+
+func TestDiffLinesTooManyEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i <= maxDiffEdits; i++ {
+		a = append(a, "a")
+		b = append(b, "b")
+	}
+	lines := DiffLines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	oldText, newText, edits := applyDiff(t, lines)
+	if oldText != strings.Join(a, "\n") || newText != strings.Join(b, "\n") || edits != len(a)+len(b) {
+		t.Fatalf("coarse diff: %d edits", edits)
+	}
+	if lines[0].Op != DiffDelete || lines[len(lines)-1].Op != DiffInsert {
+		t.Fatalf("coarse diff should delete everything before inserting")
+	}
+}
+
+// applyDiff 由差异还原旧文本和新文本，并校验行号连续
+func applyDiff(t *testing.T, lines []DiffLine) (string, string, int) {
+	t.Helper()
+	var oldLines, newLines []string
+	edits := 0
+	for _, l := range lines {
+		if l.Op != DiffInsert {
+			oldLines = append(oldLines, l.Text)
+			if l.OldLine != len(oldLines) {
+				t.Fatalf("old line %d, want %d", l.OldLine, len(oldLines))
+			}
+		}
+		if l.Op != DiffDelete {
+			newLines = append(newLines, l.Text)
+			if l.NewLine != len(newLines) {
+				t.Fatalf("new line %d, want %d", l.NewLine, len(newLines))
+			}
+		}
+		if l.Op != DiffEqual {
+			edits++
+		}
+	}
+	return strings.Join(oldLines, "\n"), strings.Join(newLines, "\n"), edits
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FileKind 附件的类别，不同类别有不同的大小限制
+type FileKind struct {
+	Name    string
+	MaxSize int64
+}
+
+var (
+	FileKindImage    = FileKind{Name: "image", MaxSize: 10 << 20}
+	FileKindDocument = FileKind{Name: "document", MaxSize: 50 << 20}
+	FileKindMedia    = FileKind{Name: "media", MaxSize: 100 << 20}
+	FileKindOther    = FileKind{Name: "other", MaxSize: 20 << 20}
+)
+
+// sniffedTypes 按内容识别出的类型 -> 类别和扩展名
+var sniffedTypes = map[string]struct {
+	kind FileKind
+	ext  string
+}{
+	"image/png":       {FileKindImage, ".png"},
+	"image/jpeg":      {FileKindImage, ".jpg"},
+	"image/gif":       {FileKindImage, ".gif"},
+	"image/webp":      {FileKindImage, ".webp"},
+	"image/bmp":       {FileKindImage, ".bmp"},
+	"application/pdf": {FileKindDocument, ".pdf"},
+	"video/mp4":       {FileKindMedia, ".mp4"},
+	"video/webm":      {FileKindMedia, ".webm"},
+	"audio/mpeg":      {FileKindMedia, ".mp3"},
+	"audio/wave":      {FileKindMedia, ".wav"},
+	"application/ogg": {FileKindMedia, ".ogg"},
+	"application/zip": {FileKindOther, ".zip"},
+}
+
+// SniffFile 根据文件开头的内容（至少512字节，文件更短时为全部内容）识别附件类型
+// 不信任客户端提供的Content-Type；无法识别或可能被浏览器当作网页执行的类型（HTML、SVG等）
+// 按application/octet-stream保存，保留原文件的扩展名。
+func SniffFile(head []byte, fileName string) (contentType, ext string, kind FileKind) {
+	detected := http.DetectContentType(head)
+	if t, ok := sniffedTypes[detected]; ok {
+		return detected, t.ext, t.kind
+	}
+
+	ext = strings.ToLower(path.Ext(fileName))
+	if len(ext) < 2 || len(ext) > 10 || strings.IndexFunc(ext[1:], func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		ext = ".bin"
+	}
+
+	// 纯文本可以安全地按文本显示
+	if strings.HasPrefix(detected, "text/plain") {
+		return detected, ext, FileKindOther
+	}
+	return "application/octet-stream", ext, FileKindOther
+}
+
+// CheckFileSize 检查文件大小是否超出该类别的限制
+func CheckFileSize(kind FileKind, size int64) error {
+	if size > kind.MaxSize {
+		return fmt.Errorf("%s files cannot be larger than %dMB", kind.Name, kind.MaxSize>>20)
+	}
+	return nil
+}
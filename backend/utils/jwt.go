@@ -1,26 +1,61 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"time"
 )
 
-var jwtSecret = []byte("fire_never_ends")
+var (
+	jwtSecret []byte
+	jwtTTL    = 15 * time.Minute
+)
+
+// ErrInvalidToken 访问令牌无效、已过期或不属于任何会话
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// InitJWT 设置签名密钥和访问令牌的有效期，启动时调用一次
+func InitJWT(secret string, ttl time.Duration) {
+	jwtSecret = []byte(secret)
+	jwtTTL = ttl
+}
 
-func GenerateToken(userID string) (string, error) {
+// AccessTokenTTL 访问令牌的有效期
+func AccessTokenTTL() time.Duration {
+	return jwtTTL
+}
+
+// GenerateToken 生成访问令牌，sessionID为令牌所属的登录会话
+func GenerateToken(userID, sessionID string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(jwtTTL).Unix(),
 	})
 	return token.SignedString(jwtSecret)
 }
 
-func ParseToken(tokenString string) (string, error) {
+// ParseToken 校验访问令牌，返回用户和会话
+// 不含会话的旧令牌视为无效，需要重新登录。
+func ParseToken(tokenString string) (userID, sessionID string, err error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
 		return jwtSecret, nil
 	})
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["user_id"].(string), nil
+	if err != nil || !token.Valid {
+		return "", "", ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", ErrInvalidToken
+	}
+	userID, _ = claims["user_id"].(string)
+	sessionID, _ = claims["sid"].(string)
+	if userID == "" || sessionID == "" {
+		return "", "", ErrInvalidToken
 	}
-	return "", err
+	return userID, sessionID, nil
 }
@@ -0,0 +1,1091 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TeXToMathML 将KaTeX风格的TeX公式转换为MathML，浏览器无需脚本即可原生显示
+// 支持常用的符号、上下标、分式、根式、重音、\left...\right、矩阵和对齐环境等；
+// 不认识的命令会显示为<merror>，语法错误（如括号不匹配）时返回error。
+func TeXToMathML(tex string, display bool) (string, error) {
+	p := &texParser{src: []rune(tex)}
+	rows, err := p.parseRows("")
+	if err != nil {
+		return "", err
+	}
+
+	body := ""
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		body = rows[0][0]
+	} else {
+		body = texTable(rows, func(int) string { return "center" }, "")
+	}
+
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString(`><semantics><mrow>`)
+	b.WriteString(body)
+	b.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	b.WriteString(xmlEscape(tex))
+	b.WriteString(`</annotation></semantics></math>`)
+	return b.String(), nil
+}
+
+// maxTeXDepth 最大嵌套深度，避免恶意输入导致栈溢出
+const maxTeXDepth = 200
+
+var (
+	errTeXTooDeep = errors.New("formula is nested too deeply")
+	texColorRe    = regexp.MustCompile(`^#?[0-9A-Za-z]+$`)
+	texLengthRe   = regexp.MustCompile(`^-?[0-9]*\.?[0-9]+(em|ex|pt|px|mu|cm|mm|in)$`)
+)
+
+type texTokenKind int
+
+const (
+	tokEOF texTokenKind = iota
+	tokChar
+	tokCommand
+	tokOpen
+	tokClose
+	tokSup
+	tokSub
+	tokAmp
+)
+
+type texToken struct {
+	kind texTokenKind
+	text string // 命令名（不含反斜杠）或字符
+}
+
+func (t texToken) is(command string) bool {
+	return t.kind == tokCommand && t.text == command
+}
+
+type texParser struct {
+	src     []rune
+	pos     int
+	depth   int
+	variant string // \mathbf 等命令设置的字体
+}
+
+// next 读取下一个记号，跳过空白和注释
+func (p *texParser) next() texToken {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if r == '%' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return texToken{kind: tokEOF}
+	}
+
+	r := p.src[p.pos]
+	p.pos++
+	switch r {
+	case '\\':
+		start := p.pos
+		for p.pos < len(p.src) && isASCIILetter(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start && p.pos < len(p.src) {
+			p.pos++
+		}
+		name := string(p.src[start:p.pos])
+		if name == "operatorname" && p.pos < len(p.src) && p.src[p.pos] == '*' {
+			p.pos++
+			name += "*"
+		}
+		return texToken{kind: tokCommand, text: name}
+	case '{':
+		return texToken{kind: tokOpen}
+	case '}':
+		return texToken{kind: tokClose}
+	case '^':
+		return texToken{kind: tokSup}
+	case '_':
+		return texToken{kind: tokSub}
+	case '&':
+		return texToken{kind: tokAmp}
+	}
+	return texToken{kind: tokChar, text: string(r)}
+}
+
+func (p *texParser) peek() texToken {
+	pos := p.pos
+	tok := p.next()
+	p.pos = pos
+	return tok
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// readRawGroup 读取{...}中的原始文本，不带花括号时读取单个字符
+func (p *texParser) readRawGroup() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", errors.New("missing argument")
+	}
+	if p.src[p.pos] != '{' {
+		p.pos++
+		return string(p.src[p.pos-1]), nil
+	}
+	return p.readBalanced('{', '}')
+}
+
+// readOptional 读取可选参数[...]
+func (p *texParser) readOptional() (string, bool, error) {
+	pos := p.pos
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '[' {
+		p.pos = pos
+		return "", false, nil
+	}
+	s, err := p.readBalanced('[', ']')
+	return s, true, err
+}
+
+func (p *texParser) readBalanced(open, close rune) (string, error) {
+	p.pos++ // 跳过开括号
+	start, level := p.pos, 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch r := p.src[p.pos]; {
+		case r == '\\':
+			p.pos++
+		case r == '{':
+			level++
+		case r == '}' && level > 0:
+			level--
+		case r == close && level == 0:
+			s := string(p.src[start:p.pos])
+			p.pos++
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("missing %q", close)
+}
+
+// parseSub 用新的解析器解析一段原始文本（如\sqrt的可选参数）
+func (p *texParser) parseSub(src string) (string, error) {
+	sub := &texParser{src: []rune(src), depth: p.depth, variant: p.variant}
+	items, term, err := sub.parseList()
+	if err != nil {
+		return "", err
+	}
+	if term.kind != tokEOF {
+		return "", fmt.Errorf("unexpected %s", describeToken(term))
+	}
+	return mrow(items), nil
+}
+
+// parseList 解析一串原子，直到遇到结束记号（EOF、}、&、\\、\end、\right、\middle）并返回它
+func (p *texParser) parseList() ([]string, texToken, error) {
+	var items []string
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == tokEOF, tok.kind == tokClose, tok.kind == tokAmp,
+			tok.is("\\"), tok.is("cr"), tok.is("end"), tok.is("right"), tok.is("middle"):
+			p.next()
+			return items, tok, nil
+
+		// 作用于当前组中剩余部分的命令
+		case tok.is("color"), tok.is("displaystyle"), tok.is("textstyle"),
+			tok.is("scriptstyle"), tok.is("scriptscriptstyle"):
+			p.next()
+			attrs := ""
+			switch tok.text {
+			case "color":
+				color, err := p.readRawGroup()
+				if err != nil {
+					return nil, tok, err
+				}
+				if !texColorRe.MatchString(color) {
+					return nil, tok, fmt.Errorf("invalid color %q", color)
+				}
+				attrs = `mathcolor="` + color + `"`
+			case "displaystyle":
+				attrs = `displaystyle="true" scriptlevel="0"`
+			case "textstyle":
+				attrs = `displaystyle="false" scriptlevel="0"`
+			case "scriptstyle":
+				attrs = `displaystyle="false" scriptlevel="1"`
+			case "scriptscriptstyle":
+				attrs = `displaystyle="false" scriptlevel="2"`
+			}
+			rest, term, err := p.parseList()
+			if err != nil {
+				return nil, term, err
+			}
+			items = append(items, "<mstyle "+attrs+">"+strings.Join(rest, "")+"</mstyle>")
+			return items, term, nil
+		}
+
+		item, err := p.parseAtom()
+		if err != nil {
+			return nil, tok, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseRows 解析由&和\\分隔的表格，env为空时解析到输入结束，否则解析到\end{env}
+func (p *texParser) parseRows(env string) ([][]string, error) {
+	var rows [][]string
+	var row []string
+	for {
+		items, term, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, mrow(items))
+
+		switch {
+		case term.kind == tokAmp:
+		case term.is("\\"), term.is("cr"):
+			rows = append(rows, row)
+			row = nil
+			if _, _, err := p.readOptional(); err != nil {
+				return nil, err
+			}
+		case term.is("end") && env != "":
+			name, err := p.readRawGroup()
+			if err != nil {
+				return nil, err
+			}
+			if name != env {
+				return nil, fmt.Errorf(`\begin{%s} ended by \end{%s}`, env, name)
+			}
+			return appendRow(rows, row), nil
+		case term.kind == tokEOF && env == "":
+			return appendRow(rows, row), nil
+		case term.kind == tokEOF:
+			return nil, fmt.Errorf(`missing \end{%s}`, env)
+		default:
+			return nil, fmt.Errorf("unexpected %s", describeToken(term))
+		}
+	}
+}
+
+// appendRow 追加最后一行，忽略末尾\\之后的空行
+func appendRow(rows [][]string, row []string) [][]string {
+	if len(rows) > 0 && len(row) == 1 && row[0] == "<mrow></mrow>" {
+		return rows
+	}
+	return append(rows, row)
+}
+
+// parseAtom 解析一个原子及其上下标
+func (p *texParser) parseAtom() (string, error) {
+	base, limits, funcApply := "<mrow></mrow>", false, false
+	if tok := p.peek(); tok.kind != tokSup && tok.kind != tokSub {
+		p.next()
+		var err error
+		base, limits, err = p.parsePrimary(tok)
+		if err != nil {
+			return "", err
+		}
+		if tok.kind == tokCommand {
+			_, funcApply = texFunctions[tok.text]
+			funcApply = funcApply || strings.HasPrefix(tok.text, "operatorname")
+		}
+	}
+
+	var sub, sup string
+	primes := 0
+loop:
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == tokChar && tok.text == "'":
+			p.next()
+			primes++
+		case tok.is("limits"):
+			p.next()
+			limits = true
+		case tok.is("nolimits"):
+			p.next()
+			limits = false
+		case tok.kind == tokSup || tok.kind == tokSub:
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			if tok.kind == tokSup {
+				if sup != "" {
+					return "", errors.New("double superscript")
+				}
+				sup = arg
+			} else {
+				if sub != "" {
+					return "", errors.New("double subscript")
+				}
+				sub = arg
+			}
+		default:
+			break loop
+		}
+	}
+	if primes > 0 {
+		prime := "<mo>" + strings.Repeat("′", primes) + "</mo>"
+		if sup == "" {
+			sup = prime
+		} else {
+			sup = "<mrow>" + prime + sup + "</mrow>"
+		}
+	}
+
+	var out string
+	switch {
+	case sub == "" && sup == "":
+		out = base
+	case limits && sup == "":
+		out = "<munder>" + base + sub + "</munder>"
+	case limits && sub == "":
+		out = "<mover>" + base + sup + "</mover>"
+	case limits:
+		out = "<munderover>" + base + sub + sup + "</munderover>"
+	case sup == "":
+		out = "<msub>" + base + sub + "</msub>"
+	case sub == "":
+		out = "<msup>" + base + sup + "</msup>"
+	default:
+		out = "<msubsup>" + base + sub + sup + "</msubsup>"
+	}
+	if funcApply {
+		out += "<mo>\u2061</mo>"
+	}
+	return out, nil
+}
+
+// parseArg 解析命令的一个参数：{...}或单个记号
+func (p *texParser) parseArg() (string, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokEOF:
+		return "", errors.New("missing argument")
+	case tokClose, tokAmp, tokSup, tokSub:
+		return "", fmt.Errorf("unexpected %s", describeToken(tok))
+	case tokChar:
+		// 不带花括号的参数只取一个字符，如\frac12
+		if r := tok.text[0]; r >= '0' && r <= '9' {
+			return "<mn>" + p.styled(tok.text) + "</mn>", nil
+		}
+	}
+	s, _, err := p.parsePrimary(tok)
+	return s, err
+}
+
+func (p *texParser) parseGroup() (string, error) {
+	items, term, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if term.kind != tokClose {
+		if term.kind == tokEOF {
+			return "", errors.New("missing }")
+		}
+		return "", fmt.Errorf("unexpected %s", describeToken(term))
+	}
+	return mrow(items), nil
+}
+
+// parsePrimary 解析一个不带上下标的原子，limits表示上下标应放在正上方和正下方
+func (p *texParser) parsePrimary(tok texToken) (s string, limits bool, err error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxTeXDepth {
+		return "", false, errTeXTooDeep
+	}
+
+	switch tok.kind {
+	case tokOpen:
+		s, err = p.parseGroup()
+		return s, false, err
+	case tokChar:
+		return p.charItem([]rune(tok.text)[0]), false, nil
+	case tokCommand:
+		return p.command(tok.text)
+	}
+	return "", false, fmt.Errorf("unexpected %s", describeToken(tok))
+}
+
+func (p *texParser) charItem(r rune) string {
+	switch {
+	case r >= '0' && r <= '9':
+		num := []rune{r}
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c >= '0' && c <= '9' || c == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+				num = append(num, c)
+				p.pos++
+				continue
+			}
+			break
+		}
+		return "<mn>" + xmlEscape(p.styled(string(num))) + "</mn>"
+	case isASCIILetter(r) || unicode.Is(unicode.Greek, r):
+		return p.identifier(string(r))
+	case unicode.IsLetter(r):
+		return "<mtext>" + xmlEscape(string(r)) + "</mtext>"
+	}
+
+	switch r {
+	case '-':
+		return "<mo>−</mo>"
+	case '*':
+		return "<mo>∗</mo>"
+	case '~':
+		return "<mtext>\u00a0</mtext>"
+	case '(', ')', '[', ']', '|':
+		return `<mo stretchy="false">` + string(r) + "</mo>"
+	case ',', ';':
+		return `<mo separator="true">` + string(r) + "</mo>"
+	case '\'':
+		return "<mo>′</mo>"
+	}
+	return "<mo>" + xmlEscape(string(r)) + "</mo>"
+}
+
+// identifier 输出单个字母的<mi>，应用当前字体
+func (p *texParser) identifier(s string) string {
+	if p.variant == "normal" {
+		return `<mi mathvariant="normal">` + xmlEscape(s) + "</mi>"
+	}
+	return "<mi>" + xmlEscape(p.styled(s)) + "</mi>"
+}
+
+// styled 将字母和数字映射为当前字体对应的Unicode数学字母
+func (p *texParser) styled(s string) string {
+	offsets, ok := texVariantOffsets[p.variant]
+	if !ok {
+		return s
+	}
+	out := []rune(s)
+	for i, r := range out {
+		if c, ok := texVariantExceptions[p.variant][r]; ok {
+			out[i] = c
+			continue
+		}
+		switch {
+		case r >= 'A' && r <= 'Z':
+			out[i] = offsets[0] + r - 'A'
+		case r >= 'a' && r <= 'z':
+			out[i] = offsets[1] + r - 'a'
+		case r >= '0' && r <= '9' && offsets[2] != 0:
+			out[i] = offsets[2] + r - '0'
+		}
+	}
+	return string(out)
+}
+
+// command 解析一个命令
+func (p *texParser) command(name string) (string, bool, error) {
+	if width, ok := texSpaces[name]; ok {
+		return `<mspace width="` + width + `"/>`, false, nil
+	}
+	if s, ok := texGreek[name]; ok {
+		if unicode.IsUpper([]rune(s)[0]) {
+			return `<mi mathvariant="normal">` + s + "</mi>", false, nil
+		}
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texLetterlike[name]; ok {
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texOperators[name]; ok {
+		return "<mo>" + xmlEscape(s) + "</mo>", false, nil
+	}
+	if s, ok := texDelimiters[name]; ok {
+		return `<mo stretchy="false">` + xmlEscape(s) + "</mo>", false, nil
+	}
+	if op, ok := texBigOperators[name]; ok {
+		if op.limits {
+			return `<mo movablelimits="true">` + op.symbol + "</mo>", true, nil
+		}
+		return "<mo>" + op.symbol + "</mo>", false, nil
+	}
+	if s, ok := texFunctions[name]; ok {
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texLimitFunctions[name]; ok {
+		return `<mo movablelimits="true" form="prefix">` + s + "</mo>", true, nil
+	}
+	if accent, ok := texAccents[name]; ok {
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		mo := `<mo stretchy="` + fmt.Sprint(accent.stretchy) + `">` + accent.symbol + "</mo>"
+		if accent.under {
+			return `<munder accentunder="true">` + arg + mo + "</munder>", false, nil
+		}
+		return `<mover accent="true">` + arg + mo + "</mover>", false, nil
+	}
+	if variant, ok := texVariantCommands[name]; ok {
+		saved := p.variant
+		p.variant = variant
+		arg, err := p.parseArg()
+		p.variant = saved
+		return arg, false, err
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom", "dbinom", "tbinom":
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		s := "<mfrac>" + num + den + "</mfrac>"
+		if strings.HasSuffix(name, "binom") {
+			s = `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + "</mfrac><mo>)</mo></mrow>"
+		}
+		switch name[0] {
+		case 'd', 'c':
+			s = `<mstyle displaystyle="true" scriptlevel="0">` + s + "</mstyle>"
+		case 't':
+			s = `<mstyle displaystyle="false" scriptlevel="0">` + s + "</mstyle>"
+		}
+		return s, false, nil
+
+	case "sqrt":
+		index, ok, err := p.readOptional()
+		if err != nil {
+			return "", false, err
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if !ok {
+			return "<msqrt>" + arg + "</msqrt>", false, nil
+		}
+		root, err := p.parseSub(index)
+		if err != nil {
+			return "", false, err
+		}
+		return "<mroot>" + arg + root + "</mroot>", false, nil
+
+	case "text", "textrm", "textup", "textnormal", "mbox", "textit", "textbf", "textsf", "texttt":
+		text, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		text = strings.NewReplacer(`\{`, "{", `\}`, "}", `\%`, "%", `\$`, "$", `\&`, "&", `\_`, "_", `\#`, "#", "~", "\u00a0").Replace(text)
+		// 保留首尾空格，否则会被MathML忽略
+		if strings.HasPrefix(text, " ") {
+			text = "\u00a0" + text[1:]
+		}
+		if strings.HasSuffix(text, " ") {
+			text = text[:len(text)-1] + "\u00a0"
+		}
+		attr := ""
+		switch name {
+		case "textit":
+			attr = ` mathvariant="italic"`
+		case "textbf":
+			attr = ` mathvariant="bold"`
+		case "textsf":
+			attr = ` mathvariant="sans-serif"`
+		case "texttt":
+			attr = ` mathvariant="monospace"`
+		}
+		return "<mtext" + attr + ">" + xmlEscape(text) + "</mtext>", false, nil
+
+	case "operatorname", "operatorname*":
+		text, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		text = strings.TrimSpace(text)
+		if name == "operatorname*" {
+			return `<mo movablelimits="true" form="prefix">` + xmlEscape(text) + "</mo>", true, nil
+		}
+		if len([]rune(text)) == 1 {
+			return `<mi mathvariant="normal">` + xmlEscape(text) + "</mi>", false, nil
+		}
+		return "<mi>" + xmlEscape(text) + "</mi>", false, nil
+
+	case "left":
+		s, err := p.parseLeftRight()
+		return s, false, err
+
+	case "big", "Big", "bigg", "Bigg", "bigl", "Bigl", "biggl", "Biggl",
+		"bigr", "Bigr", "biggr", "Biggr", "bigm", "Bigm", "biggm", "Biggm":
+		d, err := p.readDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		size := texBigSizes[strings.TrimRight(name, "lrm")]
+		return `<mo fence="false" stretchy="true" minsize="` + size + `" maxsize="` + size + `">` + xmlEscape(d) + "</mo>", false, nil
+
+	case "begin":
+		s, err := p.parseEnvironment()
+		return s, false, err
+
+	case "not":
+		next := p.next()
+		if next.kind == tokChar && next.text == "=" {
+			return "<mo>≠</mo>", false, nil
+		}
+		s, _, err := p.parsePrimary(next)
+		if err != nil {
+			return "", false, err
+		}
+		if strings.HasPrefix(s, "<mo") {
+			return strings.Replace(s, "</mo>", "\u0338</mo>", 1), false, nil
+		}
+		return "<mrow>" + s + "<mo>\u0338</mo></mrow>", false, nil
+
+	case "overset", "stackrel", "underset":
+		over, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "underset" {
+			return "<munder>" + base + over + "</munder>", false, nil
+		}
+		return "<mover>" + base + over + "</mover>", false, nil
+
+	case "textcolor":
+		color, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		if !texColorRe.MatchString(color) {
+			return "", false, fmt.Errorf("invalid color %q", color)
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mstyle mathcolor="` + color + `">` + arg + "</mstyle>", false, nil
+
+	case "boxed", "fbox":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow style="border:1px solid;padding:0.2em">` + arg + "</mrow>", false, nil
+
+	case "phantom":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mphantom>" + arg + "</mphantom>", false, nil
+
+	case "hspace", "hspace*", "kern", "mkern", "hskip":
+		width, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		width = strings.ReplaceAll(strings.TrimSpace(width), "mu", "em")
+		if !texLengthRe.MatchString(width) {
+			return "", false, fmt.Errorf("invalid length %q", width)
+		}
+		return `<mspace width="` + width + `"/>`, false, nil
+
+	case "bmod", "mod":
+		return `<mo lspace="0.2222em" rspace="0.2222em">mod</mo>`, false, nil
+
+	case "pmod":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mspace width="1em"/><mo stretchy="false">(</mo><mi>mod</mi><mspace width="0.3333em"/>` +
+			arg + `<mo stretchy="false">)</mo></mrow>`, false, nil
+	}
+
+	return "<merror><mtext>" + xmlEscape(`\`+name) + "</mtext></merror>", false, nil
+}
+
+// parseLeftRight 解析\left...\middle...\right
+func (p *texParser) parseLeftRight() (string, error) {
+	open, err := p.readDelimiter()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	b.WriteString(texFence(open, "prefix"))
+	for {
+		items, term, err := p.parseList()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(strings.Join(items, ""))
+
+		switch {
+		case term.is("middle"):
+			d, err := p.readDelimiter()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(texFence(d, "infix"))
+		case term.is("right"):
+			d, err := p.readDelimiter()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(texFence(d, "postfix"))
+			b.WriteString("</mrow>")
+			return b.String(), nil
+		default:
+			return "", errors.New(`missing \right`)
+		}
+	}
+}
+
+func texFence(d, form string) string {
+	if d == "" {
+		return ""
+	}
+	return `<mo fence="true" form="` + form + `" stretchy="true">` + xmlEscape(d) + "</mo>"
+}
+
+// readDelimiter 读取\left、\right和\big等命令后的定界符，"."表示空定界符
+func (p *texParser) readDelimiter() (string, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokChar:
+		switch tok.text {
+		case ".":
+			return "", nil
+		case "(", ")", "[", "]", "|", "/":
+			return tok.text, nil
+		case "<":
+			return "⟨", nil
+		case ">":
+			return "⟩", nil
+		}
+	case tokCommand:
+		if d, ok := texDelimiters[tok.text]; ok {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("invalid delimiter %s", describeToken(tok))
+}
+
+// parseEnvironment 解析\begin{name}...\end{name}
+func (p *texParser) parseEnvironment() (string, error) {
+	name, err := p.readRawGroup()
+	if err != nil {
+		return "", err
+	}
+	env, ok := texEnvironments[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported environment %q", name)
+	}
+	if env.columnSpec {
+		if _, err := p.readRawGroup(); err != nil {
+			return "", err
+		}
+	}
+
+	rows, err := p.parseRows(name)
+	if err != nil {
+		return "", err
+	}
+
+	align := func(int) string { return "center" }
+	switch env.align {
+	case "left":
+		align = func(int) string { return "left" }
+	case "aligned":
+		align = func(col int) string {
+			if col%2 == 0 {
+				return "right"
+			}
+			return "left"
+		}
+		// 右列以关系符开头时补一个空元素，使其按中缀运算符排版
+		for _, row := range rows {
+			for i := 1; i < len(row); i += 2 {
+				row[i] = "<mrow><mi></mi>" + row[i] + "</mrow>"
+			}
+		}
+	}
+
+	attrs := ""
+	if env.display {
+		attrs = ` displaystyle="true"`
+	}
+	table := texTable(rows, align, attrs)
+	if env.small {
+		table = `<mstyle scriptlevel="1">` + table + "</mstyle>"
+	}
+	if env.left == "" && env.right == "" {
+		return table, nil
+	}
+	return "<mrow>" + texFence(env.left, "prefix") + table + texFence(env.right, "postfix") + "</mrow>", nil
+}
+
+func texTable(rows [][]string, align func(col int) string, attrs string) string {
+	var b strings.Builder
+	b.WriteString("<mtable" + attrs + ">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for i, cell := range row {
+			b.WriteString(`<mtd columnalign="` + align(i) + `">` + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return b.String()
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func describeToken(tok texToken) string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of formula"
+	case tokCommand:
+		return `\` + tok.text
+	case tokOpen:
+		return "{"
+	case tokClose:
+		return "}"
+	case tokSup:
+		return "^"
+	case tokSub:
+		return "_"
+	case tokAmp:
+		return "&"
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+var xmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func xmlEscape(s string) string {
+	return xmlReplacer.Replace(s)
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", "thinspace": "0.1667em",
+	":": "0.2222em", ">": "0.2222em", "medspace": "0.2222em",
+	";": "0.2778em", "thickspace": "0.2778em",
+	"!": "-0.1667em", "negthinspace": "-0.1667em",
+	" ": "0.3333em", "enspace": "0.5em",
+	"quad": "1em", "qquad": "2em",
+}
+
+var texGreek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω", "digamma": "ϝ",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+var texLetterlike = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "hslash": "ℏ", "ell": "ℓ", "aleph": "ℵ", "beth": "ℶ", "Re": "ℜ", "Im": "ℑ",
+	"wp": "℘", "imath": "ı", "jmath": "ȷ", "top": "⊤", "bot": "⊥", "angle": "∠",
+	"triangle": "△", "square": "□", "Box": "□", "diamondsuit": "♢", "heartsuit": "♡",
+	"clubsuit": "♣", "spadesuit": "♠", "flat": "♭", "natural": "♮", "sharp": "♯",
+	"checkmark": "✓", "degree": "°", "%": "%", "$": "$", "#": "#", "_": "_",
+}
+
+var texOperators = map[string]string{
+	// 二元运算符
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "oslash": "⊘",
+	"odot": "⊙", "cup": "∪", "cap": "∩", "sqcup": "⊔", "sqcap": "⊓", "uplus": "⊎",
+	"vee": "∨", "lor": "∨", "wedge": "∧", "land": "∧", "setminus": "∖", "smallsetminus": "∖",
+	"dagger": "†", "ddagger": "‡", "amalg": "⨿", "wr": "≀", "diamond": "⋄",
+	"bigtriangleup": "△", "bigtriangledown": "▽", "triangleleft": "◃", "triangleright": "▹",
+	"lhd": "⊲", "rhd": "⊳", "cdotp": "⋅", "&": "&",
+	// 关系符
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "leqslant": "⩽",
+	"geqslant": "⩾", "ll": "≪", "gg": "≫", "lll": "⋘", "ggg": "⋙", "approx": "≈",
+	"approxeq": "≊", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"asymp": "≍", "doteq": "≐", "triangleq": "≜", "coloneqq": "≔", "prec": "≺", "succ": "≻",
+	"preceq": "⪯", "succeq": "⪰", "in": "∈", "notin": "∉", "ni": "∋", "owns": "∋",
+	"subset": "⊂", "supset": "⊃", "subseteq": "⊆", "supseteq": "⊇", "subsetneq": "⊊",
+	"supsetneq": "⊋", "nsubseteq": "⊈", "sqsubseteq": "⊑", "sqsupseteq": "⊒",
+	"perp": "⊥", "parallel": "∥", "nparallel": "∦", "mid": "∣", "nmid": "∤", "vdash": "⊢",
+	"dashv": "⊣", "models": "⊨", "vDash": "⊨", "smile": "⌣", "frown": "⌢", "bowtie": "⋈",
+	"lessgtr": "≶", "gtrless": "≷", "lesssim": "≲", "gtrsim": "≳", "nless": "≮", "ngtr": "≯",
+	"nleq": "≰", "ngeq": "≱", "nsim": "≁", "ncong": "≇",
+	// 箭头
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹",
+	"impliedby": "⟸", "iff": "⟺", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"longleftrightarrow": "⟷", "Longrightarrow": "⟹", "Longleftarrow": "⟸",
+	"Longleftrightarrow": "⟺", "mapsto": "↦", "longmapsto": "⟼", "hookrightarrow": "↪",
+	"hookleftarrow": "↩", "rightharpoonup": "⇀", "rightharpoondown": "⇁",
+	"leftharpoonup": "↼", "leftharpoondown": "↽", "rightleftharpoons": "⇌",
+	"nearrow": "↗", "searrow": "↘", "swarrow": "↙", "nwarrow": "↖",
+	"updownarrow": "↕", "Updownarrow": "⇕", "leadsto": "⇝",
+	"nrightarrow": "↛", "nleftarrow": "↚", "nRightarrow": "⇏", "nLeftarrow": "⇍",
+	"twoheadrightarrow": "↠", "rightsquigarrow": "⇝",
+	// 逻辑和其他
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+	"therefore": "∴", "because": "∵", "ldots": "…", "dots": "…", "dotsc": "…", "dotso": "…",
+	"cdots": "⋯", "dotsb": "⋯", "dotsm": "⋯", "dotsi": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"prime": "′", "backprime": "‵", "colon": ":", "vert": "|", "Vert": "‖",
+}
+
+// texDelimiters 可用于\left、\right的定界符
+var texDelimiters = map[string]string{
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "lbrack": "[", "rbrack": "]",
+	"langle": "⟨", "rangle": "⟩", "lvert": "|", "rvert": "|", "vert": "|",
+	"lVert": "‖", "rVert": "‖", "Vert": "‖", "|": "‖", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "uparrow": "↑", "downarrow": "↓", "Uparrow": "⇑",
+	"Downarrow": "⇓", "backslash": "∖", "lgroup": "⟮", "rgroup": "⟯",
+}
+
+type texBigOperator struct {
+	symbol string
+	limits bool
+}
+
+var texBigOperators = map[string]texBigOperator{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true},
+	"bigcup": {"⋃", true}, "bigcap": {"⋂", true}, "bigvee": {"⋁", true}, "bigwedge": {"⋀", true},
+	"bigoplus": {"⨁", true}, "bigotimes": {"⨂", true}, "bigodot": {"⨀", true},
+	"biguplus": {"⨄", true}, "bigsqcup": {"⨆", true},
+	"int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+	"oiint": {"∯", false}, "intop": {"∫", true}, "smallint": {"∫", false},
+}
+
+var texFunctions = map[string]string{
+	"sin": "sin", "cos": "cos", "tan": "tan", "cot": "cot", "sec": "sec", "csc": "csc",
+	"arcsin": "arcsin", "arccos": "arccos", "arctan": "arctan", "sinh": "sinh", "cosh": "cosh",
+	"tanh": "tanh", "coth": "coth", "log": "log", "ln": "ln", "lg": "lg", "exp": "exp",
+	"ker": "ker", "dim": "dim", "arg": "arg", "deg": "deg", "hom": "hom", "sgn": "sgn",
+}
+
+var texLimitFunctions = map[string]string{
+	"lim": "lim", "max": "max", "min": "min", "sup": "sup", "inf": "inf", "det": "det",
+	"gcd": "gcd", "Pr": "Pr", "liminf": "lim inf", "limsup": "lim sup",
+	"argmax": "arg max", "argmin": "arg min",
+}
+
+type texAccent struct {
+	symbol   string
+	stretchy bool
+	under    bool
+}
+
+var texAccents = map[string]texAccent{
+	"hat": {"^", false, false}, "widehat": {"^", true, false},
+	"tilde": {"~", false, false}, "widetilde": {"~", true, false},
+	"bar": {"¯", false, false}, "overline": {"‾", true, false},
+	"vec": {"→", false, false}, "overrightarrow": {"→", true, false},
+	"overleftarrow": {"←", true, false}, "overleftrightarrow": {"↔", true, false},
+	"dot": {"˙", false, false}, "ddot": {"¨", false, false}, "acute": {"´", false, false},
+	"grave": {"`", false, false}, "breve": {"˘", false, false}, "check": {"ˇ", false, false},
+	"mathring": {"˚", false, false}, "overbrace": {"⏞", true, false},
+	"underline": {"_", true, true}, "underbrace": {"⏟", true, true},
+	"underrightarrow": {"→", true, true}, "underleftarrow": {"←", true, true},
+}
+
+var texVariantCommands = map[string]string{
+	"mathrm": "normal", "mathup": "normal", "rm": "normal",
+	"mathbf": "bold", "bf": "bold", "mathit": "italic", "boldsymbol": "bold-italic", "bm": "bold-italic",
+	"mathbb": "double-struck", "mathcal": "script", "mathscr": "script", "mathfrak": "fraktur",
+	"mathsf": "sans-serif", "mathtt": "monospace",
+}
+
+// texVariantOffsets 各字体的Unicode数学字母起点：大写A、小写a、数字0（0表示没有对应的数字）
+var texVariantOffsets = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"italic":        {0x1D434, 0x1D44E, 0},
+	"bold-italic":   {0x1D468, 0x1D482, 0},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// texVariantExceptions 在字母符号区（U+2100）中已有编码、数学字母区中留空的字符
+var texVariantExceptions = map[string]map[rune]rune{
+	"italic":        {'h': 'ℎ'},
+	"script":        {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
+
+var texBigSizes = map[string]string{
+	"big": "1.2em", "Big": "1.623em", "bigg": "2.047em", "Bigg": "2.470em",
+}
+
+type texEnvironment struct {
+	left, right string
+	align       string // center/left/aligned
+	columnSpec  bool   // 是否带有列格式参数，如array的{cc}
+	display     bool
+	small       bool
+}
+
+var texEnvironments = map[string]texEnvironment{
+	"matrix":      {},
+	"pmatrix":     {left: "(", right: ")"},
+	"bmatrix":     {left: "[", right: "]"},
+	"Bmatrix":     {left: "{", right: "}"},
+	"vmatrix":     {left: "|", right: "|"},
+	"Vmatrix":     {left: "‖", right: "‖"},
+	"smallmatrix": {small: true},
+	"array":       {columnSpec: true},
+	"cases":       {left: "{", align: "left"},
+	"dcases":      {left: "{", align: "left", display: true},
+	"rcases":      {right: "}", align: "left"},
+	"aligned":     {align: "aligned", display: true},
+	"align":       {align: "aligned", display: true},
+	"align*":      {align: "aligned", display: true},
+	"alignat":     {align: "aligned", columnSpec: true, display: true},
+	"alignat*":    {align: "aligned", columnSpec: true, display: true},
+	"alignedat":   {align: "aligned", columnSpec: true, display: true},
+	"split":       {align: "aligned", display: true},
+	"gathered":    {display: true},
+	"gather":      {display: true},
+	"gather*":     {display: true},
+	"equation":    {display: true},
+	"equation*":   {display: true},
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	mathPrefix = `<math xmlns="http://www.w3.org/1998/Math/MathML"><semantics><mrow>`
+	mathSuffix = `</mrow><annotation encoding="application/x-tex">`
+)
+
+func TestTeXToMathML(t *testing.T) {
+	tests := []struct {
+		tex, want string
+	}{
+		{`x^2`, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{`a_i^2`, `<msubsup><mi>a</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{`12.5x`, `<mrow><mn>12.5</mn><mi>x</mi></mrow>`},
+		{`\frac{a}{b}`, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		{`\sqrt{x}`, `<msqrt><mi>x</mi></msqrt>`},
+		{`\sqrt[3]{x}`, `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{`\alpha+\beta`, `<mrow><mi>α</mi><mo>+</mo><mi>β</mi></mrow>`},
+		{`\sum_{i=1}^n i`, `<mrow><munderover><mo movablelimits="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mi>i</mi></mrow>`},
+		{`\left( x \right)`, `<mrow><mo fence="true" form="prefix" stretchy="true">(</mo><mi>x</mi><mo fence="true" form="postfix" stretchy="true">)</mo></mrow>`},
+		{`\begin{pmatrix}a&b\\c&d\end{pmatrix}`, `<mrow><mo fence="true" form="prefix" stretchy="true">(</mo><mtable>` +
+			`<mtr><mtd columnalign="center"><mi>a</mi></mtd><mtd columnalign="center"><mi>b</mi></mtd></mtr>` +
+			`<mtr><mtd columnalign="center"><mi>c</mi></mtd><mtd columnalign="center"><mi>d</mi></mtd></mtr>` +
+			`</mtable><mo fence="true" form="postfix" stretchy="true">)</mo></mrow>`},
+		{`\hat{x}`, `<mover accent="true"><mi>x</mi><mo stretchy="false">^</mo></mover>`},
+		{`\mathbf{v}`, `<mi>𝐯</mi>`},
+		{`\text{a<b}`, `<mtext>a&lt;b</mtext>`},
+		{`a<b`, `<mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi></mrow>`},
+		{`\color{red}{x}`, `<mstyle mathcolor="red"><mi>x</mi></mstyle>`},
+		{`\hspace{1em}`, `<mspace width="1em"/>`},
+		{`\foo`, `<merror><mtext>\foo</mtext></merror>`},
+	}
+	for _, tt := range tests {
+		got, err := TeXToMathML(tt.tex, false)
+		if err != nil {
+			t.Errorf("TeXToMathML(%q): %v", tt.tex, err)
+			continue
+		}
+		want := mathPrefix + tt.want + mathSuffix + xmlEscape(tt.tex) + `</annotation></semantics></math>`
+		if got != want {
+			t.Errorf("TeXToMathML(%q) = %q, want %q", tt.tex, got, want)
+		}
+	}
+}
+
+func TestTeXToMathMLErrors(t *testing.T) {
+	tests := []string{
+		`{x`,
+		`x}`,
+		`\frac{a}`,
+		`\left( x`,
+		`\begin{matrix}a`,
+		`\color{red"}{x}`,
+		`\hspace{1e}`,
+		strings.Repeat("{", maxTeXDepth+1) + strings.Repeat("}", maxTeXDepth+1),
+	}
+	for _, tex := range tests {
+		if got, err := TeXToMathML(tex, false); err == nil {
+			t.Errorf("TeXToMathML(%.20q) = %q, want an error", tex, got)
+		}
+	}
+}
+
+func TestTeXToMathMLDisplay(t *testing.T) {
+	got, err := TeXToMathML(`a&b`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`) {
+		t.Fatalf("display formula = %q", got)
+	}
+	if !strings.Contains(got, `<mtable>`) || !strings.Contains(got, `<annotation encoding="application/x-tex">a&amp;b</annotation>`) {
+		t.Fatalf("aligned formula = %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 邮件中的一次性令牌：<id>.<过期时间>.<签名>
+// 签名覆盖用途、id和过期时间，伪造、篡改或过期的令牌不用查库即可拒绝；
+// 数据库中只保存id的哈希，用于保证令牌只能使用一次。
+
+// signedTokenKey 由JWT密钥派生，与访问令牌使用不同的密钥
+func signedTokenKey() []byte {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("user-token"))
+	return mac.Sum(nil)
+}
+
+func signToken(purpose, id, exp string) string {
+	mac := hmac.New(sha256.New, signedTokenKey())
+	mac.Write([]byte(purpose + "\n" + id + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewSignedToken 生成用于purpose的令牌，返回令牌、令牌id和过期时间
+func NewSignedToken(purpose string, ttl time.Duration) (token, id string, expiresAt time.Time, err error) {
+	id, err = GenerateRandomToken(24)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	expiresAt = time.Now().Add(ttl).Truncate(time.Second)
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return id + "." + exp + "." + signToken(purpose, id, exp), id, expiresAt, nil
+}
+
+// VerifySignedToken 校验令牌的签名、用途和有效期，返回令牌id
+func VerifySignedToken(purpose, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+	id, exp, sig := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(sig), []byte(signToken(purpose, id, exp))) {
+		return "", ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return "", ErrInvalidToken
+	}
+	return id, nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"knowledge_master_backend/storage"
+	"log"
+	"strconv"
+)
+
+// UploadedAvatar 上传后的头像，URLs按边长（"64"、"128"...）索引
+type UploadedAvatar struct {
+	URI  string // 最大尺寸的地址
+	URLs map[string]string
+	Keys []string
+}
+
+// UploadAvatar 处理头像图片并把各个尺寸上传到存储，任何一个尺寸失败时删除已上传的文件
+func UploadAvatar(store storage.Storage, userID string, data []byte) (*UploadedAvatar, error) {
+	images, err := ProcessAvatar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := GenerateRandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+
+	avatar := &UploadedAvatar{URLs: make(map[string]string, len(images))}
+	for _, img := range images {
+		key := fmt.Sprintf("avatar/%s/%s-%d.png", userID, name, img.Size)
+		if err := store.Put(key, bytes.NewReader(img.Data), int64(len(img.Data)), "image/png"); err != nil {
+			DeleteObjects(store, avatar.Keys)
+			return nil, err
+		}
+		avatar.Keys = append(avatar.Keys, key)
+		avatar.URLs[strconv.Itoa(img.Size)] = store.URL(key)
+		avatar.URI = store.URL(key)
+	}
+	return avatar, nil
+}
+
+// DeleteObjects 删除存储中的文件，失败只记录日志
+func DeleteObjects(store storage.Storage, keys []string) {
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil {
+			log.Printf("删除文件失败 - Key: %s, 错误: %v", key, err)
+		}
+	}
+}